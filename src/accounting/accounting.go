@@ -0,0 +1,517 @@
+// Package accounting totals the traffic of the connections b4 handles per
+// set, domain and device, using the kernel's conntrack byte counters.
+package accounting
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+
+	// saveInterval bounds how much accounting is lost on a crash.
+	saveInterval = 5 * time.Minute
+
+	acctSysctl = "/proc/sys/net/netfilter/nf_conntrack_acct"
+)
+
+// Counter is a byte and packet total, both directions combined.
+type Counter struct {
+	Bytes   uint64 `json:"bytes"`
+	Packets uint64 `json:"packets"`
+}
+
+// Totals breaks a period's traffic down by set name, domain and device MAC.
+type Totals struct {
+	Sets    map[string]Counter `json:"sets"`
+	Domains map[string]Counter `json:"domains"`
+	Devices map[string]Counter `json:"devices"`
+}
+
+// Attribution is what b4 knew about a connection when it queued it.
+type Attribution struct {
+	Set    string
+	Domain string
+	MAC    string
+}
+
+// Snapshot is the persisted accounting state.
+type Snapshot struct {
+	Since   time.Time          `json:"since"`
+	Total   *Totals            `json:"total"`
+	Daily   map[string]*Totals `json:"daily"`   // keyed by local date, 2006-01-02
+	Monthly map[string]*Totals `json:"monthly"` // keyed by local month, 2006-01
+}
+
+type options struct {
+	enabled         bool
+	interval        time.Duration
+	retentionDays   int
+	retentionMonths int
+	path            string
+	markSets        map[uint32]string // set ctmark -> set name
+}
+
+type flow struct {
+	attr Attribution
+	seen time.Time // last Track call
+}
+
+type lastCount struct {
+	id      uint32
+	bytes   uint64
+	packets uint64
+}
+
+// Tracker polls conntrack and attributes counter deltas to the flows b4
+// tracked while queueing them.
+type Tracker struct {
+	enabled atomic.Bool
+
+	mu      sync.Mutex
+	opts    options
+	flows   map[conntrack.Tuple]flow
+	last    map[conntrack.Tuple]lastCount
+	reseed  bool // next poll only records counters, they may be counted already
+	data    *Snapshot
+	dirty   bool
+	saved   time.Time
+	stop    chan struct{}
+	done    chan struct{}
+	dump    func() ([]conntrack.Entry, error)
+	nowFunc func() time.Time
+}
+
+var (
+	tracker     *Tracker
+	trackerOnce sync.Once
+)
+
+// Get returns the process-wide tracker.
+func Get() *Tracker {
+	trackerOnce.Do(func() {
+		tracker = newTracker()
+	})
+	return tracker
+}
+
+func newTracker() *Tracker {
+	return &Tracker{
+		flows:   make(map[conntrack.Tuple]flow),
+		last:    make(map[conntrack.Tuple]lastCount),
+		data:    newSnapshot(time.Now()),
+		dump:    conntrack.Dump,
+		nowFunc: time.Now,
+	}
+}
+
+func newSnapshot(since time.Time) *Snapshot {
+	return &Snapshot{
+		Since:   since,
+		Total:   newTotals(),
+		Daily:   make(map[string]*Totals),
+		Monthly: make(map[string]*Totals),
+	}
+}
+
+func newTotals() *Totals {
+	return &Totals{
+		Sets:    make(map[string]Counter),
+		Domains: make(map[string]Counter),
+		Devices: make(map[string]Counter),
+	}
+}
+
+// UpdateConfig applies the accounting settings of cfg to the shared tracker.
+func UpdateConfig(cfg *config.Config) {
+	Get().Configure(cfg)
+}
+
+// Track attributes the connection of a queued packet. It is a no-op while
+// accounting is disabled.
+func Track(proto uint8, src net.IP, sport uint16, dst net.IP, dport uint16, attr Attribution) {
+	t := Get()
+	if !t.enabled.Load() {
+		return
+	}
+	if key, ok := conntrack.TupleFromIP(proto, src, sport, dst, dport); ok {
+		t.track(key, attr)
+	}
+}
+
+// Stop halts polling and saves the accounting state.
+func Stop() {
+	Get().stopPolling()
+}
+
+func (t *Tracker) track(key conntrack.Tuple, attr Attribution) {
+	t.mu.Lock()
+	t.flows[key] = flow{attr: attr, seen: t.nowFunc()}
+	t.mu.Unlock()
+}
+
+// Configure starts, stops or retunes polling to match cfg.
+func (t *Tracker) Configure(cfg *config.Config) {
+	acc := cfg.System.Accounting
+	opts := options{
+		enabled:         acc.Enabled,
+		interval:        time.Duration(acc.PollInterval) * time.Second,
+		retentionDays:   acc.RetentionDays,
+		retentionMonths: acc.RetentionMonths,
+		markSets:        make(map[uint32]string),
+	}
+	if cfg.ConfigPath != "" {
+		opts.path = filepath.Join(filepath.Dir(cfg.ConfigPath), "accounting.json")
+	}
+	marks := cfg.CtMarks()
+	for _, set := range cfg.Sets {
+		if mark := marks[set.Id]; set.Enabled && mark != 0 {
+			opts.markSets[mark] = set.Name
+		}
+	}
+
+	if !opts.enabled {
+		t.stopPolling()
+		t.mu.Lock()
+		t.opts = opts
+		t.flows = make(map[conntrack.Tuple]flow)
+		t.last = make(map[conntrack.Tuple]lastCount)
+		t.mu.Unlock()
+		return
+	}
+
+	t.mu.Lock()
+	restart := t.stop == nil || t.opts.interval != opts.interval || t.opts.path != opts.path
+	// A new interval keeps polling the same totals, so the counters of the
+	// last poll stay the baseline
+	retune := t.stop != nil && t.opts.path == opts.path
+	t.mu.Unlock()
+	if restart {
+		t.stopPolling()
+	}
+
+	t.mu.Lock()
+	t.opts = opts
+	if restart && !retune {
+		t.reseed = true
+	}
+	t.mu.Unlock()
+
+	if restart {
+		t.startPolling()
+	}
+}
+
+func (t *Tracker) startPolling() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.WriteFile(acctSysctl, []byte("1"), 0644); err != nil {
+		log.Warnf("Failed to enable conntrack accounting (%s): %v", acctSysctl, err)
+	}
+	t.load()
+	t.saved = t.nowFunc()
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	t.enabled.Store(true)
+	go t.run(t.opts.interval, t.stop, t.done)
+	log.Infof("Traffic accounting started (poll every %s)", t.opts.interval)
+}
+
+func (t *Tracker) stopPolling() {
+	t.mu.Lock()
+	stop, done := t.stop, t.done
+	t.stop, t.done = nil, nil
+	t.mu.Unlock()
+	if stop == nil {
+		return
+	}
+
+	t.enabled.Store(false)
+	close(stop)
+	<-done
+
+	t.mu.Lock()
+	t.save()
+	t.mu.Unlock()
+}
+
+func (t *Tracker) run(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t.poll()
+		}
+	}
+}
+
+func (t *Tracker) poll() {
+	entries, err := t.dump()
+	if err != nil {
+		log.Errorf("Traffic accounting: %v", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.nowFunc()
+	t.apply(entries, now)
+	t.prune(now)
+	if t.dirty && now.Sub(t.saved) >= saveInterval {
+		t.save()
+	}
+	t.publish()
+}
+
+// apply adds the counter growth of every attributable conntrack entry since
+// the previous poll. Entries b4 never tracked are attributed by the set mark
+// of their rule group, when they carry one. The first poll after a start only
+// records the counters, since the loaded totals may include them.
+func (t *Tracker) apply(entries []conntrack.Entry, now time.Time) {
+	day := t.periodTotals(t.data.Daily, now.Format(dayLayout))
+	month := t.periodTotals(t.data.Monthly, now.Format(monthLayout))
+
+	live := make(map[conntrack.Tuple]lastCount, len(entries))
+	for _, e := range entries {
+		f, tracked := t.flows[e.Tuple]
+		if tracked {
+			f.seen = now
+			t.flows[e.Tuple] = f
+		} else {
+			name, ok := t.opts.markSets[e.Mark&config.SetCtMarkMask]
+			if !ok {
+				continue
+			}
+			f.attr = Attribution{Set: name}
+		}
+
+		cur := lastCount{id: e.ID, bytes: e.Bytes, packets: e.Packets}
+		live[e.Tuple] = cur
+		if t.reseed {
+			continue
+		}
+
+		delta := Counter{Bytes: e.Bytes, Packets: e.Packets}
+		if prev, ok := t.last[e.Tuple]; ok && prev.id == e.ID && prev.bytes <= e.Bytes && prev.packets <= e.Packets {
+			delta = Counter{Bytes: e.Bytes - prev.bytes, Packets: e.Packets - prev.packets}
+		}
+		if delta.Bytes == 0 && delta.Packets == 0 {
+			continue
+		}
+
+		for _, totals := range []*Totals{t.data.Total, day, month} {
+			totals.add(f.attr, delta)
+		}
+		t.dirty = true
+	}
+	t.last = live
+	t.reseed = false
+}
+
+func (t *Tracker) periodTotals(m map[string]*Totals, key string) *Totals {
+	totals, ok := m[key]
+	if !ok {
+		totals = newTotals()
+		m[key] = totals
+	}
+	return totals
+}
+
+func (tt *Totals) add(a Attribution, c Counter) {
+	addCounter(tt.Sets, a.Set, c)
+	addCounter(tt.Domains, a.Domain, c)
+	addCounter(tt.Devices, a.MAC, c)
+}
+
+func addCounter(m map[string]Counter, key string, c Counter) {
+	if key == "" {
+		return
+	}
+	cur := m[key]
+	cur.Bytes += c.Bytes
+	cur.Packets += c.Packets
+	m[key] = cur
+}
+
+// prune forgets flows whose conntrack entry is gone and rollups past their
+// retention.
+func (t *Tracker) prune(now time.Time) {
+	grace := 2 * t.opts.interval
+	for key, f := range t.flows {
+		if _, live := t.last[key]; !live && now.Sub(f.seen) > grace {
+			delete(t.flows, key)
+		}
+	}
+
+	cutoff := now.AddDate(0, 0, -t.opts.retentionDays).Format(dayLayout)
+	for key := range t.data.Daily {
+		if key <= cutoff {
+			delete(t.data.Daily, key)
+		}
+	}
+
+	months := make([]string, 0, len(t.data.Monthly))
+	for key := range t.data.Monthly {
+		months = append(months, key)
+	}
+	if len(months) > t.opts.retentionMonths {
+		sort.Strings(months)
+		for _, key := range months[:len(months)-t.opts.retentionMonths] {
+			delete(t.data.Monthly, key)
+		}
+	}
+}
+
+func (t *Tracker) publish() {
+	sets := make(map[string]uint64, len(t.data.Total.Sets))
+	for k, c := range t.data.Total.Sets {
+		sets[k] = c.Bytes
+	}
+	devices := make(map[string]uint64, len(t.data.Total.Devices))
+	for k, c := range t.data.Total.Devices {
+		devices[k] = c.Bytes
+	}
+	metrics.GetMetricsCollector().UpdateTraffic(sets, devices)
+}
+
+// Enabled reports whether accounting is currently polling.
+func (t *Tracker) Enabled() bool {
+	return t.enabled.Load()
+}
+
+// Snapshot returns a deep copy of the accounting totals.
+func (t *Tracker) Snapshot() *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &Snapshot{
+		Since:   t.data.Since,
+		Total:   t.data.Total.clone(),
+		Daily:   make(map[string]*Totals, len(t.data.Daily)),
+		Monthly: make(map[string]*Totals, len(t.data.Monthly)),
+	}
+	for k, v := range t.data.Daily {
+		s.Daily[k] = v.clone()
+	}
+	for k, v := range t.data.Monthly {
+		s.Monthly[k] = v.clone()
+	}
+	return s
+}
+
+// Reset clears all totals, including the saved ones.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.data = newSnapshot(t.nowFunc())
+	t.dirty = true
+	t.save()
+	t.publish()
+}
+
+func (tt *Totals) clone() *Totals {
+	c := newTotals()
+	for k, v := range tt.Sets {
+		c.Sets[k] = v
+	}
+	for k, v := range tt.Domains {
+		c.Domains[k] = v
+	}
+	for k, v := range tt.Devices {
+		c.Devices[k] = v
+	}
+	return c
+}
+
+// load replaces the in-memory totals with the saved ones, if any.
+func (t *Tracker) load() {
+	if t.opts.path == "" {
+		return
+	}
+	b, err := os.ReadFile(t.opts.path)
+	if err != nil {
+		return
+	}
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		log.Warnf("Ignoring corrupt accounting file %s: %v", t.opts.path, err)
+		return
+	}
+	s.Total = s.Total.ensure()
+	if s.Daily == nil {
+		s.Daily = make(map[string]*Totals)
+	}
+	if s.Monthly == nil {
+		s.Monthly = make(map[string]*Totals)
+	}
+	for k, v := range s.Daily {
+		s.Daily[k] = v.ensure()
+	}
+	for k, v := range s.Monthly {
+		s.Monthly[k] = v.ensure()
+	}
+	t.data = &s
+}
+
+// ensure fills in the maps a hand-edited or truncated file may lack.
+func (tt *Totals) ensure() *Totals {
+	if tt == nil {
+		return newTotals()
+	}
+	if tt.Sets == nil {
+		tt.Sets = make(map[string]Counter)
+	}
+	if tt.Domains == nil {
+		tt.Domains = make(map[string]Counter)
+	}
+	if tt.Devices == nil {
+		tt.Devices = make(map[string]Counter)
+	}
+	return tt
+}
+
+func (t *Tracker) save() {
+	t.saved = t.nowFunc()
+	if t.opts.path == "" || !t.dirty {
+		return
+	}
+	b, err := json.Marshal(t.data)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(t.opts.path), 0755); err != nil {
+		log.Errorf("Failed to create accounting directory: %v", err)
+		return
+	}
+	tmp := t.opts.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		log.Errorf("Failed to write accounting file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, t.opts.path); err != nil {
+		log.Errorf("Failed to save accounting file: %v", err)
+		return
+	}
+	t.dirty = false
+}
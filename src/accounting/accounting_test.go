@@ -0,0 +1,174 @@
+package accounting
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
+)
+
+// youtubeMark is the ctmark of the set the test connections are classified into.
+const youtubeMark uint32 = 2 << 16
+
+func newTestTracker(t *testing.T, entries *[]conntrack.Entry, now *time.Time) *Tracker {
+	t.Helper()
+	tr := newTracker()
+	tr.dump = func() ([]conntrack.Entry, error) { return *entries, nil }
+	tr.nowFunc = func() time.Time { return *now }
+
+	tr.opts = options{
+		enabled:         true,
+		interval:        10 * time.Second,
+		retentionDays:   2,
+		retentionMonths: 1,
+		path:            filepath.Join(t.TempDir(), "accounting.json"),
+		markSets:        map[uint32]string{youtubeMark: "youtube"},
+	}
+	tr.enabled.Store(true)
+	return tr
+}
+
+func TestTrackerAttribution(t *testing.T) {
+	now := time.Date(2026, 3, 31, 23, 59, 0, 0, time.Local)
+	var entries []conntrack.Entry
+	tr := newTestTracker(t, &entries, &now)
+
+	tracked := conntrack.Tuple{
+		Proto: 6,
+		Src:   netip.MustParseAddrPort("192.168.1.10:51000"),
+		Dst:   netip.MustParseAddrPort("142.250.74.14:443"),
+	}
+	tr.track(tracked, Attribution{Set: "youtube", Domain: "www.youtube.com", MAC: "AA:BB:CC:DD:EE:FF"})
+
+	marked := conntrack.Tuple{
+		Proto: 17,
+		Src:   netip.MustParseAddrPort("192.168.1.11:40000"),
+		Dst:   netip.MustParseAddrPort("142.250.74.15:443"),
+	}
+	stranger := conntrack.Tuple{
+		Proto: 6,
+		Src:   netip.MustParseAddrPort("192.168.1.12:40000"),
+		Dst:   netip.MustParseAddrPort("1.1.1.1:443"),
+	}
+
+	entries = []conntrack.Entry{
+		{ID: 1, Tuple: tracked, Bytes: 1000, Packets: 10},
+		{ID: 2, Tuple: marked, Mark: youtubeMark | 0x8000, Bytes: 500, Packets: 5},
+		{ID: 3, Tuple: stranger, Bytes: 9999, Packets: 9},
+	}
+	tr.poll()
+
+	now = now.Add(2 * time.Minute) // next day and next month
+	entries = []conntrack.Entry{
+		{ID: 1, Tuple: tracked, Bytes: 1600, Packets: 16},
+		{ID: 4, Tuple: marked, Bytes: 200, Packets: 2, Mark: youtubeMark}, // reused tuple, new connection
+	}
+	tr.poll()
+
+	s := tr.Snapshot()
+	if got := s.Total.Sets["youtube"]; got.Bytes != 2300 || got.Packets != 23 {
+		t.Errorf("set total = %+v, want 2300 bytes 23 packets", got)
+	}
+	if got := s.Total.Domains["www.youtube.com"].Bytes; got != 1600 {
+		t.Errorf("domain bytes = %d, want 1600", got)
+	}
+	if got := s.Total.Devices["AA:BB:CC:DD:EE:FF"].Bytes; got != 1600 {
+		t.Errorf("device bytes = %d, want 1600", got)
+	}
+	if len(s.Total.Sets) != 1 {
+		t.Errorf("untracked, unmarked traffic was counted: %+v", s.Total.Sets)
+	}
+
+	if got := s.Daily["2026-03-31"].Sets["youtube"].Bytes; got != 1500 {
+		t.Errorf("day 1 bytes = %d, want 1500", got)
+	}
+	if got := s.Daily["2026-04-01"].Sets["youtube"].Bytes; got != 800 {
+		t.Errorf("day 2 bytes = %d, want 800", got)
+	}
+	if _, ok := s.Monthly["2026-03"]; ok {
+		t.Error("monthly rollup beyond retention was kept")
+	}
+	if got := s.Monthly["2026-04"].Sets["youtube"].Bytes; got != 800 {
+		t.Errorf("month bytes = %d, want 800", got)
+	}
+
+	now = now.Add(3 * 24 * time.Hour)
+	entries = nil
+	tr.poll()
+	s = tr.Snapshot()
+	if _, ok := s.Daily["2026-03-31"]; ok {
+		t.Error("daily rollup beyond retention was kept")
+	}
+	if _, ok := tr.flows[tracked]; ok {
+		t.Error("closed flow was not forgotten")
+	}
+}
+
+func TestTrackerPersistence(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.Local)
+	var entries []conntrack.Entry
+	tr := newTestTracker(t, &entries, &now)
+
+	key := conntrack.Tuple{Proto: 6, Src: netip.MustParseAddrPort("10.0.0.2:1000"), Dst: netip.MustParseAddrPort("10.0.0.1:443")}
+	tr.track(key, Attribution{Set: "youtube"})
+	entries = []conntrack.Entry{{ID: 1, Tuple: key, Bytes: 42, Packets: 1}}
+	tr.poll()
+	tr.save()
+
+	loaded := newTracker()
+	loaded.opts = tr.opts
+	loaded.load()
+	if got := loaded.Snapshot().Total.Sets["youtube"].Bytes; got != 42 {
+		t.Errorf("loaded bytes = %d, want 42", got)
+	}
+}
+
+func TestTrackerRestartDoesNotRecount(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.Local)
+	var entries []conntrack.Entry
+	tr := newTestTracker(t, &entries, &now)
+	defer tr.stopPolling()
+
+	cfg := config.NewConfig()
+	cfg.ConfigPath = filepath.Join(filepath.Dir(tr.opts.path), "b4.json")
+	cfg.System.Accounting.Enabled = true
+	cfg.System.Accounting.PollInterval = 3600
+	tr.Configure(&cfg)
+
+	key := conntrack.Tuple{Proto: 6, Src: netip.MustParseAddrPort("10.0.0.2:1000"), Dst: netip.MustParseAddrPort("10.0.0.1:443")}
+	tr.track(key, Attribution{Set: "youtube"})
+	entries = []conntrack.Entry{{ID: 1, Tuple: key, Bytes: 100, Packets: 1}}
+	tr.poll() // seeds the counters of connections that predate the start
+	entries[0].Bytes, entries[0].Packets = 300, 3
+	tr.poll()
+
+	bytes := func() uint64 { return tr.Snapshot().Total.Sets["youtube"].Bytes }
+	if got := bytes(); got != 200 {
+		t.Fatalf("bytes = %d, want 200", got)
+	}
+
+	// Same config, a new interval, a new file: the entries are unchanged
+	for i, interval := range []int{3600, 1800, 1800} {
+		cfg.System.Accounting.PollInterval = interval
+		if i == 2 {
+			cfg.ConfigPath = filepath.Join(t.TempDir(), "b4.json")
+		}
+		tr.Configure(&cfg)
+		tr.poll()
+		if got := bytes(); got != 200 {
+			t.Errorf("after reconfigure %d: bytes = %d, want 200", i, got)
+		}
+	}
+
+	// A new interval keeps counting growth since the last poll
+	cfg.System.Accounting.PollInterval = 3600
+	tr.Configure(&cfg)
+	entries[0].Bytes = 350
+	tr.poll()
+	if got := bytes(); got != 250 {
+		t.Errorf("bytes = %d, want 250", got)
+	}
+}
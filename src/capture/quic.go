@@ -0,0 +1,36 @@
+package capture
+
+import (
+	"crypto/rand"
+
+	"github.com/daniellavrushin/b4/quic"
+)
+
+// GenerateQUICInitial wraps a generated ClientHello for domain into a client
+// Initial protected with keys derived from dcid. Empty connection IDs are
+// replaced with random 8-byte ones; the packet is padded to at least minSize.
+func GenerateQUICInitial(domain string, version uint32, dcid, scid []byte, minSize int) ([]byte, error) {
+	record, err := GenerateTLSClientHello(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dcid) == 0 {
+		dcid = make([]byte, 8)
+		if _, err := rand.Read(dcid); err != nil {
+			return nil, err
+		}
+	}
+	if len(scid) == 0 {
+		scid = make([]byte, 8)
+		if _, err := rand.Read(scid); err != nil {
+			return nil, err
+		}
+	}
+	if minSize < quic.MinInitialSize {
+		minSize = quic.MinInitialSize
+	}
+
+	frames := quic.AppendCryptoFrame(nil, 0, record[5:]) // strip TLS record header
+	return quic.BuildInitial(version, dcid, scid, 0, frames, minSize)
+}
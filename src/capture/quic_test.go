@@ -0,0 +1,32 @@
+package capture
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/daniellavrushin/b4/quic"
+	"github.com/daniellavrushin/b4/sni"
+)
+
+func TestGenerateQUICInitial(t *testing.T) {
+	dcid := []byte{0xde, 0xc0, 0x79, 0x00, 0x11, 0x22, 0x33, 0x44}
+	scid := []byte{0x55, 0x66, 0x77, 0x88}
+
+	for _, ver := range []uint32{quic.VersionV1, quic.VersionV2} {
+		pkt, err := GenerateQUICInitial("www.google.com", ver, dcid, scid, 1350)
+		if err != nil {
+			t.Fatalf("version %x: %v", ver, err)
+		}
+		if len(pkt) < 1350 {
+			t.Errorf("version %x: packet not padded: %d bytes", ver, len(pkt))
+		}
+		if !bytes.Equal(quic.ParseDCID(pkt), dcid) || !bytes.Equal(quic.ParseSCID(pkt), scid) {
+			t.Errorf("version %x: connection IDs not preserved", ver)
+		}
+
+		host, ok := sni.ParseQUICClientHelloSNI(pkt)
+		if !ok || host != "www.google.com" {
+			t.Errorf("version %x: decrypted SNI = %q, %v", ver, host, ok)
+		}
+	}
+}
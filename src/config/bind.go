@@ -26,4 +26,7 @@ func (c *Config) BindFlags(cmd *cobra.Command) {
 
 	// Web Server configuration
 	cmd.Flags().IntVar(&c.System.WebServer.Port, "web-port", c.System.WebServer.Port, "Port for internal web server (0 disables)")
+
+	// Control socket
+	cmd.Flags().StringVar(&c.System.Control.Socket, "control-socket", c.System.Control.Socket, "Unix socket for health checks (empty disables)")
 }
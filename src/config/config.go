@@ -9,6 +9,9 @@ var (
 	NEW_SET_ID  = "00000000-0000-0000-0000-000000000000"
 )
 
+// SetCtMarkMask holds the conntrack mark bits carrying a set's rule group.
+const SetCtMarkMask uint32 = 0x00ff0000
+
 type Config struct {
 	Version    int    `json:"version" bson:"version"`
 	ConfigPath string `json:"-" bson:"-"`
@@ -29,6 +32,10 @@ var DefaultSetConfig = SetConfig{
 		FakeSeqLength:  6,
 		FakeLen:        64,
 		FakingStrategy: "none",
+		FakePayload:    UDPFakeZero,
+		FakeSNI:        DefaultUDPFakeSNI,
+		CryptoSplit:    ConfigOff,
+		CryptoPadding:  false,
 		DPortFilter:    "",
 		FilterQUIC:     "disabled",
 		FilterSTUN:     true,
@@ -78,6 +85,14 @@ var DefaultSetConfig = SetConfig{
 		TargetDNS:     "",
 	},
 
+	Schedule: ScheduleConfig{
+		Enabled: false,
+		Days:    []string{},
+		Ranges:  []ScheduleTimeRange{},
+	},
+
+	Pipeline: DefaultPipeline(),
+
 	Fragmentation: FragmentationConfig{
 		Strategy:          "tcp", // "tcp", "ip", "tls", "oob", "none", "combo", "hybrid", "disorder",  "extsplit", "firstbyte"
 		ReverseOrder:      true,
@@ -119,6 +134,8 @@ var DefaultSetConfig = SetConfig{
 		TLSMod:            []string{},
 		TimestampDecrease: 600000, // Default value for timestamp faking strategy
 		TCPMD5:            false,
+		ECH:               ECHKeep,
+		MirrorSNIs:        []string{DefaultUDPFakeSNI},
 
 		SNIMutation: SNIMutationConfig{
 			Mode:         ConfigOff, // "off", "random", "grease", "padding", "fakeext", "fakesni", "advanced"
@@ -127,6 +144,13 @@ var DefaultSetConfig = SetConfig{
 			FakeExtCount: 5,
 			FakeSNIs:     []string{"ya.ru", "vk.com", "max.ru"},
 		},
+
+		AutoTTL: AutoTTLConfig{
+			Enabled: false,
+			Delta:   2,
+			Min:     3,
+			Max:     20,
+		},
 	},
 
 	Targets: TargetsConfig{
@@ -134,7 +158,12 @@ var DefaultSetConfig = SetConfig{
 		IPs:               []string{},
 		GeoSiteCategories: []string{},
 		GeoIpCategories:   []string{},
+		ASNs:              []string{},
 		SourceDevices:     []string{},
+		ECHMatch:          ECHMatchOuter,
+
+		Fingerprints:        []string{},
+		ExcludeFingerprints: []string{},
 	},
 }
 
@@ -155,11 +184,25 @@ var DefaultConfig = Config{
 			WhiteIsBlack: false,
 			Mac:          []string{},
 			MSSClamps:    []DeviceMSSClamp{},
+			Profiles:     []DeviceProfile{},
 		},
 		MSSClamp: MSSClampConfig{
 			Enabled: false,
 			Size:    88,
 		},
+		StickySets: false,
+		QUICHold:   50,
+		TCPHold:    150,
+		Injection: InjectionConfig{
+			Workers:   32,
+			QueueSize: 1024,
+			Overflow:  InjectOverflowAccept,
+		},
+		Watchdog: WatchdogConfig{
+			Enabled:      true,
+			StallTimeout: 10,
+			MaxRestarts:  3,
+		},
 	},
 
 	Sets: []*SetConfig{},
@@ -172,6 +215,10 @@ var DefaultConfig = Config{
 			GeoIpPath:   "",
 			GeoSiteURL:  "",
 			GeoIpURL:    "",
+
+			ASNSource:       ASNSourceRipestat,
+			ASNDatabasePath: "",
+			ASNRefreshHours: 24,
 		},
 
 		Tables: TablesConfig{
@@ -179,6 +226,9 @@ var DefaultConfig = Config{
 			SkipSetup:           false,
 			Masquerade:          false,
 			MasqueradeInterface: "",
+			Prefilter:           false,
+			PrefilterTimeout:    3600,
+			Backend:             "auto",
 		},
 
 		WebServer: WebServerConfig{
@@ -212,6 +262,16 @@ var DefaultConfig = Config{
 		API: ApiConfig{
 			IPInfoToken: "",
 		},
+		Accounting: AccountingConfig{
+			Enabled:         false,
+			PollInterval:    15,
+			RetentionDays:   62,
+			RetentionMonths: 24,
+		},
+
+		Control: ControlConfig{
+			Socket: "/var/run/b4.sock",
+		},
 	},
 }
 
@@ -220,13 +280,20 @@ func NewSetConfig() SetConfig {
 
 	cfg.TCP.Win.Values = append(make([]int, 0), DefaultSetConfig.TCP.Win.Values...)
 	cfg.Faking.SNIMutation.FakeSNIs = append(make([]string, 0), DefaultSetConfig.Faking.SNIMutation.FakeSNIs...)
+	cfg.Faking.MirrorSNIs = append(make([]string, 0), DefaultSetConfig.Faking.MirrorSNIs...)
 	cfg.Targets.SNIDomains = append(make([]string, 0), DefaultSetConfig.Targets.SNIDomains...)
 	cfg.Targets.IPs = append(make([]string, 0), DefaultSetConfig.Targets.IPs...)
 	cfg.Targets.GeoSiteCategories = append(make([]string, 0), DefaultSetConfig.Targets.GeoSiteCategories...)
 	cfg.Targets.GeoIpCategories = append(make([]string, 0), DefaultSetConfig.Targets.GeoIpCategories...)
+	cfg.Targets.ASNs = append(make([]string, 0), DefaultSetConfig.Targets.ASNs...)
 	cfg.Targets.SourceDevices = append(make([]string, 0), DefaultSetConfig.Targets.SourceDevices...)
+	cfg.Targets.Fingerprints = append(make([]string, 0), DefaultSetConfig.Targets.Fingerprints...)
+	cfg.Targets.ExcludeFingerprints = append(make([]string, 0), DefaultSetConfig.Targets.ExcludeFingerprints...)
 	cfg.Fragmentation.SeqOverlapPattern = append(make([]string, 0), DefaultSetConfig.Fragmentation.SeqOverlapPattern...)
 	cfg.Faking.TLSMod = append(make([]string, 0), DefaultSetConfig.Faking.TLSMod...)
+	cfg.Schedule.Days = append(make([]string, 0), DefaultSetConfig.Schedule.Days...)
+	cfg.Schedule.Ranges = append(make([]ScheduleTimeRange, 0), DefaultSetConfig.Schedule.Ranges...)
+	cfg.Pipeline = DefaultPipeline()
 
 	return cfg
 }
@@ -2,8 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/daniellavrushin/b4/log"
@@ -82,3 +86,135 @@ func (da *DeviceAliases) GetAll() map[string]string {
 	}
 	return copy
 }
+
+// validateDeviceProfiles normalizes profile MACs and drops invalid entries.
+// A later profile for the same MAC replaces an earlier one. A restricted
+// profile left without any existing set is an error, since dropping its sets
+// would apply all of them to the device.
+func (c *Config) validateDeviceProfiles() error {
+	setIds := make(map[string]bool, len(c.Sets))
+	for _, set := range c.Sets {
+		setIds[set.Id] = true
+	}
+
+	index := make(map[string]int)
+	profiles := make([]DeviceProfile, 0, len(c.Queue.Devices.Profiles))
+	for _, p := range c.Queue.Devices.Profiles {
+		hw, err := net.ParseMAC(strings.TrimSpace(p.Mac))
+		if err != nil || len(hw) != 6 {
+			log.Warnf("Ignoring device profile with invalid MAC %q", p.Mac)
+			continue
+		}
+		p.Mac = strings.ToUpper(hw.String())
+		p.Alias = strings.TrimSpace(p.Alias)
+
+		sets := make([]string, 0, len(p.Sets))
+		for _, id := range p.Sets {
+			if setIds[id] {
+				sets = append(sets, id)
+			} else {
+				log.Warnf("Device profile %s: ignoring unknown set %q", p.Mac, id)
+			}
+		}
+		if len(p.Sets) > 0 && len(sets) == 0 && !p.Bypass {
+			return fmt.Errorf("device profile %s: none of its allowed sets exist", p.Mac)
+		}
+		p.Sets = sets
+
+		if p.MSSClamp < 0 {
+			p.MSSClamp = 0
+		}
+		if p.MSSClamp > 0 {
+			p.MSSClamp = min(max(p.MSSClamp, 10), 1460)
+		}
+
+		p.DNSTarget = strings.TrimSpace(p.DNSTarget)
+		if p.DNSTarget != "" && net.ParseIP(p.DNSTarget) == nil {
+			log.Warnf("Device profile %s: ignoring invalid DNS target %q", p.Mac, p.DNSTarget)
+			p.DNSTarget = ""
+		}
+
+		if i, ok := index[p.Mac]; ok {
+			profiles[i] = p
+			continue
+		}
+		index[p.Mac] = len(profiles)
+		profiles = append(profiles, p)
+	}
+	c.Queue.Devices.Profiles = profiles
+	return nil
+}
+
+// GetDeviceProfile returns the profile of mac, enabled or not.
+func (c *Config) GetDeviceProfile(mac string) *DeviceProfile {
+	for i := range c.Queue.Devices.Profiles {
+		if strings.EqualFold(c.Queue.Devices.Profiles[i].Mac, mac) {
+			return &c.Queue.Devices.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// DeviceSetAssignments maps the MAC of every enabled profile that restricts
+// its sets to the allowed set IDs. Bypassed devices map to an empty set.
+func (c *Config) DeviceSetAssignments() map[string]map[string]bool {
+	result := make(map[string]map[string]bool)
+	for _, p := range c.Queue.Devices.Profiles {
+		if !p.Enabled || (!p.Bypass && len(p.Sets) == 0) {
+			continue
+		}
+		allowed := make(map[string]bool, len(p.Sets))
+		if !p.Bypass {
+			for _, id := range p.Sets {
+				allowed[id] = true
+			}
+		}
+		result[p.Mac] = allowed
+	}
+	return result
+}
+
+// DeviceDNSTarget returns the DNS redirect target of mac's enabled profile,
+// or "" when the device has none.
+func (c *Config) DeviceDNSTarget(mac string) string {
+	if mac == "" {
+		return ""
+	}
+	if p := c.GetDeviceProfile(mac); p != nil && p.Enabled && !p.Bypass {
+		return p.DNSTarget
+	}
+	return ""
+}
+
+// DeviceFilter returns the source MACs the firewall filters on and whether
+// they are excluded (true) or the only ones processed (false). Bypassed
+// profiles are excluded unless an allow list is in place; there the matcher
+// ignores them instead, since dropping them could empty the list.
+func (c *Config) DeviceFilter() (macs []string, exclude bool) {
+	devices := c.Queue.Devices
+	if devices.Enabled && len(devices.Mac) > 0 {
+		for _, mac := range devices.Mac {
+			if mac = strings.ToUpper(strings.TrimSpace(mac)); mac != "" {
+				macs = append(macs, mac)
+			}
+		}
+		exclude = devices.WhiteIsBlack
+		if !exclude {
+			return macs, false
+		}
+	}
+
+	for _, p := range devices.Profiles {
+		if p.Enabled && p.Bypass && !slices.Contains(macs, p.Mac) {
+			macs = append(macs, p.Mac)
+		}
+	}
+	return macs, len(macs) > 0
+}
+
+// DeviceFilterFingerprint changes whenever the firewall's MAC filter does.
+func (c *Config) DeviceFilterFingerprint() string {
+	macs, exclude := c.DeviceFilter()
+	sorted := slices.Sorted(slices.Values(macs))
+	return fmt.Sprintf("%v:%s", exclude, strings.Join(sorted, ","))
+}
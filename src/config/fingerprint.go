@@ -0,0 +1,69 @@
+package config
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/daniellavrushin/b4/log"
+)
+
+var (
+	ja3Pattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	ja4Pattern = regexp.MustCompile(`^[tqd][0-9a-z]{2}[di][0-9]{4}[0-9a-z]{2}_[0-9a-f]{12}_[0-9a-f]{12}$`)
+)
+
+// validFingerprint accepts a JA3 hash, a JA4 fingerprint, or a prefix of
+// either ending in "*".
+func validFingerprint(fp string) bool {
+	if prefix, ok := strings.CutSuffix(fp, "*"); ok {
+		return prefix != "" && !strings.ContainsAny(prefix, "* ")
+	}
+	return ja3Pattern.MatchString(fp) || ja4Pattern.MatchString(fp)
+}
+
+func normalizeFingerprints(setName string, fps []string) []string {
+	out := make([]string, 0, len(fps))
+	for _, fp := range fps {
+		fp = strings.ToLower(strings.TrimSpace(fp))
+		if fp == "" || slices.Contains(out, fp) {
+			continue
+		}
+		if !validFingerprint(fp) {
+			log.Warnf("Set '%s': ignoring invalid fingerprint %q", setName, fp)
+			continue
+		}
+		out = append(out, fp)
+	}
+	return out
+}
+
+func matchFingerprint(fps []string, ja3, ja4 string) bool {
+	for _, fp := range fps {
+		if prefix, ok := strings.CutSuffix(fp, "*"); ok {
+			if ja3 != "" && strings.HasPrefix(ja3, prefix) || ja4 != "" && strings.HasPrefix(ja4, prefix) {
+				return true
+			}
+		} else if fp == ja3 || fp == ja4 {
+			return true
+		}
+	}
+	return false
+}
+
+// FingerprintAllowed reports whether a ClientHello with these fingerprints
+// may use the set.
+func (t *TargetsConfig) FingerprintAllowed(ja3, ja4 string) bool {
+	if matchFingerprint(t.ExcludeFingerprints, ja3, ja4) {
+		return false
+	}
+	return len(t.Fingerprints) == 0 || matchFingerprint(t.Fingerprints, ja3, ja4)
+}
+
+// FingerprintOnly reports whether the set targets ClientHellos by their
+// fingerprint alone, whatever their destination.
+func (t *TargetsConfig) FingerprintOnly() bool {
+	return len(t.Fingerprints) > 0 &&
+		len(t.SNIDomains) == 0 && len(t.GeoSiteCategories) == 0 &&
+		len(t.IPs) == 0 && len(t.GeoIpCategories) == 0 && len(t.ASNs) == 0
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+const (
+	testJA3 = "cd08e31494f9531f560d64c695473da9"
+	testJA4 = "t13d1516h2_8daaf6152771_e5627efa2ab1"
+)
+
+func TestNormalizeFingerprints(t *testing.T) {
+	got := normalizeFingerprints("test", []string{" CD08E31494F9531F560D64C695473DA9", testJA3, "t13d*", "nope", "", "*", testJA4})
+	want := []string{testJA3, "t13d*", testJA4}
+	if !slices.Equal(got, want) {
+		t.Errorf("normalizeFingerprints = %v, want %v", got, want)
+	}
+}
+
+func TestFingerprintAllowed(t *testing.T) {
+	tg := TargetsConfig{}
+	if !tg.FingerprintAllowed(testJA3, testJA4) {
+		t.Error("set without fingerprints should allow any ClientHello")
+	}
+
+	tg.Fingerprints = []string{"t13d15*"}
+	if !tg.FingerprintAllowed("", testJA4) || tg.FingerprintAllowed(testJA3, "t12d1516h2_8daaf6152771_e5627efa2ab1") {
+		t.Error("JA4 prefix not applied")
+	}
+	if tg.FingerprintAllowed("", "") {
+		t.Error("unknown fingerprint allowed by a fingerprint-limited set")
+	}
+
+	tg = TargetsConfig{ExcludeFingerprints: []string{testJA3}}
+	if tg.FingerprintAllowed(testJA3, testJA4) || !tg.FingerprintAllowed("", testJA4) {
+		t.Error("excluded JA3 not applied")
+	}
+}
+
+func TestFingerprintOnly(t *testing.T) {
+	tg := TargetsConfig{Fingerprints: []string{testJA4}}
+	if !tg.FingerprintOnly() {
+		t.Error("set with only fingerprints should target by fingerprint")
+	}
+	tg.SNIDomains = []string{"example.com"}
+	if tg.FingerprintOnly() {
+		t.Error("set with domains should only be limited by fingerprint")
+	}
+}
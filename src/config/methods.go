@@ -3,11 +3,14 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/daniellavrushin/b4/geodat"
 	"github.com/daniellavrushin/b4/log"
@@ -116,6 +119,35 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.System.Tables.PrefilterTimeout <= 0 {
+		c.System.Tables.PrefilterTimeout = DefaultConfig.System.Tables.PrefilterTimeout
+	}
+
+	switch c.System.Tables.Backend {
+	case "auto", "nftables", "iptables", "netlink":
+	default:
+		c.System.Tables.Backend = DefaultConfig.System.Tables.Backend
+	}
+
+	switch c.System.Geo.ASNSource {
+	case ASNSourceRipestat, ASNSourceFile:
+	default:
+		c.System.Geo.ASNSource = DefaultConfig.System.Geo.ASNSource
+	}
+	if c.System.Geo.ASNRefreshHours < 1 {
+		c.System.Geo.ASNRefreshHours = DefaultConfig.System.Geo.ASNRefreshHours
+	}
+
+	if c.System.Accounting.PollInterval < 5 {
+		c.System.Accounting.PollInterval = DefaultConfig.System.Accounting.PollInterval
+	}
+	if c.System.Accounting.RetentionDays < 1 {
+		c.System.Accounting.RetentionDays = DefaultConfig.System.Accounting.RetentionDays
+	}
+	if c.System.Accounting.RetentionMonths < 1 {
+		c.System.Accounting.RetentionMonths = DefaultConfig.System.Accounting.RetentionMonths
+	}
+
 	c.MainSet = nil
 	for _, set := range c.Sets {
 		if set.Id == MAIN_SET_ID {
@@ -141,6 +173,70 @@ func (c *Config) Validate() error {
 			}
 		}
 
+		asns := make([]string, 0, len(set.Targets.ASNs))
+		for _, a := range set.Targets.ASNs {
+			n, err := geodat.ParseASN(a)
+			if err != nil {
+				log.Warnf("Set '%s': ignoring %v", set.Name, err)
+				continue
+			}
+			asns = append(asns, fmt.Sprintf("AS%d", n))
+		}
+		set.Targets.ASNs = asns
+
+		set.Targets.Fingerprints = normalizeFingerprints(set.Name, set.Targets.Fingerprints)
+		set.Targets.ExcludeFingerprints = normalizeFingerprints(set.Name, set.Targets.ExcludeFingerprints)
+
+		set.Schedule.normalize(set.Name)
+		set.Pipeline = normalizePipeline(set.Name, set.Pipeline)
+
+		switch set.Targets.ECHMatch {
+		case ECHMatchOuter, ECHMatchLearned, ECHMatchBoth:
+		default:
+			set.Targets.ECHMatch = ECHMatchOuter
+		}
+		switch set.Faking.ECH {
+		case ECHKeep, ECHStrip:
+		default:
+			set.Faking.ECH = ECHKeep
+		}
+
+		mirrorSNIs := make([]string, 0, len(set.Faking.MirrorSNIs))
+		for _, d := range set.Faking.MirrorSNIs {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if d != "" && !slices.Contains(mirrorSNIs, d) {
+				mirrorSNIs = append(mirrorSNIs, d)
+			}
+		}
+		if len(mirrorSNIs) == 0 {
+			mirrorSNIs = append(mirrorSNIs, DefaultUDPFakeSNI)
+		}
+		set.Faking.MirrorSNIs = mirrorSNIs
+
+		if at := &set.Faking.AutoTTL; at.Enabled {
+			if at.Min < 1 {
+				at.Min = 1
+			}
+			if at.Max < at.Min {
+				at.Max = at.Min
+			}
+		}
+
+		switch set.UDP.FakePayload {
+		case UDPFakeZero, UDPFakeInitial:
+		default:
+			set.UDP.FakePayload = UDPFakeZero
+		}
+		switch set.UDP.CryptoSplit {
+		case ConfigOff, CryptoSplitOn, CryptoSplitReorder:
+		default:
+			set.UDP.CryptoSplit = ConfigOff
+		}
+		set.UDP.FakeSNI = strings.TrimSpace(set.UDP.FakeSNI)
+		if set.UDP.FakeSNI == "" {
+			set.UDP.FakeSNI = DefaultUDPFakeSNI
+		}
+
 		if set.TCP.Duplicate.Enabled {
 			if set.TCP.Duplicate.Count < 1 {
 				set.TCP.Duplicate.Count = 1
@@ -148,7 +244,7 @@ func (c *Config) Validate() error {
 			if set.TCP.Duplicate.Count > 10 {
 				set.TCP.Duplicate.Count = 10
 			}
-			if len(set.Targets.IPs) == 0 && len(set.Targets.GeoIpCategories) == 0 {
+			if len(set.Targets.IPs) == 0 && len(set.Targets.GeoIpCategories) == 0 && len(set.Targets.ASNs) == 0 {
 				log.Warnf("Set '%s' has duplication enabled but no IP targets configured", set.Name)
 			}
 		}
@@ -162,7 +258,11 @@ func (c *Config) Validate() error {
 		if set.UDP.ConnBytesLimit > c.MainSet.UDP.ConnBytesLimit {
 			set.UDP.ConnBytesLimit = c.MainSet.UDP.ConnBytesLimit
 		}
-
+		// Without IP targets only the ctmark leads into the set's rule group
+		if c.HasRuleGroup(set) && !c.Queue.StickySets &&
+			len(set.Targets.IPs) == 0 && len(set.Targets.GeoIpCategories) == 0 && len(set.Targets.ASNs) == 0 {
+			log.Warnf("Set '%s' has its own connbytes limits or UDP ports but no IP targets; they only apply with sticky sets enabled", set.Name)
+		}
 	}
 
 	if len(c.MainSet.Targets.GeoSiteCategories) > 0 && c.System.Geo.GeoSitePath == "" {
@@ -195,10 +295,42 @@ func (c *Config) Validate() error {
 		dc.Mac = strings.ToUpper(strings.TrimSpace(dc.Mac))
 	}
 
+	if err := c.validateDeviceProfiles(); err != nil {
+		return err
+	}
+
 	if c.Queue.Threads < 1 {
 		return fmt.Errorf("threads must be at least 1")
 	}
 
+	inj := &c.Queue.Injection
+	if inj.Workers < 1 {
+		inj.Workers = DefaultConfig.Queue.Injection.Workers
+	}
+	if inj.QueueSize < inj.Workers {
+		inj.QueueSize = max(DefaultConfig.Queue.Injection.QueueSize, inj.Workers)
+	}
+	switch inj.Overflow {
+	case InjectOverflowAccept, InjectOverflowDrop:
+	default:
+		inj.Overflow = InjectOverflowAccept
+	}
+
+	if c.Queue.QUICHold < 0 {
+		c.Queue.QUICHold = 0
+	}
+	if c.Queue.TCPHold < 0 {
+		c.Queue.TCPHold = 0
+	}
+
+	wd := &c.Queue.Watchdog
+	if wd.StallTimeout < 1 {
+		wd.StallTimeout = DefaultConfig.Queue.Watchdog.StallTimeout
+	}
+	if wd.MaxRestarts < 1 {
+		wd.MaxRestarts = DefaultConfig.Queue.Watchdog.MaxRestarts
+	}
+
 	if c.Queue.StartNum < 0 || c.Queue.StartNum > 65535 {
 		return fmt.Errorf("queue-num must be between 0 and 65535")
 	}
@@ -309,6 +441,15 @@ func (c *Config) GetTargetsForSetWithCache(set *SetConfig, geositeDomains, geoip
 		}
 	}
 
+	if len(set.Targets.ASNs) > 0 {
+		// A failed lookup falls back to cached prefixes; don't drop the set over it
+		asnIps, err := geodat.LoadASNPrefixes(c.ASNOptions(), set.Targets.ASNs)
+		if err != nil {
+			log.Warnf("Failed to resolve ASNs for set '%s': %v", set.Name, err)
+		}
+		ips = append(ips, asnIps...)
+	}
+
 	if len(set.Targets.IPs) > 0 {
 		ips = append(ips, set.Targets.IPs...)
 	}
@@ -317,6 +458,55 @@ func (c *Config) GetTargetsForSetWithCache(set *SetConfig, geositeDomains, geoip
 	return domains, ips, nil
 }
 
+// ASNOptions returns the ASN resolver settings. The prefix cache lives next
+// to the config file.
+func (c *Config) ASNOptions() geodat.ASNOptions {
+	opts := geodat.ASNOptions{
+		Source:       c.System.Geo.ASNSource,
+		DatabasePath: c.System.Geo.ASNDatabasePath,
+		Refresh:      time.Duration(c.System.Geo.ASNRefreshHours) * time.Hour,
+	}
+	if c.ConfigPath != "" {
+		opts.CachePath = filepath.Join(filepath.Dir(c.ConfigPath), "asn_cache.json")
+	}
+	return opts
+}
+
+// ReloadASNTargets reloads the match lists of the enabled sets that target
+// ASNs and leaves the other sets as they are. It reports whether any set was
+// reloaded.
+func (c *Config) ReloadASNTargets() (bool, error) {
+	reloaded := false
+	for _, set := range c.Sets {
+		if !set.Enabled || len(set.Targets.ASNs) == 0 {
+			continue
+		}
+		if _, _, err := c.GetTargetsForSet(set); err != nil {
+			return false, err
+		}
+		reloaded = true
+	}
+	return reloaded, nil
+}
+
+// CollectASNs returns the ASNs targeted by all enabled sets.
+func (cfg *Config) CollectASNs() []string {
+	seen := make(map[string]bool)
+	var asns []string
+	for _, set := range cfg.Sets {
+		if !set.Enabled {
+			continue
+		}
+		for _, a := range set.Targets.ASNs {
+			if !seen[a] {
+				seen[a] = true
+				asns = append(asns, a)
+			}
+		}
+	}
+	return asns
+}
+
 func (c *Config) GetSetById(id string) *SetConfig {
 	for _, set := range c.Sets {
 		if set.Id == id {
@@ -326,6 +516,36 @@ func (c *Config) GetSetById(id string) *SetConfig {
 	return nil
 }
 
+// MatchesQUICBySNI reports whether an enabled set picks QUIC flows by the
+// ClientHello they carry, by domain or fingerprint, rather than by address.
+func (c *Config) MatchesQUICBySNI() bool {
+	for _, set := range c.Sets {
+		if !set.Enabled || set.UDP.FilterQUIC != "parse" {
+			continue
+		}
+		t := &set.Targets
+		if len(t.DomainsToMatch) > 0 || len(t.Fingerprints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesTCPBySNI reports whether an enabled set picks TCP flows by the
+// ClientHello they carry, by domain or fingerprint, rather than by address.
+func (c *Config) MatchesTCPBySNI() bool {
+	for _, set := range c.Sets {
+		if !set.Enabled {
+			continue
+		}
+		t := &set.Targets
+		if len(t.DomainsToMatch) > 0 || len(t.Fingerprints) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (set *SetConfig) ResetToDefaults() {
 	defaultSet := DefaultSetConfig
 
@@ -345,12 +565,26 @@ func (set *SetConfig) ResetToDefaults() {
 	set.Faking.SNIMutation.FakeSNIs = make([]string, len(defaultSet.Faking.SNIMutation.FakeSNIs))
 	copy(set.Faking.SNIMutation.FakeSNIs, defaultSet.Faking.SNIMutation.FakeSNIs)
 
+	set.Faking.MirrorSNIs = make([]string, len(defaultSet.Faking.MirrorSNIs))
+	copy(set.Faking.MirrorSNIs, defaultSet.Faking.MirrorSNIs)
+
 	set.Fragmentation.SeqOverlapPattern = make([]string, len(defaultSet.Fragmentation.SeqOverlapPattern))
 	copy(set.Fragmentation.SeqOverlapPattern, defaultSet.Fragmentation.SeqOverlapPattern)
 
 	set.Faking.TLSMod = make([]string, len(defaultSet.Faking.TLSMod))
 	copy(set.Faking.TLSMod, defaultSet.Faking.TLSMod)
 
+	set.Pipeline = DefaultPipeline()
+}
+
+// ECHByOuterSNI reports whether ECH ClientHellos may match on their outer SNI.
+func (t *TargetsConfig) ECHByOuterSNI() bool {
+	return t.ECHMatch != ECHMatchLearned
+}
+
+// ECHByLearnedIP reports whether ECH ClientHellos may match on learned destination IPs.
+func (t *TargetsConfig) ECHByLearnedIP() bool {
+	return t.ECHMatch == ECHMatchLearned || t.ECHMatch == ECHMatchBoth
 }
 
 func (t *TargetsConfig) AppendIP(ip []string) error {
@@ -425,13 +659,103 @@ func (cfg *Config) CollectUDPPorts() []string {
 	return ports
 }
 
+// CollectUDPPorts returns the UDP ports queued for this set: 443 plus its
+// dport filter, merged into ranges.
+func (set *SetConfig) CollectUDPPorts() []string {
+	ports := []string{"443"}
+	for _, p := range strings.Split(set.UDP.DPortFilter, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ports = append(ports, p)
+		}
+	}
+	sort.Strings(ports)
+	return mergeAndNormalizePorts(ports)
+}
+
+// HasRuleGroup reports whether an enabled set gets its own firewall rule
+// group, its connbytes limits or UDP ports differing from the main set's.
+func (c *Config) HasRuleGroup(set *SetConfig) bool {
+	if c.MainSet == nil || !set.Enabled || set.Id == MAIN_SET_ID {
+		return false
+	}
+	return set.TCP.ConnBytesLimit != c.MainSet.TCP.ConnBytesLimit ||
+		set.UDP.ConnBytesLimit != c.MainSet.UDP.ConnBytesLimit ||
+		strings.TrimSpace(set.UDP.DPortFilter) != ""
+}
+
+// setCtMarkSlots is the number of distinct set marks SetCtMarkMask holds.
+const setCtMarkSlots = 255
+
+// CtMarks returns the conntrack mark of every set, keyed by set id. A mark is
+// derived from the set's id, so it stays the same when sets are reordered or
+// others are removed. Sets whose ids hash to a taken mark get the next free
+// one in id order; sets beyond the last free mark get none.
+func (c *Config) CtMarks() map[string]uint32 {
+	ids := make([]string, 0, len(c.Sets))
+	for _, set := range c.Sets {
+		ids = append(ids, set.Id)
+	}
+	sort.Strings(ids)
+
+	marks := make(map[string]uint32, len(ids))
+	taken := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := marks[id]; ok || len(taken) == setCtMarkSlots {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		slot := h.Sum32() % setCtMarkSlots
+		for taken[slot+1] {
+			slot = (slot + 1) % setCtMarkSlots
+		}
+		taken[slot+1] = true
+		marks[id] = (slot + 1) << 16
+	}
+	return marks
+}
+
+// CtMarkOf returns the conntrack mark of set, or 0 when it is not one of
+// c.Sets or no mark is left for it.
+func (c *Config) CtMarkOf(set *SetConfig) uint32 {
+	return c.CtMarks()[set.Id]
+}
+
+// SetByCtMark returns the set whose mark is carried in the SetCtMarkMask bits
+// of mark, or nil when there is none or it is not active at the moment.
+func (c *Config) SetByCtMark(mark uint32) *SetConfig {
+	mark &= SetCtMarkMask
+	if mark == 0 {
+		return nil
+	}
+	marks := c.CtMarks()
+	for _, set := range c.Sets {
+		if marks[set.Id] != mark {
+			continue
+		}
+		if !set.IsActive(time.Now()) {
+			return nil
+		}
+		return set
+	}
+	return nil
+}
+
 // CollectDeviceMSSClamps returns per-device MSS clamp entries grouped by size.
-// The key is the MSS size, and the value is a slice of MAC addresses.
+// The key is the MSS size, and the value is a slice of MAC addresses. A
+// device profile's clamp replaces a mss_clamps entry for the same MAC.
 func (cfg *Config) CollectDeviceMSSClamps() map[int][]string {
 	result := make(map[int][]string)
+	profiled := make(map[string]bool)
+	for _, p := range cfg.Queue.Devices.Profiles {
+		if p.Enabled && p.MSSClamp > 0 {
+			result[p.MSSClamp] = append(result[p.MSSClamp], p.Mac)
+			profiled[p.Mac] = true
+		}
+	}
 	for _, dc := range cfg.Queue.Devices.MSSClamps {
 		mac := strings.ToUpper(strings.TrimSpace(dc.Mac))
-		if mac == "" || dc.Size <= 0 {
+		if mac == "" || dc.Size <= 0 || profiled[mac] {
 			continue
 		}
 		result[dc.Size] = append(result[dc.Size], mac)
@@ -487,6 +811,58 @@ func (cfg *Config) CollectDuplicateIPs() (ipv4 []string, ipv6 []string) {
 	return
 }
 
+// CollectPrefilterIPs returns IPv4 and IPv6 IPs/CIDRs (including geoip categories)
+// of all enabled sets. Used to fill the kernel-side target prefilter sets.
+func (cfg *Config) CollectPrefilterIPs() (ipv4 []string, ipv6 []string) {
+	seen := make(map[string]bool)
+	for _, set := range cfg.Sets {
+		if !set.Enabled {
+			continue
+		}
+		for _, ipStr := range set.Targets.IpsToMatch {
+			ipStr = strings.TrimSpace(ipStr)
+			if ipStr == "" || seen[ipStr] {
+				continue
+			}
+			seen[ipStr] = true
+			if strings.Contains(ipStr, ":") {
+				ipv6 = append(ipv6, ipStr)
+			} else {
+				ipv4 = append(ipv4, ipStr)
+			}
+		}
+	}
+	return
+}
+
+// PrefilterFingerprint returns a string representation of the prefilter configuration for comparison.
+func (cfg *Config) PrefilterFingerprint() string {
+	if !cfg.System.Tables.Prefilter {
+		return ""
+	}
+	ipv4, ipv6 := cfg.CollectPrefilterIPs()
+	sort.Strings(ipv4)
+	sort.Strings(ipv6)
+	return fmt.Sprintf("%d;%s;%s", cfg.System.Tables.PrefilterTimeout, strings.Join(ipv4, ","), strings.Join(ipv6, ","))
+}
+
+// RuleGroupFingerprint returns a string representation of the per-set connbytes
+// limits, UDP ports and IP targets the firewall rule groups are built from.
+func (cfg *Config) RuleGroupFingerprint() string {
+	parts := []string{}
+	marks := cfg.CtMarks()
+	for _, set := range cfg.Sets {
+		if !set.Enabled || set.Id == MAIN_SET_ID {
+			continue
+		}
+		ips := append([]string(nil), set.Targets.IpsToMatch...)
+		sort.Strings(ips)
+		parts = append(parts, fmt.Sprintf("%x:%d:%d:%s:%s", marks[set.Id], set.TCP.ConnBytesLimit, set.UDP.ConnBytesLimit,
+			strings.Join(set.CollectUDPPorts(), ","), strings.Join(ips, ",")))
+	}
+	return strings.Join(parts, ";")
+}
+
 func (c *Config) Clone() *Config {
 	data, _ := json.Marshal(c)
 	var clone Config
@@ -1,9 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestSaveToFile_And_LoadFromFile(t *testing.T) {
@@ -326,6 +328,154 @@ func TestGetSetById(t *testing.T) {
 	})
 }
 
+func TestMatchesQUICBySNI(t *testing.T) {
+	cfg := NewConfig()
+	set := NewSetConfig()
+	set.Enabled = true
+	set.UDP.FilterQUIC = "parse"
+	cfg.Sets = []*SetConfig{&set}
+
+	if cfg.MatchesQUICBySNI() {
+		t.Error("set without domains reported as matching QUIC by SNI")
+	}
+
+	set.Targets.DomainsToMatch = []string{"youtube.com"}
+	if !cfg.MatchesQUICBySNI() {
+		t.Error("parse set with domains not reported")
+	}
+
+	set.UDP.FilterQUIC = "all"
+	if cfg.MatchesQUICBySNI() {
+		t.Error("set matching QUIC by address reported as matching by SNI")
+	}
+
+	set.UDP.FilterQUIC = "parse"
+	set.Enabled = false
+	if cfg.MatchesQUICBySNI() {
+		t.Error("disabled set reported")
+	}
+}
+
+func TestHasRuleGroup(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Validate()
+	set := NewSetConfig()
+	set.Id = "second"
+	set.Enabled = true
+	cfg.Sets = append(cfg.Sets, &set)
+
+	if cfg.HasRuleGroup(cfg.MainSet) {
+		t.Error("main set reported with its own rule group")
+	}
+	if cfg.HasRuleGroup(&set) {
+		t.Error("set with the main set's limits reported with its own rule group")
+	}
+
+	set.UDP.DPortFilter = "50000-50100"
+	if !cfg.HasRuleGroup(&set) {
+		t.Error("set with its own UDP ports not reported")
+	}
+
+	set.UDP.DPortFilter = ""
+	set.TCP.ConnBytesLimit = cfg.MainSet.TCP.ConnBytesLimit - 1
+	if !cfg.HasRuleGroup(&set) {
+		t.Error("set with a lower TCP limit not reported")
+	}
+
+	set.Enabled = false
+	if cfg.HasRuleGroup(&set) {
+		t.Error("disabled set reported")
+	}
+}
+
+func TestMatchesTCPBySNI(t *testing.T) {
+	cfg := NewConfig()
+	set := NewSetConfig()
+	set.Enabled = true
+	cfg.Sets = []*SetConfig{&set}
+
+	if cfg.MatchesTCPBySNI() {
+		t.Error("set without domains reported as matching TCP by SNI")
+	}
+
+	set.Targets.DomainsToMatch = []string{"youtube.com"}
+	if !cfg.MatchesTCPBySNI() {
+		t.Error("set with domains not reported")
+	}
+
+	set.Enabled = false
+	if cfg.MatchesTCPBySNI() {
+		t.Error("disabled set reported")
+	}
+}
+
+func TestSetCtMarkLookup(t *testing.T) {
+	cfg := NewConfig()
+	set1 := NewSetConfig()
+	set1.Id = "set-1"
+	set2 := NewSetConfig()
+	set2.Id = "set-2"
+	set3 := NewSetConfig()
+	set3.Id = "set-3"
+	set3.Enabled = false
+	cfg.Sets = []*SetConfig{&set1, &set2, &set3}
+
+	t.Run("round trips through the mark", func(t *testing.T) {
+		mark := cfg.CtMarkOf(&set2)
+		if mark == 0 || mark&^SetCtMarkMask != 0 {
+			t.Fatalf("CtMarkOf = %#x", mark)
+		}
+		if found := cfg.SetByCtMark(mark | 0x8000); found != &set2 {
+			t.Error("other mark bits should be ignored")
+		}
+	})
+
+	t.Run("survives reordering and removing sets", func(t *testing.T) {
+		mark := cfg.CtMarkOf(&set2)
+		moved := NewConfig()
+		moved.Sets = []*SetConfig{&set2, &set3}
+		if moved.CtMarkOf(&set2) != mark {
+			t.Error("mark changed with the set's position")
+		}
+	})
+
+	t.Run("rejects unset, unknown, disabled and off schedule", func(t *testing.T) {
+		for _, mark := range []uint32{0, cfg.CtMarkOf(&set3)} {
+			if cfg.SetByCtMark(mark) != nil {
+				t.Errorf("SetByCtMark(%#x) should be nil", mark)
+			}
+		}
+		other := NewSetConfig()
+		other.Id = "other"
+		if cfg.CtMarkOf(&other) != 0 {
+			t.Error("set outside the config should have no mark")
+		}
+
+		offDay := scheduleDays[(time.Now().Weekday()+3)%7]
+		set1.Schedule = ScheduleConfig{Enabled: true, Days: []string{offDay}}
+		defer func() { set1.Schedule = ScheduleConfig{} }()
+		if cfg.SetByCtMark(cfg.CtMarkOf(&set1)) != nil {
+			t.Error("set outside its schedule should not be sticky")
+		}
+	})
+
+	t.Run("colliding ids get distinct marks", func(t *testing.T) {
+		many := NewConfig()
+		seen := make(map[uint32]bool)
+		for i := range 100 {
+			set := NewSetConfig()
+			set.Id = fmt.Sprintf("set-%d", i)
+			many.Sets = append(many.Sets, &set)
+		}
+		for id, mark := range many.CtMarks() {
+			if mark == 0 || seen[mark] {
+				t.Fatalf("set %s got mark %#x twice or none", id, mark)
+			}
+			seen[mark] = true
+		}
+	})
+}
+
 func TestGetTargetsForSet(t *testing.T) {
 	t.Run("combines manual domains", func(t *testing.T) {
 		cfg := NewConfig()
@@ -625,3 +775,143 @@ func TestResetToDefaults(t *testing.T) {
 			DefaultSetConfig.Fragmentation.SNIPosition, set.Fragmentation.SNIPosition)
 	}
 }
+
+func TestDeviceProfiles(t *testing.T) {
+	t.Run("validate normalizes and dedupes", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.Profiles = []DeviceProfile{
+			{Mac: "aa-bb-cc-dd-ee-ff", Enabled: true, Sets: []string{MAIN_SET_ID, "missing"}, MSSClamp: 5000, DNSTarget: "bogus"},
+			{Mac: "not-a-mac", Enabled: true},
+			{Mac: "AA:BB:CC:DD:EE:FF", Enabled: true, Alias: " TV ", Sets: []string{MAIN_SET_ID}, MSSClamp: 3},
+		}
+		cfg.Validate()
+
+		if len(cfg.Queue.Devices.Profiles) != 1 {
+			t.Fatalf("expected 1 profile, got %d", len(cfg.Queue.Devices.Profiles))
+		}
+		p := cfg.Queue.Devices.Profiles[0]
+		if p.Mac != "AA:BB:CC:DD:EE:FF" || p.Alias != "TV" || p.MSSClamp != 10 {
+			t.Errorf("unexpected profile %+v", p)
+		}
+		if got := cfg.GetDeviceProfile("aa:bb:cc:dd:ee:ff"); got == nil || got.Alias != "TV" {
+			t.Error("GetDeviceProfile should match case-insensitively")
+		}
+	})
+
+	t.Run("drops unknown sets and invalid dns", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.Profiles = []DeviceProfile{
+			{Mac: "AA:BB:CC:DD:EE:FF", Enabled: true, Sets: []string{MAIN_SET_ID, "missing"}, DNSTarget: "bogus"},
+		}
+		cfg.Validate()
+
+		p := cfg.Queue.Devices.Profiles[0]
+		if len(p.Sets) != 1 || p.DNSTarget != "" {
+			t.Errorf("unexpected profile %+v", p)
+		}
+	})
+
+	t.Run("restricted profile without existing sets", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.Profiles = []DeviceProfile{
+			{Mac: "AA:BB:CC:DD:EE:FF", Enabled: true, Sets: []string{"missing"}},
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Error("expected an error instead of lifting the restriction")
+		}
+		if p := cfg.Queue.Devices.Profiles[0]; len(p.Sets) != 1 {
+			t.Errorf("profile changed on error: %+v", p)
+		}
+
+		cfg.Queue.Devices.Profiles[0].Bypass = true
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("bypassed profile: %v", err)
+		}
+	})
+
+	t.Run("assignments and dns targets", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.Profiles = []DeviceProfile{
+			{Mac: "AA:BB:CC:DD:EE:01", Enabled: true, Sets: []string{MAIN_SET_ID}, DNSTarget: "9.9.9.9"},
+			{Mac: "AA:BB:CC:DD:EE:02", Enabled: true, Bypass: true, DNSTarget: "9.9.9.9"},
+			{Mac: "AA:BB:CC:DD:EE:03", Enabled: false, Sets: []string{MAIN_SET_ID}, DNSTarget: "9.9.9.9"},
+		}
+		cfg.Validate()
+
+		a := cfg.DeviceSetAssignments()
+		if len(a) != 2 || !a["AA:BB:CC:DD:EE:01"][MAIN_SET_ID] || len(a["AA:BB:CC:DD:EE:02"]) != 0 {
+			t.Errorf("unexpected assignments %v", a)
+		}
+		if cfg.DeviceDNSTarget("aa:bb:cc:dd:ee:01") != "9.9.9.9" {
+			t.Error("expected DNS target for enabled profile")
+		}
+		if cfg.DeviceDNSTarget("AA:BB:CC:DD:EE:02") != "" || cfg.DeviceDNSTarget("AA:BB:CC:DD:EE:03") != "" {
+			t.Error("bypassed and disabled profiles must not redirect DNS")
+		}
+	})
+
+	t.Run("profile mss clamp overrides device clamp", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.MSSClamps = []DeviceMSSClamp{{Mac: "AA:BB:CC:DD:EE:FF", Size: 88}}
+		cfg.Queue.Devices.Profiles = []DeviceProfile{{Mac: "AA:BB:CC:DD:EE:FF", Enabled: true, MSSClamp: 200}}
+		cfg.Validate()
+
+		result := cfg.CollectDeviceMSSClamps()
+		if len(result[88]) != 0 || len(result[200]) != 1 {
+			t.Errorf("unexpected clamps %v", result)
+		}
+	})
+}
+
+func TestDeviceFilter(t *testing.T) {
+	bypass := DeviceProfile{Mac: "AA:BB:CC:DD:EE:02", Enabled: true, Bypass: true}
+
+	t.Run("no filter", func(t *testing.T) {
+		cfg := NewConfig()
+		if macs, _ := cfg.DeviceFilter(); len(macs) != 0 {
+			t.Errorf("expected no filter, got %v", macs)
+		}
+	})
+
+	t.Run("bypass profiles exclude devices", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.Profiles = []DeviceProfile{bypass}
+		cfg.Validate()
+
+		macs, exclude := cfg.DeviceFilter()
+		if !exclude || len(macs) != 1 || macs[0] != bypass.Mac {
+			t.Errorf("got %v exclude=%v", macs, exclude)
+		}
+	})
+
+	t.Run("merged with blocklist", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.Enabled = true
+		cfg.Queue.Devices.WhiteIsBlack = true
+		cfg.Queue.Devices.Mac = []string{"AA:BB:CC:DD:EE:01", "aa:bb:cc:dd:ee:02"}
+		cfg.Queue.Devices.Profiles = []DeviceProfile{bypass}
+		cfg.Validate()
+
+		macs, exclude := cfg.DeviceFilter()
+		if !exclude || len(macs) != 2 {
+			t.Errorf("got %v exclude=%v", macs, exclude)
+		}
+	})
+
+	t.Run("allowlist unchanged", func(t *testing.T) {
+		cfg := NewConfig()
+		cfg.Queue.Devices.Enabled = true
+		cfg.Queue.Devices.Mac = []string{"AA:BB:CC:DD:EE:01"}
+		cfg.Queue.Devices.Profiles = []DeviceProfile{bypass}
+		cfg.Validate()
+
+		macs, exclude := cfg.DeviceFilter()
+		if exclude || len(macs) != 1 || macs[0] != "AA:BB:CC:DD:EE:01" {
+			t.Errorf("got %v exclude=%v", macs, exclude)
+		}
+		empty := NewConfig()
+		if cfg.DeviceFilterFingerprint() == empty.DeviceFilterFingerprint() {
+			t.Error("fingerprint should reflect the filter")
+		}
+	})
+}
@@ -39,6 +39,171 @@ var migrationRegistry = map[int]MigrationFunc{
 	20: migrateV20to21, // Add SOCKS5 proxy server config
 	21: migrateV21to22, // Add NAT masquerade config
 	22: migrateV22to23, // Add TCP MSS clamping config
+	23: migrateV23to24, // Add ECH matching and fake stripping options
+	24: migrateV24to25, // Add encrypted QUIC Initial fakes
+	25: migrateV25to26, // Add QUIC CRYPTO frame splitting
+	26: migrateV26to27, // Add kernel-side target prefilter sets
+	27: migrateV27to28, // Add firewall backend selection
+	28: migrateV28to29, // Add ASN targets
+	29: migrateV29to30, // Add device profiles
+	30: migrateV30to31, // Add set schedules
+	31: migrateV31to32, // Add traffic accounting
+	32: migrateV32to33, // Add ctmark set stickiness
+	33: migrateV33to34, // Add automatic fake TTL
+	34: migrateV34to35, // Add TCP strategy pipeline
+	35: migrateV35to36, // Add fingerprint targets
+	36: migrateV36to37, // Add mirrored fake ClientHellos
+	37: migrateV37to38, // Add injection scheduler limits
+	38: migrateV38to39, // Add queue worker watchdog
+	39: migrateV39to40, // Add control socket
+	40: migrateV40to41, // Add QUIC flight hold setting
+	41: migrateV41to42, // Add TCP ClientHello hold setting
+}
+
+func migrateV41to42(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v41->v42: Adding TCP ClientHello hold setting")
+	c.Queue.TCPHold = DefaultConfig.Queue.TCPHold
+	return nil
+}
+
+func migrateV40to41(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v40->v41: Adding QUIC flight hold setting")
+	c.Queue.QUICHold = DefaultConfig.Queue.QUICHold
+	return nil
+}
+
+func migrateV39to40(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v39->v40: Adding control socket")
+	c.System.Control = DefaultConfig.System.Control
+	return nil
+}
+
+func migrateV38to39(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v38->v39: Adding queue worker watchdog")
+	c.Queue.Watchdog = DefaultConfig.Queue.Watchdog
+	return nil
+}
+
+func migrateV37to38(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v37->v38: Adding injection scheduler limits")
+	c.Queue.Injection = DefaultConfig.Queue.Injection
+	return nil
+}
+
+func migrateV36to37(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v36->v37: Adding mirror decoy SNIs")
+	for _, set := range c.Sets {
+		set.Faking.MirrorSNIs = append([]string{}, DefaultSetConfig.Faking.MirrorSNIs...)
+	}
+	return nil
+}
+
+func migrateV35to36(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v35->v36: Adding fingerprint targets")
+	for _, set := range c.Sets {
+		set.Targets.Fingerprints = []string{}
+		set.Targets.ExcludeFingerprints = []string{}
+	}
+	return nil
+}
+
+// The default pipeline runs the steps in the order they were hard-coded in,
+// each gated by its section, so existing sets keep their behavior.
+func migrateV34to35(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v34->v35: Converting TCP strategies to pipelines")
+	for _, set := range c.Sets {
+		set.Pipeline = DefaultPipeline()
+	}
+	return nil
+}
+
+func migrateV33to34(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v33->v34: Adding automatic fake TTL")
+	for _, set := range c.Sets {
+		set.Faking.AutoTTL = DefaultSetConfig.Faking.AutoTTL
+	}
+	return nil
+}
+
+func migrateV32to33(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v32->v33: Adding ctmark set stickiness")
+	c.Queue.StickySets = DefaultConfig.Queue.StickySets
+	return nil
+}
+
+func migrateV31to32(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v31->v32: Adding traffic accounting")
+	c.System.Accounting = DefaultConfig.System.Accounting
+	return nil
+}
+
+func migrateV30to31(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v30->v31: Adding set schedules")
+	for _, set := range c.Sets {
+		set.Schedule = ScheduleConfig{Days: []string{}, Ranges: []ScheduleTimeRange{}}
+	}
+	return nil
+}
+
+func migrateV29to30(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v29->v30: Adding device profiles")
+	c.Queue.Devices.Profiles = []DeviceProfile{}
+	return nil
+}
+
+func migrateV28to29(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v28->v29: Adding ASN targets")
+	c.System.Geo.ASNSource = DefaultConfig.System.Geo.ASNSource
+	c.System.Geo.ASNDatabasePath = DefaultConfig.System.Geo.ASNDatabasePath
+	c.System.Geo.ASNRefreshHours = DefaultConfig.System.Geo.ASNRefreshHours
+
+	for _, set := range c.Sets {
+		set.Targets.ASNs = []string{}
+	}
+	return nil
+}
+
+func migrateV27to28(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v27->v28: Adding firewall backend selection")
+	c.System.Tables.Backend = DefaultConfig.System.Tables.Backend
+	return nil
+}
+
+func migrateV26to27(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v26->v27: Adding kernel-side target prefilter config")
+	c.System.Tables.Prefilter = DefaultConfig.System.Tables.Prefilter
+	c.System.Tables.PrefilterTimeout = DefaultConfig.System.Tables.PrefilterTimeout
+	return nil
+}
+
+func migrateV25to26(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v25->v26: Adding QUIC CRYPTO frame split options")
+
+	for _, set := range c.Sets {
+		set.UDP.CryptoSplit = DefaultSetConfig.UDP.CryptoSplit
+		set.UDP.CryptoPadding = DefaultSetConfig.UDP.CryptoPadding
+	}
+	return nil
+}
+
+func migrateV24to25(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v24->v25: Adding encrypted QUIC Initial fake options")
+
+	for _, set := range c.Sets {
+		set.UDP.FakePayload = DefaultSetConfig.UDP.FakePayload
+		set.UDP.FakeSNI = DefaultSetConfig.UDP.FakeSNI
+	}
+	return nil
+}
+
+func migrateV23to24(c *Config, _ map[string]interface{}) error {
+	log.Tracef("Migration v23->v24: Adding ECH matching and fake stripping options")
+
+	for _, set := range c.Sets {
+		set.Targets.ECHMatch = DefaultSetConfig.Targets.ECHMatch
+		set.Faking.ECH = DefaultSetConfig.Faking.ECH
+	}
+	return nil
 }
 
 func migrateV22to23(c *Config, _ map[string]interface{}) error {
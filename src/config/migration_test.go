@@ -97,4 +97,13 @@ func TestApplyMigrations(t *testing.T) {
 		}
 	})
 
+	t.Run("v32 to v33 leaves sticky sets off", func(t *testing.T) {
+		cfg := NewConfig()
+		if err := cfg.applyMigrations(32, map[string]interface{}{}); err != nil {
+			t.Fatalf("migration failed: %v", err)
+		}
+		if cfg.Queue.StickySets {
+			t.Error("sticky sets mark by set position and must be opted into")
+		}
+	})
 }
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/daniellavrushin/b4/log"
+)
+
+// Pipeline step actions. Mutate, desync, window, fake and post_desync run
+// only while their section of the set is enabled, so the migrated pipeline
+// keeps following the set's switches.
+const (
+	StepMutate     = "mutate"      // rewrite the ClientHello per Faking.SNIMutation
+	StepTLSSplit   = "tls_split"   // split the TLS record in two at At[0]
+	StepDesync     = "desync"      // TCP.Desync packets
+	StepWindow     = "window"      // TCP.Win packets
+	StepFake       = "fake"        // fake ClientHellos, Count overrides Faking.SNISeqLength
+	StepSplit      = "split"       // cut the payload into pending segments at At
+	StepSend       = "send"        // send Count pending segments, or all
+	StepFragment   = "fragment"    // deliver with a fragmentation strategy
+	StepDelay      = "delay"       // wait Delay ms
+	StepPostDesync = "post_desync" // fake RST after delivery
+)
+
+var StrategyActions = []string{
+	StepMutate, StepTLSSplit, StepDesync, StepWindow, StepFake,
+	StepSplit, StepSend, StepFragment, StepDelay, StepPostDesync,
+}
+
+var FragmentStrategies = []string{
+	"tcp", "ip", "oob", "tls", "disorder", "extsplit", "firstbyte", "combo", "hybrid", ConfigNone,
+}
+
+// Split positions besides payload offsets.
+const (
+	SplitAtSNI    = "sni"
+	SplitAtMidSNI = "midsni"
+	SplitAtSNIEnd = "sniend"
+)
+
+// DefaultPipeline is the order dropAndInjectTCP used before pipelines.
+func DefaultPipeline() []StrategyStep {
+	return []StrategyStep{
+		{Action: StepMutate},
+		{Action: StepDesync},
+		{Action: StepWindow},
+		{Action: StepFake},
+		{Action: StepFragment},
+		{Action: StepPostDesync},
+	}
+}
+
+func validSplitAt(at string) bool {
+	switch at {
+	case SplitAtSNI, SplitAtMidSNI, SplitAtSNIEnd:
+		return true
+	}
+	n, err := strconv.Atoi(at)
+	return err == nil && n > 0
+}
+
+func normalizePipeline(setName string, steps []StrategyStep) []StrategyStep {
+	out := make([]StrategyStep, 0, len(steps))
+	for _, step := range steps {
+		step.Action = strings.ToLower(strings.TrimSpace(step.Action))
+		if !slices.Contains(StrategyActions, step.Action) {
+			log.Warnf("Set '%s': ignoring unknown pipeline action %q", setName, step.Action)
+			continue
+		}
+		if step.Strategy != "" && !slices.Contains(FragmentStrategies, step.Strategy) {
+			log.Warnf("Set '%s': unknown fragment strategy %q, using the set's", setName, step.Strategy)
+			step.Strategy = ""
+		}
+
+		at := make([]string, 0, len(step.At))
+		for _, a := range step.At {
+			a = strings.ToLower(strings.TrimSpace(a))
+			if !validSplitAt(a) {
+				log.Warnf("Set '%s': ignoring invalid split position %q", setName, a)
+				continue
+			}
+			at = append(at, a)
+		}
+		step.At = at
+		step.Count = max(step.Count, 0)
+		step.Delay = max(step.Delay, 0)
+		out = append(out, step)
+	}
+	if len(out) == 0 {
+		return DefaultPipeline()
+	}
+	return out
+}
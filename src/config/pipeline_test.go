@@ -0,0 +1,32 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizePipeline(t *testing.T) {
+	steps := normalizePipeline("test", []StrategyStep{
+		{Action: " Split ", At: []string{"sni", "0", "bogus", "12"}},
+		{Action: "teleport"},
+		{Action: StepFragment, Strategy: "warp"},
+		{Action: StepSend, Count: -2},
+	})
+
+	if len(steps) != 3 {
+		t.Fatalf("got %d steps, want 3: %+v", len(steps), steps)
+	}
+	if steps[0].Action != StepSplit || !slices.Equal(steps[0].At, []string{"sni", "12"}) {
+		t.Errorf("split step = %+v", steps[0])
+	}
+	if steps[1].Strategy != "" {
+		t.Errorf("unknown strategy kept: %q", steps[1].Strategy)
+	}
+	if steps[2].Count != 0 {
+		t.Errorf("negative count kept: %d", steps[2].Count)
+	}
+
+	if got := normalizePipeline("test", nil); len(got) != len(DefaultPipeline()) || got[0].Action != StepMutate {
+		t.Errorf("empty pipeline = %+v, want the default", got)
+	}
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/log"
+)
+
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+var scheduleLocations sync.Map // timezone name -> *time.Location
+
+// IsActive reports whether the set is enabled and, if it has a schedule,
+// inside one of its windows at t.
+func (s *SetConfig) IsActive(t time.Time) bool {
+	return s.Enabled && (!s.Schedule.Enabled || s.Schedule.ActiveAt(t))
+}
+
+// NextScheduleBoundary returns the earliest NextBoundary over the enabled sets
+// with a schedule, or the zero time when no set is scheduled.
+func (c *Config) NextScheduleBoundary(t time.Time) time.Time {
+	var next time.Time
+	for _, set := range c.Sets {
+		if !set.Enabled || !set.Schedule.Enabled {
+			continue
+		}
+		if b := set.Schedule.NextBoundary(t); next.IsZero() || b.Before(next) {
+			next = b
+		}
+	}
+	return next
+}
+
+// ActiveAt reports whether t falls inside the schedule. A window that runs
+// past midnight belongs to the day it starts on.
+func (sc *ScheduleConfig) ActiveAt(t time.Time) bool {
+	t = t.In(sc.location())
+	minute := t.Hour()*60 + t.Minute()
+	today := sc.hasDay(t.Weekday())
+	yesterday := sc.hasDay((t.Weekday() + 6) % 7)
+
+	if len(sc.Ranges) == 0 {
+		return today
+	}
+	for _, r := range sc.Ranges {
+		start, end, ok := r.minutes()
+		if !ok {
+			continue
+		}
+		if start < end {
+			if today && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		if (today && minute >= start) || (yesterday && minute < end) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextBoundary returns the first instant after t at which ActiveAt may change:
+// the next window start or end, or the next midnight.
+func (sc *ScheduleConfig) NextBoundary(t time.Time) time.Time {
+	loc := sc.location()
+	local := t.In(loc)
+	y, m, d := local.Date()
+
+	var next time.Time
+	consider := func(c time.Time) {
+		if c.After(t) && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+	for day := 0; day <= 1; day++ {
+		consider(time.Date(y, m, d+day, 0, 0, 0, 0, loc))
+		for _, r := range sc.Ranges {
+			start, end, ok := r.minutes()
+			if !ok {
+				continue
+			}
+			consider(time.Date(y, m, d+day, start/60, start%60, 0, 0, loc))
+			consider(time.Date(y, m, d+day, end/60, end%60, 0, 0, loc))
+		}
+	}
+	if next.IsZero() {
+		next = time.Date(y, m, d+2, 0, 0, 0, 0, loc)
+	}
+	return next
+}
+
+func (sc *ScheduleConfig) hasDay(wd time.Weekday) bool {
+	return len(sc.Days) == 0 || slices.Contains(sc.Days, scheduleDays[wd])
+}
+
+func (sc *ScheduleConfig) location() *time.Location {
+	if sc.Timezone == "" {
+		return time.Local
+	}
+	if loc, ok := scheduleLocations.Load(sc.Timezone); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(sc.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	scheduleLocations.Store(sc.Timezone, loc)
+	return loc
+}
+
+// normalize lower-cases day names and drops days, ranges and timezones that
+// cannot be parsed.
+func (sc *ScheduleConfig) normalize(setName string) {
+	days := make([]string, 0, len(sc.Days))
+	for _, d := range sc.Days {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if len(d) > 3 {
+			d = d[:3]
+		}
+		if !slices.Contains(scheduleDays, d) {
+			log.Warnf("Set '%s': ignoring unknown schedule day %q", setName, d)
+			continue
+		}
+		if !slices.Contains(days, d) {
+			days = append(days, d)
+		}
+	}
+	sc.Days = days
+
+	ranges := make([]ScheduleTimeRange, 0, len(sc.Ranges))
+	for _, r := range sc.Ranges {
+		r.Start = strings.TrimSpace(r.Start)
+		r.End = strings.TrimSpace(r.End)
+		if _, _, ok := r.minutes(); !ok {
+			log.Warnf("Set '%s': ignoring invalid schedule range %s-%s", setName, r.Start, r.End)
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+	sc.Ranges = ranges
+
+	sc.Timezone = strings.TrimSpace(sc.Timezone)
+	if sc.Timezone != "" {
+		if _, err := time.LoadLocation(sc.Timezone); err != nil {
+			log.Warnf("Set '%s': unknown schedule timezone %q, using local time", setName, sc.Timezone)
+			sc.Timezone = ""
+		}
+	}
+}
+
+func (r ScheduleTimeRange) minutes() (start, end int, ok bool) {
+	start, ok1 := parseClock(r.Start)
+	end, ok2 := parseClock(r.End)
+	return start, end, ok1 && ok2
+}
+
+// parseClock parses "HH:MM" into minutes since midnight; "24:00" is accepted
+// as the end of the day.
+func parseClock(s string) (int, bool) {
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 {
+		return 0, false
+	}
+	if h == 24 && m == 0 {
+		return 0, true
+	}
+	if h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
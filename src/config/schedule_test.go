@@ -0,0 +1,115 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActiveAt(t *testing.T) {
+	utc := func(day, hour, min int) time.Time {
+		// 2026-03-02 is a Monday
+		return time.Date(2026, 3, 2+day, hour, min, 0, 0, time.UTC)
+	}
+
+	sc := ScheduleConfig{
+		Enabled:  true,
+		Days:     []string{"mon", "fri"},
+		Ranges:   []ScheduleTimeRange{{Start: "08:00", End: "12:00"}, {Start: "22:00", End: "02:00"}},
+		Timezone: "UTC",
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"monday morning", utc(0, 9, 30), true},
+		{"monday window end is exclusive", utc(0, 12, 0), false},
+		{"monday late", utc(0, 23, 0), true},
+		{"tuesday after midnight belongs to monday", utc(1, 1, 59), true},
+		{"tuesday morning", utc(1, 9, 0), false},
+		{"friday after midnight belongs to thursday", utc(4, 1, 0), false},
+		{"friday morning", utc(4, 8, 0), true},
+	}
+	for _, tt := range tests {
+		if got := sc.ActiveAt(tt.t); got != tt.want {
+			t.Errorf("%s: ActiveAt = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	allDay := ScheduleConfig{Enabled: true, Days: []string{"sat", "sun"}, Timezone: "UTC"}
+	if !allDay.ActiveAt(utc(5, 3, 0)) || allDay.ActiveAt(utc(4, 23, 59)) {
+		t.Error("schedule without ranges should cover whole selected days")
+	}
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	sc := ScheduleConfig{
+		Enabled:  true,
+		Ranges:   []ScheduleTimeRange{{Start: "18:00", End: "24:00"}},
+		Timezone: "Asia/Tokyo",
+	}
+	// 10:00 UTC is 19:00 in Tokyo
+	if !sc.ActiveAt(time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Error("expected active in Tokyo evening")
+	}
+	if sc.ActiveAt(time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC)) {
+		t.Error("expected inactive after Tokyo midnight")
+	}
+}
+
+func TestScheduleNextBoundary(t *testing.T) {
+	sc := ScheduleConfig{
+		Enabled:  true,
+		Ranges:   []ScheduleTimeRange{{Start: "08:00", End: "12:00"}},
+		Timezone: "UTC",
+	}
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.UTC) }
+
+	if got := sc.NextBoundary(at(7, 0)); !got.Equal(at(8, 0)) {
+		t.Errorf("NextBoundary before window = %v", got)
+	}
+	if got := sc.NextBoundary(at(8, 0)); !got.Equal(at(12, 0)) {
+		t.Errorf("NextBoundary at window start = %v", got)
+	}
+	if got := sc.NextBoundary(at(13, 0)); !got.Equal(at(24, 0)) {
+		t.Errorf("NextBoundary after window = %v, want midnight", got)
+	}
+
+	cfg := NewConfig()
+	cfg.Validate()
+	if !cfg.NextScheduleBoundary(at(0, 0)).IsZero() {
+		t.Error("config without schedules should have no boundary")
+	}
+}
+
+func TestScheduleNormalize(t *testing.T) {
+	cfg := NewConfig()
+	set := NewSetConfig()
+	set.Id = "scheduled"
+	set.Enabled = true
+	set.Schedule = ScheduleConfig{
+		Enabled:  true,
+		Days:     []string{"Monday", "fri", "FRI", "someday"},
+		Ranges:   []ScheduleTimeRange{{Start: "8:00", End: "12:30"}, {Start: "25:00", End: "26:00"}},
+		Timezone: "Nowhere/Invalid",
+	}
+	cfg.Sets = []*SetConfig{&set}
+	cfg.Validate()
+
+	sc := set.Schedule
+	if len(sc.Days) != 2 || sc.Days[0] != "mon" || sc.Days[1] != "fri" {
+		t.Errorf("Days = %v", sc.Days)
+	}
+	if len(sc.Ranges) != 1 {
+		t.Errorf("Ranges = %v", sc.Ranges)
+	}
+	if sc.Timezone != "" {
+		t.Errorf("Timezone = %q, want empty", sc.Timezone)
+	}
+
+	set.Enabled = false
+	if set.IsActive(time.Now()) {
+		t.Error("disabled set must never be active")
+	}
+}
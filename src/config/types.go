@@ -11,12 +11,42 @@ const (
 	ConfigNone = "none"
 )
 
+const (
+	ECHMatchOuter   = "outer"   // match ECH hellos by their cleartext outer SNI
+	ECHMatchLearned = "learned" // match ECH hellos only by IPs learned from earlier SNI hits
+	ECHMatchBoth    = "both"
+
+	ECHKeep  = "keep"
+	ECHStrip = "strip"
+)
+
+const (
+	InjectOverflowAccept = "accept" // pass the packet on unmodified
+	InjectOverflowDrop   = "drop"
+)
+
+const (
+	ASNSourceRipestat = "ripestat" // RIPEstat announced-prefixes API
+	ASNSourceFile     = "file"     // local MRT or CSV prefix database
+)
+
+const (
+	UDPFakeZero    = "zero"
+	UDPFakeInitial = "initial"
+
+	DefaultUDPFakeSNI = "www.google.com"
+
+	CryptoSplitOn      = "split"
+	CryptoSplitReorder = "reorder"
+)
+
 const (
 	FakePayloadRandom = iota
 	FakePayloadCustom
 	FakePayloadDefault1
 	FakePayloadDefault2
 	FakePayloadCapture
+	FakePayloadMirror
 )
 
 type ApiConfig struct {
@@ -24,14 +54,36 @@ type ApiConfig struct {
 }
 
 type QueueConfig struct {
-	StartNum    int            `json:"start_num" bson:"start_num"`
-	Threads     int            `json:"threads" bson:"threads"`
-	Mark        uint           `json:"mark" bson:"mark"`
-	IPv4Enabled bool           `json:"ipv4" bson:"ipv4"`
-	IPv6Enabled bool           `json:"ipv6" bson:"ipv6"`
-	Interfaces  []string       `json:"interfaces" bson:"interfaces"`
-	Devices     DevicesConfig  `json:"devices" bson:"devices"`
-	MSSClamp    MSSClampConfig `json:"mss_clamp" bson:"mss_clamp"`
+	StartNum    int             `json:"start_num" bson:"start_num"`
+	Threads     int             `json:"threads" bson:"threads"`
+	Mark        uint            `json:"mark" bson:"mark"`
+	IPv4Enabled bool            `json:"ipv4" bson:"ipv4"`
+	IPv6Enabled bool            `json:"ipv6" bson:"ipv6"`
+	Interfaces  []string        `json:"interfaces" bson:"interfaces"`
+	Devices     DevicesConfig   `json:"devices" bson:"devices"`
+	MSSClamp    MSSClampConfig  `json:"mss_clamp" bson:"mss_clamp"`
+	StickySets  bool            `json:"sticky_sets" bson:"sticky_sets"` // stamp the chosen set into the ctmark
+	QUICHold    int             `json:"quic_hold" bson:"quic_hold"`     // ms an Initial without a visible SNI waits for the rest of its flight, 0 disables
+	TCPHold     int             `json:"tcp_hold" bson:"tcp_hold"`       // ms a ClientHello spanning several segments waits for the rest, 0 disables
+	Injection   InjectionConfig `json:"injection" bson:"injection"`
+	Watchdog    WatchdogConfig  `json:"watchdog" bson:"watchdog"`
+}
+
+// InjectionConfig bounds the work each queue worker runs to inject
+// strategies. Packets arriving with the queue full are handled per Overflow.
+type InjectionConfig struct {
+	Workers   int    `json:"workers" bson:"workers"`       // concurrent injections per queue
+	QueueSize int    `json:"queue_size" bson:"queue_size"` // injections waiting or paused per queue
+	Overflow  string `json:"overflow" bson:"overflow"`     // "accept", "drop"
+}
+
+// WatchdogConfig restarts queue workers that stop issuing verdicts. While a
+// worker's restarts keep failing the queue rules are taken down, so traffic
+// passes untouched instead of stalling.
+type WatchdogConfig struct {
+	Enabled      bool `json:"enabled" bson:"enabled"`
+	StallTimeout int  `json:"stall_timeout" bson:"stall_timeout"` // seconds packets may wait for a verdict
+	MaxRestarts  int  `json:"max_restarts" bson:"max_restarts"`   // failed restarts in a row before failing open
 }
 
 type DevicesConfig struct {
@@ -40,6 +92,7 @@ type DevicesConfig struct {
 	WhiteIsBlack bool             `json:"wisb" bson:"wisb"`
 	Mac          []string         `json:"mac" bson:"mac"`
 	MSSClamps    []DeviceMSSClamp `json:"mss_clamps" bson:"mss_clamps"`
+	Profiles     []DeviceProfile  `json:"profiles" bson:"profiles"`
 }
 
 type TCPConfig struct {
@@ -83,7 +136,11 @@ type UDPConfig struct {
 	FakeSeqLength  int    `json:"fake_seq_length" bson:"fake_seq_length"`
 	FakeLen        int    `json:"fake_len" bson:"fake_len"`
 	FakingStrategy string `json:"faking_strategy" bson:"faking_strategy"`
-	DPortFilter    string `json:"dport_filter" bson:"dport_filter"` // can be a comma separated list of ports and port ranges, e.g. "80,443,1000-2000"
+	FakePayload    string `json:"fake_payload" bson:"fake_payload"`     // "zero", "initial" - encrypted Initial with a decoy SNI
+	FakeSNI        string `json:"fake_sni" bson:"fake_sni"`             // decoy server name for "initial" fakes
+	CryptoSplit    string `json:"crypto_split" bson:"crypto_split"`     // "off", "split", "reorder" - re-pack the ClientHello CRYPTO frames
+	CryptoPadding  bool   `json:"crypto_padding" bson:"crypto_padding"` // interleave PING/PADDING frames between re-packed CRYPTO frames
+	DPortFilter    string `json:"dport_filter" bson:"dport_filter"`     // can be a comma separated list of ports and port ranges, e.g. "80,443,1000-2000"
 	FilterQUIC     string `json:"filter_quic" bson:"filter_quic"`
 	FilterSTUN     bool   `json:"filter_stun" bson:"filter_stun"`
 	ConnBytesLimit int    `json:"conn_bytes_limit" bson:"conn_bytes_limit"`
@@ -124,7 +181,21 @@ type FakingConfig struct {
 	TimestampDecrease uint32   `json:"timestamp_decrease" bson:"timestamp_decrease"` // Amount to decrease TCP timestamp option
 
 	SNIMutation SNIMutationConfig `json:"sni_mutation" bson:"sni_mutation"`
-	TCPMD5      bool              `json:"tcp_md5" bson:"tcp_md5"` // Enable TCP MD5 option insertion
+	TCPMD5      bool              `json:"tcp_md5" bson:"tcp_md5"`         // Enable TCP MD5 option insertion
+	ECH         string            `json:"ech" bson:"ech"`                 // "keep", "strip" - encrypted_client_hello in fake ClientHellos
+	MirrorSNIs  []string          `json:"mirror_snis" bson:"mirror_snis"` // Decoy SNIs for fakes mirroring the real ClientHello
+
+	AutoTTL AutoTTLConfig `json:"auto_ttl" bson:"auto_ttl"`
+}
+
+// AutoTTLConfig derives fake TTLs from the server's hop distance, inferred
+// from the TTL of its replies. Destinations with no reply seen yet keep the
+// fixed TTLs.
+type AutoTTLConfig struct {
+	Enabled bool  `json:"enabled" bson:"enabled"`
+	Delta   uint8 `json:"delta" bson:"delta"` // hops short of the server
+	Min     uint8 `json:"min" bson:"min"`
+	Max     uint8 `json:"max" bson:"max"`
 }
 
 type SNIMutationConfig struct {
@@ -140,19 +211,45 @@ type TargetsConfig struct {
 	IPs               []string `json:"ip" bson:"ip"`
 	GeoSiteCategories []string `json:"geosite_categories" bson:"geosite_categories"`
 	GeoIpCategories   []string `json:"geoip_categories" bson:"geoip_categories"`
+	ASNs              []string `json:"asns" bson:"asns"` // origin ASNs whose announced prefixes are matched, e.g. "AS13335"
 	SourceDevices     []string `json:"source_devices" bson:"source_devices"`
-	DomainsToMatch    []string `json:"-" bson:"-"`
-	IpsToMatch        []string `json:"-" bson:"-"`
+	ECHMatch          string   `json:"ech_match" bson:"ech_match"` // "outer", "learned", "both"
+
+	// JA3 hashes or JA4 fingerprints, a trailing "*" matches a prefix.
+	// Fingerprints limits the set to those ClientHellos, or targets them
+	// anywhere when the set has no domain or IP targets.
+	Fingerprints        []string `json:"fingerprints" bson:"fingerprints"`
+	ExcludeFingerprints []string `json:"exclude_fingerprints" bson:"exclude_fingerprints"`
+
+	DomainsToMatch []string `json:"-" bson:"-"`
+	IpsToMatch     []string `json:"-" bson:"-"`
 }
 
 type SystemConfig struct {
-	Tables    TablesConfig    `json:"tables" bson:"tables"`
-	Logging   Logging         `json:"logging" bson:"logging"`
-	WebServer WebServerConfig `json:"web_server" bson:"web_server"`
-	Socks5    Socks5Config    `json:"socks5" bson:"socks5"`
-	Checker   DiscoveryConfig `json:"checker" bson:"checker"`
-	Geo       GeoDatConfig    `json:"geo" bson:"geo"`
-	API       ApiConfig       `json:"api" bson:"api"`
+	Tables     TablesConfig     `json:"tables" bson:"tables"`
+	Logging    Logging          `json:"logging" bson:"logging"`
+	WebServer  WebServerConfig  `json:"web_server" bson:"web_server"`
+	Socks5     Socks5Config     `json:"socks5" bson:"socks5"`
+	Checker    DiscoveryConfig  `json:"checker" bson:"checker"`
+	Geo        GeoDatConfig     `json:"geo" bson:"geo"`
+	API        ApiConfig        `json:"api" bson:"api"`
+	Accounting AccountingConfig `json:"accounting" bson:"accounting"`
+	Control    ControlConfig    `json:"control" bson:"control"`
+}
+
+// ControlConfig is the local unix socket serving health checks, available
+// whether or not the web server is enabled.
+type ControlConfig struct {
+	Socket string `json:"socket" bson:"socket"` // empty disables
+}
+
+// AccountingConfig controls conntrack-based traffic accounting of the
+// connections b4 handles.
+type AccountingConfig struct {
+	Enabled         bool `json:"enabled" bson:"enabled"`
+	PollInterval    int  `json:"poll_interval" bson:"poll_interval"`       // seconds between conntrack dumps
+	RetentionDays   int  `json:"retention_days" bson:"retention_days"`     // daily rollups kept
+	RetentionMonths int  `json:"retention_months" bson:"retention_months"` // monthly rollups kept
 }
 
 type Socks5Config struct {
@@ -170,6 +267,9 @@ type TablesConfig struct {
 	SkipSetup           bool   `json:"skip_setup" bson:"skip_setup"`
 	Masquerade          bool   `json:"masquerade" bson:"masquerade"`
 	MasqueradeInterface string `json:"masquerade_interface" bson:"masquerade_interface"`
+	Prefilter           bool   `json:"prefilter" bson:"prefilter"`                 // queue only destinations found in the kernel target sets
+	PrefilterTimeout    int    `json:"prefilter_timeout" bson:"prefilter_timeout"` // seconds a learned IP stays in the sets
+	Backend             string `json:"backend" bson:"backend"`                     // "auto", "nftables", "iptables" or "netlink"
 }
 
 type WebServerConfig struct {
@@ -205,6 +305,34 @@ type SetConfig struct {
 	Targets       TargetsConfig       `json:"targets" bson:"targets"`
 	Enabled       bool                `json:"enabled" bson:"enabled"`
 	DNS           DNSConfig           `json:"dns" bson:"dns"`
+	Schedule      ScheduleConfig      `json:"schedule" bson:"schedule"`
+	Pipeline      []StrategyStep      `json:"pipeline" bson:"pipeline"`
+}
+
+// StrategyStep is one action of a set's TCP strategy pipeline. Params left
+// unset come from the set's sections.
+type StrategyStep struct {
+	Action   string   `json:"action" bson:"action"`
+	Strategy string   `json:"strategy,omitempty" bson:"strategy,omitempty"` // fragment
+	At       []string `json:"at,omitempty" bson:"at,omitempty"`             // split, tls_split: "sni", "midsni", "sniend" or an offset
+	Count    int      `json:"count,omitempty" bson:"count,omitempty"`       // fake, send
+	Reverse  bool     `json:"reverse,omitempty" bson:"reverse,omitempty"`   // send: last segment first
+	Delay    int      `json:"delay,omitempty" bson:"delay,omitempty"`       // delay: milliseconds
+}
+
+// ScheduleConfig limits an enabled set to certain days and hours.
+type ScheduleConfig struct {
+	Enabled  bool                `json:"enabled" bson:"enabled"`
+	Days     []string            `json:"days" bson:"days"`         // "mon".."sun"; empty means every day
+	Ranges   []ScheduleTimeRange `json:"ranges" bson:"ranges"`     // empty means the whole day
+	Timezone string              `json:"timezone" bson:"timezone"` // IANA name; empty means local time
+}
+
+// ScheduleTimeRange is a "HH:MM" window. An End at or before Start runs past
+// midnight into the next day.
+type ScheduleTimeRange struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
 }
 
 type GeoDatConfig struct {
@@ -212,6 +340,10 @@ type GeoDatConfig struct {
 	GeoIpPath   string `json:"ipdat_path" bson:"ipdat_path"`
 	GeoSiteURL  string `json:"sitedat_url" bson:"sitedat_url"`
 	GeoIpURL    string `json:"ipdat_url" bson:"ipdat_url"`
+
+	ASNSource       string `json:"asn_source" bson:"asn_source"`               // "ripestat" or "file"
+	ASNDatabasePath string `json:"asn_database_path" bson:"asn_database_path"` // CSV/TSV or MRT RIB dump for the "file" source
+	ASNRefreshHours int    `json:"asn_refresh_hours" bson:"asn_refresh_hours"`
 }
 
 type ComboFragConfig struct {
@@ -258,3 +390,14 @@ type DeviceMSSClamp struct {
 	Mac  string `json:"mac" bson:"mac"`
 	Size int    `json:"size" bson:"size"`
 }
+
+// DeviceProfile gathers everything b4 does differently for one device.
+type DeviceProfile struct {
+	Mac       string   `json:"mac" bson:"mac"`
+	Alias     string   `json:"alias" bson:"alias"`
+	Enabled   bool     `json:"enabled" bson:"enabled"`
+	Bypass    bool     `json:"bypass" bson:"bypass"`         // never process this device's traffic
+	Sets      []string `json:"sets" bson:"sets"`             // IDs of the only sets applied to the device; empty means all
+	MSSClamp  int      `json:"mss_clamp" bson:"mss_clamp"`   // 0 keeps the global/per-device clamp settings
+	DNSTarget string   `json:"dns_target" bson:"dns_target"` // redirect all of the device's DNS queries here
+}
@@ -0,0 +1,178 @@
+// Package conntrack talks to the kernel connection tracker over ctnetlink:
+// it dumps entries with their counters and reads and updates ctmarks.
+package conntrack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// ctnetlink message and attribute numbers (linux/netfilter/nfnetlink_conntrack.h).
+const (
+	ipctnlMsgCtNew = 0
+	ipctnlMsgCtGet = 1
+
+	ctaTupleOrig     = 1
+	ctaMark          = 8
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+	ctaID            = 12
+	ctaMarkMask      = 21
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaCountersPackets = 1
+	ctaCountersBytes   = 2
+)
+
+// Tuple is the original-direction tuple of a connection as b4 saw it in the
+// queue, before any NAT.
+type Tuple struct {
+	Proto uint8
+	Src   netip.AddrPort
+	Dst   netip.AddrPort
+}
+
+// TupleFromIP builds a tuple from packet addresses, unmapping IPv4 addresses
+// held in 16-byte form.
+func TupleFromIP(proto uint8, src net.IP, sport uint16, dst net.IP, dport uint16) (Tuple, bool) {
+	s, ok1 := netip.AddrFromSlice(src)
+	d, ok2 := netip.AddrFromSlice(dst)
+	if !ok1 || !ok2 {
+		return Tuple{}, false
+	}
+	return Tuple{
+		Proto: proto,
+		Src:   netip.AddrPortFrom(s.Unmap(), sport),
+		Dst:   netip.AddrPortFrom(d.Unmap(), dport),
+	}, true
+}
+
+// Entry is one conntrack entry with both directions' counters summed.
+type Entry struct {
+	ID      uint32
+	Tuple   Tuple
+	Mark    uint32
+	Bytes   uint64
+	Packets uint64
+}
+
+// Dump lists the kernel conntrack table. Counters are only non-zero for
+// connections created while net.netfilter.nf_conntrack_acct is 1.
+func Dump() ([]Entry, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial netfilter netlink: %w", err)
+	}
+	defer conn.Close()
+
+	msgs, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8 | ipctnlMsgCtGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("conntrack dump: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(msgs))
+	for _, m := range msgs {
+		if e, ok := parseEntry(m.Data); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// parseEntry decodes a ctnetlink message body (nfgenmsg + attributes).
+func parseEntry(data []byte) (Entry, bool) {
+	var e Entry
+	if len(data) < 4 {
+		return e, false
+	}
+	ad, err := netlink.NewAttributeDecoder(data[4:])
+	if err != nil {
+		return e, false
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	var src, dst netip.Addr
+	var sport, dport uint16
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaTupleOrig:
+			ad.Nested(func(t *netlink.AttributeDecoder) error {
+				for t.Next() {
+					switch t.Type() {
+					case ctaTupleIP:
+						t.Nested(func(ip *netlink.AttributeDecoder) error {
+							for ip.Next() {
+								switch ip.Type() {
+								case ctaIPv4Src, ctaIPv6Src:
+									src, _ = netip.AddrFromSlice(ip.Bytes())
+								case ctaIPv4Dst, ctaIPv6Dst:
+									dst, _ = netip.AddrFromSlice(ip.Bytes())
+								}
+							}
+							return nil
+						})
+					case ctaTupleProto:
+						t.Nested(func(p *netlink.AttributeDecoder) error {
+							for p.Next() {
+								switch p.Type() {
+								case ctaProtoNum:
+									e.Tuple.Proto = p.Uint8()
+								case ctaProtoSrcPort:
+									sport = p.Uint16()
+								case ctaProtoDstPort:
+									dport = p.Uint16()
+								}
+							}
+							return nil
+						})
+					}
+				}
+				return nil
+			})
+		case ctaCountersOrig, ctaCountersReply:
+			ad.Nested(func(c *netlink.AttributeDecoder) error {
+				for c.Next() {
+					switch c.Type() {
+					case ctaCountersPackets:
+						e.Packets += c.Uint64()
+					case ctaCountersBytes:
+						e.Bytes += c.Uint64()
+					}
+				}
+				return nil
+			})
+		case ctaMark:
+			e.Mark = ad.Uint32()
+		case ctaID:
+			e.ID = ad.Uint32()
+		}
+	}
+	if ad.Err() != nil || !src.IsValid() || !dst.IsValid() {
+		return e, false
+	}
+	e.Tuple.Src = netip.AddrPortFrom(src, sport)
+	e.Tuple.Dst = netip.AddrPortFrom(dst, dport)
+	return e, true
+}
@@ -0,0 +1,111 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+)
+
+func buildEntryMsg(t *testing.T, src, dst netip.Addr, sport, dport uint16, mark, id uint32, bytes uint64) []byte {
+	t.Helper()
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Nested(ctaTupleOrig, func(tuple *netlink.AttributeEncoder) error {
+		tuple.Nested(ctaTupleIP, func(ip *netlink.AttributeEncoder) error {
+			ip.Bytes(ctaIPv4Src, src.AsSlice())
+			ip.Bytes(ctaIPv4Dst, dst.AsSlice())
+			return nil
+		})
+		tuple.Nested(ctaTupleProto, func(p *netlink.AttributeEncoder) error {
+			p.Uint8(ctaProtoNum, 6)
+			p.Uint16(ctaProtoSrcPort, sport)
+			p.Uint16(ctaProtoDstPort, dport)
+			return nil
+		})
+		return nil
+	})
+	for _, typ := range []uint16{ctaCountersOrig, ctaCountersReply} {
+		ae.Nested(typ, func(c *netlink.AttributeEncoder) error {
+			c.Uint64(ctaCountersPackets, 2)
+			c.Uint64(ctaCountersBytes, bytes)
+			return nil
+		})
+	}
+	ae.Uint32(ctaMark, mark)
+	ae.Uint32(ctaID, id)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte{2, 0, 0, 0}, b...)
+}
+
+func TestParseEntry(t *testing.T) {
+	src := netip.MustParseAddr("192.168.1.10")
+	dst := netip.MustParseAddr("142.250.74.14")
+	e, ok := parseEntry(buildEntryMsg(t, src, dst, 51000, 443, 0x00020000, 77, 1500))
+	if !ok {
+		t.Fatal("expected entry to parse")
+	}
+	want := Tuple{Proto: 6, Src: netip.AddrPortFrom(src, 51000), Dst: netip.AddrPortFrom(dst, 443)}
+	if e.Tuple != want {
+		t.Errorf("tuple = %+v, want %+v", e.Tuple, want)
+	}
+	if e.Bytes != 3000 || e.Packets != 4 {
+		t.Errorf("counters = %d bytes %d packets, want 3000/4", e.Bytes, e.Packets)
+	}
+	if e.Mark != 0x00020000 || e.ID != 77 {
+		t.Errorf("mark/id = %#x/%d", e.Mark, e.ID)
+	}
+
+	if _, ok := parseEntry([]byte{2, 0}); ok {
+		t.Error("short message should not parse")
+	}
+}
+
+func TestMarkFromAttr(t *testing.T) {
+	msg := buildEntryMsg(t, netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1"), 1000, 443, 0x00038000, 1, 0)
+	mark, ok := MarkFromAttr(msg[4:])
+	if !ok || mark != 0x00038000 {
+		t.Errorf("MarkFromAttr = %#x, %v; want 0x38000, true", mark, ok)
+	}
+
+	if _, ok := MarkFromAttr(nil); ok {
+		t.Error("empty attribute should carry no mark")
+	}
+}
+
+func TestEncodeSetMark(t *testing.T) {
+	tuple, ok := TupleFromIP(17, net.ParseIP("2001:db8::2"), 5000, net.ParseIP("2001:db8::1"), 443)
+	if !ok {
+		t.Fatal("TupleFromIP failed")
+	}
+	data, err := encodeSetMark(tuple, 0x00020000, 0x00ff0000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 10 { // AF_INET6
+		t.Errorf("family = %d, want AF_INET6", data[0])
+	}
+
+	e, ok := parseEntry(data)
+	if !ok {
+		t.Fatal("encoded message does not parse")
+	}
+	if e.Tuple != tuple || e.Mark != 0x00020000 {
+		t.Errorf("round trip = %+v mark %#x, want %+v", e.Tuple, e.Mark, tuple)
+	}
+}
+
+func TestTupleFromIPUnmaps(t *testing.T) {
+	tuple, ok := TupleFromIP(6, net.ParseIP("192.168.1.10"), 5000, net.ParseIP("8.8.8.8").To4(), 443)
+	if !ok {
+		t.Fatal("TupleFromIP failed")
+	}
+	if !tuple.Src.Addr().Is4() || tuple.Src.String() != "192.168.1.10:5000" {
+		t.Errorf("src = %v, want unmapped IPv4", tuple.Src)
+	}
+}
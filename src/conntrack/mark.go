@@ -0,0 +1,98 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// MarkFromAttr returns the ctmark carried in the NFQA_CT attribute of a
+// queued packet, which the kernel includes when the queue was bound with
+// NFQA_CFG_F_CONNTRACK. ok is false for untracked packets.
+func MarkFromAttr(ct []byte) (mark uint32, ok bool) {
+	ad, err := netlink.NewAttributeDecoder(ct)
+	if err != nil {
+		return 0, false
+	}
+	ad.ByteOrder = binary.BigEndian
+	for ad.Next() {
+		if ad.Type() == ctaMark {
+			mark, ok = ad.Uint32(), true
+		}
+	}
+	if ad.Err() != nil {
+		return 0, false
+	}
+	return mark, ok
+}
+
+// Conn is a ctnetlink socket for mark updates. It is not safe for concurrent
+// use; each queue worker owns one.
+type Conn struct {
+	c *netlink.Conn
+}
+
+// Dial opens a ctnetlink socket.
+func Dial() (*Conn, error) {
+	c, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial netfilter netlink: %w", err)
+	}
+	return &Conn{c: c}, nil
+}
+
+// Close closes the socket.
+func (c *Conn) Close() error {
+	return c.c.Close()
+}
+
+// SetMark replaces the bits of mask in the ctmark of the existing connection
+// t, leaving the other bits alone. It fails with ENOENT while the connection
+// is still unconfirmed.
+func (c *Conn) SetMark(t Tuple, mark, mask uint32) error {
+	data, err := encodeSetMark(t, mark, mask)
+	if err != nil {
+		return err
+	}
+	_, err = c.c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8 | ipctnlMsgCtNew),
+			Flags: netlink.Request | netlink.Acknowledge,
+		},
+		Data: data,
+	})
+	return err
+}
+
+func encodeSetMark(t Tuple, mark, mask uint32) ([]byte, error) {
+	family, srcAttr, dstAttr := byte(unix.AF_INET), uint16(ctaIPv4Src), uint16(ctaIPv4Dst)
+	if t.Src.Addr().Is6() {
+		family, srcAttr, dstAttr = unix.AF_INET6, ctaIPv6Src, ctaIPv6Dst
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	ae.Nested(ctaTupleOrig, func(tuple *netlink.AttributeEncoder) error {
+		tuple.Nested(ctaTupleIP, func(ip *netlink.AttributeEncoder) error {
+			ip.Bytes(srcAttr, t.Src.Addr().AsSlice())
+			ip.Bytes(dstAttr, t.Dst.Addr().AsSlice())
+			return nil
+		})
+		tuple.Nested(ctaTupleProto, func(p *netlink.AttributeEncoder) error {
+			p.Uint8(ctaProtoNum, t.Proto)
+			p.Uint16(ctaProtoSrcPort, t.Src.Port())
+			p.Uint16(ctaProtoDstPort, t.Dst.Port())
+			return nil
+		})
+		return nil
+	})
+	ae.Uint32(ctaMark, mark)
+	ae.Uint32(ctaMarkMask, mask)
+	attrs, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{family, unix.NFNETLINK_V0, 0, 0}, attrs...), nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/http/handler"
+	"github.com/daniellavrushin/b4/metrics"
+	"github.com/spf13/cobra"
+)
+
+var (
+	controlSocket string
+	controlJSON   bool
+)
+
+// controlCommand makes cmd and its subcommands clients of the running b4
+// and adds it to the root command.
+func controlCommand(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&controlSocket, "socket", config.DefaultConfig.System.Control.Socket, "Control socket of the running b4")
+	cmd.PersistentFlags().BoolVar(&controlJSON, "json", false, "Print JSON instead of a table")
+	// Usage only helps with bad arguments, not with a failed request
+	cmd.PersistentPreRun = func(c *cobra.Command, args []string) {
+		c.SilenceUsage = true
+	}
+	rootCmd.AddCommand(cmd)
+}
+
+// controlClient calls the REST API of the running b4 over the control socket.
+type controlClient struct {
+	http *http.Client
+}
+
+func newControlClient() *controlClient {
+	return &controlClient{http: &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", controlSocket)
+			},
+		},
+	}}
+}
+
+// request sends body as JSON and returns the status and body of the answer.
+func (c *controlClient) request(method, path string, body interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://b4"+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("b4 not reachable on %s: %w", controlSocket, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("read response: %w", err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// call is request for endpoints that answer 2xx on success.
+func (c *controlClient) call(method, path string, body interface{}) ([]byte, error) {
+	status, data, err := c.request(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if status < 200 || status > 299 {
+		msg := strings.TrimSpace(string(data))
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			msg = apiErr.Error
+		}
+		if msg == "" {
+			msg = http.StatusText(status)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, msg)
+	}
+	return data, nil
+}
+
+// show prints data as JSON with --json, or decodes it for printTable
+// otherwise.
+func show[T any](data []byte, printTable func(T)) error {
+	if controlJSON {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return fmt.Errorf("unexpected response: %w", err)
+		}
+		buf.WriteByte('\n')
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	printTable(v)
+	return nil
+}
+
+// newTable starts a table on stdout with the given header; Flush prints it.
+func newTable(header ...string) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	return tw
+}
+
+// apiMessage is the answer of endpoints that only report an action done.
+type apiMessage struct {
+	Message string `json:"message"`
+}
+
+func printMessage(m apiMessage) {
+	fmt.Println(m.Message)
+}
+
+var tablesCmd = &cobra.Command{
+	Use:   "tables",
+	Short: "Manage the firewall rules of the running b4",
+}
+
+var tablesRefreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Reinstall the firewall rules",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := newControlClient().call(http.MethodPost, "/api/tables/refresh", nil)
+		if err != nil {
+			return err
+		}
+		return show(data, printMessage)
+	},
+}
+
+var metricsReset bool
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Show traffic and queue worker metrics of the running b4",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c := newControlClient()
+		if metricsReset {
+			data, err := c.call(http.MethodPost, "/api/metrics/reset", nil)
+			if err != nil {
+				return err
+			}
+			return show(data, printMessage)
+		}
+		data, err := c.call(http.MethodGet, "/api/metrics", nil)
+		if err != nil {
+			return err
+		}
+		return show(data, printMetrics)
+	},
+}
+
+func printMetrics(m *metrics.MetricsCollector) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Uptime\t%s\n", m.Uptime)
+	fmt.Fprintf(tw, "Connections\t%d total, %d targeted, %d TCP, %d UDP, %.1f/s\n",
+		m.TotalConnections, m.TargetedConnections, m.TCPConnections, m.UDPConnections, m.CurrentCPS)
+	fmt.Fprintf(tw, "Packets\t%d, %.1f/s\n", m.PacketsProcessed, m.CurrentPPS)
+	fmt.Fprintf(tw, "Active flows\t%d\n", m.ActiveFlows)
+	fmt.Fprintf(tw, "NFQueue\t%s\n", m.NFQueueStatus)
+	fmt.Fprintf(tw, "Tables\t%s\n", m.TablesStatus)
+	fmt.Fprintf(tw, "Memory\t%.1f%%\n", m.MemoryUsage.Percent)
+	tw.Flush()
+
+	if len(m.WorkerStatus) == 0 {
+		return
+	}
+	fmt.Println()
+	tw = newTable("WORKER", "STATUS", "PROCESSED", "RECEIVED", "VERDICTS", "RESTARTS", "PENDING")
+	for _, w := range m.WorkerStatus {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%d\n", w.ID, w.Status, w.Processed,
+			w.Liveness.Received, w.Liveness.Verdicts, w.Liveness.Restarts, w.Injection.Pending)
+	}
+	tw.Flush()
+}
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List the LAN devices the running b4 knows from DHCP",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := newControlClient().call(http.MethodGet, "/api/devices", nil)
+		if err != nil {
+			return err
+		}
+		return show(data, printDevices)
+	},
+}
+
+func printDevices(r handler.DevicesResponse) {
+	if !r.Available {
+		fmt.Println("No DHCP lease source available")
+		return
+	}
+	sort.Slice(r.Devices, func(i, j int) bool { return r.Devices[i].IP < r.Devices[j].IP })
+	tw := newTable("MAC", "IP", "HOSTNAME", "VENDOR", "ALIAS")
+	for _, d := range r.Devices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.MAC, d.IP, d.Hostname, d.Vendor, d.Alias)
+	}
+	tw.Flush()
+}
+
+func init() {
+	tablesCmd.AddCommand(tablesRefreshCmd)
+	controlCommand(tablesCmd)
+
+	metricsCmd.Flags().BoolVar(&metricsReset, "reset", false, "Reset the statistics instead of showing them")
+	controlCommand(metricsCmd)
+
+	controlCommand(devicesCmd)
+}
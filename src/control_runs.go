@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/daniellavrushin/b4/detector"
+	"github.com/daniellavrushin/b4/discovery"
+	"github.com/daniellavrushin/b4/http/handler"
+	"github.com/spf13/cobra"
+)
+
+const runPollInterval = 2 * time.Second
+
+// runProgress reads a status answer of a discovery or detector run.
+type runProgress func(data []byte) (done bool, progress string, err error)
+
+// waitRun polls the status of a run until it ends and returns its last
+// status. Interrupting the wait cancels the run.
+func waitRun(c *controlClient, kind, id string, progress runProgress) ([]byte, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(runPollInterval)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		data, err := c.call(http.MethodGet, "/api/"+kind+"/status/"+url.PathEscape(id), nil)
+		if err != nil {
+			return nil, err
+		}
+		done, p, err := progress(data)
+		if err != nil {
+			return nil, err
+		}
+		if p != last {
+			fmt.Fprintln(os.Stderr, p)
+			last = p
+		}
+		if done {
+			return data, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if _, err := c.call(http.MethodDelete, "/api/"+kind+"/cancel/"+url.PathEscape(id), nil); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s %s canceled", kind, id)
+		case <-ticker.C:
+		}
+	}
+}
+
+var discoveryCmd = &cobra.Command{
+	Use:   "discovery",
+	Short: "Find working bypass strategies for domains",
+}
+
+var (
+	discoveryWait      bool
+	discoverySkipDNS   bool
+	discoverySkipCache bool
+	discoveryTLS       string
+)
+
+var discoveryRunCmd = &cobra.Command{
+	Use:   "run <domain|url>...",
+	Short: "Start a discovery run",
+	Long: `Starts a discovery run for the domains and prints its ID, or with --wait
+follows it and prints the best strategy per domain. Interrupting --wait
+cancels the run.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runDiscovery,
+}
+
+var discoveryStatusCmd = &cobra.Command{
+	Use:   "status <id>",
+	Short: "Show the progress and results of a discovery run",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := newControlClient().call(http.MethodGet, "/api/discovery/status/"+url.PathEscape(args[0]), nil)
+		if err != nil {
+			return err
+		}
+		return show(data, printDiscovery)
+	},
+}
+
+func runDiscovery(cmd *cobra.Command, args []string) error {
+	c := newControlClient()
+	req := handler.DiscoveryRequest{
+		CheckURLs:  args,
+		SkipDNS:    discoverySkipDNS,
+		SkipCache:  discoverySkipCache,
+		TLSVersion: discoveryTLS,
+	}
+	data, err := c.call(http.MethodPost, "/api/discovery/start", req)
+	if err != nil {
+		return err
+	}
+	if !discoveryWait {
+		return show(data, func(r handler.DiscoveryResponse) {
+			fmt.Printf("%s, id %s\n", r.Message, r.Id)
+		})
+	}
+
+	var started handler.DiscoveryResponse
+	if err := json.Unmarshal(data, &started); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	data, err = waitRun(c, "discovery", started.Id, func(data []byte) (bool, string, error) {
+		var s discovery.CheckSuite
+		if err := json.Unmarshal(data, &s); err != nil {
+			return false, "", fmt.Errorf("unexpected response: %w", err)
+		}
+		p := fmt.Sprintf("[%d/%d] %s", s.CompletedChecks, s.TotalChecks, s.Status)
+		if s.CurrentDomain != "" {
+			p += fmt.Sprintf(": %s %s", s.CurrentDomain, s.CurrentPhase)
+		}
+		return discoveryFinished(s.Status), p, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := show(data, printDiscovery); err != nil {
+		return err
+	}
+
+	var s discovery.CheckSuite
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	if s.Status != discovery.CheckStatusComplete {
+		return fmt.Errorf("discovery %s", s.Status)
+	}
+	return nil
+}
+
+func discoveryFinished(status discovery.CheckStatus) bool {
+	switch status {
+	case discovery.CheckStatusComplete, discovery.CheckStatusFailed, discovery.CheckStatusCanceled:
+		return true
+	}
+	return false
+}
+
+func printDiscovery(s *discovery.CheckSuite) {
+	fmt.Printf("Discovery %s: %s, %d/%d checks, %d successful\n",
+		s.Id, s.Status, s.CompletedChecks, s.TotalChecks, s.SuccessfulChecks)
+	if len(s.DomainDiscoveryResults) == 0 {
+		return
+	}
+
+	domains := make([]string, 0, len(s.DomainDiscoveryResults))
+	for d := range s.DomainDiscoveryResults {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+
+	fmt.Println()
+	tw := newTable("DOMAIN", "WORKS", "BEST PRESET", "SPEED", "IMPROVEMENT")
+	for _, d := range domains {
+		r := s.DomainDiscoveryResults[d]
+		fmt.Fprintf(tw, "%s\t%t\t%s\t%.2f MB/s\t%+.0f%%\n",
+			r.Domain, r.BestSuccess, r.BestPreset, r.BestSpeed/1024/1024, r.Improvement)
+	}
+	tw.Flush()
+}
+
+var detectorCmd = &cobra.Command{
+	Use:   "detector",
+	Short: "Detect how the network blocks traffic",
+}
+
+var (
+	detectorWait  bool
+	detectorTests []string
+)
+
+var detectorRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Start a detector run",
+	Long: `Starts a detector run and prints its ID, or with --wait follows it and
+prints the summary of each test. Interrupting --wait cancels the run.`,
+	Args: cobra.NoArgs,
+	RunE: runDetector,
+}
+
+var detectorStatusCmd = &cobra.Command{
+	Use:   "status <id>",
+	Short: "Show the progress and results of a detector run",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := newControlClient().call(http.MethodGet, "/api/detector/status/"+url.PathEscape(args[0]), nil)
+		if err != nil {
+			return err
+		}
+		return show(data, printDetector)
+	},
+}
+
+func runDetector(cmd *cobra.Command, args []string) error {
+	c := newControlClient()
+	data, err := c.call(http.MethodPost, "/api/detector/start", handler.DetectorRequest{Tests: detectorTests})
+	if err != nil {
+		return err
+	}
+	if !detectorWait {
+		return show(data, func(r handler.DetectorResponse) {
+			fmt.Printf("%s, id %s\n", r.Message, r.Id)
+		})
+	}
+
+	var started handler.DetectorResponse
+	if err := json.Unmarshal(data, &started); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	data, err = waitRun(c, "detector", started.Id, func(data []byte) (bool, string, error) {
+		var s detector.DetectorSuite
+		if err := json.Unmarshal(data, &s); err != nil {
+			return false, "", fmt.Errorf("unexpected response: %w", err)
+		}
+		p := fmt.Sprintf("[%d/%d] %s", s.CompletedChecks, s.TotalChecks, s.Status)
+		if s.CurrentTest != "" {
+			p += fmt.Sprintf(": %s", s.CurrentTest)
+		}
+		return detectorFinished(s.Status), p, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := show(data, printDetector); err != nil {
+		return err
+	}
+
+	var s detector.DetectorSuite
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+	if s.Status != detector.StatusComplete {
+		return fmt.Errorf("detector %s", s.Status)
+	}
+	return nil
+}
+
+func detectorFinished(status detector.SuiteStatus) bool {
+	switch status {
+	case detector.StatusComplete, detector.StatusFailed, detector.StatusCanceled:
+		return true
+	}
+	return false
+}
+
+func printDetector(s *detector.DetectorSuite) {
+	fmt.Printf("Detector %s: %s, %d/%d checks\n", s.Id, s.Status, s.CompletedChecks, s.TotalChecks)
+
+	tw := newTable("TEST", "SUMMARY")
+	if s.DNSResult != nil {
+		fmt.Fprintf(tw, "dns\t%s\n", s.DNSResult.Summary)
+	}
+	if s.DomainsResult != nil {
+		fmt.Fprintf(tw, "domains\t%s\n", s.DomainsResult.Summary)
+	}
+	if s.TCPResult != nil {
+		fmt.Fprintf(tw, "tcp\t%s\n", s.TCPResult.Summary)
+	}
+	if s.QUICResult != nil {
+		fmt.Fprintf(tw, "quic\t%s\n", s.QUICResult.Summary)
+	}
+	tw.Flush()
+}
+
+func init() {
+	discoveryRunCmd.Flags().BoolVar(&discoveryWait, "wait", false, "Follow the run and print its results")
+	discoveryRunCmd.Flags().BoolVar(&discoverySkipDNS, "skip-dns", false, "Skip the DNS checks")
+	discoveryRunCmd.Flags().BoolVar(&discoverySkipCache, "skip-cache", false, "Ignore strategies cached by earlier runs")
+	discoveryRunCmd.Flags().StringVar(&discoveryTLS, "tls", "auto", "TLS version to test with (auto, tls12, tls13)")
+	discoveryCmd.AddCommand(discoveryRunCmd, discoveryStatusCmd)
+	controlCommand(discoveryCmd)
+
+	allTests := []string{
+		string(detector.TestDNS), string(detector.TestDomains),
+		string(detector.TestTCP), string(detector.TestQUIC),
+	}
+	detectorRunCmd.Flags().BoolVar(&detectorWait, "wait", false, "Follow the run and print its results")
+	detectorRunCmd.Flags().StringSliceVar(&detectorTests, "tests", allTests, "Tests to run ("+strings.Join(allTests, ", ")+")")
+	detectorCmd.AddCommand(detectorRunCmd, detectorStatusCmd)
+	controlCommand(detectorCmd)
+}
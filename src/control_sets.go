@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/spf13/cobra"
+)
+
+var setsCmd = &cobra.Command{
+	Use:   "sets",
+	Short: "List and change the sets of the running b4",
+	Long: `List and change the sets of the running b4. A set is named by its ID or,
+when that is unique, its name.`,
+}
+
+var setsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the sets in match order",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := newControlClient().call(http.MethodGet, "/api/sets", nil)
+		if err != nil {
+			return err
+		}
+		return show(data, printSets)
+	},
+}
+
+func printSets(sets []*config.SetConfig) {
+	tw := newTable("#", "NAME", "ENABLED", "DOMAINS", "IPS", "GEOSITE", "GEOIP", "ID")
+	for i, s := range sets {
+		t := s.Targets
+		fmt.Fprintf(tw, "%d\t%s\t%t\t%d\t%d\t%d\t%d\t%s\n", i+1, s.Name, s.Enabled,
+			len(t.SNIDomains), len(t.IPs), len(t.GeoSiteCategories), len(t.GeoIpCategories), s.Id)
+	}
+	tw.Flush()
+}
+
+var setsAddDomainCmd = &cobra.Command{
+	Use:   "add-domain <set> <domain>...",
+	Short: "Add domains to a set",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runSetsAddDomain,
+}
+
+// addDomainResult is what add-domain prints with --json.
+type addDomainResult struct {
+	Set     string   `json:"set"`
+	Added   []string `json:"added"`
+	Skipped []string `json:"skipped"` // already in the set
+}
+
+func runSetsAddDomain(cmd *cobra.Command, args []string) error {
+	c := newControlClient()
+	set, err := findSet(c, args[0])
+	if err != nil {
+		return err
+	}
+
+	have := make(map[string]bool, len(set.Targets.SNIDomains))
+	for _, d := range set.Targets.SNIDomains {
+		have[strings.ToLower(d)] = true
+	}
+
+	res := addDomainResult{Set: set.Id, Added: []string{}, Skipped: []string{}}
+	for _, d := range args[1:] {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" {
+			continue
+		}
+		if have[d] {
+			res.Skipped = append(res.Skipped, d)
+			continue
+		}
+		body := map[string]string{"domain": d}
+		if _, err := c.call(http.MethodPost, "/api/sets/"+url.PathEscape(set.Id)+"/add-domain", body); err != nil {
+			return err
+		}
+		have[d] = true
+		res.Added = append(res.Added, d)
+	}
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return show(data, func(r addDomainResult) {
+		for _, d := range r.Added {
+			fmt.Printf("Added %s to %s\n", d, set.Name)
+		}
+		for _, d := range r.Skipped {
+			fmt.Printf("%s already in %s\n", d, set.Name)
+		}
+	})
+}
+
+var setsEnableCmd = &cobra.Command{
+	Use:   "enable <set>",
+	Short: "Enable a set",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setEnabled(args[0], true)
+	},
+}
+
+var setsDisableCmd = &cobra.Command{
+	Use:   "disable <set>",
+	Short: "Disable a set",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setEnabled(args[0], false)
+	},
+}
+
+// setEnabled switches a set on or off through the same update the web UI
+// makes, sending the set back as it was fetched apart from "enabled".
+func setEnabled(ref string, enabled bool) error {
+	c := newControlClient()
+	set, err := findSet(c, ref)
+	if err != nil {
+		return err
+	}
+	path := "/api/sets/" + url.PathEscape(set.Id)
+
+	data, err := c.call(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	if set.Enabled != enabled {
+		raw["enabled"] = enabled
+		if data, err = c.call(http.MethodPut, path, raw); err != nil {
+			return err
+		}
+	}
+	return show(data, func(s config.SetConfig) {
+		fmt.Printf("Set %s %s\n", s.Name, state)
+	})
+}
+
+// findSet picks the set ref names, by ID or else by unique name.
+func findSet(c *controlClient, ref string) (*config.SetConfig, error) {
+	data, err := c.call(http.MethodGet, "/api/sets", nil)
+	if err != nil {
+		return nil, err
+	}
+	var sets []*config.SetConfig
+	if err := json.Unmarshal(data, &sets); err != nil {
+		return nil, fmt.Errorf("unexpected response: %w", err)
+	}
+
+	for _, s := range sets {
+		if s.Id == ref {
+			return s, nil
+		}
+	}
+	var found *config.SetConfig
+	for _, s := range sets {
+		if strings.EqualFold(s.Name, ref) {
+			if found != nil {
+				return nil, fmt.Errorf("more than one set is named %q, use its ID", ref)
+			}
+			found = s
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no set %q", ref)
+	}
+	return found, nil
+}
+
+func init() {
+	setsCmd.AddCommand(setsListCmd, setsAddDomainCmd, setsEnableCmd, setsDisableCmd)
+	controlCommand(setsCmd)
+}
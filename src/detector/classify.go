@@ -1,8 +1,11 @@
 package detector
 
 import (
+	"encoding/binary"
 	"fmt"
 	"strings"
+
+	"github.com/daniellavrushin/b4/quic"
 )
 
 // ClassifyTLSError classifies a TLS connection error into a DomainStatus.
@@ -130,6 +133,65 @@ func ClassifyTCPError(err error, bytesRead int64) (TCPStatus, string) {
 	return TCPError, err.Error()
 }
 
+// ClassifyUDPError classifies a failed UDP/QUIC probe into a UDPStatus.
+func ClassifyUDPError(err error) (UDPStatus, string) {
+	if err == nil {
+		return UDPOk, ""
+	}
+
+	msg := strings.ToLower(err.Error())
+
+	if strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded") {
+		return UDPNoResponse, "No response (datagrams silently dropped)"
+	}
+
+	resetPatterns := []struct {
+		pattern string
+		detail  string
+	}{
+		{"connection refused", "ICMP port unreachable received"},
+		{"host is unreachable", "ICMP host unreachable received"},
+		{"no route to host", "ICMP host unreachable received"},
+		{"network is unreachable", "ICMP network unreachable received"},
+	}
+
+	for _, p := range resetPatterns {
+		if strings.Contains(msg, p.pattern) {
+			return UDPReset, p.detail
+		}
+	}
+
+	if strings.Contains(msg, "no such host") || strings.Contains(msg, "no address") {
+		return UDPError, "DNS resolution failed"
+	}
+
+	return UDPError, err.Error()
+}
+
+// ClassifyQUICResponse classifies the first datagram received in reply to a QUIC Initial.
+func ClassifyQUICResponse(resp []byte) (UDPStatus, string) {
+	if len(resp) == 0 {
+		return UDPNoResponse, "Empty reply"
+	}
+	if resp[0]&0x80 == 0 || len(resp) < 5 {
+		return UDPOk, fmt.Sprintf("Non-QUIC reply (%d bytes)", len(resp))
+	}
+
+	version := binary.BigEndian.Uint32(resp[1:5])
+	if version == 0 {
+		return UDPOk, "Version negotiation received"
+	}
+
+	switch {
+	case quic.IsInitial(resp):
+		return UDPOk, fmt.Sprintf("Server Initial received (%d bytes)", len(resp))
+	case version == quic.VersionV1 && (resp[0]&0x30)>>4 == 0x03:
+		return UDPOk, "Retry received"
+	default:
+		return UDPOk, fmt.Sprintf("QUIC long-header reply (%d bytes)", len(resp))
+	}
+}
+
 func formatKB(kb float64) string {
 	if kb < 1 {
 		return "<1"
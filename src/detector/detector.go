@@ -58,6 +58,12 @@ func (s *DetectorSuite) Run() {
 			s.mu.Lock()
 			s.TCPResult = result
 			s.mu.Unlock()
+
+		case TestQUIC:
+			result := s.runQUICCheck(ctx)
+			s.mu.Lock()
+			s.QUICResult = result
+			s.mu.Unlock()
 		}
 	}
 
@@ -80,6 +86,8 @@ func (s *DetectorSuite) estimateTotalChecks() int {
 			total += len(CheckDomains) * 3 // TLS1.3 + TLS1.2 + HTTP
 		case TestTCP:
 			total += len(TCPTargets)
+		case TestQUIC:
+			total += len(QUICTargets) + len(s.udpTargets())
 		}
 	}
 	return total
@@ -0,0 +1,56 @@
+package detector
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRunFillsQUICResult(t *testing.T) {
+	// A local UDP endpoint answering every datagram
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	saved := QUICTargets
+	QUICTargets = nil
+	defer func() { QUICTargets = saved }()
+
+	suite := NewDetectorSuite([]TestType{TestQUIC})
+	suite.UDPTargets = []UDPTarget{{ID: "local", Host: "127.0.0.1", Port: conn.LocalAddr().(*net.UDPAddr).Port, Probe: "raw"}}
+	suite.Run()
+
+	if suite.QUICResult == nil {
+		t.Fatal("QUICResult not filled")
+	}
+	if suite.QUICResult.UDPOkCount != 1 || len(suite.QUICResult.UDPTargets) != 1 {
+		t.Errorf("unexpected QUIC result: %+v", suite.QUICResult)
+	}
+	if suite.TotalChecks != 1 || suite.CompletedChecks != 1 {
+		t.Errorf("checks %d/%d, want 1/1", suite.CompletedChecks, suite.TotalChecks)
+	}
+	if suite.Status != StatusComplete {
+		t.Errorf("status = %s, want complete", suite.Status)
+	}
+}
+
+func TestCountUDPStatus(t *testing.T) {
+	var ok, blocked, noResponse int
+	for _, st := range []UDPStatus{UDPOk, UDPReset, UDPNoResponse, UDPNoResponse, UDPError} {
+		countUDPStatus(st, &ok, &blocked, &noResponse)
+	}
+	if ok != 1 || blocked != 1 || noResponse != 2 {
+		t.Errorf("ok/blocked/no response = %d/%d/%d, want 1/1/2", ok, blocked, noResponse)
+	}
+}
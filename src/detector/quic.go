@@ -0,0 +1,276 @@
+package detector
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/capture"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/quic"
+	"github.com/daniellavrushin/b4/stun"
+)
+
+const (
+	udpProbeTimeout  = 3 * time.Second
+	udpProbeAttempts = 2 // datagrams are retransmitted once before giving up
+)
+
+func (s *DetectorSuite) runQUICCheck(ctx context.Context) *QUICResult {
+	udpTargets := s.udpTargets()
+	log.DiscoveryLogf("[Detector] Starting QUIC handshake test for %d targets and UDP reachability for %d endpoints",
+		len(QUICTargets), len(udpTargets))
+
+	result := &QUICResult{}
+	quicResults := make([]QUICTargetResult, len(QUICTargets))
+	udpResults := make([]UDPTargetResult, len(udpTargets))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, 10)
+
+	for i, target := range QUICTargets {
+		if s.isCanceled() {
+			break
+		}
+		wg.Add(1)
+		go func(idx int, tgt QUICTarget) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			quicResults[idx] = s.testQUICTarget(ctx, tgt)
+
+			s.mu.Lock()
+			s.CompletedChecks++
+			s.mu.Unlock()
+		}(i, target)
+	}
+
+	for i, target := range udpTargets {
+		if s.isCanceled() {
+			break
+		}
+		wg.Add(1)
+		go func(idx int, tgt UDPTarget) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			udpResults[idx] = s.testUDPTarget(ctx, tgt)
+
+			s.mu.Lock()
+			s.CompletedChecks++
+			s.mu.Unlock()
+		}(i, target)
+	}
+
+	wg.Wait()
+
+	for _, qr := range quicResults {
+		if qr.Status == "" {
+			continue // skipped after cancel
+		}
+		result.Targets = append(result.Targets, qr)
+		countUDPStatus(qr.Status, &result.OkCount, &result.BlockedCount, &result.NoResponseCount)
+	}
+
+	for _, ur := range udpResults {
+		if ur.Status == "" {
+			continue
+		}
+		result.UDPTargets = append(result.UDPTargets, ur)
+		countUDPStatus(ur.Status, &result.UDPOkCount, &result.UDPBlockedCount, &result.UDPNoResponseCount)
+	}
+
+	result.Summary = fmt.Sprintf("%d/%d QUIC handshakes answered, %d blocked, %d without response; "+
+		"%d/%d UDP endpoints reachable, %d blocked, %d without response",
+		result.OkCount, len(result.Targets), result.BlockedCount, result.NoResponseCount,
+		result.UDPOkCount, len(result.UDPTargets), result.UDPBlockedCount, result.UDPNoResponseCount)
+
+	log.DiscoveryLogf("[Detector] QUIC check complete: %s", result.Summary)
+	return result
+}
+
+// countUDPStatus adds a probe's status to its count. Only an ICMP error
+// counts as blocked; no response is counted on its own as inconclusive.
+func countUDPStatus(status UDPStatus, ok, blocked, noResponse *int) {
+	switch status {
+	case UDPOk:
+		*ok++
+	case UDPReset:
+		*blocked++
+	case UDPNoResponse:
+		*noResponse++
+	}
+}
+
+func (s *DetectorSuite) udpTargets() []UDPTarget {
+	if len(s.UDPTargets) > 0 {
+		return s.UDPTargets
+	}
+	return DefaultUDPTargets
+}
+
+func (s *DetectorSuite) testQUICTarget(ctx context.Context, target QUICTarget) QUICTargetResult {
+	tr := QUICTargetResult{Target: target}
+
+	ip, err := resolveIPv4First(ctx, target.Host)
+	if err != nil {
+		tr.Status = UDPError
+		tr.Detail = "DNS resolution failed"
+		return tr
+	}
+	tr.IP = ip
+
+	probe, err := buildQUICProbe(target.Host)
+	if err != nil {
+		tr.Status = UDPError
+		tr.Detail = err.Error()
+		return tr
+	}
+
+	resp, latency, err := exchangeUDP(ctx, net.JoinHostPort(ip, "443"), probe)
+	tr.Latency = latency
+	if err != nil {
+		tr.Status, tr.Detail = ClassifyUDPError(err)
+		return tr
+	}
+
+	tr.Status, tr.Detail = ClassifyQUICResponse(resp)
+	return tr
+}
+
+func (s *DetectorSuite) testUDPTarget(ctx context.Context, target UDPTarget) UDPTargetResult {
+	tr := UDPTargetResult{Target: target}
+
+	ip, err := resolveIPv4First(ctx, target.Host)
+	if err != nil {
+		tr.Status = UDPError
+		tr.Detail = "DNS resolution failed"
+		return tr
+	}
+
+	resp, latency, err := exchangeUDP(ctx, net.JoinHostPort(ip, strconv.Itoa(target.Port)), buildUDPProbe(target.Probe))
+	tr.Latency = latency
+	if err != nil {
+		tr.Status, tr.Detail = ClassifyUDPError(err)
+		if tr.Status == UDPNoResponse && target.Probe == "wireguard" {
+			tr.Detail += " (WireGuard peers stay silent without valid keys, only ICMP errors are conclusive)"
+		}
+		return tr
+	}
+
+	tr.Status = UDPOk
+	if target.Probe == "stun" && len(resp) >= 20 && binary.BigEndian.Uint32(resp[4:8]) == 0x2112A442 {
+		tr.Detail = "STUN " + stun.MessageTypeName(binary.BigEndian.Uint16(resp[0:2]))
+	} else {
+		tr.Detail = fmt.Sprintf("Reply received (%d bytes)", len(resp))
+	}
+	return tr
+}
+
+// buildQUICProbe returns a QUIC v1 client Initial carrying a ClientHello for host
+func buildQUICProbe(host string) ([]byte, error) {
+	return capture.GenerateQUICInitial(host, quic.VersionV1, nil, nil, quic.MinInitialSize)
+}
+
+func buildUDPProbe(kind string) []byte {
+	switch kind {
+	case "stun":
+		return stun.BuildBindingRequest()
+	case "wireguard":
+		// Handshake initiation: type 1, 3 reserved bytes, 144 bytes of sender index, keys and MACs
+		msg := make([]byte, 148)
+		_, _ = rand.Read(msg[4:])
+		msg[0] = 0x01
+		return msg
+	default:
+		msg := make([]byte, 32)
+		_, _ = rand.Read(msg)
+		return msg
+	}
+}
+
+// exchangeUDP sends payload to addr and waits for a single reply datagram.
+// The payload is retransmitted if no reply arrives within udpProbeTimeout.
+func exchangeUDP(ctx context.Context, addr string, payload []byte) ([]byte, int64, error) {
+	d := net.Dialer{Timeout: udpProbeTimeout}
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 2048)
+	var lastErr error
+
+	for attempt := 0; attempt < udpProbeAttempts; attempt++ {
+		start := time.Now()
+		if _, err := conn.Write(payload); err != nil {
+			return nil, time.Since(start).Milliseconds(), err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+		n, err := conn.Read(buf)
+		latency := time.Since(start).Milliseconds()
+		if err == nil {
+			return buf[:n], latency, nil
+		}
+		lastErr = err
+
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, latency, err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, udpProbeTimeout.Milliseconds(), lastErr
+}
+
+func resolveIPv4First(ctx context.Context, host string) (string, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no addresses for %s", host)
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return ips[0].String(), nil
+}
+
+// NormalizeUDPTargets validates user-supplied UDP targets and fills in defaults.
+func NormalizeUDPTargets(targets []UDPTarget) ([]UDPTarget, error) {
+	out := make([]UDPTarget, 0, len(targets))
+	for _, t := range targets {
+		if t.Host == "" {
+			return nil, fmt.Errorf("udp target host is required")
+		}
+		if t.Port < 1 || t.Port > 65535 {
+			return nil, fmt.Errorf("invalid udp target port %d for %s", t.Port, t.Host)
+		}
+		switch t.Probe {
+		case "":
+			t.Probe = "raw"
+		case "stun", "wireguard", "raw":
+		default:
+			return nil, fmt.Errorf("unknown udp probe type: %s", t.Probe)
+		}
+		if t.ID == "" {
+			t.ID = net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
@@ -177,3 +177,28 @@ var TCPTargets = []TCPTarget{
 	{"MD.HOST-02", "https://profinance.cc/img/landing/introduction.png", "AS200019", "Alexhost", "MD"},
 	{"FI.HOST-03", "https://cascademl.com/images/5.jpg", "AS215730", "H2nexus", "FI"},
 }
+
+// QUICTargets — HTTP/3 endpoints for the QUIC handshake test.
+var QUICTargets = []QUICTarget{
+	{"GOOGLE", "www.google.com", "Google"},
+	{"YOUTUBE", "www.youtube.com", "Google"},
+	{"YT-IMG", "i.ytimg.com", "Google"},
+	{"CF-QUIC", "cloudflare-quic.com", "Cloudflare"},
+	{"CF", "www.cloudflare.com", "Cloudflare"},
+	{"FACEBOOK", "www.facebook.com", "Meta"},
+	{"INSTAGRAM", "www.instagram.com", "Meta"},
+	{"DISCORD", "discord.com", "Cloudflare"},
+	{"NGINX", "quic.nginx.org", "F5"},
+	{"LITESPEED", "www.litespeedtech.com", "LiteSpeed"},
+	{"FASTLY", "www.fastly.com", "Fastly"},
+	{"AKAMAI", "www.akamai.com", "Akamai"},
+}
+
+// DefaultUDPTargets — UDP endpoints that answer protocol probes, used when
+// the request does not supply its own list.
+var DefaultUDPTargets = []UDPTarget{
+	{"STUN-GOOGLE", "stun.l.google.com", 19302, "stun"},
+	{"STUN-CF", "stun.cloudflare.com", 3478, "stun"},
+	{"STUN-TWILIO", "global.stun.twilio.com", 3478, "stun"},
+	{"STUN-NEXTCLOUD", "stun.nextcloud.com", 443, "stun"},
+}
@@ -21,6 +21,7 @@ const (
 	TestDNS     TestType = "dns"
 	TestDomains TestType = "domains"
 	TestTCP     TestType = "tcp"
+	TestQUIC    TestType = "quic"
 )
 
 // DNS check types
@@ -138,6 +139,61 @@ type TCPResult struct {
 	Summary       string          `json:"summary"`
 }
 
+// QUIC and UDP reachability test types
+
+type UDPStatus string
+
+const (
+	UDPOk UDPStatus = "OK"
+	// UDPNoResponse is inconclusive: a silent server looks the same as one
+	// whose datagrams are dropped on the way
+	UDPNoResponse UDPStatus = "NO_RESPONSE"
+	UDPReset      UDPStatus = "RESET"
+	UDPError      UDPStatus = "ERROR"
+)
+
+type QUICTarget struct {
+	ID       string `json:"id"`
+	Host     string `json:"host"`
+	Provider string `json:"provider"`
+}
+
+type QUICTargetResult struct {
+	Target  QUICTarget `json:"target"`
+	IP      string     `json:"ip,omitempty"`
+	Status  UDPStatus  `json:"status"`
+	Latency int64      `json:"latency_ms"`
+	Detail  string     `json:"detail,omitempty"`
+}
+
+// UDPTarget is a host:port probed with a protocol-shaped datagram.
+// Probe is one of "stun", "wireguard" or "raw".
+type UDPTarget struct {
+	ID    string `json:"id"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	Probe string `json:"probe"`
+}
+
+type UDPTargetResult struct {
+	Target  UDPTarget `json:"target"`
+	Status  UDPStatus `json:"status"`
+	Latency int64     `json:"latency_ms"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+type QUICResult struct {
+	Targets            []QUICTargetResult `json:"targets"`
+	UDPTargets         []UDPTargetResult  `json:"udp_targets"`
+	OkCount            int                `json:"ok_count"`
+	BlockedCount       int                `json:"blocked_count"`
+	NoResponseCount    int                `json:"no_response_count"`
+	UDPOkCount         int                `json:"udp_ok_count"`
+	UDPBlockedCount    int                `json:"udp_blocked_count"`
+	UDPNoResponseCount int                `json:"udp_no_response_count"`
+	Summary            string             `json:"summary"`
+}
+
 // Overall detection suite
 
 type DetectorSuite struct {
@@ -154,6 +210,10 @@ type DetectorSuite struct {
 	DNSResult     *DNSResult     `json:"dns_result,omitempty"`
 	DomainsResult *DomainsResult `json:"domains_result,omitempty"`
 	TCPResult     *TCPResult     `json:"tcp_result,omitempty"`
+	QUICResult    *QUICResult    `json:"quic_result,omitempty"`
+
+	// UDPTargets overrides DefaultUDPTargets for the QUIC test when non-empty
+	UDPTargets []UDPTarget `json:"udp_targets,omitempty"`
 
 	mu     sync.RWMutex `json:"-"`
 	cancel chan struct{} `json:"-"`
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/daniellavrushin/b4/events"
 	"github.com/daniellavrushin/b4/log"
 )
 
@@ -113,6 +114,8 @@ func (m *Manager) refresh() {
 	leaseHostnames := enrichHostnames()
 
 	m.mu.Lock()
+	known := m.macToIP
+	var seen []events.Event
 	m.ipToMAC = make(map[string]string, len(entries))
 	m.macToIP = make(map[string]string, len(entries))
 	m.hostnames = make(map[string]string)
@@ -125,11 +128,22 @@ func (m *Manager) refresh() {
 			m.hostnames[mac] = hostname
 		}
 		log.Tracef("DHCP: %s -> %s (dev: %s)", entry.IP, mac, entry.Device)
+		if known[mac] != entry.IP {
+			seen = append(seen, events.Event{
+				Type:     events.TypeDevice,
+				MAC:      mac,
+				IP:       entry.IP,
+				Hostname: m.hostnames[mac],
+			})
+		}
 	}
 	count := len(m.ipToMAC)
 	m.mu.Unlock()
 
 	log.Infof("DHCP: loaded %d entries from ARP table", count)
+	for _, e := range seen {
+		events.Publish(e)
+	}
 	m.notifyCallbacks()
 }
 
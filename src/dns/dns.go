@@ -1,5 +1,10 @@
 package dns
 
+import (
+	"encoding/binary"
+	"net"
+)
+
 func ParseQueryDomain(payload []byte) (string, bool) {
 	// DNS header is 12 bytes
 	if len(payload) < 12 {
@@ -29,3 +34,70 @@ func ParseQueryDomain(payload []byte) (string, bool) {
 	}
 	return string(domain), true
 }
+
+// ParseAnswerIPs extracts the question domain and the A/AAAA records of a DNS
+// response. Records of CNAME chains are returned together since they all
+// resolve the question domain.
+func ParseAnswerIPs(payload []byte) (string, []net.IP, bool) {
+	if len(payload) < 12 || payload[2]&0x80 == 0 || payload[3]&0x0f != 0 {
+		return "", nil, false
+	}
+	qdCount := int(binary.BigEndian.Uint16(payload[4:6]))
+	anCount := int(binary.BigEndian.Uint16(payload[6:8]))
+	if qdCount != 1 || anCount == 0 {
+		return "", nil, false
+	}
+
+	domain, ok := ParseQueryDomain(payload)
+	if !ok {
+		return "", nil, false
+	}
+
+	pos := skipName(payload, 12)
+	if pos < 0 || pos+4 > len(payload) {
+		return "", nil, false
+	}
+	pos += 4 // qtype, qclass
+
+	var ips []net.IP
+	for i := 0; i < anCount; i++ {
+		pos = skipName(payload, pos)
+		if pos < 0 || pos+10 > len(payload) {
+			break
+		}
+		rrType := binary.BigEndian.Uint16(payload[pos : pos+2])
+		rdLen := int(binary.BigEndian.Uint16(payload[pos+8 : pos+10]))
+		pos += 10
+		if pos+rdLen > len(payload) {
+			break
+		}
+		switch {
+		case rrType == 1 && rdLen == 4:
+			ips = append(ips, net.IP(append([]byte(nil), payload[pos:pos+4]...)))
+		case rrType == 28 && rdLen == 16:
+			ips = append(ips, net.IP(append([]byte(nil), payload[pos:pos+16]...)))
+		}
+		pos += rdLen
+	}
+
+	return domain, ips, len(ips) > 0
+}
+
+// skipName returns the offset right after the (possibly compressed) name at pos, or -1.
+func skipName(payload []byte, pos int) int {
+	for pos < len(payload) {
+		length := int(payload[pos])
+		switch {
+		case length == 0:
+			return pos + 1
+		case length&0xc0 == 0xc0:
+			if pos+2 > len(payload) {
+				return -1
+			}
+			return pos + 2
+		default:
+			pos += 1 + length
+		}
+	}
+	return -1
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseAnswerIPs(t *testing.T) {
+	resp := []byte{
+		0x12, 0x34, 0x81, 0x80, // id, flags: response, no error
+		0x00, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, // 1 question, 3 answers
+		0x03, 'w', 'w', 'w', 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'c', 'o', 'm', 0x00,
+		0x00, 0x01, 0x00, 0x01,
+		// www.example.com CNAME cdn.example.com
+		0xc0, 0x0c, 0x00, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x06,
+		0x03, 'c', 'd', 'n', 0xc0, 0x10,
+		// cdn.example.com A 93.184.216.34
+		0xc0, 0x2d, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04,
+		93, 184, 216, 34,
+		// cdn.example.com AAAA 2606:2800:220:1::248
+		0xc0, 0x2d, 0x00, 0x1c, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x10,
+		0x26, 0x06, 0x28, 0x00, 0x02, 0x20, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0x02, 0x48,
+	}
+
+	domain, ips, ok := ParseAnswerIPs(resp)
+	if !ok {
+		t.Fatal("expected answer to parse")
+	}
+	if domain != "www.example.com" {
+		t.Errorf("domain = %q, want www.example.com", domain)
+	}
+	want := []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("2606:2800:220:1::248")}
+	if len(ips) != len(want) {
+		t.Fatalf("got %d IPs, want %d", len(ips), len(want))
+	}
+	for i := range want {
+		if !ips[i].Equal(want[i]) {
+			t.Errorf("ips[%d] = %s, want %s", i, ips[i], want[i])
+		}
+	}
+
+	t.Run("query is ignored", func(t *testing.T) {
+		query := append([]byte(nil), resp[:33]...)
+		query[2] = 0x01
+		query[7] = 0x00
+		if _, _, ok := ParseAnswerIPs(query); ok {
+			t.Error("expected query to be rejected")
+		}
+	})
+
+	t.Run("nxdomain is ignored", func(t *testing.T) {
+		nx := append([]byte(nil), resp...)
+		nx[3] = 0x83
+		if _, _, ok := ParseAnswerIPs(nx); ok {
+			t.Error("expected NXDOMAIN to be rejected")
+		}
+	})
+}
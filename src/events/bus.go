@@ -0,0 +1,119 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// whose buffer is full misses the event and has it counted as dropped.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	active atomic.Int32
+}
+
+type Subscription struct {
+	C <-chan Event
+
+	ch      chan Event
+	bus     *Bus
+	filter  atomic.Pointer[Filter]
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+var (
+	bus     *Bus
+	busOnce sync.Once
+)
+
+// GetBus returns the process-wide event bus.
+func GetBus() *Bus {
+	busOnce.Do(func() {
+		bus = NewBus()
+	})
+	return bus
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Active reports whether anyone listens, so publishers can skip building events.
+func Active() bool {
+	return GetBus().Active()
+}
+
+// Publish sends e to the process-wide bus.
+func Publish(e Event) {
+	GetBus().Publish(e)
+}
+
+func (b *Bus) Active() bool {
+	return b.active.Load() > 0
+}
+
+func (b *Bus) Subscribe(f Filter, buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 256
+	}
+	ch := make(chan Event, buffer)
+	s := &Subscription{C: ch, ch: ch, bus: b}
+	s.SetFilter(f)
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	b.active.Add(1)
+	return s
+}
+
+func (b *Bus) Publish(e Event) {
+	if !b.Active() {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.filter.Load().Match(&e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// SetFilter replaces the subscription's filter.
+func (s *Subscription) SetFilter(f Filter) {
+	f = f.normalize()
+	s.filter.Store(&f)
+}
+
+func (s *Subscription) Filter() Filter {
+	return *s.filter.Load()
+}
+
+// Dropped returns how many events were lost because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes C.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		close(s.ch)
+		s.bus.mu.Unlock()
+		s.bus.active.Add(-1)
+	})
+}
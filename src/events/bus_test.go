@@ -0,0 +1,93 @@
+package events
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestFilter_Match(t *testing.T) {
+	e := Event{Type: TypeConnection, Protocol: "TCP", Set: "YouTube", Domain: "rr3.googlevideo.com", MAC: "AA:BB:CC:DD:EE:FF"}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"type", Filter{Types: []Type{TypeVerdict, TypeConnection}}, true},
+		{"other type", Filter{Types: []Type{TypeVerdict}}, false},
+		{"set any case", Filter{Set: "youtube"}, true},
+		{"other set", Filter{Set: "main"}, false},
+		{"mac lower", Filter{MAC: "aa:bb:cc:dd:ee:ff"}, true},
+		{"domain glob", Filter{Domain: "*.GoogleVideo.com"}, true},
+		{"domain miss", Filter{Domain: "*.youtube.com"}, false},
+		{"protocol", Filter{Protocol: "tcp"}, true},
+		{"other protocol", Filter{Protocol: "UDP"}, false},
+	}
+	for _, tt := range tests {
+		f := tt.filter.normalize()
+		if got := f.Match(&e); got != tt.want {
+			t.Errorf("%s: Match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	f := Filter{Domain: "*"}.normalize()
+	if f.Match(&Event{Type: TypeDevice}) {
+		t.Error("domain filter should not match events without a domain")
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	q, _ := url.ParseQuery("types=connection,%20verdict,&set=Main&mac=aa:bb:cc:dd:ee:ff&protocol=udp&domain=*.Example.com")
+	f := ParseFilter(q)
+
+	if len(f.Types) != 2 || f.Types[0] != TypeConnection || f.Types[1] != TypeVerdict {
+		t.Errorf("Types = %v", f.Types)
+	}
+	if f.Set != "Main" || f.MAC != "AA:BB:CC:DD:EE:FF" || f.Protocol != "UDP" || f.Domain != "*.example.com" {
+		t.Errorf("unexpected filter %+v", f)
+	}
+}
+
+func TestBus_SubscribeFilterAndDrop(t *testing.T) {
+	b := NewBus()
+	if b.Active() {
+		t.Fatal("new bus should be inactive")
+	}
+
+	udp := b.Subscribe(Filter{Protocol: "UDP"}, 1)
+	all := b.Subscribe(Filter{}, 4)
+	if !b.Active() {
+		t.Fatal("bus with subscribers should be active")
+	}
+
+	b.Publish(Event{Type: TypeConnection, Protocol: "TCP"})
+	b.Publish(Event{Type: TypeConnection, Protocol: "UDP"})
+	b.Publish(Event{Type: TypeVerdict, Protocol: "UDP"})
+
+	if e := <-udp.C; e.Protocol != "UDP" || e.Time.IsZero() {
+		t.Errorf("udp subscriber got %+v", e)
+	}
+	if udp.Dropped() != 1 {
+		t.Errorf("udp Dropped = %d, want 1", udp.Dropped())
+	}
+	if len(all.C) != 3 {
+		t.Errorf("unfiltered subscriber has %d events, want 3", len(all.C))
+	}
+
+	udp.SetFilter(Filter{Types: []Type{TypeDevice}})
+	b.Publish(Event{Type: TypeConnection, Protocol: "UDP"})
+	if len(udp.C) != 0 {
+		t.Error("replaced filter should reject the event")
+	}
+
+	udp.Close()
+	udp.Close()
+	all.Close()
+	if b.Active() {
+		t.Error("bus should be inactive after all subscriptions closed")
+	}
+	if _, ok := <-udp.C; ok {
+		t.Error("closed subscription channel should be closed")
+	}
+}
@@ -0,0 +1,127 @@
+// Package events carries structured runtime events (connections, verdicts,
+// applied strategies, DNS redirects, devices, set schedules, queue watchdog
+// actions) to API subscribers.
+package events
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeConnection  Type = "connection"
+	TypeVerdict     Type = "verdict"
+	TypeStrategy    Type = "strategy"
+	TypeDNSRedirect Type = "dns_redirect"
+	TypeDevice      Type = "device"
+	TypeSchedule    Type = "schedule"
+	TypeWatchdog    Type = "watchdog"
+)
+
+const (
+	VerdictAccept = "accept"
+	VerdictDrop   = "drop"
+	VerdictInject = "inject" // dropped and re-sent by b4 with the set's strategy
+)
+
+const (
+	WatchdogRestart       = "restart"        // a stalled worker's queue handle was reopened
+	WatchdogRestartFailed = "restart_failed" // reopening the queue handle failed
+	WatchdogFailOpen      = "fail_open"      // queue rules removed, traffic bypasses b4
+	WatchdogRecover       = "recover"        // queue rules restored after failing open
+)
+
+type Event struct {
+	Type        Type      `json:"type"`
+	Time        time.Time `json:"time"`
+	Protocol    string    `json:"protocol,omitempty"` // "TCP", "UDP", "TCP-DUP", "P-TCP", "P-UDP", "DNS"
+	Set         string    `json:"set,omitempty"`
+	SNISet      string    `json:"sni_set,omitempty"` // set matched by SNI/domain
+	IPSet       string    `json:"ip_set,omitempty"`  // set matched by destination IP
+	Domain      string    `json:"domain,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	MAC         string    `json:"mac,omitempty"`
+	Verdict     string    `json:"verdict,omitempty"`
+	Strategy    string    `json:"strategy,omitempty"`
+	JA3         string    `json:"ja3,omitempty"` // ClientHello fingerprints
+	JA4         string    `json:"ja4,omitempty"`
+	Target      string    `json:"target,omitempty"` // DNS redirect target
+	IP          string    `json:"ip,omitempty"`     // device address
+	Hostname    string    `json:"hostname,omitempty"`
+	Active      *bool     `json:"active,omitempty"` // set schedule state after a transition
+	Queue       int       `json:"queue,omitempty"`  // nfqueue number a watchdog action concerns
+	Action      string    `json:"action,omitempty"` // watchdog action, see WatchdogRestart
+}
+
+// Filter selects the events a subscriber receives. Empty fields match
+// everything; Domain is a case-insensitive glob such as "*.googlevideo.com".
+type Filter struct {
+	Types    []Type `json:"types,omitempty"`
+	Set      string `json:"set,omitempty"`
+	MAC      string `json:"mac,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// ParseFilter reads a filter from query parameters: types (comma separated),
+// set, mac, domain and protocol.
+func ParseFilter(q url.Values) Filter {
+	f := Filter{
+		Set:      q.Get("set"),
+		MAC:      q.Get("mac"),
+		Domain:   q.Get("domain"),
+		Protocol: q.Get("protocol"),
+	}
+	for _, t := range strings.Split(q.Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f.Types = append(f.Types, Type(t))
+		}
+	}
+	return f.normalize()
+}
+
+func (f Filter) normalize() Filter {
+	f.Set = strings.TrimSpace(f.Set)
+	f.MAC = strings.ToUpper(strings.TrimSpace(f.MAC))
+	f.Domain = strings.ToLower(strings.TrimSpace(f.Domain))
+	f.Protocol = strings.ToUpper(strings.TrimSpace(f.Protocol))
+	return f
+}
+
+func (f *Filter) Match(e *Event) bool {
+	if len(f.Types) > 0 {
+		ok := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if f.Set != "" && !strings.EqualFold(f.Set, e.Set) {
+		return false
+	}
+	if f.MAC != "" && !strings.EqualFold(f.MAC, e.MAC) {
+		return false
+	}
+	if f.Protocol != "" && !strings.EqualFold(f.Protocol, e.Protocol) {
+		return false
+	}
+	if f.Domain != "" {
+		if e.Domain == "" {
+			return false
+		}
+		if ok, _ := path.Match(f.Domain, strings.ToLower(e.Domain)); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,311 @@
+package geodat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/log"
+)
+
+const DefaultRipestatURL = "https://stat.ripe.net"
+
+// ASNSource resolves the prefixes announced by autonomous systems.
+type ASNSource interface {
+	Name() string
+	Prefixes(asns []uint32) (map[uint32][]string, error)
+}
+
+// RipestatSource queries the RIPEstat announced-prefixes data call.
+type RipestatSource struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func (s *RipestatSource) Name() string { return "ripestat" }
+
+func (s *RipestatSource) Prefixes(asns []uint32) (map[uint32][]string, error) {
+	base := strings.TrimRight(s.BaseURL, "/")
+	if base == "" {
+		base = DefaultRipestatURL
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	result := make(map[uint32][]string, len(asns))
+	for _, asn := range asns {
+		url := fmt.Sprintf("%s/data/announced-prefixes/data.json?resource=AS%d", base, asn)
+		resp, err := client.Get(url)
+		if err != nil {
+			return result, fmt.Errorf("failed to fetch prefixes of AS%d: %w", asn, err)
+		}
+
+		var body struct {
+			Data struct {
+				Prefixes []struct {
+					Prefix string `json:"prefix"`
+				} `json:"prefixes"`
+			} `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return result, fmt.Errorf("RIPEstat returned %s for AS%d", resp.Status, asn)
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to decode prefixes of AS%d: %w", asn, err)
+		}
+
+		prefixes := make([]string, 0, len(body.Data.Prefixes))
+		for _, p := range body.Data.Prefixes {
+			if pfx, err := netip.ParsePrefix(p.Prefix); err == nil {
+				prefixes = append(prefixes, pfx.Masked().String())
+			}
+		}
+		result[asn] = prefixes
+	}
+	return result, nil
+}
+
+// PrefixFileSource reads a local prefix-to-origin database: CSV/TSV lines of
+// prefix and ASN in either order (pyasn ipasn files included), or an MRT
+// TABLE_DUMP_V2 RIB dump. Both may be gzip or bzip2 compressed.
+type PrefixFileSource struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	db      map[uint32][]string
+}
+
+func (s *PrefixFileSource) Name() string { return "file" }
+
+func (s *PrefixFileSource) Prefixes(asns []uint32) (map[uint32][]string, error) {
+	st, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil || !st.ModTime().Equal(s.modTime) {
+		db, err := loadPrefixDatabase(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.db, s.modTime = db, st.ModTime()
+		log.Infof("Loaded ASN prefix database %s (%d origin ASNs)", s.Path, len(db))
+	}
+
+	result := make(map[uint32][]string, len(asns))
+	for _, asn := range asns {
+		result[asn] = append([]string{}, s.db[asn]...)
+	}
+	return result, nil
+}
+
+// ParseASN accepts "13335", "AS13335" and "ASN13335" (any case).
+func ParseASN(s string) (uint32, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "AS"), "N")
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid ASN %q", s)
+	}
+	return uint32(n), nil
+}
+
+type asnCacheEntry struct {
+	Prefixes []string  `json:"prefixes"`
+	Updated  time.Time `json:"updated"`
+}
+
+// ASNResolver serves ASN prefixes from a disk-backed cache, asking its source
+// only for ASNs that are missing or older than the refresh interval. When the
+// source fails, stale entries are used rather than dropping the targets.
+type ASNResolver struct {
+	mu        sync.Mutex
+	source    ASNSource
+	cachePath string
+	refresh   time.Duration
+	entries   map[uint32]asnCacheEntry
+	loaded    bool
+}
+
+func NewASNResolver(source ASNSource, cachePath string, refresh time.Duration) *ASNResolver {
+	return &ASNResolver{
+		source:    source,
+		cachePath: cachePath,
+		refresh:   refresh,
+		entries:   make(map[uint32]asnCacheEntry),
+	}
+}
+
+// Prefixes returns the deduplicated prefixes of all given ASNs.
+func (r *ASNResolver) Prefixes(asns []uint32) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.update(asns)
+
+	seen := make(map[string]bool)
+	var prefixes []string
+	for _, asn := range asns {
+		for _, p := range r.entries[asn].Prefixes {
+			if !seen[p] {
+				seen[p] = true
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	return prefixes, err
+}
+
+// Refresh re-resolves stale ASNs and reports whether any prefix list changed.
+func (r *ASNResolver) Refresh(asns []uint32) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(asns)
+}
+
+func (r *ASNResolver) update(asns []uint32) (bool, error) {
+	r.loadCache()
+
+	var stale []uint32
+	now := time.Now()
+	for _, asn := range asns {
+		e, ok := r.entries[asn]
+		if !ok || now.Sub(e.Updated) >= r.refresh {
+			stale = append(stale, asn)
+		}
+	}
+	if len(stale) == 0 {
+		return false, nil
+	}
+
+	fetched, err := r.source.Prefixes(stale)
+	changed := false
+	for asn, prefixes := range fetched {
+		sort.Strings(prefixes)
+		if old, ok := r.entries[asn]; !ok || strings.Join(old.Prefixes, ",") != strings.Join(prefixes, ",") {
+			changed = true
+		}
+		r.entries[asn] = asnCacheEntry{Prefixes: prefixes, Updated: now}
+	}
+	if len(fetched) > 0 {
+		r.saveCache()
+	}
+	if err != nil {
+		log.Warnf("ASN source %s failed, using cached prefixes: %v", r.source.Name(), err)
+	} else {
+		log.Tracef("Resolved %d ASNs via %s", len(fetched), r.source.Name())
+	}
+	return changed, err
+}
+
+func (r *ASNResolver) loadCache() {
+	if r.loaded || r.cachePath == "" {
+		r.loaded = true
+		return
+	}
+	r.loaded = true
+
+	b, err := os.ReadFile(r.cachePath)
+	if err != nil {
+		return
+	}
+	var entries map[uint32]asnCacheEntry
+	if err := json.Unmarshal(b, &entries); err != nil || entries == nil {
+		log.Warnf("Ignoring corrupt ASN cache %s: %v", r.cachePath, err)
+		return
+	}
+	r.entries = entries
+}
+
+func (r *ASNResolver) saveCache() {
+	if r.cachePath == "" {
+		return
+	}
+	b, err := json.Marshal(r.entries)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.cachePath), 0755); err != nil {
+		log.Errorf("Failed to create ASN cache directory: %v", err)
+		return
+	}
+	tmp := r.cachePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		log.Errorf("Failed to write ASN cache: %v", err)
+		return
+	}
+	_ = os.Rename(tmp, r.cachePath)
+}
+
+// ASNOptions selects and configures the ASN source.
+type ASNOptions struct {
+	Source       string // "ripestat" or "file"
+	DatabasePath string
+	RipestatURL  string
+	CachePath    string
+	Refresh      time.Duration
+}
+
+var (
+	asnMu       sync.Mutex
+	asnResolver *ASNResolver
+	asnOptions  ASNOptions
+)
+
+func sharedASNResolver(opts ASNOptions) *ASNResolver {
+	asnMu.Lock()
+	defer asnMu.Unlock()
+
+	if asnResolver == nil || asnOptions != opts {
+		if opts.Source == "file" {
+			// The file source reloads on its own when the database changes
+			asnResolver = NewASNResolver(&PrefixFileSource{Path: opts.DatabasePath}, "", 0)
+		} else {
+			asnResolver = NewASNResolver(&RipestatSource{BaseURL: opts.RipestatURL}, opts.CachePath, opts.Refresh)
+		}
+		asnOptions = opts
+	}
+	return asnResolver
+}
+
+func parseASNs(asns []string) []uint32 {
+	out := make([]uint32, 0, len(asns))
+	for _, s := range asns {
+		if n, err := ParseASN(s); err == nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// LoadASNPrefixes returns the prefixes announced by the given ASNs.
+func LoadASNPrefixes(opts ASNOptions, asns []string) ([]string, error) {
+	if len(asns) == 0 {
+		return nil, nil
+	}
+	return sharedASNResolver(opts).Prefixes(parseASNs(asns))
+}
+
+// RefreshASNPrefixes re-resolves stale ASNs, reporting whether prefixes changed.
+func RefreshASNPrefixes(opts ASNOptions, asns []string) (bool, error) {
+	if len(asns) == 0 {
+		return false, nil
+	}
+	return sharedASNResolver(opts).Refresh(parseASNs(asns))
+}
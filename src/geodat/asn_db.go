@@ -0,0 +1,265 @@
+package geodat
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MRT (RFC 6396) record types used by RIB dumps from RouteViews and RIPE RIS.
+const (
+	mrtTableDumpV2 = 13
+
+	mrtRIBIPv4Unicast        = 2
+	mrtRIBIPv6Unicast        = 4
+	mrtRIBIPv4UnicastAddPath = 8
+	mrtRIBIPv6UnicastAddPath = 10
+
+	bgpAttrASPath    = 2
+	bgpAttrAS4Path   = 17
+	bgpAttrExtLength = 0x10
+	bgpASSequence    = 2
+)
+
+// loadPrefixDatabase reads a prefix database file into origin ASN -> prefixes.
+func loadPrefixDatabase(path string) (map[uint32][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := decompress(bufio.NewReaderSize(f, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	head, _ := br.Peek(12)
+
+	db := make(map[uint32]map[string]struct{})
+	add := func(asn uint32, prefix netip.Prefix) {
+		if asn == 0 || prefix.Bits() == 0 {
+			return
+		}
+		if db[asn] == nil {
+			db[asn] = make(map[string]struct{})
+		}
+		db[asn][prefix.Masked().String()] = struct{}{}
+	}
+
+	if isMRT(head) {
+		err = readMRT(br, add)
+	} else {
+		err = readPrefixText(br, add)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	result := make(map[uint32][]string, len(db))
+	for asn, set := range db {
+		prefixes := make([]string, 0, len(set))
+		for p := range set {
+			prefixes = append(prefixes, p)
+		}
+		sort.Strings(prefixes)
+		result[asn] = prefixes
+	}
+	return result, nil
+}
+
+func decompress(r *bufio.Reader) (io.Reader, error) {
+	magic, _ := r.Peek(3)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return gzip.NewReader(r)
+	case bytes.Equal(magic, []byte("BZh")):
+		return bzip2.NewReader(r), nil
+	}
+	return r, nil
+}
+
+func isMRT(head []byte) bool {
+	return len(head) == 12 && binary.BigEndian.Uint16(head[4:6]) == mrtTableDumpV2
+}
+
+// readPrefixText parses lines holding a prefix and an ASN in any order,
+// separated by commas, semicolons, tabs or spaces. Other lines are skipped.
+func readPrefixText(r io.Reader, add func(uint32, netip.Prefix)) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(c rune) bool {
+			return c == ',' || c == ';' || c == '\t' || c == ' ' || c == '"'
+		})
+
+		var prefix netip.Prefix
+		var asn uint32
+		for _, f := range fields {
+			if !prefix.IsValid() && strings.Contains(f, "/") {
+				if p, err := netip.ParsePrefix(f); err == nil {
+					prefix = p
+					continue
+				}
+			}
+			if asn == 0 {
+				if n, err := ParseASN(f); err == nil {
+					asn = n
+				}
+			}
+		}
+		if prefix.IsValid() {
+			add(asn, prefix)
+		}
+	}
+	return sc.Err()
+}
+
+// readMRT walks TABLE_DUMP_V2 RIB records and takes the origin AS of the first
+// RIB entry of every prefix.
+func readMRT(r io.Reader, add func(uint32, netip.Prefix)) error {
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		typ := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		if typ != mrtTableDumpV2 {
+			continue
+		}
+
+		var v6, addPath bool
+		switch subtype {
+		case mrtRIBIPv4Unicast:
+		case mrtRIBIPv6Unicast:
+			v6 = true
+		case mrtRIBIPv4UnicastAddPath:
+			addPath = true
+		case mrtRIBIPv6UnicastAddPath:
+			v6, addPath = true, true
+		default:
+			continue
+		}
+
+		prefix, asn, ok := parseRIBRecord(body, v6, addPath)
+		if ok {
+			add(asn, prefix)
+		}
+	}
+}
+
+func parseRIBRecord(b []byte, v6, addPath bool) (netip.Prefix, uint32, bool) {
+	if len(b) < 5 {
+		return netip.Prefix{}, 0, false
+	}
+	bits := int(b[4])
+	n := (bits + 7) / 8
+	b = b[5:]
+	if len(b) < n+2 {
+		return netip.Prefix{}, 0, false
+	}
+
+	var addr netip.Addr
+	if v6 {
+		var a [16]byte
+		copy(a[:], b[:n])
+		addr = netip.AddrFrom16(a)
+	} else {
+		var a [4]byte
+		copy(a[:], b[:n])
+		addr = netip.AddrFrom4(a)
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return netip.Prefix{}, 0, false
+	}
+
+	b = b[n:]
+	if binary.BigEndian.Uint16(b[:2]) == 0 {
+		return netip.Prefix{}, 0, false
+	}
+	b = b[2:]
+
+	// First RIB entry: peer index, originated time, optional path id, attributes
+	skip := 6
+	if addPath {
+		skip += 4
+	}
+	if len(b) < skip+2 {
+		return netip.Prefix{}, 0, false
+	}
+	attrLen := int(binary.BigEndian.Uint16(b[skip : skip+2]))
+	b = b[skip+2:]
+	if len(b) < attrLen {
+		return netip.Prefix{}, 0, false
+	}
+
+	asn := originASN(b[:attrLen])
+	return prefix, asn, asn != 0
+}
+
+// originASN returns the last AS of the AS_PATH (AS4_PATH when present).
+func originASN(attrs []byte) uint32 {
+	var path, path4 []byte
+	for len(attrs) >= 3 {
+		flags, typ := attrs[0], attrs[1]
+		hdr, l := 3, int(attrs[2])
+		if flags&bgpAttrExtLength != 0 {
+			if len(attrs) < 4 {
+				return 0
+			}
+			hdr, l = 4, int(binary.BigEndian.Uint16(attrs[2:4]))
+		}
+		if len(attrs) < hdr+l {
+			return 0
+		}
+		switch typ {
+		case bgpAttrASPath:
+			path = attrs[hdr : hdr+l]
+		case bgpAttrAS4Path:
+			path4 = attrs[hdr : hdr+l]
+		}
+		attrs = attrs[hdr+l:]
+	}
+	if path4 != nil {
+		path = path4
+	}
+
+	var origin uint32
+	for len(path) >= 2 {
+		segType, count := path[0], int(path[1])
+		if len(path) < 2+4*count {
+			return 0
+		}
+		if segType == bgpASSequence && count > 0 {
+			origin = binary.BigEndian.Uint32(path[2+4*(count-1):])
+		} else {
+			// The origin is ambiguous when the path ends in an AS_SET
+			origin = 0
+		}
+		path = path[2+4*count:]
+	}
+	return origin
+}
@@ -0,0 +1,176 @@
+package geodat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseASN(t *testing.T) {
+	for in, want := range map[string]uint32{
+		"13335":      13335,
+		"AS13335":    13335,
+		"as15169":    15169,
+		"ASN 32934":  32934,
+		"4200000000": 4200000000,
+	} {
+		got, err := ParseASN(strings.ReplaceAll(in, " ", ""))
+		if err != nil || got != want {
+			t.Errorf("ParseASN(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "AS", "AS0", "ASx1", "4294967296"} {
+		if _, err := ParseASN(in); err == nil {
+			t.Errorf("ParseASN(%q) should fail", in)
+		}
+	}
+}
+
+func ripestatStub(t *testing.T, fail *atomic.Bool, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path != "/data/announced-prefixes/data.json" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("resource") {
+		case "AS64500":
+			fmt.Fprint(w, `{"data":{"prefixes":[{"prefix":"192.0.2.0/24"},{"prefix":"2001:db8::/32"}]}}`)
+		default:
+			fmt.Fprint(w, `{"data":{"prefixes":[]}}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRipestatSource(t *testing.T) {
+	var fail atomic.Bool
+	var calls atomic.Int32
+	srv := ripestatStub(t, &fail, &calls)
+
+	src := &RipestatSource{BaseURL: srv.URL}
+	got, err := src.Prefixes([]uint32{64500, 64501})
+	if err != nil {
+		t.Fatalf("Prefixes: %v", err)
+	}
+	if want := []string{"192.0.2.0/24", "2001:db8::/32"}; !reflect.DeepEqual(got[64500], want) {
+		t.Errorf("AS64500 = %v, want %v", got[64500], want)
+	}
+	if len(got[64501]) != 0 {
+		t.Errorf("AS64501 = %v, want none", got[64501])
+	}
+}
+
+func TestASNResolver_CacheAndFallback(t *testing.T) {
+	var fail atomic.Bool
+	var calls atomic.Int32
+	srv := ripestatStub(t, &fail, &calls)
+	cache := filepath.Join(t.TempDir(), "asn_cache.json")
+
+	r := NewASNResolver(&RipestatSource{BaseURL: srv.URL}, cache, time.Hour)
+	prefixes, err := r.Prefixes([]uint32{64500})
+	if err != nil || len(prefixes) != 2 {
+		t.Fatalf("Prefixes = %v, %v", prefixes, err)
+	}
+	if _, err := r.Prefixes([]uint32{64500}); err != nil || calls.Load() != 1 {
+		t.Fatalf("fresh entry should be served from cache, calls=%d err=%v", calls.Load(), err)
+	}
+
+	// A new resolver with a zero refresh must go to the source, which now
+	// fails; the cached prefixes are still returned.
+	fail.Store(true)
+	r = NewASNResolver(&RipestatSource{BaseURL: srv.URL}, cache, 0)
+	prefixes, err = r.Prefixes([]uint32{64500})
+	if err == nil {
+		t.Error("expected source error")
+	}
+	if len(prefixes) != 2 {
+		t.Errorf("stale fallback = %v, want 2 prefixes", prefixes)
+	}
+}
+
+func TestPrefixFileSource_Text(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipasn.csv")
+	data := "# prefix,asn\n" +
+		"198.51.100.0/24,64500\n" +
+		"AS64500;203.0.113.7/24\n" +
+		"2001:db8:1::/48\t64501\n" +
+		"garbage line\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := (&PrefixFileSource{Path: path}).Prefixes([]uint32{64500, 64501})
+	if err != nil {
+		t.Fatalf("Prefixes: %v", err)
+	}
+	if want := []string{"198.51.100.0/24", "203.0.113.0/24"}; !reflect.DeepEqual(got[64500], want) {
+		t.Errorf("AS64500 = %v, want %v", got[64500], want)
+	}
+	if want := []string{"2001:db8:1::/48"}; !reflect.DeepEqual(got[64501], want) {
+		t.Errorf("AS64501 = %v, want %v", got[64501], want)
+	}
+}
+
+// mrtRIB builds a TABLE_DUMP_V2 RIB_IPV4_UNICAST record whose single entry
+// has the given AS_SEQUENCE path.
+func mrtRIB(prefix []byte, bits byte, path ...uint32) []byte {
+	asPath := []byte{bgpASSequence, byte(len(path))}
+	for _, as := range path {
+		asPath = binary.BigEndian.AppendUint32(asPath, as)
+	}
+	attrs := append([]byte{0x40, bgpAttrASPath, byte(len(asPath))}, asPath...)
+
+	body := []byte{0, 0, 0, 1, bits}
+	body = append(body, prefix...)
+	body = binary.BigEndian.AppendUint16(body, 1) // entry count
+	body = binary.BigEndian.AppendUint16(body, 0) // peer index
+	body = binary.BigEndian.AppendUint32(body, 0) // originated time
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+
+	rec := binary.BigEndian.AppendUint32(nil, 0)
+	rec = binary.BigEndian.AppendUint16(rec, mrtTableDumpV2)
+	rec = binary.BigEndian.AppendUint16(rec, mrtRIBIPv4Unicast)
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(body)))
+	return append(rec, body...)
+}
+
+func TestPrefixFileSource_MRT(t *testing.T) {
+	var dump []byte
+	dump = append(dump, mrtRIB([]byte{192, 0, 2}, 24, 3356, 64500)...)
+	dump = append(dump, mrtRIB([]byte{10}, 8, 174, 64501)...)
+
+	path := filepath.Join(t.TempDir(), "rib.mrt")
+	if err := os.WriteFile(path, dump, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := (&PrefixFileSource{Path: path}).Prefixes([]uint32{64500, 64501, 3356})
+	if err != nil {
+		t.Fatalf("Prefixes: %v", err)
+	}
+	if want := []string{"192.0.2.0/24"}; !reflect.DeepEqual(got[64500], want) {
+		t.Errorf("AS64500 = %v, want %v", got[64500], want)
+	}
+	if want := []string{"10.0.0.0/8"}; !reflect.DeepEqual(got[64501], want) {
+		t.Errorf("AS64501 = %v, want %v", got[64501], want)
+	}
+	if len(got[3356]) != 0 {
+		t.Errorf("transit AS3356 should not own prefixes, got %v", got[3356])
+	}
+}
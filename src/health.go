@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/daniellavrushin/b4/http/handler"
+	"github.com/spf13/cobra"
+)
+
+var healthLive bool
+
+var healthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "Check a running b4 through its control socket",
+	Long: `Asks the running b4 whether it is ready (queue workers bound, firewall
+rules in place, config and geodata loaded), or with --live only whether it
+answers. Exits non-zero otherwise, for container HEALTHCHECK and supervisors.`,
+	Args: cobra.NoArgs,
+	RunE: runHealth,
+}
+
+func init() {
+	healthCmd.Flags().BoolVar(&healthLive, "live", false, "Only check that b4 answers")
+	controlCommand(healthCmd)
+}
+
+func runHealth(cmd *cobra.Command, args []string) error {
+	path, want := "/readyz", "ready"
+	if healthLive {
+		path, want = "/healthz", "live"
+	}
+	// Not ready answers 503 with the report, so this skips call
+	status, data, err := newControlClient().request(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	var report handler.HealthReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return fmt.Errorf("unexpected health response (%d): %w", status, err)
+	}
+
+	if err := show(data, printHealth); err != nil {
+		return err
+	}
+	if report.Status != "ok" {
+		return fmt.Errorf("b4 is not %s", want)
+	}
+	return nil
+}
+
+func printHealth(report handler.HealthReport) {
+	fmt.Printf("%s (up %s)\n", report.Status, time.Duration(report.UptimeSec)*time.Second)
+
+	names := make([]string, 0, len(report.Checks))
+	for name := range report.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := newTable("CHECK", "STATE", "DETAIL")
+	for _, name := range names {
+		c := report.Checks[name]
+		state := "ok"
+		if !c.OK {
+			state = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, state, c.Detail)
+	}
+	tw.Flush()
+}
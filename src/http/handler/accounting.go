@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/daniellavrushin/b4/accounting"
+)
+
+func (api *API) RegisterAccountingApi() {
+	api.mux.HandleFunc("/api/accounting", api.handleAccounting)
+	api.mux.HandleFunc("/api/accounting/daily", api.handleAccountingDaily)
+	api.mux.HandleFunc("/api/accounting/monthly", api.handleAccountingMonthly)
+	api.mux.HandleFunc("/api/accounting/reset", api.resetAccounting)
+}
+
+// handleAccounting returns the all-time traffic per set, domain and device.
+func (api *API) handleAccounting(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	t := accounting.Get()
+	s := t.Snapshot()
+	sendResponse(w, map[string]interface{}{
+		"enabled": t.Enabled(),
+		"since":   s.Since,
+		"total":   s.Total,
+	})
+}
+
+// handleAccountingDaily returns the daily rollups, optionally limited to the
+// most recent ?days=N.
+func (api *API) handleAccountingDaily(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	n, ok := periodLimit(w, r, "days")
+	if !ok {
+		return
+	}
+	t := accounting.Get()
+	sendResponse(w, map[string]interface{}{
+		"enabled": t.Enabled(),
+		"daily":   latestPeriods(t.Snapshot().Daily, n),
+	})
+}
+
+// handleAccountingMonthly returns the monthly rollups, optionally limited to
+// the most recent ?months=N.
+func (api *API) handleAccountingMonthly(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	n, ok := periodLimit(w, r, "months")
+	if !ok {
+		return
+	}
+	t := accounting.Get()
+	sendResponse(w, map[string]interface{}{
+		"enabled": t.Enabled(),
+		"monthly": latestPeriods(t.Snapshot().Monthly, n),
+	})
+}
+
+func (api *API) resetAccounting(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	accounting.Get().Reset()
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Traffic accounting reset successfully",
+	})
+}
+
+func periodLimit(w http.ResponseWriter, r *http.Request, param string) (int, bool) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		writeJsonError(w, http.StatusBadRequest, "invalid "+param)
+		return 0, false
+	}
+	return n, true
+}
+
+// latestPeriods keeps the n most recent periods of m; n <= 0 keeps all.
+func latestPeriods(m map[string]*accounting.Totals, n int) map[string]*accounting.Totals {
+	if n <= 0 || len(m) <= n {
+		return m
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make(map[string]*accounting.Totals, n)
+	for _, k := range keys[len(keys)-n:] {
+		out[k] = m[k]
+	}
+	return out
+}
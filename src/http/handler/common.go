@@ -3,6 +3,7 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"sync"
 
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/geodat"
@@ -28,6 +29,53 @@ var (
 	tablesRefreshFunc  func() error
 )
 
+// configMu serializes changes to the shared config, made by API requests
+// and by background jobs through UpdateConfig.
+var configMu sync.Mutex
+
+// LockConfigWrites runs the requests of next that can change the config one
+// at a time; reads pass without the lock.
+func LockConfigWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			configMu.Lock()
+			defer configMu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UpdateConfig applies a change to cfg made outside of an API request. edit
+// gets a copy under the lock the API holds and reports whether it changed
+// anything; a changed copy is applied like an API save.
+func UpdateConfig(cfg *config.Config, edit func(newCfg *config.Config) (bool, error)) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	oldCfg := cfg.Clone()
+	newCfg := cfg.Clone()
+	changed, err := edit(newCfg)
+	if err != nil || !changed {
+		return err
+	}
+	api := &API{cfg: cfg}
+	if err := api.saveAndPushConfig(newCfg); err != nil {
+		return err
+	}
+	api.PerformSoftRestart(cfg, oldCfg)
+	return nil
+}
+
+// ConfigSnapshot returns a copy of cfg taken under the lock config changes
+// hold, for background jobs that read it outside of an API request.
+func ConfigSnapshot(cfg *config.Config) *config.Config {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return cfg.Clone()
+}
+
 func setJsonHeader(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 }
@@ -112,6 +160,9 @@ func (api *API) RegisterEndpoints(mux *http.ServeMux, cfg *config.Config) {
 	api.RegisterDevicesApi()
 	api.RegisterSocks5Api()
 	api.RegisterDetectorApi()
+	api.RegisterEventsApi()
+	api.RegisterAccountingApi()
+	api.RegisterTablesApi()
 }
 
 func sendResponse(w http.ResponseWriter, response interface{}) {
@@ -1,8 +1,13 @@
 package handler
 
 import (
+	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
 )
 
 func TestSetJsonHeader(t *testing.T) {
@@ -30,3 +35,57 @@ func TestSendResponse(t *testing.T) {
 		t.Error("response body is empty")
 	}
 }
+
+func TestUpdateConfig_SerializedWithAPIWrites(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ConfigPath = filepath.Join(t.TempDir(), "b4.json")
+
+	wrote := make(chan struct{})
+	api := LockConfigWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.Queue.Threads = 2
+		close(wrote)
+	}))
+
+	err := UpdateConfig(&cfg, func(newCfg *config.Config) (bool, error) {
+		go api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/config", nil))
+		select {
+		case <-wrote:
+			t.Error("API write ran while the config was being updated")
+		case <-time.After(50 * time.Millisecond):
+		}
+		newCfg.Queue.Threads = 8
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateConfig: %v", err)
+	}
+
+	<-wrote
+	if cfg.Queue.Threads != 2 {
+		t.Errorf("threads = %d, want the later API write to win", cfg.Queue.Threads)
+	}
+
+	// Reads don't wait for the lock
+	configMu.Lock()
+	defer configMu.Unlock()
+	rec := httptest.NewRecorder()
+	LockConfigWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("GET: got %d, want 204", rec.Code)
+	}
+}
+
+func TestUpdateConfig_Unchanged(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ConfigPath = filepath.Join(t.TempDir(), "b4.json")
+
+	err := UpdateConfig(&cfg, func(newCfg *config.Config) (bool, error) {
+		newCfg.Queue.Threads = 8
+		return false, nil
+	})
+	if err != nil || cfg.Queue.Threads == 8 {
+		t.Errorf("got threads %d, err %v; an unchanged edit must not apply", cfg.Queue.Threads, err)
+	}
+}
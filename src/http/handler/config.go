@@ -9,6 +9,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/daniellavrushin/b4/accounting"
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/log"
 	"github.com/daniellavrushin/b4/metrics"
@@ -336,6 +337,8 @@ func (a *API) saveAndPushConfig(newCfg *config.Config) error {
 		return fmt.Errorf("failed to save config to file: %v", err)
 	}
 
+	accounting.UpdateConfig(newCfg)
+
 	if ouiDB != nil {
 		if a.cfg.Queue.Devices.VendorLookup && !newCfg.Queue.Devices.VendorLookup {
 			go ouiDB.Cleanup()
@@ -394,13 +397,30 @@ func (a *API) PerformSoftRestart(newCfg *config.Config, oldCfg *config.Config) b
 		log.Infof("MSS clamp settings changed, refreshing firewall rules")
 	}
 
+	if oldCfg.PrefilterFingerprint() != newCfg.PrefilterFingerprint() {
+		shouldUpdate = true
+		log.Infof("Prefilter targets changed, refreshing firewall rules")
+	}
+
+	if oldCfg.RuleGroupFingerprint() != newCfg.RuleGroupFingerprint() {
+		shouldUpdate = true
+		log.Infof("Per-set rule groups changed, refreshing firewall rules")
+	}
+
+	if oldCfg.DeviceFilterFingerprint() != newCfg.DeviceFilterFingerprint() {
+		shouldUpdate = true
+		log.Infof("Device filter changed, refreshing firewall rules")
+	}
+
 	if shouldUpdate {
 		log.Infof("Core settings changed, performing soft system restart")
 		if oldPorts != newPorts {
 			log.Infof("UDP ports changed (%s -> %s), refreshing firewall rules", oldPorts, newPorts)
 		}
-		if err := tablesRefreshFunc(); err != nil {
-			log.Errorf("Failed to refresh tables: %v", err)
+		if tablesRefreshFunc != nil {
+			if err := tablesRefreshFunc(); err != nil {
+				log.Errorf("Failed to refresh tables: %v", err)
+			}
 		}
 	}
 
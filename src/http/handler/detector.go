@@ -43,13 +43,22 @@ func (api *API) handleStartDetector(w http.ResponseWriter, r *http.Request) {
 			tests = append(tests, detector.TestDomains)
 		case "tcp":
 			tests = append(tests, detector.TestTCP)
+		case "quic":
+			tests = append(tests, detector.TestQUIC)
 		default:
 			http.Error(w, fmt.Sprintf("Unknown test type: %s", t), http.StatusBadRequest)
 			return
 		}
 	}
 
+	udpTargets, err := detector.NormalizeUDPTargets(req.UDPTargets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	suite := detector.NewDetectorSuite(tests)
+	suite.UDPTargets = udpTargets
 
 	go func() {
 		suite.Run()
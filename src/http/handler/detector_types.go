@@ -1,7 +1,10 @@
 package handler
 
+import "github.com/daniellavrushin/b4/detector"
+
 type DetectorRequest struct {
-	Tests []string `json:"tests"` // "dns", "domains", "tcp"
+	Tests      []string             `json:"tests"`                 // "dns", "domains", "tcp", "quic"
+	UDPTargets []detector.UDPTarget `json:"udp_targets,omitempty"` // overrides the default UDP endpoints for "quic"
 }
 
 type DetectorResponse struct {
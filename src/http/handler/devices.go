@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -11,7 +12,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
 )
 
 const (
@@ -243,6 +246,34 @@ func (api *API) RegisterDevicesApi() {
 	api.mux.HandleFunc("/api/devices", api.handleDevices)
 	api.mux.HandleFunc("/api/devices/{mac}/vendor", api.handleDeviceVendor)
 	api.mux.HandleFunc("/api/devices/{mac}/alias", api.handleDeviceAlias)
+	api.mux.HandleFunc("/api/devices/profiles", api.handleDeviceProfiles)
+	api.mux.HandleFunc("/api/devices/{mac}/profile", api.handleDeviceProfile)
+	api.mux.HandleFunc("/api/devices/fingerprints", api.handleDeviceFingerprints)
+	api.mux.HandleFunc("/api/devices/{mac}/fingerprints", api.handleDeviceFingerprints)
+}
+
+// handleDeviceFingerprints lists the most frequent ClientHello fingerprints
+// of every device, or of the one in the path. limit caps each list (10).
+func (api *API) handleDeviceFingerprints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	limit, ok := periodLimit(w, r, "limit")
+	if !ok {
+		return
+	}
+	if limit == 0 {
+		limit = 10
+	}
+
+	mac := r.PathValue("mac")
+	if mac != "" {
+		mac = formatMAC(mac)
+	}
+
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(metrics.GetMetricsCollector().DeviceFingerprints(mac, limit))
 }
 
 func (api *API) handleDeviceAlias(w http.ResponseWriter, r *http.Request) {
@@ -252,10 +283,7 @@ func (api *API) handleDeviceAlias(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mac = normalizeMAC(mac)
-	if len(mac) == 12 {
-		mac = fmt.Sprintf("%s:%s:%s:%s:%s:%s", mac[0:2], mac[2:4], mac[4:6], mac[6:8], mac[8:10], mac[10:12])
-	}
+	mac = formatMAC(mac)
 
 	switch r.Method {
 	case http.MethodGet:
@@ -364,6 +392,9 @@ func (api *API) handleDevices(w http.ResponseWriter, r *http.Request) {
 		}
 
 		alias, _ := api.deviceAliases.Get(macAddr)
+		if p := api.cfg.GetDeviceProfile(macAddr); p != nil && p.Alias != "" {
+			alias = p.Alias
+		}
 
 		devices = append(devices, DeviceInfo{
 			MAC:       macAddr,
@@ -382,6 +413,124 @@ func (api *API) handleDevices(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (api *API) handleDeviceProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	profiles := api.cfg.Queue.Devices.Profiles
+	if profiles == nil {
+		profiles = []config.DeviceProfile{}
+	}
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(profiles)
+}
+
+func (api *API) handleDeviceProfile(w http.ResponseWriter, r *http.Request) {
+	mac := r.PathValue("mac")
+	if mac == "" {
+		http.Error(w, "MAC address required", http.StatusBadRequest)
+		return
+	}
+	mac = formatMAC(mac)
+	if hw, err := net.ParseMAC(mac); err != nil || len(hw) != 6 {
+		writeJsonError(w, http.StatusBadRequest, "invalid MAC address")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p := api.cfg.GetDeviceProfile(mac)
+		if p == nil {
+			writeJsonError(w, http.StatusNotFound, "profile not found")
+			return
+		}
+		setJsonHeader(w)
+		json.NewEncoder(w).Encode(p)
+
+	case http.MethodPut, http.MethodPost:
+		var profile config.DeviceProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		profile.Mac = mac
+
+		if t := strings.TrimSpace(profile.DNSTarget); t != "" && net.ParseIP(t) == nil {
+			writeJsonError(w, http.StatusBadRequest, "invalid DNS target")
+			return
+		}
+		for _, id := range profile.Sets {
+			if api.cfg.GetSetById(id) == nil {
+				writeJsonError(w, http.StatusBadRequest, fmt.Sprintf("unknown set %q", id))
+				return
+			}
+		}
+
+		oldConfig := api.cfg.Clone()
+
+		if p := api.cfg.GetDeviceProfile(mac); p != nil {
+			*p = profile
+		} else {
+			api.cfg.Queue.Devices.Profiles = append(api.cfg.Queue.Devices.Profiles, profile)
+		}
+
+		if err := api.saveAndPushConfig(api.cfg); err != nil {
+			*api.cfg = *oldConfig
+			writeJsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		api.PerformSoftRestart(api.cfg, oldConfig)
+
+		log.Infof("Saved policy profile for device %s", mac)
+		setJsonHeader(w)
+		json.NewEncoder(w).Encode(api.cfg.GetDeviceProfile(mac))
+
+	case http.MethodDelete:
+		if api.cfg.GetDeviceProfile(mac) == nil {
+			writeJsonError(w, http.StatusNotFound, "profile not found")
+			return
+		}
+
+		oldConfig := api.cfg.Clone()
+
+		profiles := make([]config.DeviceProfile, 0, len(api.cfg.Queue.Devices.Profiles))
+		for _, p := range api.cfg.Queue.Devices.Profiles {
+			if !strings.EqualFold(p.Mac, mac) {
+				profiles = append(profiles, p)
+			}
+		}
+		api.cfg.Queue.Devices.Profiles = profiles
+
+		if err := api.saveAndPushConfig(api.cfg); err != nil {
+			*api.cfg = *oldConfig
+			http.Error(w, "Failed to save", http.StatusInternalServerError)
+			return
+		}
+		api.PerformSoftRestart(api.cfg, oldConfig)
+
+		log.Infof("Deleted policy profile for device %s", mac)
+		setJsonHeader(w)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"mac":     mac,
+		})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// formatMAC turns any common MAC notation into upper-case colon form.
+func formatMAC(mac string) string {
+	mac = normalizeMAC(mac)
+	if len(mac) == 12 {
+		mac = fmt.Sprintf("%s:%s:%s:%s:%s:%s", mac[0:2], mac[2:4], mac[4:6], mac[6:8], mac[8:10], mac[10:12])
+	}
+	return mac
+}
+
 func normalizeMAC(mac string) string {
 	return strings.ToUpper(strings.ReplaceAll(strings.ReplaceAll(mac, ":", ""), "-", ""))
 }
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestHandleDeviceProfile(t *testing.T) {
+	refreshed := 0
+	SetTablesRefreshFunc(func() error { refreshed++; return nil })
+	defer SetTablesRefreshFunc(nil)
+
+	cfg := config.NewConfig()
+	api := &API{cfg: &cfg, mux: http.NewServeMux()}
+	api.mux.HandleFunc("/api/devices/profiles", api.handleDeviceProfiles)
+	api.mux.HandleFunc("/api/devices/{mac}/profile", api.handleDeviceProfile)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/api/devices/aabbccddeeff/profile", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET missing profile: expected 404, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/api/devices/nope/profile", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid MAC: expected 400, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/api/devices/aabbccddeeff/profile", `{"sets":["missing"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT unknown set: expected 400, got %d", rec.Code)
+	}
+
+	rec := do(http.MethodPut, "/api/devices/aa-bb-cc-dd-ee-ff/profile",
+		`{"alias":"TV","enabled":true,"bypass":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var p config.DeviceProfile
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if p.Mac != "AA:BB:CC:DD:EE:FF" || p.Alias != "TV" || !p.Bypass {
+		t.Errorf("unexpected profile %+v", p)
+	}
+	if refreshed != 1 {
+		t.Errorf("bypass profile should refresh firewall rules once, got %d", refreshed)
+	}
+
+	rec = do(http.MethodGet, "/api/devices/profiles", "")
+	var list []config.DeviceProfile
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 {
+		t.Errorf("list: %v %v", list, err)
+	}
+
+	if rec := do(http.MethodDelete, "/api/devices/AA:BB:CC:DD:EE:FF/profile", ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE: expected 200, got %d", rec.Code)
+	}
+	if len(cfg.Queue.Devices.Profiles) != 0 {
+		t.Error("profile should be removed")
+	}
+	if rec := do(http.MethodDelete, "/api/devices/AA:BB:CC:DD:EE:FF/profile", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE missing: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestDeleteSetAllowedByDeviceProfile(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ConfigPath = filepath.Join(t.TempDir(), "b4.json")
+	tv := config.NewSetConfig()
+	tv.Id, tv.Name = "tv", "TV"
+	other := config.NewSetConfig()
+	other.Id, other.Name = "other", "Other"
+	cfg.Sets = append(cfg.Sets, &tv, &other)
+	cfg.Queue.Devices.Profiles = []config.DeviceProfile{
+		{Mac: "AA:BB:CC:DD:EE:FF", Enabled: true, Sets: []string{"tv"}},
+	}
+	api := &API{cfg: &cfg}
+
+	rec := httptest.NewRecorder()
+	api.deleteSet(rec, "tv")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("deleting the only allowed set: expected 409, got %d", rec.Code)
+	}
+	if cfg.GetSetById("tv") == nil || len(cfg.Queue.Devices.Profiles[0].Sets) != 1 {
+		t.Error("config changed by a rejected delete")
+	}
+
+	rec = httptest.NewRecorder()
+	api.deleteSet(rec, "other")
+	if rec.Code != http.StatusOK {
+		t.Errorf("deleting an unrelated set: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
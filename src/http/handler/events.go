@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/daniellavrushin/b4/events"
+)
+
+func (api *API) RegisterEventsApi() {
+	api.mux.HandleFunc("/api/events/stream", api.handleEventsStream)
+}
+
+// handleEventsStream streams bus events as newline-delimited JSON until the
+// client disconnects. Filters are the query parameters of events.ParseFilter,
+// e.g. /api/events/stream?types=connection,verdict&domain=*.youtube.com
+func (api *API) handleEventsStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJsonError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	sub := events.GetBus().Subscribe(events.ParseFilter(r.URL.Query()), 512)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/events"
+)
+
+func TestHandleEventsStream(t *testing.T) {
+	cfg := config.NewConfig()
+	api := &API{cfg: &cfg}
+	mux := http.NewServeMux()
+	api.mux = mux
+	api.RegisterEventsApi()
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/events/stream?types=connection&set=Main")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// The subscription exists once the headers are flushed
+	deadline := time.Now().Add(2 * time.Second)
+	for !events.Active() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events.Publish(events.Event{Type: events.TypeVerdict, Set: "Main"})
+	events.Publish(events.Event{Type: events.TypeConnection, Set: "Other"})
+	events.Publish(events.Event{Type: events.TypeConnection, Set: "Main", Domain: "example.com"})
+
+	sc := bufio.NewScanner(resp.Body)
+	if !sc.Scan() {
+		t.Fatalf("no event line: %v", sc.Err())
+	}
+	var e events.Event
+	if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+		t.Fatalf("invalid NDJSON line %q: %v", sc.Text(), err)
+	}
+	if e.Type != events.TypeConnection || e.Set != "Main" || e.Domain != "example.com" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestHandleEventsStream_MethodNotAllowed(t *testing.T) {
+	cfg := config.NewConfig()
+	api := &API{cfg: &cfg}
+	mux := http.NewServeMux()
+	api.mux = mux
+	api.RegisterEventsApi()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/events/stream", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+var (
+	startedAt      = time.Now()
+	rulesCheckFunc func() error
+	configLoadErr  error
+)
+
+// HealthCheck is the outcome of one readiness check.
+type HealthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthWorker is a queue worker as seen by the readiness check.
+type HealthWorker struct {
+	Queue  uint16 `json:"queue"`
+	Bound  bool   `json:"bound"`
+	Status string `json:"status"`
+}
+
+// HealthReport is the body of /healthz and /readyz. Status is "ok" or
+// "fail"; the endpoints answer 200 or 503 to match.
+type HealthReport struct {
+	Status    string                 `json:"status"`
+	UptimeSec int64                  `json:"uptime_sec"`
+	Checks    map[string]HealthCheck `json:"checks,omitempty"`
+	Workers   []HealthWorker         `json:"workers,omitempty"`
+}
+
+// SetRulesCheckFunc sets how readiness verifies the firewall rules. Left
+// unset, rules count as verified only when their setup is skipped.
+func SetRulesCheckFunc(fn func() error) {
+	rulesCheckFunc = fn
+}
+
+// SetConfigLoadError records that the config file failed to load and b4
+// runs on defaults, which fails readiness.
+func SetConfigLoadError(err error) {
+	configLoadErr = err
+}
+
+// RegisterHealth serves /healthz (the process answers) and /readyz (packets
+// are being processed) on mux.
+func RegisterHealth(mux *http.ServeMux, cfg *config.Config) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeHealth(w, &HealthReport{Status: "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeHealth(w, readiness(cfg))
+	})
+}
+
+func writeHealth(w http.ResponseWriter, report *HealthReport) {
+	report.UptimeSec = int64(time.Since(startedAt).Seconds())
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	setJsonHeader(w)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func readiness(cfg *config.Config) *HealthReport {
+	report := &HealthReport{Status: "ok", Checks: make(map[string]HealthCheck)}
+	report.Checks["config"] = configCheck(cfg)
+	report.Checks["geodata"] = geodataCheck(cfg)
+	report.Checks["tables"] = tablesCheck(cfg)
+	report.Checks["nfq"], report.Workers = nfqCheck()
+
+	for _, c := range report.Checks {
+		if !c.OK {
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+func configCheck(cfg *config.Config) HealthCheck {
+	if configLoadErr != nil {
+		return HealthCheck{Detail: configLoadErr.Error()}
+	}
+	path := cfg.ConfigPath
+	if path == "" {
+		path = "defaults"
+	}
+	return HealthCheck{OK: true, Detail: fmt.Sprintf("%s, version %d", path, cfg.Version)}
+}
+
+// geodataCheck verifies that the geodata files the enabled sets draw
+// categories from are configured and readable.
+func geodataCheck(cfg *config.Config) HealthCheck {
+	var site, ip int
+	for _, set := range cfg.Sets {
+		if set.Enabled {
+			site += len(set.Targets.GeoSiteCategories)
+			ip += len(set.Targets.GeoIpCategories)
+		}
+	}
+	if site == 0 && ip == 0 {
+		return HealthCheck{OK: true, Detail: "not used"}
+	}
+
+	geo := cfg.System.Geo
+	for _, f := range []struct {
+		name, path string
+		used       int
+	}{{"geosite", geo.GeoSitePath, site}, {"geoip", geo.GeoIpPath, ip}} {
+		if f.used == 0 {
+			continue
+		}
+		if f.path == "" {
+			return HealthCheck{Detail: fmt.Sprintf("%d %s categories in use but no %s file configured", f.used, f.name, f.name)}
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return HealthCheck{Detail: err.Error()}
+		}
+	}
+	return HealthCheck{OK: true, Detail: fmt.Sprintf("%d geosite, %d geoip categories", site, ip)}
+}
+
+func tablesCheck(cfg *config.Config) HealthCheck {
+	if cfg.System.Tables.SkipSetup {
+		return HealthCheck{OK: true, Detail: "setup skipped"}
+	}
+	if rulesCheckFunc == nil {
+		return HealthCheck{Detail: "not verified"}
+	}
+	if err := rulesCheckFunc(); err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	return HealthCheck{OK: true, Detail: "rules verified"}
+}
+
+func nfqCheck() (HealthCheck, []HealthWorker) {
+	if globalPool == nil || len(globalPool.Workers) == 0 {
+		return HealthCheck{Detail: "no queue workers"}, nil
+	}
+	workers := make([]HealthWorker, 0, len(globalPool.Workers))
+	ready := 0
+	for _, w := range globalPool.Workers {
+		_, status := w.GetStats()
+		hw := HealthWorker{Queue: w.QueueNum(), Bound: w.Bound(), Status: status}
+		if hw.Bound && hw.Status == "active" {
+			ready++
+		}
+		workers = append(workers, hw)
+	}
+	check := HealthCheck{
+		OK:     ready == len(workers),
+		Detail: fmt.Sprintf("%d/%d workers bound and active", ready, len(workers)),
+	}
+	return check, workers
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.System.Tables.SkipSetup = true
+	mux := http.NewServeMux()
+	RegisterHealth(mux, &cfg)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", rec.Code)
+	}
+
+	// No queue workers registered, so b4 is live but not ready
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d, want 503", rec.Code)
+	}
+	var report HealthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if report.Status != "fail" || report.Checks["nfq"].OK || !report.Checks["tables"].OK {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/readyz", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /readyz = %d, want 405", rec.Code)
+	}
+}
+
+func TestGeodataCheck(t *testing.T) {
+	cfg := config.NewConfig()
+	if c := geodataCheck(&cfg); !c.OK {
+		t.Errorf("no categories in use reported as %+v", c)
+	}
+
+	set := config.NewSetConfig()
+	set.Enabled = true
+	set.Targets.GeoSiteCategories = []string{"youtube"}
+	cfg.Sets = []*config.SetConfig{&set}
+	cfg.System.Geo.GeoSitePath = ""
+	if c := geodataCheck(&cfg); c.OK {
+		t.Error("categories without a geosite file reported ok")
+	}
+
+	cfg.System.Geo.GeoSitePath = filepath.Join(t.TempDir(), "geosite.dat")
+	if c := geodataCheck(&cfg); c.OK {
+		t.Error("missing geosite file reported ok")
+	}
+
+	set.Enabled = false
+	if c := geodataCheck(&cfg); !c.OK {
+		t.Errorf("disabled set counted: %+v", c)
+	}
+}
+
+func TestTablesCheck(t *testing.T) {
+	defer SetRulesCheckFunc(nil)
+	cfg := config.NewConfig()
+
+	SetRulesCheckFunc(nil)
+	if c := tablesCheck(&cfg); c.OK {
+		t.Error("unverified rules reported ok")
+	}
+	SetRulesCheckFunc(func() error { return errors.New("iptables rules missing") })
+	if c := tablesCheck(&cfg); c.OK || c.Detail != "iptables rules missing" {
+		t.Errorf("failed verify reported as %+v", c)
+	}
+	SetRulesCheckFunc(func() error { return nil })
+	if c := tablesCheck(&cfg); !c.OK {
+		t.Errorf("verified rules reported as %+v", c)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/geodat"
 	"github.com/daniellavrushin/b4/log"
 	"github.com/google/uuid"
 )
@@ -232,8 +233,16 @@ func (api *API) deleteSet(w http.ResponseWriter, id string) {
 		return
 	}
 
+	sets := api.cfg.Sets
 	api.cfg.Sets = filtered
 
+	// Refuse to leave a device profile that allowed only this set unrestricted
+	if err := api.cfg.Validate(); err != nil {
+		api.cfg.Sets = sets
+		writeJsonError(w, http.StatusConflict, err.Error())
+		return
+	}
+
 	if err := api.saveAndPushConfig(api.cfg); err != nil {
 		log.Errorf("Failed to save config after deleting set: %v", err)
 		http.Error(w, "Failed to save", http.StatusInternalServerError)
@@ -311,6 +320,9 @@ func (api *API) initializeSetDefaults(set *config.SetConfig) {
 	if set.Targets.GeoIpCategories == nil {
 		set.Targets.GeoIpCategories = []string{}
 	}
+	if set.Targets.ASNs == nil {
+		set.Targets.ASNs = []string{}
+	}
 	if set.Targets.SourceDevices == nil {
 		set.Targets.SourceDevices = []string{}
 	}
@@ -339,6 +351,13 @@ func (api *API) loadTargetsForSetCached(set *config.SetConfig) {
 			ips = append(ips, cached...)
 		}
 	}
+	if len(set.Targets.ASNs) > 0 {
+		asnIps, err := geodat.LoadASNPrefixes(api.cfg.ASNOptions(), set.Targets.ASNs)
+		if err != nil {
+			log.Warnf("Failed to resolve ASNs for set '%s': %v", set.Name, err)
+		}
+		ips = append(ips, asnIps...)
+	}
 	ips = append(ips, set.Targets.IPs...)
 	set.Targets.IpsToMatch = ips
 }
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/daniellavrushin/b4/log"
+)
+
+func (api *API) RegisterTablesApi() {
+	api.mux.HandleFunc("/api/tables/refresh", api.handleTablesRefresh)
+}
+
+func (api *API) handleTablesRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if tablesRefreshFunc == nil {
+		writeJsonError(w, http.StatusConflict, "firewall rules are not managed by b4")
+		return
+	}
+
+	if err := tablesRefreshFunc(); err != nil {
+		log.Errorf("Failed to refresh tables: %v", err)
+		writeJsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Infof("Firewall rules refreshed")
+	setJsonHeader(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Firewall rules refreshed",
+	})
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestHandleTablesRefresh(t *testing.T) {
+	defer SetTablesRefreshFunc(nil)
+	cfg := config.NewConfig()
+	api := &API{cfg: &cfg}
+
+	refresh := func() int {
+		rec := httptest.NewRecorder()
+		api.handleTablesRefresh(rec, httptest.NewRequest(http.MethodPost, "/api/tables/refresh", nil))
+		return rec.Code
+	}
+
+	SetTablesRefreshFunc(nil)
+	if code := refresh(); code != http.StatusConflict {
+		t.Errorf("unmanaged rules: got %d, want 409", code)
+	}
+
+	SetTablesRefreshFunc(func() error { return errors.New("nft failed") })
+	if code := refresh(); code != http.StatusInternalServerError {
+		t.Errorf("failed refresh: got %d, want 500", code)
+	}
+
+	calls := 0
+	SetTablesRefreshFunc(func() error { calls++; return nil })
+	if code := refresh(); code != http.StatusOK || calls != 1 {
+		t.Errorf("refresh: got %d after %d calls, want 200 after 1", code, calls)
+	}
+
+	rec := httptest.NewRecorder()
+	api.handleTablesRefresh(rec, httptest.NewRequest(http.MethodGet, "/api/tables/refresh", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got %d, want 405", rec.Code)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"embed"
 	"fmt"
 	"io"
+	"net"
 	stdhttp "net/http"
+	"os"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
@@ -19,6 +23,11 @@ import (
 //go:embed ui/dist/*
 var uiDist embed.FS
 
+var (
+	api     *handler.API
+	apiOnce sync.Once
+)
+
 func StartServer(cfg *config.Config, pool *nfq.Pool) (*stdhttp.Server, error) {
 	if cfg.System.WebServer.Port == 0 {
 		log.Infof("Web server disabled (port 0)")
@@ -31,10 +40,12 @@ func StartServer(cfg *config.Config, pool *nfq.Pool) (*stdhttp.Server, error) {
 	registerWebSocketEndpoints(mux)
 
 	registerAPIEndpoints(mux, cfg)
+	handler.RegisterHealth(mux, cfg)
 
 	handler.RegisterSpa(mux, uiDist)
 
 	var httpHandler stdhttp.Handler = mux
+	httpHandler = handler.LockConfigWrites(httpHandler)
 	httpHandler = cors(httpHandler)
 
 	bindAddr := cfg.System.WebServer.BindAddress
@@ -91,18 +102,86 @@ func StartServer(cfg *config.Config, pool *nfq.Pool) (*stdhttp.Server, error) {
 	return srv, nil
 }
 
+// StartControlServer serves the REST API and the health endpoints on the
+// control unix socket for the b4 subcommands, even with the web server
+// disabled.
+func StartControlServer(cfg *config.Config, pool *nfq.Pool) (*stdhttp.Server, error) {
+	path := cfg.System.Control.Socket
+	if path == "" {
+		log.Infof("Control socket disabled")
+		return nil, nil
+	}
+
+	handler.SetNFQPool(pool)
+	mux := stdhttp.NewServeMux()
+	registerAPIEndpoints(mux, cfg)
+	handler.RegisterHealth(mux, cfg)
+
+	ln, err := listenControlSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Control socket listening on %s", path)
+
+	srv := &stdhttp.Server{
+		Handler:           handler.LockConfigWrites(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != stdhttp.ErrServerClosed {
+			log.Errorf("Control socket error: %v", err)
+		}
+	}()
+
+	return srv, nil
+}
+
+// listenControlSocket binds the control socket owner-only. It refuses a
+// path another instance still answers on or that is not a socket, and only
+// removes a stale socket left behind by an unclean exit.
+func listenControlSocket(path string) (net.Listener, error) {
+	fi, err := os.Lstat(path)
+	switch {
+	case err == nil:
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("control socket %s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use by another instance", path)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("remove stale control socket: %w", err)
+		}
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("stat control socket: %w", err)
+	}
+
+	// Created with the umask applied, so it is never open to others
+	old := syscall.Umask(0o077)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(old)
+	if err != nil {
+		return nil, fmt.Errorf("listen on control socket: %w", err)
+	}
+	return ln, nil
+}
+
 // registerWebSocketEndpoints registers all WebSocket handlers
 func registerWebSocketEndpoints(mux *stdhttp.ServeMux) {
 	mux.HandleFunc("/api/ws/logs", ws.HandleLogsWebSocket)
 	mux.HandleFunc("/api/ws/metrics", ws.HandleMetricsWebSocket)
 	mux.HandleFunc("/api/ws/discovery", ws.HandleDiscoveryWebSocket)
-	log.Tracef("WebSocket endpoints registered: /api/ws/logs, /api/ws/metrics, /api/ws/discovery")
+	mux.HandleFunc("/api/ws/events", ws.HandleEventsWebSocket)
+	log.Tracef("WebSocket endpoints registered: /api/ws/logs, /api/ws/metrics, /api/ws/discovery, /api/ws/events")
 }
 
-// registerAPIEndpoints registers all REST API handlers
+// registerAPIEndpoints registers all REST API handlers. The web server and
+// the control socket share one API so they act on the same state.
 func registerAPIEndpoints(mux *stdhttp.ServeMux, cfg *config.Config) {
-
-	api := handler.NewAPIHandler(cfg)
+	apiOnce.Do(func() {
+		api = handler.NewAPIHandler(cfg)
+	})
 	api.RegisterEndpoints(mux, cfg)
 
 	log.Tracef("REST API endpoints registered")
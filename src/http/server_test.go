@@ -0,0 +1,77 @@
+package http
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenControlSocket(t *testing.T) {
+	t.Run("creates an owner-only socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "b4.sock")
+		ln, err := listenControlSocket(path)
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		defer ln.Close()
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+			t.Errorf("socket mode = %v, want no group or other access", perm)
+		}
+	})
+
+	t.Run("refuses a socket another instance answers on", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "b4.sock")
+		ln, err := listenControlSocket(path)
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		defer ln.Close()
+
+		if second, err := listenControlSocket(path); err == nil {
+			second.Close()
+			t.Fatal("second listener took over a live socket")
+		}
+		if conn, err := net.Dial("unix", path); err != nil {
+			t.Errorf("first instance no longer reachable: %v", err)
+		} else {
+			conn.Close()
+		}
+	})
+
+	t.Run("replaces a stale socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "b4.sock")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		ln.Close()
+
+		ln, err = listenControlSocket(path)
+		if err != nil {
+			t.Fatalf("stale socket not replaced: %v", err)
+		}
+		ln.Close()
+	})
+
+	t.Run("leaves a path that is not a socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "b4.sock")
+		if err := os.WriteFile(path, []byte("keep"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if ln, err := listenControlSocket(path); err == nil {
+			ln.Close()
+			t.Fatal("listened over a regular file")
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != "keep" {
+			t.Errorf("file = %q, %v, want it untouched", data, err)
+		}
+	})
+}
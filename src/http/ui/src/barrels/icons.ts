@@ -67,4 +67,8 @@ export {
   UploadFile as UploadIcon,
   FilterAlt as FilterIcon,
   OpenInFull as FullscreenIcon,
+  Schedule as ScheduleIcon,
+  AccountTree as PipelineIcon,
+  ArrowUpward as MoveUpIcon,
+  ArrowDownward as MoveDownIcon,
 } from "@mui/icons-material";
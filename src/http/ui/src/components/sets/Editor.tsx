@@ -15,6 +15,7 @@ import {
   DomainIcon,
   ImportExportIcon,
   SaveIcon,
+  ScheduleIcon,
   TcpIcon,
   UdpIcon,
 } from "@b4.icons";
@@ -27,11 +28,14 @@ import {
   B4Config,
   B4SetConfig,
   MAIN_SET_ID,
+  ScheduleTimeRange,
+  StrategyStep,
   SystemConfig,
 } from "@models/config";
 
 import { DnsSettings } from "./Dns";
 import { ImportExportSettings } from "./ImportExport";
+import { ScheduleSettings } from "./Schedule";
 import { SetStats } from "./Manager";
 import { TargetSettings } from "./Target";
 import { TcpTabContainer } from "./tcp/TcpTabContainer";
@@ -90,6 +94,7 @@ export const SetEditorPage = ({
     TCP,
     UDP,
     DNS,
+    SCHEDULE,
     IMPORT_EXPORT,
   }
 
@@ -110,7 +115,16 @@ export const SetEditorPage = ({
 
   const handleChange = (
     field: string,
-    value: string | number | boolean | string[] | number[] | null | undefined,
+    value:
+      | string
+      | number
+      | boolean
+      | string[]
+      | number[]
+      | ScheduleTimeRange[]
+      | StrategyStep[]
+      | null
+      | undefined,
   ) => {
     setEditedSet((prev) => {
       if (!prev) return prev;
@@ -245,6 +259,7 @@ export const SetEditorPage = ({
             <B4Tab icon={<TcpIcon />} label="TCP" inline />
             <B4Tab icon={<UdpIcon />} label="UDP" inline />
             <B4Tab icon={<DnsIcon />} label="DNS" inline />
+            <B4Tab icon={<ScheduleIcon />} label="Schedule" inline />
             <B4Tab icon={<ImportExportIcon />} label="Import/Export" inline />
           </B4Tabs>
         </Box>
@@ -285,6 +300,10 @@ export const SetEditorPage = ({
           />
         </TabPanel>
 
+        <TabPanel value={activeTab} index={TABS.SCHEDULE}>
+          <ScheduleSettings config={editedSet} onChange={handleChange} />
+        </TabPanel>
+
         <TabPanel value={activeTab} index={TABS.IMPORT_EXPORT}>
           <ImportExportSettings
             config={editedSet}
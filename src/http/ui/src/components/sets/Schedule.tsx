@@ -0,0 +1,197 @@
+import {
+  Grid,
+  Box,
+  Stack,
+  Typography,
+  IconButton,
+  ToggleButton,
+  ToggleButtonGroup,
+} from "@mui/material";
+import { ScheduleIcon, ClearIcon } from "@b4.icons";
+import {
+  B4Alert,
+  B4PlusButton,
+  B4Section,
+  B4Switch,
+  B4TextField,
+} from "@b4.elements";
+import {
+  B4SetConfig,
+  ScheduleConfig,
+  ScheduleDay,
+  ScheduleTimeRange,
+} from "@models/config";
+import { colors } from "@design";
+
+interface ScheduleSettingsProps {
+  readonly config: B4SetConfig;
+  readonly onChange: (
+    field: string,
+    value: string | boolean | string[] | ScheduleTimeRange[],
+  ) => void;
+}
+
+const DAYS: { value: ScheduleDay; label: string }[] = [
+  { value: "mon", label: "Mon" },
+  { value: "tue", label: "Tue" },
+  { value: "wed", label: "Wed" },
+  { value: "thu", label: "Thu" },
+  { value: "fri", label: "Fri" },
+  { value: "sat", label: "Sat" },
+  { value: "sun", label: "Sun" },
+];
+
+const EMPTY_SCHEDULE: ScheduleConfig = {
+  enabled: false,
+  days: [],
+  ranges: [],
+  timezone: "",
+};
+
+export function ScheduleSettings({ config, onChange }: ScheduleSettingsProps) {
+  const schedule = { ...EMPTY_SCHEDULE, ...config.schedule };
+  const ranges = schedule.ranges || [];
+
+  const updateRange = (index: number, patch: Partial<ScheduleTimeRange>) => {
+    onChange(
+      "schedule.ranges",
+      ranges.map((r, i) => (i === index ? { ...r, ...patch } : r)),
+    );
+  };
+
+  return (
+    <B4Section
+      title="Schedule"
+      description="Activate this set only on certain days and hours"
+      icon={<ScheduleIcon />}
+    >
+      <Grid container spacing={3}>
+        <Grid size={{ xs: 12, md: 6 }}>
+          <B4Switch
+            label="Enable Schedule"
+            checked={schedule.enabled}
+            onChange={(checked: boolean) => {
+              onChange("schedule.enabled", checked);
+              if (!config.schedule) {
+                onChange("schedule.days", []);
+                onChange("schedule.ranges", []);
+                onChange("schedule.timezone", "");
+              }
+            }}
+            description="Outside its windows the set does not match any traffic"
+          />
+        </Grid>
+
+        {schedule.enabled && (
+          <>
+            <Grid size={{ xs: 12, md: 6 }}>
+              <B4TextField
+                label="Timezone"
+                value={schedule.timezone}
+                onChange={(e) => onChange("schedule.timezone", e.target.value)}
+                placeholder="e.g., Europe/Moscow"
+                helperText="IANA timezone name; empty uses the router's local time"
+              />
+            </Grid>
+
+            <Grid size={{ xs: 12 }}>
+              <Typography variant="subtitle2" sx={{ mb: 1 }}>
+                Days
+              </Typography>
+              <ToggleButtonGroup
+                value={schedule.days}
+                onChange={(_, value: string[]) =>
+                  onChange("schedule.days", value)
+                }
+                size="small"
+                sx={{
+                  "& .MuiToggleButton-root": {
+                    color: colors.text.secondary,
+                    borderColor: colors.border.default,
+                    textTransform: "none",
+                    px: 2,
+                    "&.Mui-selected": {
+                      bgcolor: colors.accent.secondary,
+                      color: colors.secondary,
+                      borderColor: colors.secondary,
+                      "&:hover": { bgcolor: colors.accent.secondary },
+                    },
+                  },
+                }}
+              >
+                {DAYS.map((d) => (
+                  <ToggleButton key={d.value} value={d.value}>
+                    {d.label}
+                  </ToggleButton>
+                ))}
+              </ToggleButtonGroup>
+              <Typography
+                variant="caption"
+                color="text.secondary"
+                sx={{ display: "block", mt: 0.5 }}
+              >
+                No days selected means every day
+              </Typography>
+            </Grid>
+
+            <Grid size={{ xs: 12 }}>
+              <Typography variant="subtitle2" sx={{ mb: 1 }}>
+                Time Windows
+              </Typography>
+              <Stack spacing={1}>
+                {ranges.map((r, i) => (
+                  <Box
+                    key={i}
+                    sx={{ display: "flex", gap: 1, alignItems: "center" }}
+                  >
+                    <B4TextField
+                      label="From"
+                      type="time"
+                      value={r.start}
+                      onChange={(e) => updateRange(i, { start: e.target.value })}
+                      sx={{ maxWidth: 160 }}
+                    />
+                    <B4TextField
+                      label="To"
+                      type="time"
+                      value={r.end}
+                      onChange={(e) => updateRange(i, { end: e.target.value })}
+                      sx={{ maxWidth: 160 }}
+                    />
+                    <IconButton
+                      size="small"
+                      onClick={() =>
+                        onChange(
+                          "schedule.ranges",
+                          ranges.filter((_, j) => j !== i),
+                        )
+                      }
+                    >
+                      <ClearIcon fontSize="small" />
+                    </IconButton>
+                  </Box>
+                ))}
+                <Box>
+                  <B4PlusButton
+                    onClick={() =>
+                      onChange("schedule.ranges", [
+                        ...ranges,
+                        { start: "18:00", end: "23:00" },
+                      ])
+                    }
+                  />
+                </Box>
+              </Stack>
+            </Grid>
+
+            <B4Alert severity="info" sx={{ m: 0 }}>
+              {ranges.length === 0
+                ? "No time windows: the set is active all day on the selected days."
+                : "A window whose end is before its start runs past midnight and belongs to the day it starts on."}
+            </B4Alert>
+          </>
+        )}
+      </Grid>
+    </B4Section>
+  );
+}
@@ -24,6 +24,7 @@ import {
   IpIcon,
   DragIcon,
   DnsIcon,
+  ScheduleIcon,
   FakingIcon,
   TcpIcon,
   CheckIcon,
@@ -461,6 +462,13 @@ export const SetCard = ({
                 set.dns?.enabled ? `DNS → ${set.dns.target_dns}` : "DNS OFF"
               }
             />
+            <QuickFlag
+              icon={<ScheduleIcon />}
+              enabled={set.schedule?.enabled}
+              tooltip={
+                set.schedule?.enabled ? "Active on schedule" : "Always active"
+              }
+            />
           </Box>
         </CardContent>
       </CardActionArea>
@@ -28,6 +28,7 @@ import {
   IpIcon,
   DeviceIcon,
   RefreshIcon,
+  FingerprintIcon,
 } from "@b4.icons";
 
 import {
@@ -47,6 +48,7 @@ import { B4SetConfig, GeoConfig } from "@models/config";
 import { useDevices } from "@b4.devices";
 import { colors } from "@design";
 import { SetStats } from "./Manager";
+import { TargetFingerprints } from "./TargetFingerprints";
 
 interface TargetSettingsProps {
   config: B4SetConfig;
@@ -98,6 +100,7 @@ export const TargetSettings = ({
   } = useDevices();
   const [newBypassDomain, setNewBypassDomain] = useState("");
   const [newBypassIP, setNewBypassIP] = useState("");
+  const [newBypassASN, setNewBypassASN] = useState("");
   const [newBypassCategory, setNewBypassCategory] = useState("");
   const [availableCategories, setAvailableCategories] = useState<string[]>([]);
   const [loadingCategories, setLoadingCategories] = useState(false);
@@ -209,6 +212,36 @@ export const TargetSettings = ({
     );
   };
 
+  const asns = config.targets.asns ?? [];
+
+  const handleAddBypassASN = () => {
+    const value = newBypassASN.trim();
+    if (!value) return;
+
+    const existing = new Set(asns);
+    const next = [...asns];
+
+    for (const raw of value.split(/[\s,|]+/).filter(Boolean)) {
+      const num = raw.toUpperCase().replace(/^ASN?/, "");
+      if (!/^\d+$/.test(num) || Number(num) === 0) continue;
+      const asn = `AS${Number(num)}`;
+      if (!existing.has(asn)) {
+        existing.add(asn);
+        next.push(asn);
+      }
+    }
+
+    onChange("targets.asns", next);
+    setNewBypassASN("");
+  };
+
+  const handleRemoveBypassASN = (asn: string) => {
+    onChange(
+      "targets.asns",
+      asns.filter((a) => a !== asn),
+    );
+  };
+
   const handleAddBypassGeoIPCategory = (category: string) => {
     if (category && !config.targets.geoip_categories.includes(category)) {
       onChange("targets.geoip_categories", [
@@ -332,6 +365,7 @@ export const TargetSettings = ({
                 }
                 inline
               />
+              <B4Tab icon={<FingerprintIcon />} label="Fingerprints" inline />
             </B4Tabs>
           </Box>
 
@@ -582,6 +616,60 @@ export const TargetSettings = ({
                   </Box>
                 </Grid>
               )}
+
+              {/* ASNs */}
+              <Grid size={{ sm: 12, md: 6 }}>
+                <Box>
+                  <Typography
+                    variant="h6"
+                    sx={{
+                      display: "flex",
+                      alignItems: "center",
+                      gap: 1,
+                      mb: 2,
+                    }}
+                  >
+                    <IpIcon /> Bypass ASNs
+                    <Tooltip title="Match every prefix announced by an autonomous system. Prefixes are resolved via the ASN source in Geo settings and refreshed periodically.">
+                      <InfoIcon fontSize="small" color="action" />
+                    </Tooltip>
+                  </Typography>
+                  <Box
+                    sx={{ display: "flex", gap: 1, alignItems: "flex-start" }}
+                  >
+                    <B4TextField
+                      label="Add Bypass ASN"
+                      value={newBypassASN}
+                      onChange={(e) => setNewBypassASN(e.target.value)}
+                      onKeyDown={(e) => {
+                        if (
+                          e.key === "Enter" ||
+                          e.key === "Tab" ||
+                          e.key === ","
+                        ) {
+                          e.preventDefault();
+                          handleAddBypassASN();
+                        }
+                      }}
+                      helperText="e.g. AS13335, 15169"
+                      placeholder="AS13335"
+                    />
+                    <B4PlusButton
+                      onClick={handleAddBypassASN}
+                      disabled={!newBypassASN}
+                    />
+                  </Box>
+                  <Box sx={{ mt: 2 }}>
+                    <B4ChipList
+                      items={asns}
+                      getKey={(a) => a}
+                      getLabel={(a) => a}
+                      onDelete={handleRemoveBypassASN}
+                      title="Active Bypass ASNs"
+                    />
+                  </Box>
+                </Box>
+              </Grid>
             </Grid>
           </TabPanel>
 
@@ -770,6 +858,11 @@ export const TargetSettings = ({
               </Box>
             )}
           </TabPanel>
+
+          {/* Fingerprints Tab */}
+          <TabPanel value={tabValue} index={3}>
+            <TargetFingerprints config={config} onChange={onChange} />
+          </TabPanel>
         </B4Section>
       </Stack>
 
@@ -0,0 +1,179 @@
+import { useState, useEffect } from "react";
+import { Grid, Box, Typography, Tooltip, Chip, Stack } from "@mui/material";
+import { FingerprintIcon, InfoIcon, BlockIcon } from "@b4.icons";
+import { B4Alert, B4ChipList, B4PlusButton, B4TextField } from "@b4.elements";
+import { B4SetConfig } from "@models/config";
+
+interface FingerprintStat {
+  ja3: string;
+  ja4: string;
+  count: number;
+  last_domain?: string;
+}
+
+interface TargetFingerprintsProps {
+  config: B4SetConfig;
+  onChange: (field: string, value: string[]) => void;
+}
+
+const FINGERPRINT_RE =
+  /^([0-9a-f]{32}|[tqd][0-9a-z]{2}[di]\d{4}[0-9a-z]{2}_[0-9a-f]{12}_[0-9a-f]{12}|[^*\s]+\*)$/;
+
+export const TargetFingerprints = ({
+  config,
+  onChange,
+}: TargetFingerprintsProps) => {
+  const [newInclude, setNewInclude] = useState("");
+  const [newExclude, setNewExclude] = useState("");
+  const [seen, setSeen] = useState<Record<string, FingerprintStat[]>>({});
+
+  const include = config.targets.fingerprints ?? [];
+  const exclude = config.targets.exclude_fingerprints ?? [];
+
+  useEffect(() => {
+    fetch("/api/devices/fingerprints?limit=5")
+      .then((r) => (r.ok ? r.json() : {}))
+      .then((data: Record<string, FingerprintStat[]>) => setSeen(data))
+      .catch(() => setSeen({}));
+  }, []);
+
+  const add = (field: string, list: string[], value: string) => {
+    const next = [...list];
+    for (const raw of value.split(/[\s,]+/).filter(Boolean)) {
+      const fp = raw.toLowerCase();
+      if (FINGERPRINT_RE.test(fp) && !next.includes(fp)) {
+        next.push(fp);
+      }
+    }
+    onChange(field, next);
+  };
+
+  const renderList = (
+    field: string,
+    list: string[],
+    value: string,
+    setValue: (v: string) => void,
+    label: string,
+    title: string,
+  ) => (
+    <>
+      <Box sx={{ display: "flex", gap: 1, alignItems: "flex-start" }}>
+        <B4TextField
+          label={label}
+          value={value}
+          onChange={(e) => setValue(e.target.value)}
+          onKeyDown={(e) => {
+            if (e.key === "Enter" || e.key === ",") {
+              e.preventDefault();
+              add(field, list, value);
+              setValue("");
+            }
+          }}
+          helperText="JA3 hash, JA4, or a prefix ending in *"
+          placeholder="t13d1516h2_*"
+        />
+        <B4PlusButton
+          onClick={() => {
+            add(field, list, value);
+            setValue("");
+          }}
+          disabled={!value.trim()}
+        />
+      </Box>
+      <Box sx={{ mt: 2 }}>
+        <B4ChipList
+          items={list}
+          getKey={(f) => f}
+          getLabel={(f) => f}
+          onDelete={(f) => onChange(field, list.filter((x) => x !== f))}
+          title={title}
+        />
+      </Box>
+    </>
+  );
+
+  const seenEntries = Object.entries(seen).filter(([, fps]) => fps.length > 0);
+
+  return (
+    <Stack spacing={2}>
+      <B4Alert severity="info" sx={{ m: 0 }}>
+        Match ClientHellos by their JA3/JA4 fingerprint, which identifies the
+        client app. With domain or IP targets the set only applies to the
+        listed fingerprints; without them it applies to those fingerprints
+        anywhere. Excluded fingerprints never use this set.
+      </B4Alert>
+
+      <Grid container spacing={2}>
+        <Grid size={{ sm: 12, md: 6 }}>
+          <Typography
+            variant="h6"
+            sx={{ display: "flex", alignItems: "center", gap: 1, mb: 2 }}
+          >
+            <FingerprintIcon /> Fingerprints
+            <Tooltip title="Limit the set to these client fingerprints.">
+              <InfoIcon fontSize="small" color="action" />
+            </Tooltip>
+          </Typography>
+          {renderList(
+            "targets.fingerprints",
+            include,
+            newInclude,
+            setNewInclude,
+            "Add Fingerprint",
+            "Active Fingerprints",
+          )}
+        </Grid>
+        <Grid size={{ sm: 12, md: 6 }}>
+          <Typography
+            variant="h6"
+            sx={{ display: "flex", alignItems: "center", gap: 1, mb: 2 }}
+          >
+            <BlockIcon /> Excluded Fingerprints
+            <Tooltip title="Never apply the set to these client fingerprints, e.g. a banking app.">
+              <InfoIcon fontSize="small" color="action" />
+            </Tooltip>
+          </Typography>
+          {renderList(
+            "targets.exclude_fingerprints",
+            exclude,
+            newExclude,
+            setNewExclude,
+            "Exclude Fingerprint",
+            "Excluded Fingerprints",
+          )}
+        </Grid>
+      </Grid>
+
+      {seenEntries.length > 0 && (
+        <Box>
+          <Typography variant="subtitle2" sx={{ mb: 1 }}>
+            Recently seen per device
+          </Typography>
+          {seenEntries.map(([mac, fps]) => (
+            <Box key={mac} sx={{ mb: 1.5 }}>
+              <Typography variant="caption" color="text.secondary">
+                {mac}
+              </Typography>
+              <Box sx={{ display: "flex", flexWrap: "wrap", gap: 0.5 }}>
+                {fps.map((fp) => (
+                  <Tooltip
+                    key={fp.ja3 + fp.ja4}
+                    title={`JA3 ${fp.ja3}${fp.last_domain ? ` · ${fp.last_domain}` : ""} · ${fp.count} hellos. Click to add.`}
+                  >
+                    <Chip
+                      size="small"
+                      label={fp.ja4 || fp.ja3}
+                      onClick={() =>
+                        add("targets.fingerprints", include, fp.ja4 || fp.ja3)
+                      }
+                    />
+                  </Tooltip>
+                ))}
+              </Box>
+            </Box>
+          ))}
+        </Box>
+      )}
+    </Stack>
+  );
+};
@@ -59,6 +59,39 @@ const UDP_FAKING_STRATEGIES = [
   { value: "checksum", label: "Checksum", description: "Corrupt UDP checksum" },
 ];
 
+const UDP_FAKE_PAYLOADS = [
+  {
+    value: "zero",
+    label: "Zero-filled",
+    description: "Fake datagrams are zeros of the configured size",
+  },
+  {
+    value: "initial",
+    label: "Encrypted QUIC Initial",
+    description:
+      "Valid Initial with a ClientHello for the decoy SNI, decryptable by DPI",
+  },
+];
+
+const UDP_CRYPTO_SPLITS = [
+  {
+    value: "off",
+    label: "Off",
+    description: "Split the SNI with IP fragmentation",
+  },
+  {
+    value: "split",
+    label: "Split",
+    description:
+      "Re-encrypt the ClientHello cut inside the SNI across CRYPTO frames",
+  },
+  {
+    value: "reorder",
+    label: "Split & Reorder",
+    description: "Like split, with CRYPTO frames and datagrams sent in reverse",
+  },
+];
+
 export const UdpSettings = ({ config, main, onChange }: UdpSettingsProps) => {
   const isQuicEnabled = config.udp.filter_quic !== "disabled";
   const hasPortFilter =
@@ -171,7 +204,7 @@ export const UdpSettings = ({ config, main, onChange }: UdpSettingsProps) => {
                 helperText={
                   main.id === config.id
                     ? "Main set limit (changing requires service restart to take effect)"
-                    : `Max: ${main.udp.conn_bytes_limit} (limited by main set)`
+                    : `Max: ${main.udp.conn_bytes_limit} (limited by main set); applies to this set's IPs, and to its domains only with sticky sets on`
                 }
               />
             </Grid>
@@ -216,6 +249,61 @@ export const UdpSettings = ({ config, main, onChange }: UdpSettingsProps) => {
               />
             </Grid>
 
+            <Grid size={{ xs: 12, md: 6 }}>
+              <B4Select
+                label="Fake Payload"
+                value={config.udp.fake_payload || "zero"}
+                options={UDP_FAKE_PAYLOADS}
+                onChange={(e) =>
+                  onChange("udp.fake_payload", e.target.value as string)
+                }
+                helperText={
+                  UDP_FAKE_PAYLOADS.find(
+                    (o) => o.value === (config.udp.fake_payload || "zero")
+                  )?.description
+                }
+              />
+            </Grid>
+
+            {config.udp.fake_payload === "initial" && (
+              <Grid size={{ xs: 12, md: 6 }}>
+                <B4TextField
+                  label="Decoy SNI"
+                  value={config.udp.fake_sni || ""}
+                  onChange={(e) => onChange("udp.fake_sni", e.target.value)}
+                  placeholder="www.google.com"
+                  helperText="Server name carried by the fake Initial packets"
+                />
+              </Grid>
+            )}
+
+            <Grid size={{ xs: 12, md: 6 }}>
+              <B4Select
+                label="CRYPTO Frame Split"
+                value={config.udp.crypto_split || "off"}
+                options={UDP_CRYPTO_SPLITS}
+                onChange={(e) =>
+                  onChange("udp.crypto_split", e.target.value as string)
+                }
+                helperText={
+                  UDP_CRYPTO_SPLITS.find(
+                    (o) => o.value === (config.udp.crypto_split || "off")
+                  )?.description
+                }
+              />
+            </Grid>
+
+            {config.udp.crypto_split && config.udp.crypto_split !== "off" && (
+              <Grid size={{ xs: 12, md: 6 }}>
+                <B4Switch
+                  label="PING/PADDING Frames"
+                  checked={config.udp.crypto_padding || false}
+                  onChange={(checked) => onChange("udp.crypto_padding", checked)}
+                  description="Interleave PING and PADDING frames between CRYPTO frames"
+                />
+              </Grid>
+            )}
+
             <Grid size={{ xs: 12, md: 6 }}>
               <B4Slider
                 label="Fake Packet Count"
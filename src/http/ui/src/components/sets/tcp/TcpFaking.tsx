@@ -45,6 +45,7 @@ const FAKE_PAYLOAD_TYPES = [
   { value: 2, label: "Preset: Google (classic)" },
   { value: 3, label: "Preset: DuckDuckGo" },
   { value: 4, label: "My own Payload File" },
+  { value: 5, label: "Mirror Client Hello" },
 ];
 
 const MUTATION_MODES: { value: MutationMode; label: string }[] = [
@@ -135,6 +136,7 @@ const incomingStrategyDescriptions: Record<string, string> = {
 
 export const TcpFaking = ({ config, onChange }: TcpFakingProps) => {
   const [newFakeSni, setNewFakeSni] = useState("");
+  const [newMirrorSni, setNewMirrorSni] = useState("");
   const [newWinValue, setNewWinValue] = useState("");
   const { captures, loadCaptures } = useCaptures();
 
@@ -208,6 +210,16 @@ export const TcpFaking = ({ config, onChange }: TcpFakingProps) => {
     );
   };
 
+  const mirrorSnis = config.faking.mirror_snis || [];
+
+  const handleAddMirrorSni = () => {
+    const sni = newMirrorSni.trim().toLowerCase();
+    if (sni && !mirrorSnis.includes(sni)) {
+      onChange("faking.mirror_snis", [...mirrorSnis, sni]);
+    }
+    setNewMirrorSni("");
+  };
+
   const handleAddWinValue = () => {
     const val = Number.parseInt(newWinValue, 10);
     if (
@@ -291,6 +303,45 @@ export const TcpFaking = ({ config, onChange }: TcpFakingProps) => {
               )}
             </Stack>
           </Grid>
+          {config.faking.sni_type === FakingPayloadType.MIRROR && (
+            <Grid container size={{ xs: 12 }}>
+              <Grid size={{ xs: 12, md: 6 }}>
+                <Box sx={{ display: "flex", gap: 1, alignItems: "flex-start" }}>
+                  <B4TextField
+                    label="Add Decoy SNI"
+                    value={newMirrorSni}
+                    onChange={(e) => setNewMirrorSni(e.target.value)}
+                    onKeyDown={(e) => {
+                      if (e.key === "Enter") {
+                        e.preventDefault();
+                        handleAddMirrorSni();
+                      }
+                    }}
+                    placeholder="e.g., www.google.com"
+                    helperText="Fakes copy the client's own ClientHello with one of these SNIs; one as long as the real SNI is preferred"
+                    disabled={!config.faking.sni}
+                  />
+                  <B4PlusButton
+                    onClick={handleAddMirrorSni}
+                    disabled={!config.faking.sni || !newMirrorSni.trim()}
+                  />
+                </Box>
+              </Grid>
+              <B4ChipList
+                items={mirrorSnis}
+                getKey={(s) => s}
+                getLabel={(s) => s}
+                onDelete={(s) =>
+                  onChange(
+                    "faking.mirror_snis",
+                    mirrorSnis.filter((x) => x !== s),
+                  )
+                }
+                title="Decoy SNIs"
+                gridSize={{ xs: 12, md: 6 }}
+              />
+            </Grid>
+          )}
           {config.faking.sni_type === FakingPayloadType.CAPTURE && (
             <Grid container size={{ xs: 12 }}>
               {captures.length > 0 && (
@@ -343,6 +394,63 @@ export const TcpFaking = ({ config, onChange }: TcpFakingProps) => {
               disabled={!config.faking.sni}
             />
           </Grid>
+          <Grid size={{ xs: 12, md: 8 }}>
+            <B4Switch
+              label="Auto TTL"
+              checked={config.faking.auto_ttl?.enabled || false}
+              onChange={(checked: boolean) =>
+                onChange("faking.auto_ttl.enabled", checked)
+              }
+              description="Derive fake TTLs from each server's hop distance, learned from its SYN-ACK. Fixed TTLs apply until a reply is seen"
+              disabled={!config.faking.sni}
+            />
+          </Grid>
+          {config.faking.auto_ttl?.enabled && (
+            <>
+              <Grid size={{ xs: 12, md: 4 }}>
+                <B4Slider
+                  label="Auto TTL Delta"
+                  value={config.faking.auto_ttl.delta}
+                  onChange={(value: number) =>
+                    onChange("faking.auto_ttl.delta", value)
+                  }
+                  min={0}
+                  max={10}
+                  step={1}
+                  helperText="Hops short of the server the fakes expire"
+                  disabled={!config.faking.sni}
+                />
+              </Grid>
+              <Grid size={{ xs: 12, md: 4 }}>
+                <B4Slider
+                  label="Auto TTL Min"
+                  value={config.faking.auto_ttl.min}
+                  onChange={(value: number) =>
+                    onChange("faking.auto_ttl.min", value)
+                  }
+                  min={1}
+                  max={64}
+                  step={1}
+                  helperText="Lowest TTL auto mode may pick"
+                  disabled={!config.faking.sni}
+                />
+              </Grid>
+              <Grid size={{ xs: 12, md: 4 }}>
+                <B4Slider
+                  label="Auto TTL Max"
+                  value={config.faking.auto_ttl.max}
+                  onChange={(value: number) =>
+                    onChange("faking.auto_ttl.max", value)
+                  }
+                  min={1}
+                  max={64}
+                  step={1}
+                  helperText="Highest TTL auto mode may pick"
+                  disabled={!config.faking.sni}
+                />
+              </Grid>
+            </>
+          )}
           <Grid size={{ xs: 12, md: 4 }}>
             <B4TextField
               label="Sequence Offset"
@@ -37,7 +37,7 @@ export const TcpGeneral = ({ config, main, onChange }: TcpGeneralProps) => {
             helperText={
               main.id === config.id
                 ? "Main set limit (changing requires service restart to take effect)"
-                : `Max: ${main.tcp.conn_bytes_limit} (limited by main set)`
+                : `Max: ${main.tcp.conn_bytes_limit} (limited by main set); applies to this set's IPs, and to its domains only with sticky sets on`
             }
           />
         </Grid>
@@ -0,0 +1,260 @@
+import { Box, IconButton, Grid, Stack, Typography } from "@mui/material";
+import {
+  B4Alert,
+  B4FormHeader,
+  B4PlusButton,
+  B4Select,
+  B4Switch,
+  B4TextField,
+} from "@b4.elements";
+import { ClearIcon, MoveDownIcon, MoveUpIcon, RestoreIcon } from "@b4.icons";
+import { B4SetConfig, StrategyAction, StrategyStep } from "@models/config";
+import { createDefaultPipeline } from "@models/defaults";
+import { colors } from "@design";
+
+interface TcpPipelineProps {
+  config: B4SetConfig;
+  onChange: (field: string, value: StrategyStep[]) => void;
+}
+
+interface ActionOption {
+  value: StrategyAction;
+  label: string;
+  description: string;
+}
+
+const ACTIONS: ActionOption[] = [
+  {
+    value: "mutate",
+    label: "Mutate ClientHello",
+    description: "Rewrite the ClientHello per Faking → SNI Mutation",
+  },
+  {
+    value: "tls_split",
+    label: "Split TLS Record",
+    description: "Split the TLS record in two inside the same packet",
+  },
+  {
+    value: "desync",
+    label: "Desync",
+    description: "Send the TCP Desync packets from General",
+  },
+  {
+    value: "window",
+    label: "Window",
+    description: "Send the window manipulation packets from General",
+  },
+  {
+    value: "fake",
+    label: "Fake SNI",
+    description: "Send fake ClientHellos per Faking",
+  },
+  {
+    value: "split",
+    label: "Split Segments",
+    description: "Cut the payload into TCP segments, sent by Send steps",
+  },
+  {
+    value: "send",
+    label: "Send",
+    description: "Send pending segments, or the whole packet if not split",
+  },
+  {
+    value: "fragment",
+    label: "Fragment",
+    description: "Deliver with a Splitting strategy",
+  },
+  { value: "delay", label: "Delay", description: "Wait before the next step" },
+  {
+    value: "post_desync",
+    label: "Post-Desync RST",
+    description: "Fake RST after delivery, when Post Desync is on",
+  },
+];
+
+const FRAGMENT_STRATEGIES = [
+  { value: "", label: "Set's Splitting strategy" },
+  { value: "tcp", label: "TCP" },
+  { value: "ip", label: "IP" },
+  { value: "tls", label: "TLS Record" },
+  { value: "oob", label: "OOB" },
+  { value: "disorder", label: "Disorder" },
+  { value: "extsplit", label: "Extension Split" },
+  { value: "firstbyte", label: "First Byte" },
+  { value: "combo", label: "Combo" },
+  { value: "hybrid", label: "Hybrid" },
+  { value: "none", label: "None" },
+];
+
+export const TcpPipeline = ({ config, onChange }: TcpPipelineProps) => {
+  const steps = config.pipeline?.length
+    ? config.pipeline
+    : createDefaultPipeline();
+
+  const update = (next: StrategyStep[]) => onChange("pipeline", next);
+
+  const patch = (index: number, p: Partial<StrategyStep>) =>
+    update(steps.map((s, i) => (i === index ? { ...s, ...p } : s)));
+
+  const move = (index: number, delta: number) => {
+    const next = [...steps];
+    const [step] = next.splice(index, 1);
+    next.splice(index + delta, 0, step);
+    update(next);
+  };
+
+  const renderParams = (step: StrategyStep, i: number) => {
+    switch (step.action) {
+      case "fragment":
+        return (
+          <B4Select
+            label="Strategy"
+            value={step.strategy || ""}
+            options={FRAGMENT_STRATEGIES}
+            onChange={(e) => patch(i, { strategy: e.target.value as string })}
+          />
+        );
+      case "split":
+      case "tls_split":
+        return (
+          <B4TextField
+            label="Positions"
+            value={(step.at || []).join(", ")}
+            onChange={(e) =>
+              patch(i, {
+                at: e.target.value
+                  .split(",")
+                  .map((s) => s.trim())
+                  .filter(Boolean),
+              })
+            }
+            placeholder={step.action === "split" ? "1, sni, midsni" : "sni"}
+            helperText="Payload offsets or sni, midsni, sniend"
+          />
+        );
+      case "fake":
+        return (
+          <B4TextField
+            label="Count"
+            type="number"
+            value={step.count || 0}
+            onChange={(e) => patch(i, { count: Number(e.target.value) })}
+            helperText="0 uses Fake Packet Count"
+          />
+        );
+      case "send":
+        return (
+          <Stack direction="row" spacing={2} alignItems="center">
+            <B4TextField
+              label="Segments"
+              type="number"
+              value={step.count || 0}
+              onChange={(e) => patch(i, { count: Number(e.target.value) })}
+              helperText="0 sends all pending"
+            />
+            <B4Switch
+              label="Reverse"
+              checked={step.reverse || false}
+              onChange={(checked: boolean) => patch(i, { reverse: checked })}
+            />
+          </Stack>
+        );
+      case "delay":
+        return (
+          <B4TextField
+            label="Delay (ms)"
+            type="number"
+            value={step.delay || 0}
+            onChange={(e) => patch(i, { delay: Number(e.target.value) })}
+          />
+        );
+      default:
+        return null;
+    }
+  };
+
+  return (
+    <Stack spacing={2}>
+      <B4FormHeader label="Strategy Pipeline" />
+      <B4Alert severity="info" sx={{ m: 0 }}>
+        Steps run top to bottom on each matched ClientHello. Mutate, Desync,
+        Window, Fake and Post-Desync only act while enabled in their sections.
+        Whatever no step delivered is sent at the end.
+      </B4Alert>
+
+      {steps.map((step, i) => (
+        <Box
+          key={i}
+          sx={{
+            p: 1.5,
+            border: 1,
+            borderColor: colors.border.default,
+            borderRadius: 1,
+          }}
+        >
+          <Grid container spacing={2} alignItems="center">
+            <Grid size={{ xs: 12, md: 4 }}>
+              <Stack direction="row" spacing={1} alignItems="center">
+                <Typography
+                  variant="body2"
+                  sx={{ color: colors.text.secondary, minWidth: 20 }}
+                >
+                  {i + 1}
+                </Typography>
+                <B4Select
+                  label="Action"
+                  value={step.action}
+                  options={ACTIONS}
+                  onChange={(e) =>
+                    patch(i, { action: e.target.value as StrategyAction })
+                  }
+                  helperText={
+                    ACTIONS.find((a) => a.value === step.action)?.description
+                  }
+                />
+              </Stack>
+            </Grid>
+            <Grid size={{ xs: 12, md: 6 }}>{renderParams(step, i)}</Grid>
+            <Grid size={{ xs: 12, md: 2 }}>
+              <Stack direction="row" justifyContent="flex-end">
+                <IconButton
+                  size="small"
+                  disabled={i === 0}
+                  onClick={() => move(i, -1)}
+                >
+                  <MoveUpIcon fontSize="small" />
+                </IconButton>
+                <IconButton
+                  size="small"
+                  disabled={i === steps.length - 1}
+                  onClick={() => move(i, 1)}
+                >
+                  <MoveDownIcon fontSize="small" />
+                </IconButton>
+                <IconButton
+                  size="small"
+                  onClick={() => update(steps.filter((_, j) => j !== i))}
+                >
+                  <ClearIcon fontSize="small" />
+                </IconButton>
+              </Stack>
+            </Grid>
+          </Grid>
+        </Box>
+      ))}
+
+      <Stack direction="row" spacing={1} alignItems="center">
+        <B4PlusButton onClick={() => update([...steps, { action: "send" }])} />
+        <IconButton
+          size="small"
+          onClick={() => update(createDefaultPipeline())}
+        >
+          <RestoreIcon fontSize="small" />
+        </IconButton>
+        <Typography variant="caption" color="text.secondary">
+          Restore the default order
+        </Typography>
+      </Stack>
+    </Stack>
+  );
+};
@@ -0,0 +1,158 @@
+import { useState, useEffect } from "react";
+import { Autocomplete, Button, Stack } from "@mui/material";
+import { DeviceUnknowIcon } from "@b4.icons";
+import { B4TextField } from "@b4.fields";
+import { colors } from "@design";
+import { B4Dialog, B4Switch, B4Badge, B4Alert } from "@b4.elements";
+import { B4SetConfig, DeviceProfile } from "@models/config";
+
+interface DeviceProfileDialogProps {
+  open: boolean;
+  mac: string;
+  name?: string;
+  profile?: DeviceProfile;
+  sets: B4SetConfig[];
+  onSave: (profile: DeviceProfile) => void;
+  onDelete: () => void;
+  onClose: () => void;
+}
+
+const emptyProfile = (mac: string, alias = ""): DeviceProfile => ({
+  mac,
+  alias,
+  enabled: true,
+  bypass: false,
+  sets: [],
+  mss_clamp: 0,
+  dns_target: "",
+});
+
+export const DeviceProfileDialog = ({
+  open,
+  mac,
+  name,
+  profile,
+  sets,
+  onSave,
+  onDelete,
+  onClose,
+}: DeviceProfileDialogProps) => {
+  const [draft, setDraft] = useState<DeviceProfile>(
+    profile ?? emptyProfile(mac, name),
+  );
+
+  useEffect(() => {
+    if (open) setDraft(profile ?? emptyProfile(mac, name));
+  }, [open, mac, name, profile]);
+
+  const update = (patch: Partial<DeviceProfile>) =>
+    setDraft((d) => ({ ...d, ...patch }));
+
+  const selectedSets = sets.filter((s) => draft.sets.includes(s.id));
+
+  return (
+    <B4Dialog
+      open={open}
+      onClose={onClose}
+      title="Device Profile"
+      subtitle={mac}
+      icon={<DeviceUnknowIcon />}
+      maxWidth="sm"
+      fullWidth
+      actions={
+        <Stack direction="row" spacing={2} sx={{ width: "100%" }}>
+          {profile && (
+            <Button color="error" onClick={onDelete}>
+              Remove Profile
+            </Button>
+          )}
+          <Stack sx={{ flex: 1 }} />
+          <Button onClick={onClose}>Cancel</Button>
+          <Button
+            variant="contained"
+            onClick={() => onSave({ ...draft, mac })}
+            sx={{ bgcolor: colors.secondary }}
+          >
+            Save
+          </Button>
+        </Stack>
+      }
+    >
+      <Stack spacing={2}>
+        <B4TextField
+          label="Alias"
+          value={draft.alias}
+          onChange={(e) => update({ alias: e.target.value })}
+          placeholder={name || "Device name"}
+        />
+        <B4Switch
+          label="Profile Enabled"
+          checked={draft.enabled}
+          onChange={(checked) => update({ enabled: checked })}
+          description="Disabled profiles are kept but not applied"
+        />
+        <B4Switch
+          label="Bypass Device"
+          checked={draft.bypass}
+          onChange={(checked) => update({ bypass: checked })}
+          description="Never process traffic from this device"
+        />
+        {draft.bypass ? (
+          <B4Alert severity="info">
+            Bypassed devices are excluded from the firewall rules; set, MSS and
+            DNS settings below are ignored.
+          </B4Alert>
+        ) : (
+          <>
+            <Autocomplete
+              multiple
+              size="small"
+              options={sets}
+              getOptionLabel={(s) => s.name}
+              isOptionEqualToValue={(a, b) => a.id === b.id}
+              value={selectedSets}
+              onChange={(_, value) => update({ sets: value.map((s) => s.id) })}
+              renderInput={(params) => (
+                <B4TextField
+                  {...params}
+                  label="Assigned Sets"
+                  placeholder={selectedSets.length === 0 ? "All sets" : ""}
+                  helperText="Leave empty to let every set match this device"
+                />
+              )}
+              renderValue={(value, getTagProps) =>
+                value.map((s, index) => (
+                  <B4Badge
+                    {...getTagProps({ index })}
+                    key={s.id}
+                    label={s.name}
+                    size="small"
+                  />
+                ))
+              }
+            />
+            <B4TextField
+              label="MSS Clamp"
+              type="number"
+              value={draft.mss_clamp || ""}
+              onChange={(e) =>
+                update({
+                  mss_clamp: e.target.value === "" ? 0 : Number(e.target.value),
+                })
+              }
+              placeholder="off"
+              helperText="Overrides the per-device MSS value (10-1460)"
+            />
+            <B4TextField
+              label="DNS Target"
+              value={draft.dns_target}
+              onChange={(e) => update({ dns_target: e.target.value })}
+              placeholder="e.g. 9.9.9.9"
+              helperText="Redirect every DNS query of this device to this server"
+            />
+          </>
+        )}
+      </Stack>
+    </B4Dialog>
+  );
+};
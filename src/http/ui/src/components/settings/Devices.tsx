@@ -17,10 +17,11 @@ import {
   Tooltip,
   TextField,
 } from "@mui/material";
-import { DeviceMSSClamp } from "@models/config";
+import { DeviceMSSClamp, DeviceProfile } from "@models/config";
 import { DeviceUnknowIcon, RefreshIcon } from "@b4.icons";
 import EditIcon from "@mui/icons-material/Edit";
 import RestoreIcon from "@mui/icons-material/Restore";
+import TuneIcon from "@mui/icons-material/Tune";
 import { colors } from "@design";
 import {
   B4Section,
@@ -31,6 +32,7 @@ import {
   B4InlineEdit,
 } from "@b4.elements";
 import { useDevices, DeviceInfo, DevicesSettingsProps } from "@b4.devices";
+import { DeviceProfileDialog } from "./DeviceProfileDialog";
 
 const DeviceNameCell = ({
   device,
@@ -100,12 +102,14 @@ const DeviceNameCell = ({
 
 export const DevicesSettings = ({ config, onChange }: DevicesSettingsProps) => {
   const [editingMac, setEditingMac] = useState<string | null>(null);
+  const [profileDevice, setProfileDevice] = useState<DeviceInfo | null>(null);
 
   const selectedMacs = config.queue.devices?.mac || [];
   const enabled = config.queue.devices?.enabled || false;
   const vendorLookup = config.queue.devices?.vendor_lookup || false;
   const wisb = config.queue.devices?.wisb || false;
   const mssClamps: DeviceMSSClamp[] = config.queue.devices?.mss_clamps || [];
+  const profiles: DeviceProfile[] = config.queue.devices?.profiles || [];
   const {
     devices,
     loading,
@@ -155,6 +159,24 @@ export const DevicesSettings = ({ config, onChange }: DevicesSettingsProps) => {
     onChange("queue.devices.mss_clamps", current);
   };
 
+  const getProfile = (mac: string) =>
+    profiles.find((p) => p.mac.toUpperCase() === mac.toUpperCase());
+
+  const handleProfileSave = (profile: DeviceProfile) => {
+    const mac = profile.mac.toUpperCase();
+    const rest = profiles.filter((p) => p.mac.toUpperCase() !== mac);
+    onChange("queue.devices.profiles", [...rest, { ...profile, mac }]);
+    setProfileDevice(null);
+  };
+
+  const handleProfileDelete = (mac: string) => {
+    onChange(
+      "queue.devices.profiles",
+      profiles.filter((p) => p.mac.toUpperCase() !== mac.toUpperCase()),
+    );
+    setProfileDevice(null);
+  };
+
   const isSelected = (mac: string) => selectedMacs.includes(mac);
   const allSelected =
     devices.length > 0 && selectedMacs.length === devices.length;
@@ -164,7 +186,7 @@ export const DevicesSettings = ({ config, onChange }: DevicesSettingsProps) => {
   return (
     <B4Section
       title="Device Filtering"
-      description="Filter traffic by source device MAC address"
+      description="Filter traffic and assign policy profiles by source device MAC address"
       icon={<DeviceUnknowIcon />}
     >
       <Grid container spacing={2}>
@@ -195,191 +217,230 @@ export const DevicesSettings = ({ config, onChange }: DevicesSettingsProps) => {
         </Grid>
 
         {enabled && (
-          <>
-            <B4Alert severity={wisb ? "warning" : "info"}>
-              {wisb
-                ? "Blacklist mode: Selected devices will be EXCLUDED from DPI bypass"
-                : "Whitelist mode: Only selected devices will use DPI bypass"}
-            </B4Alert>
+          <B4Alert severity={wisb ? "warning" : "info"}>
+            {wisb
+              ? "Blacklist mode: Selected devices will be EXCLUDED from DPI bypass"
+              : "Whitelist mode: Only selected devices will use DPI bypass"}
+          </B4Alert>
+        )}
 
-            {available ? (
-              <Grid size={{ xs: 12 }}>
-                <Box
-                  sx={{
-                    display: "flex",
-                    justifyContent: "space-between",
-                    alignItems: "center",
-                    mb: 1,
-                  }}
-                >
-                  <Typography variant="subtitle2">
-                    Available Devices
-                    {source && (
-                      <Chip
-                        label={source}
-                        size="small"
-                        sx={{
-                          ml: 1,
-                          bgcolor: colors.accent.secondary,
-                          color: colors.secondary,
-                        }}
-                      />
-                    )}
-                  </Typography>
-                  <B4TooltipButton
-                    title="Refresh devices"
-                    icon={
-                      loading ? <CircularProgress size={18} /> : <RefreshIcon />
-                    }
-                    onClick={() => {
-                      loadDevices().catch(() => {});
+        {available ? (
+          <Grid size={{ xs: 12 }}>
+            <Box
+              sx={{
+                display: "flex",
+                justifyContent: "space-between",
+                alignItems: "center",
+                mb: 1,
+              }}
+            >
+              <Typography variant="subtitle2">
+                Available Devices
+                {source && (
+                  <Chip
+                    label={source}
+                    size="small"
+                    sx={{
+                      ml: 1,
+                      bgcolor: colors.accent.secondary,
+                      color: colors.secondary,
                     }}
                   />
-                </Box>
+                )}
+              </Typography>
+              <B4TooltipButton
+                title="Refresh devices"
+                icon={
+                  loading ? <CircularProgress size={18} /> : <RefreshIcon />
+                }
+                onClick={() => {
+                  loadDevices().catch(() => {});
+                }}
+              />
+            </Box>
 
-                <TableContainer
-                  component={Paper}
-                  sx={{
-                    bgcolor: colors.background.paper,
-                    border: `1px solid ${colors.border.default}`,
-                    maxHeight: 300,
-                  }}
-                >
-                  <Table size="small" stickyHeader>
-                    <TableHead>
-                      <TableRow>
+            <TableContainer
+              component={Paper}
+              sx={{
+                bgcolor: colors.background.paper,
+                border: `1px solid ${colors.border.default}`,
+                maxHeight: 300,
+              }}
+            >
+              <Table size="small" stickyHeader>
+                <TableHead>
+                  <TableRow>
+                    {enabled && (
+                      <TableCell
+                        padding="checkbox"
+                        sx={{ bgcolor: colors.background.dark }}
+                      >
+                        <Checkbox
+                          color="secondary"
+                          indeterminate={someSelected}
+                          checked={allSelected}
+                          onChange={(e) =>
+                            onChange(
+                              "queue.devices.mac",
+                              e.target.checked
+                                ? devices.map((d) => d.mac)
+                                : [],
+                            )
+                          }
+                        />
+                      </TableCell>
+                    )}
+                    {["MAC Address", "IP", "Name", "MSS", "Profile"].map(
+                      (label) => (
                         <TableCell
-                          padding="checkbox"
-                          sx={{ bgcolor: colors.background.dark }}
+                          key={label}
+                          sx={{
+                            bgcolor: colors.background.dark,
+                            color: colors.text.secondary,
+                          }}
                         >
-                          <Checkbox
-                            color="secondary"
-                            indeterminate={someSelected}
-                            checked={allSelected}
-                            onChange={(e) =>
-                              onChange(
-                                "queue.devices.mac",
-                                e.target.checked
-                                  ? devices.map((d) => d.mac)
-                                  : [],
-                              )
-                            }
+                          {label}
+                        </TableCell>
+                      ),
+                    )}
+                  </TableRow>
+                </TableHead>
+                <TableBody>
+                  {devices.length === 0 ? (
+                    <TableRow>
+                      <TableCell colSpan={enabled ? 6 : 5} align="center">
+                        {loading
+                          ? "Loading devices..."
+                          : "No devices found"}
+                      </TableCell>
+                    </TableRow>
+                  ) : (
+                    devices.map((device) => (
+                      <TableRow
+                        key={device.mac}
+                        hover
+                        onClick={() => enabled && handleMacToggle(device.mac)}
+                        sx={{ cursor: enabled ? "pointer" : "default" }}
+                      >
+                        {enabled && (
+                          <TableCell padding="checkbox">
+                            <Checkbox
+                              checked={isSelected(device.mac)}
+                              color="secondary"
+                            />
+                          </TableCell>
+                        )}
+                        <TableCell
+                          sx={{
+                            fontFamily: "monospace",
+                            fontSize: "0.85rem",
+                          }}
+                        >
+                          {device.mac}
+                        </TableCell>
+                        <TableCell
+                          sx={{
+                            fontFamily: "monospace",
+                            fontSize: "0.85rem",
+                          }}
+                        >
+                          {device.ip}
+                        </TableCell>
+                        <TableCell onClick={(e) => e.stopPropagation()}>
+                          <DeviceNameCell
+                            device={device}
+                            isSelected={isSelected(device.mac)}
+                            isEditing={editingMac === device.mac}
+                            onStartEdit={() => setEditingMac(device.mac)}
+                            onSaveAlias={async (alias) => {
+                              const result = await setAlias(
+                                device.mac,
+                                alias,
+                              );
+                              if (result.success) setEditingMac(null);
+                            }}
+                            onResetAlias={async () => {
+                              const result = await resetAlias(device.mac);
+                              if (result.success) setEditingMac(null);
+                            }}
+                            onCancelEdit={() => setEditingMac(null)}
                           />
                         </TableCell>
-                        {["MAC Address", "IP", "Name", "MSS"].map((label) => (
-                          <TableCell
-                            key={label}
+                        <TableCell onClick={(e) => e.stopPropagation()}>
+                          <TextField
+                            size="small"
+                            type="number"
+                            value={getMSSSize(device.mac)}
+                            onChange={(e) => {
+                              const val =
+                                e.target.value === ""
+                                  ? null
+                                  : Number(e.target.value);
+                              handleMSSChange(device.mac, val);
+                            }}
+                            placeholder="off"
+                            slotProps={{
+                              htmlInput: {
+                                min: 10,
+                                max: 1460,
+                                style: { width: 50, padding: "4px 8px" },
+                              },
+                            }}
                             sx={{
-                              bgcolor: colors.background.dark,
-                              color: colors.text.secondary,
+                              "& .MuiOutlinedInput-root": {
+                                fontSize: "0.85rem",
+                              },
                             }}
+                          />
+                        </TableCell>
+                        <TableCell onClick={(e) => e.stopPropagation()}>
+                          <Tooltip
+                            title={
+                              getProfile(device.mac)
+                                ? getProfile(device.mac)?.bypass
+                                  ? "Bypassed - edit profile"
+                                  : "Edit profile"
+                                : "Create profile"
+                            }
                           >
-                            {label}
-                          </TableCell>
-                        ))}
-                      </TableRow>
-                    </TableHead>
-                    <TableBody>
-                      {devices.length === 0 ? (
-                        <TableRow>
-                          <TableCell colSpan={5} align="center">
-                            {loading
-                              ? "Loading devices..."
-                              : "No devices found"}
-                          </TableCell>
-                        </TableRow>
-                      ) : (
-                        devices.map((device) => (
-                          <TableRow
-                            key={device.mac}
-                            hover
-                            onClick={() => handleMacToggle(device.mac)}
-                            sx={{ cursor: "pointer" }}
-                          >
-                            <TableCell padding="checkbox">
-                              <Checkbox
-                                checked={isSelected(device.mac)}
-                                color="secondary"
-                              />
-                            </TableCell>
-                            <TableCell
-                              sx={{
-                                fontFamily: "monospace",
-                                fontSize: "0.85rem",
-                              }}
-                            >
-                              {device.mac}
-                            </TableCell>
-                            <TableCell
-                              sx={{
-                                fontFamily: "monospace",
-                                fontSize: "0.85rem",
-                              }}
+                            <IconButton
+                              size="small"
+                              color={
+                                getProfile(device.mac)?.enabled
+                                  ? "secondary"
+                                  : "default"
+                              }
+                              onClick={() => setProfileDevice(device)}
                             >
-                              {device.ip}
-                            </TableCell>
-                            <TableCell onClick={(e) => e.stopPropagation()}>
-                              <DeviceNameCell
-                                device={device}
-                                isSelected={isSelected(device.mac)}
-                                isEditing={editingMac === device.mac}
-                                onStartEdit={() => setEditingMac(device.mac)}
-                                onSaveAlias={async (alias) => {
-                                  const result = await setAlias(
-                                    device.mac,
-                                    alias,
-                                  );
-                                  if (result.success) setEditingMac(null);
-                                }}
-                                onResetAlias={async () => {
-                                  const result = await resetAlias(device.mac);
-                                  if (result.success) setEditingMac(null);
-                                }}
-                                onCancelEdit={() => setEditingMac(null)}
-                              />
-                            </TableCell>
-                            <TableCell onClick={(e) => e.stopPropagation()}>
-                              <TextField
-                                size="small"
-                                type="number"
-                                value={getMSSSize(device.mac)}
-                                onChange={(e) => {
-                                  const val =
-                                    e.target.value === ""
-                                      ? null
-                                      : Number(e.target.value);
-                                  handleMSSChange(device.mac, val);
-                                }}
-                                placeholder="off"
-                                slotProps={{
-                                  htmlInput: {
-                                    min: 10,
-                                    max: 1460,
-                                    style: { width: 50, padding: "4px 8px" },
-                                  },
-                                }}
-                                sx={{
-                                  "& .MuiOutlinedInput-root": {
-                                    fontSize: "0.85rem",
-                                  },
-                                }}
-                              />
-                            </TableCell>
-                          </TableRow>
-                        ))
-                      )}
-                    </TableBody>
-                  </Table>
-                </TableContainer>
-              </Grid>
-            ) : (
-              <B4Alert severity="warning">
-                ARP table not available. Device discovery unavailable.
-              </B4Alert>
-            )}
-          </>
+                              <TuneIcon sx={{ fontSize: 18 }} />
+                            </IconButton>
+                          </Tooltip>
+                        </TableCell>
+                      </TableRow>
+                    ))
+                  )}
+                </TableBody>
+              </Table>
+            </TableContainer>
+          </Grid>
+        ) : (
+          <B4Alert severity="warning">
+            ARP table not available. Device discovery unavailable.
+          </B4Alert>
         )}
       </Grid>
+      {profileDevice && (
+        <DeviceProfileDialog
+          open
+          mac={profileDevice.mac}
+          name={profileDevice.alias || profileDevice.vendor}
+          profile={getProfile(profileDevice.mac)}
+          sets={config.sets}
+          onSave={handleProfileSave}
+          onDelete={() => handleProfileDelete(profileDevice.mac)}
+          onClose={() => setProfileDevice(null)}
+        />
+      )}
     </B4Section>
   );
 };
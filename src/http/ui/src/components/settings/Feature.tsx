@@ -1,6 +1,7 @@
 import { ToggleOnIcon } from "@b4.icons";
-import { B4Config } from "@models/config";
+import { B4Config, FirewallBackend } from "@models/config";
 import {
+  B4Select,
   B4Slider,
   B4FormGroup,
   B4Section,
@@ -10,6 +11,13 @@ import {
 } from "@b4.elements";
 import { Box, Typography } from "@mui/material";
 
+const FIREWALL_BACKENDS: Array<{ value: FirewallBackend; label: string }> = [
+  { value: "auto", label: "Auto" },
+  { value: "nftables", label: "nftables (nft)" },
+  { value: "iptables", label: "iptables" },
+  { value: "netlink", label: "Native netlink" },
+] as const;
+
 interface FeatureSettingsProps {
   config: B4Config;
   onChange: (
@@ -56,6 +64,15 @@ export const FeatureSettings = ({ config, onChange }: FeatureSettingsProps) => {
           }
           description="Skip automatic IPTables/NFTables rules configuration"
         />
+        <B4Select
+          label="Firewall Backend"
+          value={config.system.tables.backend || "auto"}
+          options={FIREWALL_BACKENDS}
+          onChange={(e) =>
+            onChange("system.tables.backend", String(e.target.value))
+          }
+          helperText="Netlink programs nftables directly and needs neither nft nor iptables binaries"
+        />
         <B4Slider
           label="Firewall Monitor Interval in seconds (default 10s)"
           value={config.system.tables.monitor_interval}
@@ -75,6 +92,42 @@ export const FeatureSettings = ({ config, onChange }: FeatureSettingsProps) => {
             )
           }
         />
+        <B4Switch
+          label="Sticky Sets"
+          checked={config.queue.sticky_sets ?? false}
+          onChange={(checked: boolean) =>
+            onChange("queue.sticky_sets", checked)
+          }
+          description="Remember each connection's set in its conntrack mark so later packets and replies keep it (requires restart)"
+        />
+        <B4Switch
+          label="Kernel Target Prefilter"
+          checked={config.system.tables.prefilter || false}
+          onChange={(checked: boolean) =>
+            onChange("system.tables.prefilter", checked)
+          }
+          description="Queue only destinations in nftables sets/ipsets built from set IPs, geoip and learned IPs"
+        />
+        {config.system.tables.prefilter && (
+          <B4Slider
+            label="Learned IP Timeout in seconds"
+            value={config.system.tables.prefilter_timeout || 3600}
+            onChange={(value: number) =>
+              onChange("system.tables.prefilter_timeout", value)
+            }
+            min={300}
+            max={86400}
+            step={300}
+            helperText="How long IPs from DNS answers and SNI matches stay in the kernel sets"
+            alert={
+              <B4Alert severity="info">
+                Domain targets are matched only after their IPs were seen in a
+                DNS answer passing through B4. Clients using DoH/DoT bypass
+                this.
+              </B4Alert>
+            }
+          />
+        )}
         <B4Switch
           label="NAT Masquerade"
           checked={config.system.tables.masquerade}
@@ -123,6 +176,42 @@ export const FeatureSettings = ({ config, onChange }: FeatureSettingsProps) => {
             </Box>
           </B4FormGroup>
         )}
+        <B4Switch
+          label="Traffic Accounting"
+          checked={config.system.accounting?.enabled || false}
+          onChange={(checked: boolean) =>
+            onChange("system.accounting.enabled", checked)
+          }
+          description="Count bytes per set, domain and device from conntrack, with daily and monthly totals"
+        />
+        {config.system.accounting?.enabled && (
+          <>
+            <B4Slider
+              label="Accounting Poll Interval in seconds"
+              value={config.system.accounting.poll_interval || 15}
+              onChange={(value: number) =>
+                onChange("system.accounting.poll_interval", value)
+              }
+              min={5}
+              max={300}
+              step={5}
+              helperText="How often conntrack counters are read"
+            />
+            <B4Slider
+              label="Keep Daily Totals (days)"
+              value={config.system.accounting.retention_days || 62}
+              onChange={(value: number) =>
+                onChange("system.accounting.retention_days", value)
+              }
+              min={1}
+              max={366}
+              step={1}
+              helperText={`Monthly totals are kept for ${
+                config.system.accounting.retention_months || 24
+              } months`}
+            />
+          </>
+        )}
         <B4FormGroup label="Network Interfaces" columns={1}>
           <Box>
             <Typography variant="body2" color="text.secondary" sx={{ mb: 1 }}>
@@ -1,4 +1,4 @@
-import { B4Config } from "@models/config";
+import { ASNSource, B4Config } from "@models/config";
 import {
   Grid,
   Stack,
@@ -10,14 +10,25 @@ import {
   Chip,
   Divider,
 } from "@mui/material";
-import { DomainIcon, DownloadIcon, SuccessIcon } from "@b4.icons";
-import { B4Alert, B4Section, B4TextField } from "@b4.elements";
+import { DomainIcon, DownloadIcon, IpIcon, SuccessIcon } from "@b4.icons";
+import {
+  B4Alert,
+  B4Section,
+  B4Select,
+  B4Slider,
+  B4TextField,
+} from "@b4.elements";
 import { useState, useEffect, useCallback, useMemo } from "react";
 import { colors } from "@design";
 import { geodatApi, GeodatSource, GeoFileInfo } from "@b4.settings";
 
 const CUSTOM_SOURCE = "__custom__";
 
+const ASN_SOURCES: Array<{ value: ASNSource; label: string }> = [
+  { value: "ripestat", label: "RIPEstat API" },
+  { value: "file", label: "Local prefix database" },
+] as const;
+
 interface GeoFileCardProps {
   title: string;
   fileInfo: GeoFileInfo;
@@ -211,9 +222,14 @@ const GeoFileCard = ({
 export interface GeoSettingsProps {
   config: B4Config;
   loadConfig: () => void;
+  onChange: (field: string, value: string | number) => void;
 }
 
-export const GeoSettings = ({ config, loadConfig }: GeoSettingsProps) => {
+export const GeoSettings = ({
+  config,
+  loadConfig,
+  onChange,
+}: GeoSettingsProps) => {
   const [sources, setSources] = useState<GeodatSource[]>([]);
   const [destPath, setDestPath] = useState<string>("/etc/b4");
 
@@ -445,6 +461,44 @@ export const GeoSettings = ({ config, loadConfig }: GeoSettingsProps) => {
           </Grid>
         </Grid>
       </B4Section>
+
+      <B4Section
+        title="ASN Prefixes"
+        description="Where sets with ASN targets get their announced prefixes"
+        icon={<IpIcon />}
+      >
+        <B4Select
+          label="ASN Source"
+          value={config.system.geo.asn_source || "ripestat"}
+          options={ASN_SOURCES}
+          onChange={(e) =>
+            onChange("system.geo.asn_source", String(e.target.value))
+          }
+          helperText="RIPEstat needs internet access; a local database works offline"
+        />
+        {config.system.geo.asn_source === "file" && (
+          <B4TextField
+            label="Prefix Database Path"
+            value={config.system.geo.asn_database_path || ""}
+            onChange={(e) =>
+              onChange("system.geo.asn_database_path", e.target.value)
+            }
+            placeholder="/etc/b4/ipasn.dat"
+            helperText="CSV/TSV of prefix and origin ASN, or an MRT RIB dump (gzip/bzip2 allowed)"
+          />
+        )}
+        <B4Slider
+          label="Refresh Interval (hours)"
+          value={config.system.geo.asn_refresh_hours || 24}
+          onChange={(value: number) =>
+            onChange("system.geo.asn_refresh_hours", value)
+          }
+          min={1}
+          max={168}
+          step={1}
+          helperText="Cached prefixes older than this are re-resolved"
+        />
+      </B4Section>
     </Stack>
   );
 };
@@ -2,6 +2,7 @@ import { NetworkIcon } from "@b4.icons";
 import {
   B4FormGroup,
   B4Section,
+  B4Select,
   B4TextField,
   B4Slider,
   B4Switch,
@@ -17,8 +18,23 @@ interface NetworkSettingsProps {
   ) => void;
 }
 
+const OVERFLOW_POLICIES = [
+  { value: "accept", label: "Pass packet unmodified" },
+  { value: "drop", label: "Drop packet" },
+];
+
 export const NetworkSettings = ({ config, onChange }: NetworkSettingsProps) => {
   const mss = config.queue.mss_clamp ?? { enabled: false, size: 88 };
+  const injection = config.queue.injection ?? {
+    workers: 32,
+    queue_size: 1024,
+    overflow: "accept",
+  };
+  const watchdog = config.queue.watchdog ?? {
+    enabled: true,
+    stall_timeout: 10,
+    max_restarts: 3,
+  };
 
   return (
     <B4Section
@@ -50,6 +66,102 @@ export const NetworkSettings = ({ config, onChange }: NetworkSettingsProps) => {
           step={1}
           helperText="Number of worker threads for processing packets simultaneously (default 4)"
         />
+        <B4Slider
+          label="QUIC Flight Hold"
+          value={config.queue.quic_hold ?? 50}
+          onChange={(value: number) => onChange("queue.quic_hold", value)}
+          min={0}
+          max={500}
+          step={10}
+          valueSuffix=" ms"
+          helperText="How long a QUIC Initial without a visible SNI waits for the rest of its flight, only for flows a set can match (0 = off, default 50)"
+        />
+        <B4Slider
+          label="TCP ClientHello Hold"
+          value={config.queue.tcp_hold ?? 150}
+          onChange={(value: number) => onChange("queue.tcp_hold", value)}
+          min={0}
+          max={1000}
+          step={10}
+          valueSuffix=" ms"
+          helperText="How long a ClientHello spanning several TCP segments waits for the rest, only for flows a set can match (0 = off, default 150)"
+        />
+      </B4FormGroup>
+      <B4FormGroup label="Injection Queue" columns={2}>
+        <B4Slider
+          label="Injection Workers"
+          value={injection.workers}
+          onChange={(value: number) =>
+            onChange("queue.injection.workers", value)
+          }
+          min={1}
+          max={256}
+          step={1}
+          helperText="Packets each queue thread runs strategies for at once (default 32)"
+        />
+        <B4TextField
+          label="Queue Size"
+          type="number"
+          value={injection.queue_size}
+          onChange={(e) =>
+            onChange("queue.injection.queue_size", Number(e.target.value))
+          }
+          helperText="Packets waiting or paused between sends per queue thread (default 1024)"
+        />
+        <B4Select
+          label="When the Queue Is Full"
+          value={injection.overflow}
+          options={OVERFLOW_POLICIES}
+          onChange={(e) =>
+            onChange("queue.injection.overflow", String(e.target.value))
+          }
+          helperText="What happens to new target packets while the queue is full"
+        />
+        <B4Alert severity="info">
+          Restart B4 after changing the worker count or queue size.
+        </B4Alert>
+      </B4FormGroup>
+      <B4FormGroup label="Worker Watchdog" columns={2}>
+        <B4Switch
+          label="Restart Stalled Workers"
+          checked={watchdog.enabled}
+          onChange={(checked: boolean) =>
+            onChange("queue.watchdog.enabled", checked)
+          }
+          description="Reopen a queue thread that stops answering packets"
+        />
+        {watchdog.enabled && (
+          <>
+            <B4Slider
+              label="Stall Timeout"
+              value={watchdog.stall_timeout}
+              onChange={(value: number) =>
+                onChange("queue.watchdog.stall_timeout", value)
+              }
+              min={1}
+              max={120}
+              step={1}
+              valueSuffix=" sec"
+              helperText="How long packets may wait for a verdict (default 10s)"
+            />
+            <B4Slider
+              label="Restarts Before Failing Open"
+              value={watchdog.max_restarts}
+              onChange={(value: number) =>
+                onChange("queue.watchdog.max_restarts", value)
+              }
+              min={1}
+              max={10}
+              step={1}
+              helperText="Failed restarts in a row before the queue rules are removed (default 3)"
+            />
+          </>
+        )}
+        <B4Alert>
+          While restarts keep failing, B4 removes its firewall rules so traffic
+          passes untouched instead of stalling, and puts them back once the
+          workers recover.
+        </B4Alert>
       </B4FormGroup>
       <B4FormGroup label="Global MSS Clamping" columns={2}>
         <B4Switch
@@ -117,6 +229,13 @@ export const NetworkSettings = ({ config, onChange }: NetworkSettingsProps) => {
           placeholder="/path/to/server.key"
           helperText="Path to TLS private key file (empty = HTTP mode)"
         />
+        <B4TextField
+          label="Control Socket"
+          value={config.system.control?.socket || ""}
+          onChange={(e) => onChange("system.control.socket", e.target.value)}
+          placeholder="/var/run/b4.sock"
+          helperText="Unix socket for b4 health and CLI commands (empty = disabled, requires restart)"
+        />
       </B4FormGroup>
       <B4FormGroup label="SOCKS5 Server" columns={2}>
         <B4Switch
@@ -180,6 +180,8 @@ export function SettingsPage() {
           JSON.stringify(originalConfig.system.socks5) ||
         JSON.stringify(config.system.tables) !==
           JSON.stringify(originalConfig.system.tables) ||
+        JSON.stringify(config.system.accounting) !==
+          JSON.stringify(originalConfig.system.accounting) ||
         JSON.stringify(config.queue.devices) !==
           JSON.stringify(originalConfig.queue.devices),
 
@@ -434,6 +436,7 @@ export function SettingsPage() {
             loadConfig={() => {
               loadConfig().catch(() => {});
             }}
+            onChange={handleChange}
           />
         </TabPanel>
 
@@ -12,6 +12,7 @@ export enum FakingPayloadType {
   DEFAULT = 2,
   DEFAULT2 = 3,
   CAPTURE = 4,
+  MIRROR = 5,
 }
 
 export type MutationMode =
@@ -43,6 +44,15 @@ export interface FakingConfig {
   tls_mod: string[];
   tcp_md5: boolean;
   timestamp_decrease: number;
+  ech?: "keep" | "strip";
+  mirror_snis?: string[];
+  auto_ttl?: AutoTTLConfig;
+}
+export interface AutoTTLConfig {
+  enabled: boolean;
+  delta: number;
+  min: number;
+  max: number;
 }
 export type FragmentationStrategy =
   | "tcp"
@@ -90,7 +100,11 @@ export interface TargetsConfig {
   ip: string[];
   geosite_categories: string[];
   geoip_categories: string[];
+  asns?: string[];
   source_devices?: string[];
+  ech_match?: "outer" | "learned" | "both";
+  fingerprints?: string[];
+  exclude_fingerprints?: string[];
 }
 
 export interface DomainStatisticsConfig {
@@ -111,12 +125,18 @@ export interface CategoryPreviewConfig {
 export type UdpMode = "drop" | "fake";
 export type UdpFilterQuicMode = "disabled" | "all" | "parse";
 export type UdpFakingStrategy = "none" | "ttl" | "checksum";
+export type UdpFakePayload = "zero" | "initial";
+export type UdpCryptoSplit = "off" | "split" | "reorder";
 
 export interface UdpConfig {
   mode: UdpMode;
   fake_seq_length: number;
   fake_len: number;
   faking_strategy: UdpFakingStrategy;
+  fake_payload?: UdpFakePayload;
+  fake_sni?: string;
+  crypto_split?: UdpCryptoSplit;
+  crypto_padding?: boolean;
   dport_filter: string;
   filter_quic: UdpFilterQuicMode;
   conn_bytes_limit: number;
@@ -133,6 +153,23 @@ export interface QueueConfig {
   interfaces: string[];
   devices: DevicesConfig;
   mss_clamp: MSSClampConfig;
+  sticky_sets?: boolean;
+  quic_hold?: number;
+  tcp_hold?: number;
+  injection?: InjectionConfig;
+  watchdog?: WatchdogConfig;
+}
+
+export interface InjectionConfig {
+  workers: number;
+  queue_size: number;
+  overflow: "accept" | "drop";
+}
+
+export interface WatchdogConfig {
+  enabled: boolean;
+  stall_timeout: number;
+  max_restarts: number;
 }
 
 export interface DevicesConfig {
@@ -141,6 +178,7 @@ export interface DevicesConfig {
   vendor_lookup: boolean;
   wisb: boolean;
   mss_clamps: DeviceMSSClamp[];
+  profiles?: DeviceProfile[];
 }
 
 export interface DeviceMSSClamp {
@@ -148,6 +186,16 @@ export interface DeviceMSSClamp {
   size: number;
 }
 
+export interface DeviceProfile {
+  mac: string;
+  alias: string;
+  enabled: boolean;
+  bypass: boolean;
+  sets: string[];
+  mss_clamp: number;
+  dns_target: string;
+}
+
 export interface DiscoveryConfig {
   discovery_timeout: number;
   config_propagate_ms: number;
@@ -203,24 +251,44 @@ export interface WebServerConfig {
   tls_cert: string;
   tls_key: string;
 }
+export interface ControlConfig {
+  socket: string;
+}
 export interface TableConfig {
   monitor_interval: number;
   skip_setup: boolean;
   masquerade: boolean;
   masquerade_interface: string;
+  prefilter?: boolean;
+  prefilter_timeout?: number;
+  backend?: FirewallBackend;
 }
 
+export type FirewallBackend = "auto" | "nftables" | "iptables" | "netlink";
+
 export interface GeoConfig {
   sitedat_url: string;
   ipdat_url: string;
   sitedat_path: string;
   ipdat_path: string;
+  asn_source?: ASNSource;
+  asn_database_path?: string;
+  asn_refresh_hours?: number;
 }
 
+export type ASNSource = "ripestat" | "file";
+
 export interface ApiConfig {
   ipinfo_token: string;
 }
 
+export interface AccountingConfig {
+  enabled: boolean;
+  poll_interval: number;
+  retention_days: number;
+  retention_months: number;
+}
+
 export interface Socks5Config {
   enabled: boolean;
   port: number;
@@ -239,6 +307,8 @@ export interface SystemConfig {
   checker: DiscoveryConfig;
   geo: GeoConfig;
   api: ApiConfig;
+  accounting?: AccountingConfig;
+  control?: ControlConfig;
 }
 
 export interface B4Config {
@@ -259,6 +329,29 @@ export interface B4SetConfig {
   faking: FakingConfig;
   targets: TargetsConfig;
   dns: DNSConfig;
+  schedule?: ScheduleConfig;
+  pipeline?: StrategyStep[];
+}
+
+export type StrategyAction =
+  | "mutate"
+  | "tls_split"
+  | "desync"
+  | "window"
+  | "fake"
+  | "split"
+  | "send"
+  | "fragment"
+  | "delay"
+  | "post_desync";
+
+export interface StrategyStep {
+  action: StrategyAction;
+  strategy?: string;
+  at?: string[];
+  count?: number;
+  reverse?: boolean;
+  delay?: number;
 }
 
 export type ComboShuffleMode = "middle" | "full" | "reverse";
@@ -278,6 +371,20 @@ export interface DisorderFragConfig {
   max_jitter_us: number;
 }
 
+export type ScheduleDay = "mon" | "tue" | "wed" | "thu" | "fri" | "sat" | "sun";
+
+export interface ScheduleTimeRange {
+  start: string;
+  end: string;
+}
+
+export interface ScheduleConfig {
+  enabled: boolean;
+  days: ScheduleDay[];
+  ranges: ScheduleTimeRange[];
+  timezone: string;
+}
+
 export interface DNSConfig {
   enabled: boolean;
   target_dns: string;
@@ -1,5 +1,5 @@
 import { v4 as uuidv4 } from "uuid";
-import { B4SetConfig } from "./config";
+import { B4SetConfig, StrategyStep } from "./config";
 
 export function createDefaultSet(setCount: number): B4SetConfig {
   return {
@@ -34,6 +34,10 @@ export function createDefaultSet(setCount: number): B4SetConfig {
       fake_seq_length: 6,
       fake_len: 64,
       faking_strategy: "none",
+      fake_payload: "zero",
+      fake_sni: "www.google.com",
+      crypto_split: "off",
+      crypto_padding: false,
       dport_filter: "",
       filter_quic: "disabled",
       filter_stun: true,
@@ -87,6 +91,12 @@ export function createDefaultSet(setCount: number): B4SetConfig {
         fake_ext_count: 5,
         fake_snis: ["ya.ru", "vk.com", "max.ru"],
       },
+      auto_ttl: {
+        enabled: false,
+        delta: 2,
+        min: 3,
+        max: 20,
+      },
     } as B4SetConfig["faking"],
     targets: {
       sni_domains: [],
@@ -94,5 +104,18 @@ export function createDefaultSet(setCount: number): B4SetConfig {
       geosite_categories: [],
       geoip_categories: [],
     } as B4SetConfig["targets"],
+    pipeline: createDefaultPipeline(),
   };
 }
+
+// Same order the backend ran strategies in before pipelines existed
+export function createDefaultPipeline(): StrategyStep[] {
+  return [
+    { action: "mutate" },
+    { action: "desync" },
+    { action: "window" },
+    { action: "fake" },
+    { action: "fragment" },
+    { action: "post_desync" },
+  ];
+}
@@ -1,4 +1,5 @@
-import { B4Config, DeviceMSSClamp } from "@b4.settings";
+import { B4Config } from "@b4.settings";
+import { DeviceMSSClamp, DeviceProfile } from "@models/config";
 
 export interface DeviceInfo {
   mac: string;
@@ -19,6 +20,12 @@ export interface DevicesSettingsProps {
   config: B4Config;
   onChange: (
     field: string,
-    value: boolean | string | string[] | number | DeviceMSSClamp[],
+    value:
+      | boolean
+      | string
+      | string[]
+      | number
+      | DeviceMSSClamp[]
+      | DeviceProfile[],
   ) => void;
 }
@@ -0,0 +1,67 @@
+package ws
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/daniellavrushin/b4/events"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/gorilla/websocket"
+)
+
+// HandleEventsWebSocket streams bus events as JSON messages. The initial
+// filter comes from the query string (see events.ParseFilter); every JSON
+// filter object the client sends afterwards replaces it.
+func HandleEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("Failed to upgrade events WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := events.GetBus().Subscribe(events.ParseFilter(r.URL.Query()), 512)
+	defer sub.Close()
+	log.Tracef("Events WebSocket client connected: %s", r.RemoteAddr)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var f events.Filter
+			if err := json.Unmarshal(msg, &f); err != nil {
+				log.Tracef("Ignoring invalid events filter from %s: %v", r.RemoteAddr, err)
+				continue
+			}
+			sub.SetFilter(f)
+		}
+	}()
+
+	pingTicker := time.NewTicker(30 * time.Second)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-pingTicker.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/daniellavrushin/b4/accounting"
 	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/geodat"
 	b4http "github.com/daniellavrushin/b4/http"
 	"github.com/daniellavrushin/b4/http/handler"
 	"github.com/daniellavrushin/b4/log"
@@ -52,6 +54,9 @@ func init() {
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "Show version and exit")
 	rootCmd.Flags().BoolVar(&clearTables, "clear-tables", false, "Perform only iptables/nftables cleanup and exit")
 
+	// main prints the error returned by any command
+	rootCmd.SilenceErrors = true
+
 }
 
 func main() {
@@ -86,7 +91,9 @@ func runB4(cmd *cobra.Command, args []string) error {
 
 	log.Infof("Starting B4 packet processor")
 
-	cfg.LoadWithMigration(cfg.ConfigPath)
+	if err := cfg.LoadWithMigration(cfg.ConfigPath); err != nil {
+		handler.SetConfigLoadError(err)
+	}
 	cfg.SaveToFile(cfg.ConfigPath)
 
 	if cmd.Flags().Changed("verbose") {
@@ -138,6 +145,10 @@ func runB4(cmd *cobra.Command, args []string) error {
 	// Start netfilter queue pool
 	log.Infof("Starting netfilter queue pool (queue: %d, threads: %d)", cfg.Queue.StartNum, cfg.Queue.Threads)
 	pool := nfq.NewPool(&cfg)
+	pool.OnTargetIP(tables.AddTargetIP)
+	if !cfg.System.Tables.SkipSetup {
+		pool.OnFailOpen(tables.SuspendRules, tables.ResumeRules)
+	}
 	if err := pool.Start(); err != nil {
 		metrics.RecordEvent("error", fmt.Sprintf("NFQueue start failed: %v", err))
 		metrics.NFQueueStatus = "error"
@@ -147,13 +158,19 @@ func runB4(cmd *cobra.Command, args []string) error {
 	metrics.RecordEvent("info", fmt.Sprintf("NFQueue started with %d threads", cfg.Queue.Threads))
 	metrics.NFQueueStatus = "active"
 
+	accounting.UpdateConfig(&cfg)
+
 	// Start tables monitor to handle rule restoration if system wipes them
 	var tablesMonitor *tables.Monitor
-	if !cfg.System.Tables.SkipSetup && cfg.System.Tables.MonitorInterval > 0 {
+	if !cfg.System.Tables.SkipSetup {
 		tablesMonitor = tables.NewMonitor(&cfg)
 		tablesMonitor.Start()
+		handler.SetRulesCheckFunc(tablesMonitor.Verify)
 	}
 
+	// Keep ASN targets in sync with their announced prefixes
+	go refreshASNTargets(&cfg)
+
 	// Start internal web server if configured
 	httpServer, err := b4http.StartServer(&cfg, pool)
 	if err != nil {
@@ -161,6 +178,12 @@ func runB4(cmd *cobra.Command, args []string) error {
 		return log.Errorf("failed to start web server: %w", err)
 	}
 
+	// Serve health checks on the control socket, with or without the web server
+	controlServer, err := b4http.StartControlServer(&cfg, pool)
+	if err != nil {
+		log.Warnf("Control socket unavailable: %v", err)
+	}
+
 	// Start SOCKS5 server if configured
 	socks5Server := socks5.NewServer(&cfg)
 	if err := socks5Server.Start(); err != nil {
@@ -168,6 +191,7 @@ func runB4(cmd *cobra.Command, args []string) error {
 		return log.Errorf("failed to start SOCKS5 server: %w", err)
 	}
 	handler.SetSocks5Server(socks5Server)
+	pool.OnScheduleChange(socks5Server.UpdateConfig)
 
 	log.Infof("B4 is running. Press Ctrl+C to stop")
 	metrics.RecordEvent("info", "B4 is fully operational")
@@ -181,17 +205,17 @@ func runB4(cmd *cobra.Command, args []string) error {
 	metrics.RecordEvent("info", fmt.Sprintf("Shutdown initiated by signal: %v", sig))
 
 	// Perform graceful shutdown with timeout
-	return gracefulShutdown(&cfg, pool, httpServer, socks5Server, metrics)
+	return gracefulShutdown(&cfg, pool, httpServer, controlServer, socks5Server, metrics)
 }
 
-func gracefulShutdown(cfg *config.Config, pool *nfq.Pool, httpServer *http.Server, socks5Server *socks5.Server, metrics *handler.MetricsCollector) error {
+func gracefulShutdown(cfg *config.Config, pool *nfq.Pool, httpServer, controlServer *http.Server, socks5Server *socks5.Server, metrics *handler.MetricsCollector) error {
 	// Create shutdown context with timeout
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// Create wait group for parallel shutdown
 	var wg sync.WaitGroup
-	shutdownErrors := make(chan error, 4)
+	shutdownErrors := make(chan error, 5)
 
 	// Shutdown HTTP server
 	if httpServer != nil {
@@ -208,6 +232,18 @@ func gracefulShutdown(cfg *config.Config, pool *nfq.Pool, httpServer *http.Serve
 		}()
 	}
 
+	// Shutdown control socket
+	if controlServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := controlServer.Shutdown(shutdownCtx); err != nil {
+				log.Errorf("Control socket shutdown error: %v", err)
+				shutdownErrors <- fmt.Errorf("control socket shutdown: %w", err)
+			}
+		}()
+	}
+
 	// Shutdown SOCKS5 server
 	if socks5Server != nil {
 		wg.Add(1)
@@ -249,6 +285,7 @@ func gracefulShutdown(cfg *config.Config, pool *nfq.Pool, httpServer *http.Serve
 		}
 
 		quic.Shutdown()
+		accounting.Stop()
 	}()
 
 	// Clean up iptables/nftables rules
@@ -312,6 +349,32 @@ func gracefulShutdown(cfg *config.Config, pool *nfq.Pool, httpServer *http.Serve
 	return nil
 }
 
+// refreshASNTargets re-resolves ASN targets hourly; the resolver only asks its
+// source for ASNs older than the configured refresh interval. When prefixes
+// change, the sets that target ASNs are reloaded and applied like an API
+// save, so firewall rules follow if they carry them.
+func refreshASNTargets(cfg *config.Config) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		snap := handler.ConfigSnapshot(cfg)
+		changed, err := geodat.RefreshASNPrefixes(snap.ASNOptions(), snap.CollectASNs())
+		if err != nil {
+			log.Warnf("ASN refresh failed: %v", err)
+		}
+		if !changed {
+			continue
+		}
+
+		if err := handler.UpdateConfig(cfg, (*config.Config).ReloadASNTargets); err != nil {
+			log.Errorf("Failed to apply refreshed ASN targets: %v", err)
+			continue
+		}
+		log.Infof("ASN prefixes changed, targets reloaded")
+	}
+}
+
 func initTimezone() {
 	// Load timezone from TZ environment variable, default to UTC
 	tzName := os.Getenv("TZ")
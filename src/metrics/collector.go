@@ -3,6 +3,8 @@ package metrics
 import (
 	"fmt"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,6 +20,9 @@ type MetricsCollector struct {
 	TCPConnections      uint64            `json:"tcp_connections"`
 	UDPConnections      uint64            `json:"udp_connections"`
 	TargetedConnections uint64            `json:"targeted_connections"`
+	ECHConnections      uint64            `json:"ech_connections"`
+	ECHOuterSNIs        map[string]uint64 `json:"ech_outer_snis"`
+	TopFingerprints     map[string]uint64 `json:"top_fingerprints"` // JA4, or JA3 when JA4 is unknown
 	CurrentCPS          float64           `json:"current_cps"`
 	CurrentPPS          float64           `json:"current_pps"`
 	CPUUsage            float64           `json:"cpu_usage"`
@@ -33,11 +38,15 @@ type MetricsCollector struct {
 	RecentConnections []ConnectionLog                    `json:"recent_connections"`
 	RecentEvents      []SystemEvent                      `json:"recent_events"`
 	DeviceDomains     map[string]map[string]uint64       `json:"device_domains"`
+	SetTraffic        map[string]uint64                  `json:"set_traffic"`
+	DeviceTraffic     map[string]uint64                  `json:"device_traffic"`
 
 	lastUpdate      time.Time    `json:"-"`
 	mu              sync.RWMutex `json:"-"`
 	lastConnCount   uint64       `json:"-"`
 	lastPacketCount uint64       `json:"-"`
+
+	deviceFingerprints map[string]map[string]*FingerprintStat
 }
 
 type TimeSeriesPoint struct {
@@ -56,9 +65,32 @@ type MemoryStats struct {
 }
 
 type WorkerHealth struct {
-	Processed uint64 `json:"processed"`
-	ID        int    `json:"id"`
-	Status    string `json:"status"`
+	Processed uint64         `json:"processed"`
+	ID        int            `json:"id"`
+	Status    string         `json:"status"`
+	Injection InjectionStats `json:"injection"`
+	Liveness  LivenessStats  `json:"liveness"`
+}
+
+// InjectionStats describes a worker's injection queue. Waits cover the
+// injections started since the previous update.
+type InjectionStats struct {
+	Pending   int    `json:"pending"` // queued, running or paused
+	Paused    int    `json:"paused"`
+	Capacity  int    `json:"capacity"`
+	Overflows uint64 `json:"overflows"`
+	AvgWaitUs int64  `json:"avg_wait_us"`
+	MaxWaitUs int64  `json:"max_wait_us"`
+}
+
+// LivenessStats describes how a worker keeps up with its queue, as watched
+// by the watchdog.
+type LivenessStats struct {
+	Received         uint64 `json:"received"` // packets read from the queue
+	Verdicts         uint64 `json:"verdicts"`
+	LastVerdictAgeMs int64  `json:"last_verdict_age_ms"` // -1 before the first verdict
+	NetlinkErrors    uint64 `json:"netlink_errors"`
+	Restarts         uint64 `json:"restarts"`
 }
 
 type ConnectionLog struct {
@@ -72,6 +104,15 @@ type ConnectionLog struct {
 	HostSet     string    `json:"host_set,omitempty"`
 }
 
+// FingerprintStat counts the ClientHellos a device sent with one fingerprint.
+type FingerprintStat struct {
+	JA3      string    `json:"ja3"`
+	JA4      string    `json:"ja4"`
+	Count    uint64    `json:"count"`
+	Domain   string    `json:"last_domain,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
 type SystemEvent struct {
 	Timestamp time.Time `json:"timestamp"`
 	Level     string    `json:"level"`
@@ -90,15 +131,21 @@ func GetMetricsCollector() *MetricsCollector {
 			TopDomains:        make(map[string]uint64),
 			ProtocolDist:      make(map[string]uint64),
 			GeoDist:           make(map[string]uint64),
+			ECHOuterSNIs:      make(map[string]uint64),
+			TopFingerprints:   make(map[string]uint64),
 			ConnectionRate:    make([]TimeSeriesPoint, 0, 60),
 			PacketRate:        make([]TimeSeriesPoint, 0, 60),
 			RecentConnections: make([]ConnectionLog, 0, 10),
 			RecentEvents:      make([]SystemEvent, 0, 20),
 			WorkerStatus:      make([]WorkerHealth, 0),
 			DeviceDomains:     make(map[string]map[string]uint64),
+			SetTraffic:        make(map[string]uint64),
+			DeviceTraffic:     make(map[string]uint64),
 			NFQueueStatus:     "active",
 			TablesStatus:      "active",
 			lastUpdate:        time.Now(),
+
+			deviceFingerprints: make(map[string]map[string]*FingerprintStat),
 		}
 
 		go metricsCollector.updateLoop()
@@ -257,6 +304,111 @@ func (m *MetricsCollector) RecordConnection(protocol, domain, source, destinatio
 	}
 }
 
+// RecordECH counts a ClientHello carrying encrypted_client_hello together with its outer SNI
+func (m *MetricsCollector) RecordECH(outerSNI string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ECHConnections++
+	if outerSNI == "" {
+		return
+	}
+	m.ECHOuterSNIs[outerSNI]++
+	if len(m.ECHOuterSNIs) > 20 {
+		pruneMinCount(m.ECHOuterSNIs)
+	}
+}
+
+// RecordFingerprint counts a ClientHello's JA3/JA4 fingerprints, overall
+// and for the device that sent it.
+func (m *MetricsCollector) RecordFingerprint(sourceMac, domain, ja3, ja4 string) {
+	if ja3 == "" && ja4 == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	top := ja4
+	if top == "" {
+		top = ja3
+	}
+	m.TopFingerprints[top]++
+	if len(m.TopFingerprints) > 20 {
+		pruneMinCount(m.TopFingerprints)
+	}
+
+	if sourceMac == "" {
+		return
+	}
+	fps := m.deviceFingerprints[sourceMac]
+	if fps == nil {
+		if len(m.deviceFingerprints) >= 50 {
+			var minMac string
+			var minTotal uint64 = ^uint64(0)
+			for mac, stats := range m.deviceFingerprints {
+				var total uint64
+				for _, st := range stats {
+					total += st.Count
+				}
+				if total < minTotal {
+					minTotal = total
+					minMac = mac
+				}
+			}
+			delete(m.deviceFingerprints, minMac)
+		}
+		fps = make(map[string]*FingerprintStat)
+		m.deviceFingerprints[sourceMac] = fps
+	}
+
+	key := ja3 + "|" + ja4
+	st := fps[key]
+	if st == nil {
+		if len(fps) >= 50 {
+			var minKey string
+			var minCount uint64 = ^uint64(0)
+			for k, s := range fps {
+				if s.Count < minCount {
+					minCount = s.Count
+					minKey = k
+				}
+			}
+			delete(fps, minKey)
+		}
+		st = &FingerprintStat{JA3: ja3, JA4: ja4}
+		fps[key] = st
+	}
+	st.Count++
+	st.LastSeen = time.Now()
+	if domain != "" {
+		st.Domain = domain
+	}
+}
+
+// DeviceFingerprints returns the most frequent fingerprints of each device,
+// or of the one device when mac is set, at most limit per device.
+func (m *MetricsCollector) DeviceFingerprints(mac string, limit int) map[string][]FingerprintStat {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string][]FingerprintStat)
+	for dev, fps := range m.deviceFingerprints {
+		if mac != "" && !strings.EqualFold(dev, mac) {
+			continue
+		}
+		stats := make([]FingerprintStat, 0, len(fps))
+		for _, st := range fps {
+			stats = append(stats, *st)
+		}
+		sort.Slice(stats, func(i, j int) bool { return stats[i].Count > stats[j].Count })
+		if limit > 0 && len(stats) > limit {
+			stats = stats[:limit]
+		}
+		out[dev] = stats
+	}
+	return out
+}
+
 func (m *MetricsCollector) RecordPacket(bytes uint64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -281,6 +433,14 @@ func (m *MetricsCollector) RecordEvent(level, message string) {
 	}
 }
 
+// UpdateTraffic replaces the accounted byte totals per set and per device.
+func (m *MetricsCollector) UpdateTraffic(sets, devices map[string]uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.SetTraffic = sets
+	m.DeviceTraffic = devices
+}
+
 func (m *MetricsCollector) UpdateWorkerStatus(workers []WorkerHealth) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -301,7 +461,32 @@ func (m *MetricsCollector) UpdateSingleWorker(workerID int, status string, proce
 		ID:        workerID,
 		Status:    status,
 		Processed: processed,
+		Injection: m.WorkerStatus[workerID].Injection,
+		Liveness:  m.WorkerStatus[workerID].Liveness,
+	}
+}
+
+// UpdateWorkerInjection updates a single worker's injection queue stats.
+func (m *MetricsCollector) UpdateWorkerInjection(workerID int, stats InjectionStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.WorkerStatus) <= workerID {
+		m.WorkerStatus = append(m.WorkerStatus, WorkerHealth{ID: len(m.WorkerStatus)})
 	}
+	m.WorkerStatus[workerID].Injection = stats
+}
+
+// UpdateWorkerLiveness updates a single worker's status and liveness.
+func (m *MetricsCollector) UpdateWorkerLiveness(workerID int, status string, stats LivenessStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.WorkerStatus) <= workerID {
+		m.WorkerStatus = append(m.WorkerStatus, WorkerHealth{ID: len(m.WorkerStatus)})
+	}
+	m.WorkerStatus[workerID].Status = status
+	m.WorkerStatus[workerID].Liveness = stats
 }
 
 func (m *MetricsCollector) ResetStats() {
@@ -315,13 +500,17 @@ func (m *MetricsCollector) ResetStats() {
 	m.TCPConnections = 0
 	m.UDPConnections = 0
 	m.TargetedConnections = 0
+	m.ECHConnections = 0
 	m.CurrentCPS = 0
 	m.CurrentPPS = 0
 
 	m.TopDomains = make(map[string]uint64)
 	m.ProtocolDist = make(map[string]uint64)
 	m.GeoDist = make(map[string]uint64)
+	m.ECHOuterSNIs = make(map[string]uint64)
+	m.TopFingerprints = make(map[string]uint64)
 	m.DeviceDomains = make(map[string]map[string]uint64)
+	m.deviceFingerprints = make(map[string]map[string]*FingerprintStat)
 
 	m.ConnectionRate = make([]TimeSeriesPoint, 0, 60)
 	m.PacketRate = make([]TimeSeriesPoint, 0, 60)
@@ -356,6 +545,7 @@ func (m *MetricsCollector) GetSnapshot() *MetricsCollector {
 		TCPConnections:      m.TCPConnections,
 		UDPConnections:      m.UDPConnections,
 		TargetedConnections: m.TargetedConnections,
+		ECHConnections:      m.ECHConnections,
 		StartTime:           m.StartTime,
 		Uptime:              m.Uptime,
 		CPUUsage:            m.CPUUsage,
@@ -385,6 +575,16 @@ func (m *MetricsCollector) GetSnapshot() *MetricsCollector {
 		snapshot.TopDomains[k] = v
 	}
 
+	snapshot.ECHOuterSNIs = make(map[string]uint64, len(m.ECHOuterSNIs))
+	for k, v := range m.ECHOuterSNIs {
+		snapshot.ECHOuterSNIs[k] = v
+	}
+
+	snapshot.TopFingerprints = make(map[string]uint64, len(m.TopFingerprints))
+	for k, v := range m.TopFingerprints {
+		snapshot.TopFingerprints[k] = v
+	}
+
 	snapshot.ProtocolDist = make(map[string]uint64)
 	for k, v := range m.ProtocolDist {
 		snapshot.ProtocolDist[k] = v
@@ -424,27 +624,41 @@ func (m *MetricsCollector) GetSnapshot() *MetricsCollector {
 		}
 	}
 
+	snapshot.SetTraffic = make(map[string]uint64, len(m.SetTraffic))
+	for k, v := range m.SetTraffic {
+		snapshot.SetTraffic[k] = v
+	}
+
+	snapshot.DeviceTraffic = make(map[string]uint64, len(m.DeviceTraffic))
+	for k, v := range m.DeviceTraffic {
+		snapshot.DeviceTraffic[k] = v
+	}
+
 	snapshot.ConnectionRate = smoothTimeSeriesData(m.ConnectionRate, 3)
 	snapshot.PacketRate = smoothTimeSeriesData(m.PacketRate, 3)
 	return snapshot
 }
 
 func (m *MetricsCollector) pruneTopDomains() {
-	var minCount uint64 = ^uint64(0)
-	var minDomain string
-
 	if len(m.TopDomains) <= 10 {
 		return
 	}
+	pruneMinCount(m.TopDomains)
+}
+
+// pruneMinCount drops the least frequent key from counts
+func pruneMinCount(counts map[string]uint64) {
+	var minCount uint64 = ^uint64(0)
+	var minKey string
 
-	for domain, count := range m.TopDomains {
+	for key, count := range counts {
 		if count < minCount {
 			minCount = count
-			minDomain = domain
+			minKey = key
 		}
 	}
 
-	delete(m.TopDomains, minDomain)
+	delete(counts, minKey)
 }
 
 func smoothTimeSeriesData(data []TimeSeriesPoint, windowSize int) []TimeSeriesPoint {
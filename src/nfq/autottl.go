@@ -0,0 +1,128 @@
+package nfq
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+const (
+	// When full the cache starts over instead of tracking recency.
+	hopCacheSize = 16384
+	hopCacheTTL  = 30 * time.Minute
+)
+
+type hopEntry struct {
+	hops uint8
+	seen int64 // unix seconds
+}
+
+// hopCache remembers how many routers away each server is, learned from the
+// TTL of its SYN-ACKs.
+type hopCache struct {
+	mu   sync.RWMutex
+	hops map[netip.Addr]hopEntry
+}
+
+var hopDistances = newHopCache()
+
+func newHopCache() *hopCache {
+	return &hopCache{hops: make(map[netip.Addr]hopEntry)}
+}
+
+// initialTTL snaps a received TTL up to the initial value the sender most
+// likely used.
+func initialTTL(ttl uint8) uint8 {
+	switch {
+	case ttl <= 64:
+		return 64
+	case ttl <= 128:
+		return 128
+	default:
+		return 255
+	}
+}
+
+func hopKey(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
+
+// observe records the hop distance of server from the TTL (hop limit) its
+// packet arrived with.
+func (c *hopCache) observe(server net.IP, ttl uint8) {
+	addr, ok := hopKey(server)
+	if !ok || ttl == 0 {
+		return
+	}
+	e := hopEntry{hops: initialTTL(ttl) - ttl, seen: time.Now().Unix()}
+
+	c.mu.Lock()
+	if _, known := c.hops[addr]; !known && len(c.hops) >= hopCacheSize {
+		clear(c.hops)
+	}
+	c.hops[addr] = e
+	c.mu.Unlock()
+}
+
+func (c *hopCache) get(server net.IP) (uint8, bool) {
+	addr, ok := hopKey(server)
+	if !ok {
+		return 0, false
+	}
+	c.mu.RLock()
+	e, ok := c.hops[addr]
+	c.mu.RUnlock()
+	return e.hops, ok
+}
+
+// Cleanup forgets servers not heard from for hopCacheTTL, their route may
+// have changed since.
+func (c *hopCache) Cleanup() {
+	cutoff := time.Now().Add(-hopCacheTTL).Unix()
+	c.mu.Lock()
+	for addr, e := range c.hops {
+		if e.seen < cutoff {
+			delete(c.hops, addr)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// autoTTL is the fake TTL for a server hops routers away: delta short of it,
+// within the configured bounds.
+func autoTTL(hops uint8, at *config.AutoTTLConfig) uint8 {
+	ttl := 0
+	if hops > at.Delta {
+		ttl = int(hops - at.Delta)
+	}
+	ttl = max(ttl, int(at.Min))
+	if at.Max > 0 {
+		ttl = min(ttl, int(at.Max))
+	}
+	return uint8(ttl)
+}
+
+// withAutoTTL returns set with every fake TTL derived from the hop distance
+// to dst, or set itself when auto TTL is off or dst was not heard from yet.
+// The copy is shallow and must not be modified.
+func withAutoTTL(set *config.SetConfig, dst net.IP) *config.SetConfig {
+	if set == nil || !set.Faking.AutoTTL.Enabled {
+		return set
+	}
+	hops, ok := hopDistances.get(dst)
+	if !ok {
+		return set
+	}
+	ttl := autoTTL(hops, &set.Faking.AutoTTL)
+
+	s := *set
+	s.Faking.TTL = ttl
+	s.TCP.SynTTL = ttl
+	s.TCP.Desync.TTL = ttl
+	s.TCP.Incoming.FakeTTL = ttl
+	return &s
+}
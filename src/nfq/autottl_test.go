@@ -0,0 +1,57 @@
+package nfq
+
+import (
+	"net"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+)
+
+func TestAutoTTL(t *testing.T) {
+	at := &config.AutoTTLConfig{Enabled: true, Delta: 2, Min: 3, Max: 20}
+	tests := []struct {
+		received uint8
+		want     uint8
+	}{
+		{54, 8},   // Linux server 10 hops away
+		{116, 10}, // Windows server 12 hops away
+		{243, 10}, // initial 255
+		{63, 3},   // one hop, clamped to min
+		{30, 20},  // 34 hops, clamped to max
+	}
+	for _, tt := range tests {
+		hops := initialTTL(tt.received) - tt.received
+		if got := autoTTL(hops, at); got != tt.want {
+			t.Errorf("received TTL %d: auto TTL = %d, want %d", tt.received, got, tt.want)
+		}
+	}
+}
+
+func TestWithAutoTTL(t *testing.T) {
+	saved := hopDistances
+	hopDistances = newHopCache()
+	defer func() { hopDistances = saved }()
+
+	set := config.NewSetConfig()
+	set.Faking.TTL = 7
+	set.Faking.AutoTTL = config.AutoTTLConfig{Enabled: true, Delta: 1, Min: 1, Max: 30}
+
+	known := net.ParseIP("142.250.74.14")
+	hopDistances.observe(known, 50)
+
+	if got := withAutoTTL(&set, net.ParseIP("1.1.1.1")); got != &set {
+		t.Error("unknown destination should keep the set")
+	}
+	got := withAutoTTL(&set, known.To4())
+	if got.Faking.TTL != 13 || got.TCP.Incoming.FakeTTL != 13 || got.TCP.Desync.TTL != 13 {
+		t.Errorf("auto TTL = %d/%d/%d, want 13", got.Faking.TTL, got.TCP.Incoming.FakeTTL, got.TCP.Desync.TTL)
+	}
+	if set.Faking.TTL != 7 {
+		t.Error("withAutoTTL modified the configured set")
+	}
+
+	set.Faking.AutoTTL.Enabled = false
+	if withAutoTTL(&set, known) != &set {
+		t.Error("disabled auto TTL should keep the set")
+	}
+}
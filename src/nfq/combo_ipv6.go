@@ -11,18 +11,24 @@ import (
 	"github.com/daniellavrushin/b4/utils"
 )
 
-func (w *Worker) sendComboFragmentsV6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendComboFragmentsV6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	pi, ok := ExtractPacketInfoV6(packet)
 	if !ok || pi.PayloadLen < 20 {
 		_ = w.sock.SendIPv6(packet, dst)
 		return
 	}
 
-	combo := &cfg.Fragmentation.Combo
-
-	if combo.DecoyEnabled {
-		w.sendDecoyPacketV6(cfg, packet, pi, dst)
+	if cfg.Fragmentation.Combo.DecoyEnabled {
+		w.sendDecoyPacketV6(p, cfg, packet, pi, dst, func() { w.sendComboSegmentsV6(p, cfg, packet, pi, dst) })
+		return
 	}
+	w.sendComboSegmentsV6(p, cfg, packet, pi, dst)
+}
+
+// sendComboSegmentsV6 sends the packet in shuffled segments, holding back
+// all but the first one for the first delay.
+func (w *Worker) sendComboSegmentsV6(p pauser, cfg *config.SetConfig, packet []byte, pi PacketInfo, dst net.IP) {
+	combo := &cfg.Fragmentation.Combo
 
 	splits := GetComboSplitPoints(pi.Payload, pi.PayloadLen, combo, cfg.Fragmentation.MiddleSNI)
 	splits = uniqueSorted(splits, pi.PayloadLen)
@@ -68,36 +74,39 @@ func (w *Worker) sendComboFragmentsV6(cfg *config.SetConfig, packet []byte, dst
 		jitterMaxUs = 2000
 	}
 
-	for i, seg := range segments {
-		if i == 0 && seqovlLen > 0 {
-			payloadLen := len(seg.Data) - pi.PayloadStart
-			if seqovlLen <= payloadLen {
-				seqOffset := seg.Seq - pi.Seq0
-				fakeSeg := BuildFakeOverlapSegmentV6(packet, pi, payloadLen, seqOffset, seqovlPattern, cfg.Faking.TTL, true)
-				if fakeSeg != nil {
-					_ = w.sock.SendIPv6(fakeSeg, dst)
-					time.Sleep(50 * time.Microsecond)
-				}
+	if seqovlLen > 0 {
+		seg := segments[0]
+		payloadLen := len(seg.Data) - pi.PayloadStart
+		if seqovlLen <= payloadLen {
+			seqOffset := seg.Seq - pi.Seq0
+			fakeSeg := BuildFakeOverlapSegmentV6(packet, pi, payloadLen, seqOffset, seqovlPattern, cfg.Faking.TTL, true)
+			if fakeSeg != nil {
+				_ = w.sock.SendIPv6(fakeSeg, dst)
+				time.Sleep(50 * time.Microsecond)
 			}
 		}
+	}
 
-		_ = w.sock.SendIPv6(seg.Data, dst)
-
-		if i == 0 {
-			jitter := r.Intn(firstDelayMs/3 + 1)
-			time.Sleep(time.Duration(firstDelayMs+jitter) * time.Millisecond)
-		} else if i < len(segments)-1 {
-			time.Sleep(time.Duration(r.Intn(jitterMaxUs)) * time.Microsecond)
-		}
+	pkts := make([][]byte, len(segments))
+	gaps := make([]time.Duration, len(segments))
+	for i, seg := range segments {
+		pkts[i] = seg.Data
+	}
+	jitter := r.Intn(firstDelayMs/3 + 1)
+	gaps[0] = time.Duration(firstDelayMs+jitter) * time.Millisecond
+	for i := 1; i < len(segments)-1; i++ {
+		gaps[i] = time.Duration(r.Intn(jitterMaxUs)) * time.Microsecond
 	}
+	w.sendSpaced(p, IPv6, dst, pkts, gaps)
 }
 
-func (w *Worker) sendDecoyPacketV6(cfg *config.SetConfig, packet []byte, pi PacketInfo, dst net.IP) {
+func (w *Worker) sendDecoyPacketV6(p pauser, cfg *config.SetConfig, packet []byte, pi PacketInfo, dst net.IP, then func()) {
 	log.Tracef("sendDecoyPacketV6: Sending decoy fragment packet to %s, set: %s", dst.String(), cfg.Name)
-	fakeBlob := sock.GetPayload(&cfg.Faking)
+	fakeBlob := sock.GetPayloadFor(&cfg.Faking, pi.Payload)
 
 	if len(fakeBlob) < 3 {
 		log.Warnf("Not enough fake payload for fragmentation, need at least 3 bytes")
+		then()
 		return
 	}
 
@@ -137,7 +146,6 @@ func (w *Worker) sendDecoyPacketV6(cfg *config.SetConfig, packet []byte, pi Pack
 	time.Sleep(50 * time.Microsecond)
 	_ = w.sock.SendIPv6(seg2, dst)
 
-	if seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax); seg2d > 0 {
-		time.Sleep(time.Duration(seg2d) * time.Millisecond)
-	}
+	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
+	p.pause(time.Duration(seg2d)*time.Millisecond, then)
 }
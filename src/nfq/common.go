@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"math/rand"
 	"net"
+	"slices"
 	"sort"
 	"time"
 
@@ -79,25 +80,55 @@ func ShuffleSegments(segments []Segment, mode string, r *rand.Rand) {
 	}
 }
 
-func (w *Worker) SendSegmentsV4(segs [][]byte, dst net.IP, cfg *config.SetConfig) {
+// sendBatch sends packets to dst back to back, batched into as few
+// syscalls as the sender manages.
+func (w *Worker) sendBatch(v byte, pkts [][]byte, dst net.IP) {
+	if v == IPv4 {
+		_ = w.sock.SendBatchIPv4(pkts, dst)
+	} else {
+		_ = w.sock.SendBatchIPv6(pkts, dst)
+	}
+}
+
+func (w *Worker) SendSegmentsV4(p pauser, segs [][]byte, dst net.IP, cfg *config.SetConfig) {
 	delay := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
 	if cfg.Fragmentation.ReverseOrder {
-		for i := len(segs) - 1; i >= 0; i-- {
-			_ = w.sock.SendIPv4(segs[i], dst)
-			if i > 0 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
+		segs = slices.Clone(segs)
+		slices.Reverse(segs)
+	}
+	if delay <= 0 {
+		_ = w.sock.SendBatchIPv4(segs, dst)
+		return
+	}
+	w.sendSpaced(p, IPv4, dst, segs, evenGaps(len(segs), time.Duration(delay)*time.Millisecond))
+}
+
+// sendSpaced sends pkts in order, pausing p for gaps[i] after pkts[i]. A
+// missing or zero gap sends the next packet at once.
+func (w *Worker) sendSpaced(p pauser, v byte, dst net.IP, pkts [][]byte, gaps []time.Duration) {
+	for i, pkt := range pkts {
+		if v == IPv4 {
+			_ = w.sock.SendIPv4(pkt, dst)
+		} else {
+			_ = w.sock.SendIPv6(pkt, dst)
 		}
-	} else {
-		for i, seg := range segs {
-			_ = w.sock.SendIPv4(seg, dst)
-			if i < len(segs)-1 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
+		if i < len(gaps) && gaps[i] > 0 {
+			rest, more := pkts[i+1:], gaps[i+1:]
+			p.pause(gaps[i], func() { w.sendSpaced(p, v, dst, rest, more) })
+			return
 		}
 	}
 }
 
+// evenGaps spaces n packets d apart.
+func evenGaps(n int, d time.Duration) []time.Duration {
+	gaps := make([]time.Duration, max(n-1, 0))
+	for i := range gaps {
+		gaps[i] = d
+	}
+	return gaps
+}
+
 func SetPSH(seg []byte, ipHdrLen int) {
 	seg[ipHdrLen+13] |= 0x08
 }
@@ -127,20 +158,16 @@ func GetSNISplitPoints(payload []byte, payloadLen int, middleSNI bool, sniPositi
 	return splits
 }
 
-func (w *Worker) SendTwoSegmentsV4(seg1, seg2 []byte, dst net.IP, delay int, reverse bool) {
+func (w *Worker) SendTwoSegmentsV4(p pauser, seg1, seg2 []byte, dst net.IP, delay int, reverse bool) {
 	if reverse {
-		_ = w.sock.SendIPv4(seg2, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg1, dst)
-	} else {
-		_ = w.sock.SendIPv4(seg1, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg2, dst)
+		seg1, seg2 = seg2, seg1
+	}
+	if delay <= 0 {
+		_ = w.sock.SendBatchIPv4([][]byte{seg1, seg2}, dst)
+		return
 	}
+	_ = w.sock.SendIPv4(seg1, dst)
+	p.pause(time.Duration(delay)*time.Millisecond, func() { _ = w.sock.SendIPv4(seg2, dst) })
 }
 
 func uniqueSorted(splits []int, maxVal int) []int {
@@ -3,6 +3,7 @@ package nfq
 import (
 	"encoding/binary"
 	"net"
+	"slices"
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
@@ -61,23 +62,17 @@ done:
 	}, true
 }
 
-func (w *Worker) SendSegmentsV6(segs [][]byte, dst net.IP, cfg *config.SetConfig) {
+func (w *Worker) SendSegmentsV6(p pauser, segs [][]byte, dst net.IP, cfg *config.SetConfig) {
 	delay := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
 	if cfg.Fragmentation.ReverseOrder {
-		for i := len(segs) - 1; i >= 0; i-- {
-			_ = w.sock.SendIPv6(segs[i], dst)
-			if i > 0 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
-		}
-	} else {
-		for i, seg := range segs {
-			_ = w.sock.SendIPv6(seg, dst)
-			if i < len(segs)-1 && delay > 0 {
-				time.Sleep(time.Duration(delay) * time.Millisecond)
-			}
-		}
+		segs = slices.Clone(segs)
+		slices.Reverse(segs)
 	}
+	if delay <= 0 {
+		_ = w.sock.SendBatchIPv6(segs, dst)
+		return
+	}
+	w.sendSpaced(p, IPv6, dst, segs, evenGaps(len(segs), time.Duration(delay)*time.Millisecond))
 }
 
 func BuildSegmentV6(packet []byte, pi PacketInfo, payloadSlice []byte, seqOffset uint32) []byte {
@@ -93,20 +88,16 @@ func BuildSegmentV6(packet []byte, pi PacketInfo, payloadSlice []byte, seqOffset
 	return seg
 }
 
-func (w *Worker) SendTwoSegmentsV6(seg1, seg2 []byte, dst net.IP, delay int, reverse bool) {
+func (w *Worker) SendTwoSegmentsV6(p pauser, seg1, seg2 []byte, dst net.IP, delay int, reverse bool) {
 	if reverse {
-		_ = w.sock.SendIPv6(seg2, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(seg1, dst)
-	} else {
-		_ = w.sock.SendIPv6(seg1, dst)
-		if delay > 0 {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(seg2, dst)
+		seg1, seg2 = seg2, seg1
+	}
+	if delay <= 0 {
+		_ = w.sock.SendBatchIPv6([][]byte{seg1, seg2}, dst)
+		return
 	}
+	_ = w.sock.SendIPv6(seg1, dst)
+	p.pause(time.Duration(delay)*time.Millisecond, func() { _ = w.sock.SendIPv6(seg2, dst) })
 }
 
 func BuildFakeOverlapSegmentV6(packet []byte, pi PacketInfo, payloadLen int, seqOffset uint32, fakePattern []byte, fakeHopLimit uint8, corruptChecksum bool) []byte {
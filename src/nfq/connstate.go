@@ -1,65 +1,140 @@
 package nfq
 
 import (
-	"fmt"
+	"hash/maphash"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
+)
+
+const (
+	connShards = 64 // power of two
+
+	// A connection is checked every connIdle and expires when it saw no
+	// packet since the previous check, so it lives connIdle to
+	// 2*connIdle+connSlotSpan past its last packet.
+	connIdle     = 60 * time.Second
+	connSlotSpan = 10 * time.Second
+	connIdleTick = int64(connIdle / connSlotSpan)
+	connSlots    = int(connIdleTick) + 1
 )
 
 type connInfo struct {
+	mu        sync.Mutex // guards bytesIn and threshold
 	bytesIn   uint64
 	threshold uint64
-	set       *config.SetConfig
-	lastSeen  time.Time
+	set       atomic.Pointer[config.SetConfig]
+	touched   atomic.Bool // seen since the last expiry check
+	due       int64       // tick of the next expiry check, guarded by the shard lock
 }
 
-type connStateTracker struct {
+type connShard struct {
 	mu    sync.RWMutex
-	conns map[string]*connInfo
+	conns map[conntrack.Tuple]*connInfo
+	wheel [connSlots][]conntrack.Tuple // keys by the slot they expire in
 }
 
-var connState = &connStateTracker{
-	conns: make(map[string]*connInfo),
+// connStateTracker remembers the set of outgoing connections for their
+// incoming direction. Keys are the client-to-server tuple. Lookups take a
+// shard read lock and set the touched bit; per-connection byte counting
+// locks the entry. Nothing on the packet path reads the clock.
+type connStateTracker struct {
+	seed   maphash.Seed
+	shards [connShards]*connShard
+
+	expireMu sync.Mutex
+	tick     int64 // last wheel slot expired, in connSlotSpan units
 }
 
-func (t *connStateTracker) RegisterOutgoing(connKey string, set *config.SetConfig) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	t.conns[connKey] = &connInfo{
-		set:      set,
-		lastSeen: time.Now(),
+var connState = newConnStateTracker(time.Now())
+
+func newConnStateTracker(now time.Time) *connStateTracker {
+	t := &connStateTracker{
+		seed: maphash.MakeSeed(),
+		tick: now.UnixNano() / int64(connSlotSpan),
 	}
+	for i := range t.shards {
+		t.shards[i] = &connShard{conns: make(map[conntrack.Tuple]*connInfo)}
+	}
+	return t
 }
 
-func (t *connStateTracker) GetSetForIncoming(clientIP string, clientPort uint16, serverIP string, serverPort uint16) *config.SetConfig {
-	outKey := fmt.Sprintf("%s:%d->%s:%d", clientIP, clientPort, serverIP, serverPort)
+func (t *connStateTracker) shard(key conntrack.Tuple) *connShard {
+	return t.shards[maphash.Comparable(t.seed, key)&(connShards-1)]
+}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
+func wheelSlot(tick int64) int {
+	return int(tick % int64(connSlots))
+}
 
-	info, exists := t.conns[outKey]
-	if !exists || info.set == nil {
-		return nil
+func (info *connInfo) touch() {
+	// Skip the store when already set to keep the cache line shared
+	if !info.touched.Load() {
+		info.touched.Store(true)
+	}
+}
+
+func (t *connStateTracker) RegisterOutgoing(key conntrack.Tuple, set *config.SetConfig) {
+	sh := t.shard(key)
+
+	sh.mu.RLock()
+	info, ok := sh.conns[key]
+	sh.mu.RUnlock()
+	if ok {
+		info.set.Store(set)
+		info.touch()
+		return
 	}
 
-	info.lastSeen = time.Now()
-	return info.set
+	info = &connInfo{}
+	info.set.Store(set)
+	tick := time.Now().UnixNano() / int64(connSlotSpan)
+
+	sh.mu.Lock()
+	if existing, ok := sh.conns[key]; ok {
+		existing.set.Store(set)
+		existing.touch()
+	} else {
+		info.due = tick + connIdleTick
+		sh.conns[key] = info
+		slot := wheelSlot(info.due)
+		sh.wheel[slot] = append(sh.wheel[slot], key)
+	}
+	sh.mu.Unlock()
 }
 
-func (t *connStateTracker) TrackIncomingBytes(clientIP string, clientPort uint16, serverIP string, serverPort uint16, bytes uint64, inc *config.IncomingConfig) bool {
-	outKey := fmt.Sprintf("%s:%d->%s:%d", clientIP, clientPort, serverIP, serverPort)
+func (t *connStateTracker) lookup(key conntrack.Tuple) *connInfo {
+	sh := t.shard(key)
+	sh.mu.RLock()
+	info := sh.conns[key]
+	sh.mu.RUnlock()
+	if info != nil {
+		info.touch()
+	}
+	return info
+}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
+func (t *connStateTracker) GetSetForIncoming(key conntrack.Tuple) *config.SetConfig {
+	info := t.lookup(key)
+	if info == nil {
+		return nil
+	}
+	return info.set.Load()
+}
 
-	info, exists := t.conns[outKey]
-	if !exists {
+func (t *connStateTracker) TrackIncomingBytes(key conntrack.Tuple, bytes uint64, inc *config.IncomingConfig) bool {
+	info := t.lookup(key)
+	if info == nil {
 		return false
 	}
 
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
 	if info.threshold == 0 {
 		minKB := inc.Min
 		maxKB := inc.Max
@@ -80,7 +155,6 @@ func (t *connStateTracker) TrackIncomingBytes(clientIP string, clientPort uint16
 
 	prevBytes := info.bytesIn
 	info.bytesIn += bytes
-	info.lastSeen = time.Now()
 
 	if prevBytes < info.threshold && info.bytesIn >= info.threshold {
 		info.bytesIn = 0
@@ -91,13 +165,59 @@ func (t *connStateTracker) TrackIncomingBytes(clientIP string, clientPort uint16
 	return false
 }
 
+// Cleanup checks the wheel slots that passed since the previous call:
+// connections touched since their last check move connIdle ahead, the
+// others are dropped. A slot also holds connections registered after the
+// previous call that are due a wheel turn later; they are left for then.
 func (t *connStateTracker) Cleanup() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	now := time.Now()
-	for k, v := range t.conns {
-		if now.Sub(v.lastSeen) > 120*time.Second {
-			delete(t.conns, k)
+	t.expire(time.Now())
+}
+
+func (t *connStateTracker) expire(now time.Time) {
+	t.expireMu.Lock()
+	defer t.expireMu.Unlock()
+
+	cur := now.UnixNano() / int64(connSlotSpan)
+	from := max(t.tick+1, cur-int64(connSlots)+1)
+	for s := from; s <= cur; s++ {
+		for _, sh := range t.shards {
+			sh.expireSlot(s)
 		}
 	}
+	t.tick = cur
+}
+
+func (sh *connShard) expireSlot(tick int64) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	slot := wheelSlot(tick)
+	keys := sh.wheel[slot]
+	sh.wheel[slot] = nil
+	for _, key := range keys {
+		info, ok := sh.conns[key]
+		if !ok {
+			continue
+		}
+		if info.due <= tick {
+			if !info.touched.Swap(false) {
+				delete(sh.conns, key)
+				continue
+			}
+			info.due = tick + connIdleTick
+		}
+		next := wheelSlot(info.due)
+		sh.wheel[next] = append(sh.wheel[next], key)
+	}
+}
+
+// Len returns the number of tracked connections.
+func (t *connStateTracker) Len() int {
+	n := 0
+	for _, sh := range t.shards {
+		sh.mu.RLock()
+		n += len(sh.conns)
+		sh.mu.RUnlock()
+	}
+	return n
 }
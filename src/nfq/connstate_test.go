@@ -0,0 +1,191 @@
+package nfq
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
+)
+
+func testTuple(i int) conntrack.Tuple {
+	return conntrack.Tuple{
+		Proto: 6,
+		Src:   netip.AddrPortFrom(netip.AddrFrom4([4]byte{192, 168, byte(i >> 8), byte(i)}), uint16(40000+i%20000)),
+		Dst:   netip.AddrPortFrom(netip.AddrFrom4([4]byte{142, 250, 74, byte(i)}), 443),
+	}
+}
+
+func TestConnStateTracker(t *testing.T) {
+	tr := newConnStateTracker(time.Now())
+	set := &config.SetConfig{Name: "youtube"}
+	key := testTuple(1)
+
+	if tr.GetSetForIncoming(key) != nil {
+		t.Fatal("unknown connection should have no set")
+	}
+	tr.RegisterOutgoing(key, set)
+	if got := tr.GetSetForIncoming(key); got != set {
+		t.Fatalf("GetSetForIncoming = %v, want %v", got, set)
+	}
+
+	inc := &config.IncomingConfig{Min: 1, Max: 1}
+	if tr.TrackIncomingBytes(key, 1000, inc) {
+		t.Error("threshold crossed too early")
+	}
+	if !tr.TrackIncomingBytes(key, 100, inc) {
+		t.Error("threshold of 1KB should be crossed at 1100 bytes")
+	}
+	if tr.TrackIncomingBytes(testTuple(2), 5000, inc) {
+		t.Error("unregistered connection should not count")
+	}
+}
+
+func TestConnStateExpiry(t *testing.T) {
+	now := time.Now()
+	tr := newConnStateTracker(now)
+	set := &config.SetConfig{Name: "s"}
+	for i := 0; i < 100; i++ {
+		tr.RegisterOutgoing(testTuple(i), set)
+	}
+
+	tr.expire(now.Add(connIdle / 2))
+	if n := tr.Len(); n != 100 {
+		t.Fatalf("expired early: %d left", n)
+	}
+
+	// A packet on one connection gives it another idle period
+	tr.GetSetForIncoming(testTuple(7))
+
+	tr.expire(now.Add(connIdle + 2*connSlotSpan))
+	if n := tr.Len(); n != 1 || tr.GetSetForIncoming(testTuple(7)) == nil {
+		t.Fatalf("after idle period: %d left, want only the touched one", n)
+	}
+
+	// Touched again by the lookup above, so it survives one more check
+	tr.expire(now.Add(2*connIdle + 3*connSlotSpan))
+	if n := tr.Len(); n != 1 {
+		t.Fatalf("touched connection expired: %d left", n)
+	}
+
+	tr.expire(now.Add(3*connIdle + 4*connSlotSpan))
+	if n := tr.Len(); n != 0 {
+		t.Fatalf("idle connection did not expire: %d left", n)
+	}
+}
+
+func TestConnStateRegisterBetweenCleanups(t *testing.T) {
+	// The previous cleanup ran 25s ago, as with the pool's 30s ticker
+	now := time.Now()
+	tr := newConnStateTracker(now.Add(-25 * time.Second))
+	tr.RegisterOutgoing(testTuple(1), &config.SetConfig{Name: "s"})
+
+	for _, at := range []time.Duration{5 * time.Second, 35 * time.Second, connIdle - connSlotSpan} {
+		tr.expire(now.Add(at))
+		if tr.Len() != 1 {
+			t.Fatalf("connection registered between cleanups expired %s later", at)
+		}
+	}
+
+	tr.expire(now.Add(2*connIdle + connSlotSpan))
+	if tr.Len() != 0 {
+		t.Fatal("idle connection did not expire")
+	}
+}
+
+// mutexConnState is the previous design kept as a baseline: string keys
+// formatted per packet in one map behind one mutex.
+type mutexConnState struct {
+	mu    sync.RWMutex
+	conns map[string]*connInfo
+}
+
+func (m *mutexConnState) trackIncoming(clientIP string, clientPort uint16, serverIP string, serverPort uint16) {
+	key := fmt.Sprintf(connKeyFormat, clientIP, clientPort, serverIP, serverPort)
+	m.mu.Lock()
+	if info, ok := m.conns[key]; ok {
+		info.bytesIn += 1400
+	}
+	m.mu.Unlock()
+}
+
+// runThreads spreads b.N operations over threads goroutines, like packets
+// spread over Queue.Threads workers. ns/op falls as threads are added while
+// the tracker does not serialize them.
+func runThreads(b *testing.B, threads int, op func(i int)) {
+	per := b.N/threads + 1
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < threads; g++ {
+		wg.Add(1)
+		go func(base int) {
+			defer wg.Done()
+			for i := 0; i < per; i++ {
+				op(base + i)
+			}
+		}(g * per)
+	}
+	wg.Wait()
+}
+
+const benchConns = 4096
+
+func BenchmarkConnStateIncoming(b *testing.B) {
+	tr := newConnStateTracker(time.Now())
+	set := &config.SetConfig{}
+	keys := make([]conntrack.Tuple, benchConns)
+	for i := range keys {
+		keys[i] = testTuple(i)
+		tr.RegisterOutgoing(keys[i], set)
+	}
+	inc := &config.IncomingConfig{Min: 14, Max: 14}
+
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			runThreads(b, threads, func(i int) {
+				key := keys[i%benchConns]
+				if tr.GetSetForIncoming(key) != nil {
+					tr.TrackIncomingBytes(key, 1400, inc)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkConnStateIncomingMutex(b *testing.B) {
+	m := &mutexConnState{conns: make(map[string]*connInfo)}
+	type addr struct {
+		client, server string
+		cport          uint16
+	}
+	addrs := make([]addr, benchConns)
+	for i := range addrs {
+		k := testTuple(i)
+		addrs[i] = addr{k.Src.Addr().String(), k.Dst.Addr().String(), k.Src.Port()}
+		m.conns[fmt.Sprintf(connKeyFormat, addrs[i].client, addrs[i].cport, addrs[i].server, 443)] = &connInfo{}
+	}
+
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			runThreads(b, threads, func(i int) {
+				a := addrs[i%benchConns]
+				m.trackIncoming(a.client, a.cport, a.server, 443)
+			})
+		})
+	}
+}
+
+func BenchmarkConnStateRegister(b *testing.B) {
+	set := &config.SetConfig{}
+	for _, threads := range []int{1, 4} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			tr := newConnStateTracker(time.Now())
+			runThreads(b, threads, func(i int) {
+				tr.RegisterOutgoing(testTuple(i%65536), set)
+			})
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package nfq
+
+import (
+	"net"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/florianl/go-nfqueue"
+)
+
+// packetCtMark returns the ctmark of a queued packet's connection; tracked is
+// false when the kernel sent no conntrack info for it.
+func packetCtMark(a nfqueue.Attribute) (mark uint32, tracked bool) {
+	if a.Ct == nil {
+		return 0, false
+	}
+	return conntrack.MarkFromAttr(*a.Ct)
+}
+
+// stickySet returns the set an earlier packet of the connection was
+// classified into, read from the set bits of its ctmark. The mark is derived
+// from the set's id, so it survives matcher rebuilds and reordering sets.
+func stickySet(cfg *config.Config, mark uint32, tracked bool) *config.SetConfig {
+	if !cfg.Queue.StickySets || !tracked {
+		return nil
+	}
+	return cfg.SetByCtMark(mark)
+}
+
+// stampSet writes set into the ctmark of the packet's connection, unless it
+// already carries it, so later packets and replies skip classification and
+// the firewall sends them through the set's rule group.
+func (w *Worker) stampSet(cfg *config.Config, set *config.SetConfig, mark uint32, tracked bool, proto uint8, src net.IP, sport uint16, dst net.IP, dport uint16) {
+	if !cfg.Queue.StickySets || !tracked || w.ct == nil {
+		return
+	}
+	want := cfg.CtMarkOf(set)
+	if want == 0 || mark&config.SetCtMarkMask == want {
+		return
+	}
+	tuple, ok := conntrack.TupleFromIP(proto, src, sport, dst, dport)
+	if !ok {
+		return
+	}
+	// Fails while the connection is unconfirmed (first UDP packet dropped and
+	// re-sent by b4); the next queued packet stamps it.
+	if err := w.ct.SetMark(tuple, want, config.SetCtMarkMask); err != nil {
+		log.Tracef("Failed to stamp set '%s' on %v: %v", set.Name, tuple, err)
+	}
+}
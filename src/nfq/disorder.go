@@ -9,7 +9,7 @@ import (
 	"github.com/daniellavrushin/b4/utils"
 )
 
-func (w *Worker) sendDisorderFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendDisorderFragments(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	disorder := &cfg.Fragmentation.Disorder
 	pi, ok := ExtractPacketInfoV4(packet)
 	if !ok || pi.PayloadLen < 10 {
@@ -47,28 +47,32 @@ func (w *Worker) sendDisorderFragments(cfg *config.SetConfig, packet []byte, dst
 	minJitter, maxJitter := GetDisorderJitter(disorder)
 
 	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
-	for i, seg := range segments {
-		if i == 0 && seqovlLen > 0 {
-			payloadLen := len(seg.Data) - pi.PayloadStart
-			if seqovlLen <= payloadLen {
-				seqOffset := seg.Seq - pi.Seq0
-				fakeSeg := BuildFakeOverlapSegmentV4(packet, pi, payloadLen, seqOffset, 0, seqovlPattern, cfg.Faking.TTL, true)
-				if fakeSeg != nil {
-					_ = w.sock.SendIPv4(fakeSeg, dst)
-					time.Sleep(50 * time.Microsecond)
-				}
+	if seqovlLen > 0 {
+		seg := segments[0]
+		payloadLen := len(seg.Data) - pi.PayloadStart
+		if seqovlLen <= payloadLen {
+			seqOffset := seg.Seq - pi.Seq0
+			fakeSeg := BuildFakeOverlapSegmentV4(packet, pi, payloadLen, seqOffset, 0, seqovlPattern, cfg.Faking.TTL, true)
+			if fakeSeg != nil {
+				_ = w.sock.SendIPv4(fakeSeg, dst)
+				time.Sleep(50 * time.Microsecond)
 			}
 		}
+	}
 
-		_ = w.sock.SendIPv4(seg.Data, dst)
-		if i < len(segments)-1 {
-			if seg2d > 0 {
-				jitter := r.Intn(seg2d/2 + 1)
-				time.Sleep(time.Duration(seg2d+jitter) * time.Millisecond)
-			} else {
-				jitter := minJitter + r.Intn(maxJitter-minJitter+1)
-				time.Sleep(time.Duration(jitter) * time.Microsecond)
-			}
+	pkts := make([][]byte, len(segments))
+	gaps := make([]time.Duration, len(segments)-1)
+	for i, seg := range segments {
+		pkts[i] = seg.Data
+	}
+	for i := range gaps {
+		if seg2d > 0 {
+			jitter := r.Intn(seg2d/2 + 1)
+			gaps[i] = time.Duration(seg2d+jitter) * time.Millisecond
+		} else {
+			jitter := minJitter + r.Intn(maxJitter-minJitter+1)
+			gaps[i] = time.Duration(jitter) * time.Microsecond
 		}
 	}
+	w.sendSpaced(p, IPv4, dst, pkts, gaps)
 }
@@ -17,11 +17,23 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 		domain, ok := dns.ParseQueryDomain(payload)
 		if ok {
 			matcher := w.getMatcher()
-			if matchedSet, set := matcher.MatchSNIWithSource(domain, srcMac); matchedSet && set.DNS.Enabled && set.DNS.TargetDNS != "" {
+			matchedSet, set := matcher.MatchSNIWithSource(domain, srcMac)
+			target, setName, fragment := "", "", false
+			if matchedSet && set.DNS.Enabled {
+				target, setName, fragment = set.DNS.TargetDNS, set.Name, set.DNS.FragmentQuery
+			}
+			// A device profile DNS target overrides the set's redirect
+			if t := w.getConfig().DeviceDNSTarget(srcMac); t != "" {
+				target = t
+				if setName == "" {
+					setName = "device " + srcMac
+				}
+			}
+			if target != "" {
 
-				targetIP := net.ParseIP(set.DNS.TargetDNS)
+				targetIP := net.ParseIP(target)
 				if targetIP == nil {
-					if err := w.q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					if err := w.q.Load().SetVerdict(id, nfqueue.NfAccept); err != nil {
 						log.Tracef("failed to set verdict on packet %d: %v", id, err)
 					}
 					return 0
@@ -30,7 +42,7 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 				if ipVersion == IPv4 {
 					targetDNS := targetIP.To4()
 					if targetDNS == nil {
-						if err := w.q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+						if err := w.q.Load().SetVerdict(id, nfqueue.NfAccept); err != nil {
 							log.Tracef("failed to set verdict on packet %d: %v", id, err)
 						}
 						return 0
@@ -44,21 +56,18 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 					copy(raw[16:20], targetDNS)
 					sock.FixIPv4Checksum(raw[:ihl])
 					sock.FixUDPChecksum(raw, ihl)
-					if set.DNS.FragmentQuery {
-						w.sendFragmentedDNSQueryV4(set, raw, ihl, targetDNS)
-					} else {
-						_ = w.sock.SendIPv4(raw, targetDNS)
-					}
-					if err := w.q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					w.sendDNSQuery(IPv4, set, raw, ihl, targetDNS, fragment)
+					if err := w.q.Load().SetVerdict(id, nfqueue.NfDrop); err != nil {
 						log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 					}
-					log.Infof("DNS redirect: %s -> %s (set: %s)", domain, set.DNS.TargetDNS, set.Name)
+					log.Infof("DNS redirect: %s -> %s (set: %s)", domain, target, setName)
+					publishDNSRedirect(raw, ipVersion, sport, domain, setName, target, srcMac)
 					return 0
 
 				} else {
 					cfg := w.getConfig()
 					if !cfg.Queue.IPv6Enabled {
-						if err := w.q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+						if err := w.q.Load().SetVerdict(id, nfqueue.NfAccept); err != nil {
 							log.Tracef("failed to set verdict on packet %d: %v", id, err)
 						}
 						return 0
@@ -66,7 +75,7 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 
 					targetDNS := targetIP.To16()
 					if targetDNS == nil {
-						if err := w.q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+						if err := w.q.Load().SetVerdict(id, nfqueue.NfAccept); err != nil {
 							log.Tracef("failed to set verdict on packet %d: %v", id, err)
 						}
 						return 0
@@ -79,15 +88,12 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 
 					copy(raw[24:40], targetDNS)
 					sock.FixUDPChecksumV6(raw)
-					if set.DNS.FragmentQuery {
-						w.sendFragmentedDNSQueryV6(set, raw, targetDNS)
-					} else {
-						_ = w.sock.SendIPv6(raw, targetDNS)
-					}
-					if err := w.q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					w.sendDNSQuery(IPv6, set, raw, ihl, targetDNS, fragment)
+					if err := w.q.Load().SetVerdict(id, nfqueue.NfDrop); err != nil {
 						log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 					}
-					log.Infof("DNS redirect (IPv6): %s -> %s (set: %s)", domain, set.DNS.TargetDNS, set.Name)
+					log.Infof("DNS redirect (IPv6): %s -> %s (set: %s)", domain, target, setName)
+					publishDNSRedirect(raw, ipVersion, sport, domain, setName, target, srcMac)
 					return 0
 				}
 			}
@@ -95,6 +101,16 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 	}
 
 	if sport == 53 {
+		if w.onTargetIP != nil && w.getConfig().System.Tables.Prefilter {
+			if domain, ips, ok := dns.ParseAnswerIPs(payload); ok {
+				if matched, set := w.getMatcher().MatchSNI(domain); matched {
+					// In the kernel set before the answer reaches the client
+					w.onTargetIP(ips...)
+					log.Tracef("DNS answer: %s -> %d IPs added to prefilter (set: %s)", domain, len(ips), set.Name)
+				}
+			}
+		}
+
 		if ipVersion == IPv4 {
 			if originalDst, ok := dns.DnsNATGet(net.IP(raw[16:20]), dport); ok {
 				copy(raw[12:16], originalDst.To4())
@@ -102,7 +118,7 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 				sock.FixUDPChecksum(raw, ihl)
 				dns.DnsNATDelete(net.IP(raw[16:20]), dport)
 				_ = w.sock.SendIPv4(raw, net.IP(raw[16:20]))
-				if err := w.q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+				if err := w.q.Load().SetVerdict(id, nfqueue.NfDrop); err != nil {
 					log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 				}
 				return 0
@@ -115,7 +131,7 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 					sock.FixUDPChecksumV6(raw)
 					dns.DnsNATDelete(net.IP(raw[24:40]), dport)
 					_ = w.sock.SendIPv6(raw, net.IP(raw[24:40]))
-					if err := w.q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					if err := w.q.Load().SetVerdict(id, nfqueue.NfDrop); err != nil {
 						log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 					}
 					return 0
@@ -124,13 +140,42 @@ func (w *Worker) processDnsPacket(ipVersion byte, sport uint16, dport uint16, pa
 		}
 	}
 
-	if err := w.q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+	if err := w.q.Load().SetVerdict(id, nfqueue.NfAccept); err != nil {
 		log.Tracef("failed to set verdict on packet %d: %v", id, err)
 	}
 	return 0
 }
 
-func (w *Worker) sendFragmentedDNSQueryV4(cfg *config.SetConfig, raw []byte, ihl int, dst net.IP) {
+// sendDNSQuery sends the redirected query to dst. Fragmented queries go
+// through the injection scheduler, which waits out the gap between the
+// fragments; with the queue full the query is sent whole.
+func (w *Worker) sendDNSQuery(v byte, set *config.SetConfig, raw []byte, ihl int, dst net.IP, fragment bool) {
+	if fragment {
+		rawCopy := make([]byte, len(raw))
+		copy(rawCopy, raw)
+		dstCopy := make(net.IP, len(dst))
+		copy(dstCopy, dst)
+		if w.inject.Submit(func(t *injectTask) {
+			s := &sendSeq{}
+			if v == IPv4 {
+				w.sendFragmentedDNSQueryV4(s, set, rawCopy, ihl, dstCopy)
+			} else {
+				w.sendFragmentedDNSQueryV6(s, set, rawCopy, dstCopy)
+			}
+			s.run(t)
+		}) {
+			return
+		}
+		log.Tracef("DNS frag: injection queue full, sending query whole")
+	}
+	if v == IPv4 {
+		_ = w.sock.SendIPv4(raw, dst)
+	} else {
+		_ = w.sock.SendIPv6(raw, dst)
+	}
+}
+
+func (w *Worker) sendFragmentedDNSQueryV4(p pauser, cfg *config.SetConfig, raw []byte, ihl int, dst net.IP) {
 	udpOffset := ihl
 	if len(raw) < ihl+8 {
 		_ = w.sock.SendIPv4(raw, dst)
@@ -163,12 +208,12 @@ func (w *Worker) sendFragmentedDNSQueryV4(cfg *config.SetConfig, raw []byte, ihl
 
 	seg2d := config.ResolveSeg2Delay(cfg.UDP.Seg2Delay, cfg.UDP.Seg2DelayMax)
 
-	w.SendTwoSegmentsV4(frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV4(p, frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
 
 	log.Tracef("DNS frag: sent %d fragments for query", len(frags))
 }
 
-func (w *Worker) sendFragmentedDNSQueryV6(cfg *config.SetConfig, raw []byte, dst net.IP) {
+func (w *Worker) sendFragmentedDNSQueryV6(p pauser, cfg *config.SetConfig, raw []byte, dst net.IP) {
 	ipv6HdrLen := 40
 	if len(raw) < ipv6HdrLen+8 {
 		_ = w.sock.SendIPv6(raw, dst)
@@ -201,7 +246,7 @@ func (w *Worker) sendFragmentedDNSQueryV6(cfg *config.SetConfig, raw []byte, dst
 
 	seg2d := config.ResolveSeg2Delay(cfg.UDP.Seg2Delay, cfg.UDP.Seg2DelayMax)
 
-	w.SendTwoSegmentsV6(frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV6(p, frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
 
 	log.Tracef("DNS frag v6: sent %d fragments", len(frags))
 }
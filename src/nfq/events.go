@@ -0,0 +1,103 @@
+package nfq
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/daniellavrushin/b4/events"
+)
+
+// flowEvents publishes the bus events of one queued packet. It is nil when
+// nobody listens, and all methods are no-ops on nil.
+type flowEvents struct {
+	protocol string
+	domain   string
+	source   string
+	dest     string
+	mac      string
+	ja3      string
+	ja4      string
+}
+
+func newFlowEvents(protocol, src string, sport uint16, dst string, dport uint16, mac string) *flowEvents {
+	if !events.Active() {
+		return nil
+	}
+	return &flowEvents{
+		protocol: protocol,
+		source:   net.JoinHostPort(src, strconv.Itoa(int(sport))),
+		dest:     net.JoinHostPort(dst, strconv.Itoa(int(dport))),
+		mac:      mac,
+	}
+}
+
+func (f *flowEvents) event(t events.Type, set string) events.Event {
+	return events.Event{
+		Type:        t,
+		Protocol:    f.protocol,
+		Set:         set,
+		Domain:      f.domain,
+		Source:      f.source,
+		Destination: f.dest,
+		MAC:         f.mac,
+		JA3:         f.ja3,
+		JA4:         f.ja4,
+	}
+}
+
+// fingerprints tags the flow's events with its ClientHello fingerprints.
+func (f *flowEvents) fingerprints(ja3, ja4 string) {
+	if f == nil {
+		return
+	}
+	f.ja3, f.ja4 = ja3, ja4
+}
+
+func (f *flowEvents) connection(domain, set, sniSet, ipSet string) {
+	if f == nil {
+		return
+	}
+	f.domain = domain
+	e := f.event(events.TypeConnection, set)
+	e.SNISet, e.IPSet = sniSet, ipSet
+	events.Publish(e)
+}
+
+func (f *flowEvents) verdict(set, verdict string) {
+	if f == nil {
+		return
+	}
+	e := f.event(events.TypeVerdict, set)
+	e.Verdict = verdict
+	events.Publish(e)
+}
+
+func (f *flowEvents) strategy(set, strategy string) {
+	if f == nil {
+		return
+	}
+	e := f.event(events.TypeStrategy, set)
+	e.Strategy = strategy
+	events.Publish(e)
+}
+
+func publishDNSRedirect(raw []byte, ipVersion byte, sport uint16, domain, setName, target, mac string) {
+	if !events.Active() {
+		return
+	}
+	var src net.IP
+	if ipVersion == IPv4 {
+		src = net.IP(raw[12:16])
+	} else {
+		src = net.IP(raw[8:24])
+	}
+	events.Publish(events.Event{
+		Type:     events.TypeDNSRedirect,
+		Protocol: "DNS",
+		Set:      setName,
+		Domain:   domain,
+		Source:   net.JoinHostPort(src.String(), strconv.Itoa(int(sport))),
+		MAC:      mac,
+		Target:   target,
+	})
+}
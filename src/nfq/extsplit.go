@@ -75,7 +75,7 @@ func findPreSNIExtensionPoint(payload []byte) int {
 	return -1
 }
 
-func (w *Worker) sendExtSplitFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendExtSplitFragments(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	pi, ok := ExtractPacketInfoV4(packet)
 	if !ok || pi.PayloadLen < 50 {
 		_ = w.sock.SendIPv4(packet, dst)
@@ -85,7 +85,7 @@ func (w *Worker) sendExtSplitFragments(cfg *config.SetConfig, packet []byte, dst
 	splitPos := findPreSNIExtensionPoint(pi.Payload)
 
 	if splitPos <= 5 || splitPos >= pi.PayloadLen-10 {
-		w.sendTCPFragments(cfg, packet, dst)
+		w.sendTCPFragments(p, cfg, packet, dst)
 		return
 	}
 
@@ -99,5 +99,5 @@ func (w *Worker) sendExtSplitFragments(cfg *config.SetConfig, packet []byte, dst
 
 	delay := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
 
-	w.SendTwoSegmentsV4(seg1, seg2, dst, delay, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV4(p, seg1, seg2, dst, delay, cfg.Fragmentation.ReverseOrder)
 }
@@ -8,7 +8,7 @@ import (
 )
 
 // sendExtSplitFragmentsV6 - IPv6 version: splits before SNI extension
-func (w *Worker) sendExtSplitFragmentsV6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendExtSplitFragmentsV6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	pi, ok := ExtractPacketInfoV6(packet)
 	if !ok || pi.PayloadLen < 50 {
 		_ = w.sock.SendIPv6(packet, dst)
@@ -18,7 +18,7 @@ func (w *Worker) sendExtSplitFragmentsV6(cfg *config.SetConfig, packet []byte, d
 	splitPos := findPreSNIExtensionPoint(pi.Payload)
 
 	if splitPos <= 5 || splitPos >= pi.PayloadLen-10 {
-		w.sendTCPSegmentsv6(cfg, packet, dst)
+		w.sendTCPSegmentsv6(p, cfg, packet, dst)
 		return
 	}
 
@@ -32,5 +32,5 @@ func (w *Worker) sendExtSplitFragmentsV6(cfg *config.SetConfig, packet []byte, d
 
 	delay := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
 
-	w.SendTwoSegmentsV6(seg1, seg2, dst, delay, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV6(p, seg1, seg2, dst, delay, cfg.Fragmentation.ReverseOrder)
 }
@@ -0,0 +1,28 @@
+package nfq
+
+import (
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/sni"
+)
+
+// matchFingerprint applies a ClientHello's fingerprints to the set chosen so
+// far: a set limited to other fingerprints or excluding these is
+// dropped, and a hello left unmatched may still hit a set targeting its
+// fingerprint. changed reports whether the match differs from the input.
+func matchFingerprint(matcher *sni.SuffixSet, hello *sni.ClientHelloInfo, matched bool, set *config.SetConfig, srcMac string) (m bool, s *config.SetConfig, changed bool) {
+	if hello.JA3 == "" && hello.JA4 == "" {
+		return matched, set, false
+	}
+	if matched {
+		if set.Targets.FingerprintAllowed(hello.JA3, hello.JA4) {
+			return matched, set, false
+		}
+		log.Tracef("ClientHello %s / %s not allowed by set '%s'", hello.JA4, hello.JA3, set.Name)
+	}
+	if ok, fpSet := matcher.MatchFingerprintWithSource(hello.JA3, hello.JA4, srcMac); ok {
+		log.Tracef("ClientHello %s / %s matched set '%s' by fingerprint", hello.JA4, hello.JA3, fpSet.Name)
+		return true, fpSet, true
+	}
+	return false, set, matched
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/daniellavrushin/b4/sock"
 )
 
-func (w *Worker) sendFirstByteDesyncV6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendFirstByteDesyncV6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	pi, ok := ExtractPacketInfoV6(packet)
 	if !ok || pi.PayloadLen < 2 {
 		_ = w.sock.SendIPv6(packet, dst)
@@ -31,7 +31,5 @@ func (w *Worker) sendFirstByteDesyncV6(cfg *config.SetConfig, packet []byte, dst
 	}
 
 	jitter := int(pi.Seq0 % uint32(delay/3+1))
-	time.Sleep(time.Duration(delay+jitter) * time.Millisecond)
-
-	_ = w.sock.SendIPv6(seg2, dst)
+	p.pause(time.Duration(delay+jitter)*time.Millisecond, func() { _ = w.sock.SendIPv6(seg2, dst) })
 }
@@ -6,7 +6,7 @@ import (
 	"github.com/daniellavrushin/b4/config"
 )
 
-func (w *Worker) sendHybridFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendHybridFragments(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	ipHdrLen := int((packet[0] & 0x0F) * 4)
 	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
 	payloadStart := ipHdrLen + tcpHdrLen
@@ -23,12 +23,12 @@ func (w *Worker) sendHybridFragments(cfg *config.SetConfig, packet []byte, dst n
 	sniStart, sniEnd, hasSNI := locateSNI(payload)
 
 	if extSplit > 5 && hasSNI && sniEnd-sniStart > 6 {
-		w.sendComboFragments(cfg, packet, dst)
+		w.sendComboFragments(p, cfg, packet, dst)
 	} else if hasSNI && sniEnd-sniStart > 6 {
-		w.sendDisorderFragments(cfg, packet, dst)
+		w.sendDisorderFragments(p, cfg, packet, dst)
 	} else if extSplit > 5 {
-		w.sendExtSplitFragments(cfg, packet, dst)
+		w.sendExtSplitFragments(p, cfg, packet, dst)
 	} else {
-		w.sendFirstByteDesync(cfg, packet, dst)
+		w.sendFirstByteDesync(p, cfg, packet, dst)
 	}
 }
@@ -6,7 +6,7 @@ import (
 	"github.com/daniellavrushin/b4/config"
 )
 
-func (w *Worker) sendHybridFragmentsV6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendHybridFragmentsV6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	const ipv6HdrLen = 40
 
 	if len(packet) < ipv6HdrLen+20 {
@@ -29,12 +29,12 @@ func (w *Worker) sendHybridFragmentsV6(cfg *config.SetConfig, packet []byte, dst
 	sniStart, sniEnd, hasSNI := locateSNI(payload)
 
 	if extSplit > 5 && hasSNI && sniEnd-sniStart > 6 {
-		w.sendComboFragmentsV6(cfg, packet, dst)
+		w.sendComboFragmentsV6(p, cfg, packet, dst)
 	} else if hasSNI && sniEnd-sniStart > 6 {
-		w.sendDisorderFragmentsV6(cfg, packet, dst)
+		w.sendDisorderFragmentsV6(p, cfg, packet, dst)
 	} else if extSplit > 5 {
-		w.sendExtSplitFragmentsV6(cfg, packet, dst)
+		w.sendExtSplitFragmentsV6(p, cfg, packet, dst)
 	} else {
-		w.sendFirstByteDesyncV6(cfg, packet, dst)
+		w.sendFirstByteDesyncV6(p, cfg, packet, dst)
 	}
 }
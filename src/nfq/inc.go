@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
 	"github.com/daniellavrushin/b4/log"
 	"github.com/daniellavrushin/b4/sock"
 	"github.com/florianl/go-nfqueue"
@@ -14,8 +15,24 @@ import (
 
 var corruptionStrategies = []string{"badsum", "badseq", "badack", "all"}
 
-func (w *Worker) HandleIncoming(q *nfqueue.Nfqueue, id uint32, v byte, raw []byte, ihl int, src net.IP, dstStr string, dport uint16, srcStr string, sport uint16, payload []byte) int {
-	incomingSet := connState.GetSetForIncoming(dstStr, dport, srcStr, sport)
+// HandleIncoming applies the incoming strategy of the set the reply's
+// connection was classified into: sticky, read from its ctmark, or the one
+// registered for the outgoing direction key (client to server). SYN-ACKs
+// also teach the server's hop distance for auto TTL.
+func (w *Worker) HandleIncoming(q *queueHandle, id uint32, v byte, raw []byte, ihl int, src net.IP, key conntrack.Tuple, payload []byte, sticky *config.SetConfig) int {
+	if raw[ihl+13]&0x12 == 0x12 {
+		if v == IPv4 {
+			hopDistances.observe(src, raw[8])
+		} else {
+			hopDistances.observe(src, raw[7])
+		}
+	}
+
+	incomingSet := sticky
+	if incomingSet == nil {
+		incomingSet = connState.GetSetForIncoming(key)
+	}
+	incomingSet = withAutoTTL(incomingSet, src)
 
 	if incomingSet != nil && incomingSet.TCP.Incoming.Mode != config.ConfigOff {
 		payloadLen := len(payload)
@@ -32,7 +49,7 @@ func (w *Worker) HandleIncoming(q *nfqueue.Nfqueue, id uint32, v byte, raw []byt
 				}
 
 			case "reset":
-				if connState.TrackIncomingBytes(dstStr, dport, srcStr, sport, uint64(payloadLen), inc) {
+				if connState.TrackIncomingBytes(key, uint64(payloadLen), inc) {
 					if v == IPv4 {
 						w.InjectResetIncoming(incomingSet, raw, ihl, src)
 					} else {
@@ -41,7 +58,7 @@ func (w *Worker) HandleIncoming(q *nfqueue.Nfqueue, id uint32, v byte, raw []byt
 				}
 
 			case "fin":
-				if connState.TrackIncomingBytes(dstStr, dport, srcStr, sport, uint64(payloadLen), inc) {
+				if connState.TrackIncomingBytes(key, uint64(payloadLen), inc) {
 					if v == IPv4 {
 						w.InjectFinIncoming(incomingSet, raw, ihl, src)
 					} else {
@@ -50,7 +67,7 @@ func (w *Worker) HandleIncoming(q *nfqueue.Nfqueue, id uint32, v byte, raw []byt
 				}
 
 			case "desync":
-				if connState.TrackIncomingBytes(dstStr, dport, srcStr, sport, uint64(payloadLen), inc) {
+				if connState.TrackIncomingBytes(key, uint64(payloadLen), inc) {
 					if v == IPv4 {
 						w.InjectDesyncIncoming(incomingSet, raw, ihl, src)
 					} else {
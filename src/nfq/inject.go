@@ -0,0 +1,307 @@
+package nfq
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+const (
+	// Paused injections resume on a wheel of injectSlots slots, one per
+	// injectTick; longer pauses wait whole turns of the wheel.
+	injectTick  = time.Millisecond
+	injectSlots = 1024
+)
+
+// injectTask is the injection of one packet. It runs on a pool goroutine
+// and may pause with After, keeping its queue slot but not the goroutine.
+type injectTask struct {
+	s      *injectScheduler
+	run    func(*injectTask)
+	queued time.Time // zero once started
+	due    int64     // wheel tick a paused task resumes at
+}
+
+// injectScheduler runs a worker's injections on a fixed pool of goroutines.
+// At most size injections are queued, running or paused at once; Submit
+// refuses the rest so a burst of connections cannot pile up goroutines.
+type injectScheduler struct {
+	size    int
+	tasks   chan *injectTask
+	pending atomic.Int64 // queued, running or paused
+	paused  atomic.Int64
+
+	mu    sync.Mutex
+	wheel [injectSlots][]*injectTask
+	tick  int64 // last wheel tick fired, in injectTick units
+	wake  chan struct{}
+
+	overflows atomic.Uint64
+	waitSum   atomic.Int64 // queue wait of tasks started since the last stats call
+	waitN     atomic.Int64
+	waitMax   atomic.Int64
+}
+
+func newInjectScheduler(size int) *injectScheduler {
+	return &injectScheduler{
+		size: size,
+		// Never fills: every task in it holds one of the size pending slots
+		tasks: make(chan *injectTask, size),
+		tick:  time.Now().UnixNano() / int64(injectTick),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// start runs workers pool goroutines and the wheel until ctx is done.
+func (s *injectScheduler) start(ctx context.Context, wg *sync.WaitGroup, workers int) {
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-s.tasks:
+					s.runTask(t)
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.runWheel(ctx)
+	}()
+}
+
+// Submit queues fn as a new task, or reports false when the queue is full.
+func (s *injectScheduler) Submit(fn func(*injectTask)) bool {
+	if s.pending.Add(1) > int64(s.size) {
+		s.pending.Add(-1)
+		s.overflows.Add(1)
+		return false
+	}
+	s.tasks <- &injectTask{s: s, run: fn, queued: time.Now()}
+	return true
+}
+
+func (s *injectScheduler) runTask(t *injectTask) {
+	if !t.queued.IsZero() {
+		wait := int64(time.Since(t.queued))
+		t.queued = time.Time{}
+		s.waitSum.Add(wait)
+		s.waitN.Add(1)
+		for cur := s.waitMax.Load(); wait > cur && !s.waitMax.CompareAndSwap(cur, wait); cur = s.waitMax.Load() {
+		}
+	}
+
+	run := t.run
+	t.run = nil
+	run(t)
+	if t.run == nil {
+		s.pending.Add(-1)
+	}
+}
+
+// After pauses the task and resumes it with fn once d has passed. The
+// caller must return right after; fn runs on a pool goroutine.
+func (t *injectTask) After(d time.Duration, fn func(*injectTask)) {
+	t.run = fn
+	t.s.schedule(t, d)
+}
+
+func (s *injectScheduler) schedule(t *injectTask, d time.Duration) {
+	now := time.Now().UnixNano() / int64(injectTick)
+	due := now + int64((d+injectTick-1)/injectTick)
+
+	s.mu.Lock()
+	if due <= s.tick {
+		due = s.tick + 1
+	}
+	t.due = due
+	slot := due % injectSlots
+	s.wheel[slot] = append(s.wheel[slot], t)
+	first := s.paused.Add(1) == 1
+	s.mu.Unlock()
+
+	if first {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runWheel fires paused tasks as they come due, ticking only while any
+// task is paused.
+func (s *injectScheduler) runWheel(ctx context.Context) {
+	ticker := time.NewTicker(injectTick)
+	defer ticker.Stop()
+	for {
+		if s.paused.Load() == 0 {
+			ticker.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			}
+			ticker.Reset(injectTick)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.advance(now)
+		}
+	}
+}
+
+func (s *injectScheduler) advance(now time.Time) {
+	cur := now.UnixNano() / int64(injectTick)
+
+	var due []*injectTask
+	s.mu.Lock()
+	for tick := max(s.tick+1, cur-injectSlots+1); tick <= cur; tick++ {
+		slot := tick % injectSlots
+		keep := s.wheel[slot][:0]
+		for _, t := range s.wheel[slot] {
+			if t.due <= cur {
+				due = append(due, t)
+			} else {
+				keep = append(keep, t)
+			}
+		}
+		clear(s.wheel[slot][len(keep):])
+		s.wheel[slot] = keep
+	}
+	s.tick = max(s.tick, cur)
+	s.paused.Add(-int64(len(due)))
+	s.mu.Unlock()
+
+	for _, t := range due {
+		s.tasks <- t
+	}
+}
+
+// stats reports the queue and the wait of tasks started since the last
+// call.
+func (s *injectScheduler) stats() metrics.InjectionStats {
+	n := s.waitN.Swap(0)
+	sum := s.waitSum.Swap(0)
+	st := metrics.InjectionStats{
+		Pending:   int(s.pending.Load()),
+		Paused:    int(s.paused.Load()),
+		Capacity:  s.size,
+		Overflows: s.overflows.Load(),
+		MaxWaitUs: s.waitMax.Swap(0) / int64(time.Microsecond),
+	}
+	if n > 0 {
+		st.AvgWaitUs = sum / n / int64(time.Microsecond)
+	}
+	return st
+}
+
+// passOnOverflow reports whether a packet the injection queue had no room
+// for passes unmodified, per the overflow policy, or is dropped.
+func (w *Worker) passOnOverflow(cfg *config.Config) bool {
+	pass := cfg.Queue.Injection.Overflow != config.InjectOverflowDrop
+
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&w.lastInjectLog)
+	if now-last >= 5 && atomic.CompareAndSwapInt64(&w.lastInjectLog, last, now) {
+		action := "dropped"
+		if pass {
+			action = "passed unmodified"
+		}
+		log.Warnf("nfq queue %d injection queue full (%d) - packets %s", w.qnum, w.inject.size, action)
+	}
+	return pass
+}
+
+// pauser is a run of sends that can pause without holding a pool
+// goroutine. Senders pause at most once per call; what they send after the
+// pause goes in then.
+type pauser interface {
+	pause(d time.Duration, then func())
+}
+
+// pacing is the pending pause of a run of sends.
+type pacing struct {
+	wait time.Duration // pause before the run goes on
+	then func()        // runs once the pause is over
+}
+
+// pause holds the run for d, then runs then before it goes on. Pauses
+// shorter than a wheel tick, like the microsecond gaps of disorder, are slept
+// in place: parking would stretch them to a whole tick.
+func (p *pacing) pause(d time.Duration, then func()) {
+	if d >= injectTick {
+		p.wait, p.then = d, then
+		return
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	if then != nil {
+		then()
+	}
+}
+
+// hold takes the pending pause. With a task it parks the task on the
+// scheduler's timer wheel, runs then and resume once the pause is over, and
+// reports true: the caller must return. Without a task the pause is slept
+// and then runs in place.
+func (p *pacing) hold(t *injectTask, resume func(*injectTask)) bool {
+	d, then := p.wait, p.then
+	p.wait, p.then = 0, nil
+	if t != nil {
+		t.After(d, func(t *injectTask) {
+			if then != nil {
+				then()
+			}
+			resume(t)
+		})
+		return true
+	}
+	time.Sleep(d)
+	if then != nil {
+		then()
+	}
+	return false
+}
+
+// sendSeq runs queued sends in order, resuming after any of them pauses.
+type sendSeq struct {
+	pacing
+	steps []func()
+}
+
+func (s *sendSeq) add(fn func()) {
+	s.steps = append(s.steps, fn)
+}
+
+// run runs the queued sends on t, or in place with every pause slept when
+// there is no task.
+func (s *sendSeq) run(t *injectTask) {
+	for {
+		if s.wait > 0 {
+			if s.hold(t, s.run) {
+				return
+			}
+			continue
+		}
+		if len(s.steps) == 0 {
+			return
+		}
+		fn := s.steps[0]
+		s.steps = s.steps[1:]
+		fn()
+	}
+}
@@ -0,0 +1,143 @@
+package nfq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInjectSchedulerOverflow(t *testing.T) {
+	s := newInjectScheduler(2)
+	for i := range 2 {
+		if !s.Submit(func(*injectTask) {}) {
+			t.Fatalf("submit %d refused below capacity", i)
+		}
+	}
+	if s.Submit(func(*injectTask) {}) {
+		t.Fatal("submit accepted with the queue full")
+	}
+	if st := s.stats(); st.Pending != 2 || st.Overflows != 1 {
+		t.Errorf("stats = %+v, want 2 pending and 1 overflow", st)
+	}
+}
+
+func TestInjectSchedulerAfter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	s := newInjectScheduler(4)
+	s.start(ctx, &wg, 1)
+
+	done := make(chan time.Duration, 1)
+	start := time.Now()
+	s.Submit(func(t *injectTask) {
+		t.After(20*time.Millisecond, func(t *injectTask) {
+			t.After(10*time.Millisecond, func(*injectTask) {
+				done <- time.Since(start)
+			})
+		})
+	})
+
+	// The paused task must not hold the only pool goroutine
+	ran := make(chan struct{})
+	s.Submit(func(*injectTask) { close(ran) })
+	select {
+	case <-ran:
+		if len(done) > 0 {
+			t.Fatal("second task waited for the paused one")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second task never ran")
+	}
+
+	select {
+	case d := <-done:
+		if d < 30*time.Millisecond {
+			t.Errorf("task resumed after %v, want at least 30ms", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("paused task never resumed")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for s.pending.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if st := s.stats(); st.Pending != 0 || st.Paused != 0 {
+		t.Errorf("stats = %+v, want an empty queue", st)
+	}
+}
+
+func TestPipelinePauseOrder(t *testing.T) {
+	st := &pipelineState{}
+	var got []int
+	st.pause(0, func() { got = append(got, 1) })
+	st.pause(50*time.Microsecond, func() { got = append(got, 2) })
+	st.pause(time.Millisecond, func() { got = append(got, 3) })
+	if len(got) != 2 || st.wait != time.Millisecond || st.then == nil {
+		t.Fatalf("pause state = %v / %v, want the sub-tick pauses run and one pending", got, st.wait)
+	}
+}
+
+func TestInjectSchedulerConcurrentPauses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// More delayed injections than pool goroutines: sleeping would run them
+	// in four rounds, parked they all wait out their pauses together.
+	const workers, tasks = 32, 128
+	const delay = 30 * time.Millisecond
+	s := newInjectScheduler(tasks)
+	s.start(ctx, &wg, workers)
+
+	var mu sync.Mutex
+	order := make(map[int][]int, tasks)
+	done := make(chan struct{}, tasks)
+	start := time.Now()
+	for i := range tasks {
+		ok := s.Submit(func(t *injectTask) {
+			seq := &sendSeq{}
+			for n := range 3 {
+				seq.add(func() {
+					mu.Lock()
+					order[i] = append(order[i], n)
+					mu.Unlock()
+					if n < 2 {
+						seq.pause(delay, nil)
+					} else {
+						done <- struct{}{}
+					}
+				})
+			}
+			seq.run(t)
+		})
+		if !ok {
+			t.Fatalf("submit %d refused below capacity", i)
+		}
+	}
+
+	for range tasks {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("paused injections never finished")
+		}
+	}
+	if d := time.Since(start); d >= 4*delay {
+		t.Errorf("%d injections with two %v pauses took %v, want them to overlap", tasks, delay, d)
+	}
+	for i, got := range order {
+		if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+			t.Errorf("injection %d ran its sends as %v, want [0 1 2]", i, got)
+		}
+	}
+}
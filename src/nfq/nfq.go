@@ -1,18 +1,23 @@
 package nfq
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
 	"os"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/daniellavrushin/b4/accounting"
 	"github.com/daniellavrushin/b4/capture"
 	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
+	"github.com/daniellavrushin/b4/events"
 	"github.com/daniellavrushin/b4/log"
 	"github.com/daniellavrushin/b4/metrics"
 	"github.com/daniellavrushin/b4/quic"
@@ -34,578 +39,837 @@ func (w *Worker) Start() error {
 	}
 	w.sock = s
 
+	if cfg.Queue.StickySets {
+		if ct, err := conntrack.Dial(); err != nil {
+			log.Warnf("Sticky sets unavailable on queue %d: %v", w.qnum, err)
+		} else {
+			w.ct = ct
+		}
+	}
+
+	inj := cfg.Queue.Injection
+	w.inject = newInjectScheduler(inj.QueueSize)
+	w.inject.start(w.ctx, &w.wg, inj.Workers)
+
+	if err := w.openQueue(); err != nil {
+		return err
+	}
+
+	w.wg.Add(1)
+	go w.gc(cfg)
+
+	return nil
+}
+
+// openQueue binds the worker to its queue with a new handle and makes it
+// the current one. The watchdog calls it again to replace a stalled handle.
+// Sticky sets need the conntrack info of queued packets, which kernels
+// without CONFIG_NETFILTER_NETLINK_GLUE_CT refuse to attach; the queue is
+// then bound without it and sets are not sticky.
+func (w *Worker) openQueue() error {
+	if !w.getConfig().Queue.StickySets {
+		return w.bindQueue(0)
+	}
+	err := w.bindQueue(nfqueue.NfQaCfgFlagConntrack)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		log.Warnf("Sticky sets unavailable on queue %d: kernel can't attach conntrack info to queued packets", w.qnum)
+		return w.bindQueue(0)
+	}
+	return err
+}
+
+func (w *Worker) bindQueue(flags uint32) error {
+	mark := w.getConfig().Queue.Mark
 	c := nfqueue.Config{
 		NfQueue:      w.qnum,
 		MaxPacketLen: 0xffff,
 		MaxQueueLen:  4096,
 		Copymode:     nfqueue.NfQnlCopyPacket,
+		Flags:        flags,
 	}
-	q, err := nfqueue.Open(&c)
+	nq, err := nfqueue.Open(&c)
 	if err != nil {
 		return err
 	}
-	w.q = q
+	ctx, cancel := context.WithCancel(w.ctx)
+	q := &queueHandle{Nfqueue: nq, live: &w.live, cancel: cancel}
 
-	w.wg.Add(1)
-	go w.gc(cfg)
+	pid := os.Getpid()
+	log.Tracef("NFQ bound pid=%d queue=%d", pid, w.qnum)
+	err = q.RegisterWithErrorFunc(ctx, q.hook(func(a nfqueue.Attribute) int {
+		cfg := w.getConfig()
+		set := cfg.MainSet
 
-	w.wg.Add(1)
+		matcher := w.getMatcher()
+		id := *a.PacketID
 
-	go func() {
-		pid := os.Getpid()
-		log.Tracef("NFQ bound pid=%d queue=%d", pid, w.qnum)
-		defer w.wg.Done()
-		_ = q.RegisterWithErrorFunc(w.ctx, func(a nfqueue.Attribute) int {
-			cfg := w.getConfig()
-			set := cfg.MainSet
+		if a.Mark != nil && *a.Mark == uint32(mark) {
+			if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+				log.Tracef("failed to set verdict on packet %d: %v", id, err)
+			}
+			return 0
+		}
 
-			matcher := w.getMatcher()
-			id := *a.PacketID
+		if !w.matchesInterface(a) {
+			if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+				log.Tracef("failed to set verdict on packet %d: %v", id, err)
+			}
+			return 0
+		}
 
-			if a.Mark != nil && *a.Mark == uint32(mark) {
-				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-					log.Tracef("failed to set verdict on packet %d: %v", id, err)
+		select {
+		case <-w.ctx.Done():
+			return 0
+		default:
+		}
+
+		atomic.AddUint64(&w.packetsProcessed, 1)
+
+		if a.PacketID == nil || a.Payload == nil || len(*a.Payload) == 0 {
+			if a.PacketID != nil && q != nil {
+				if err := q.SetVerdict(*a.PacketID, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to set verdict on invalid packet %d: %v", *a.PacketID, err)
 				}
-				return 0
 			}
+			return 0
+		}
+		raw := *a.Payload
 
-			if !w.matchesInterface(a) {
+		v := raw[0] >> 4
+		if v != IPv4 && v != IPv6 {
+			if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+				log.Tracef("failed to set verdict on packet %d: %v", id, err)
+			}
+			return 0
+		}
+		var proto uint8
+		var src, dst net.IP
+		var ihl int
+		if v == IPv4 {
+			if len(raw) < 20 {
 				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
 					log.Tracef("failed to set verdict on packet %d: %v", id, err)
 				}
 				return 0
 			}
-
-			select {
-			case <-w.ctx.Done():
+			ihl = int(raw[0]&0x0f) * 4
+			if len(raw) < ihl {
+				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to set verdict on packet %d: %v", id, err)
+				}
 				return 0
-			default:
 			}
 
-			atomic.AddUint64(&w.packetsProcessed, 1)
+			fragOffset := binary.BigEndian.Uint16(raw[6:8]) & 0x1FFF
+			moreFragments := (binary.BigEndian.Uint16(raw[6:8]) & 0x2000) != 0
 
-			if a.PacketID == nil || a.Payload == nil || len(*a.Payload) == 0 {
-				if a.PacketID != nil && q != nil {
-					if err := q.SetVerdict(*a.PacketID, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on invalid packet %d: %v", *a.PacketID, err)
-					}
+			if fragOffset != 0 || moreFragments {
+				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to accept fragmented IPv4 packet %d: %v", id, err)
 				}
 				return 0
 			}
-			raw := *a.Payload
 
-			v := raw[0] >> 4
-			if v != IPv4 && v != IPv6 {
+			proto = raw[9]
+			src = net.IP(raw[12:16])
+			dst = net.IP(raw[16:20])
+
+		} else {
+			if len(raw) < IPv6HeaderLen {
 				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
 					log.Tracef("failed to set verdict on packet %d: %v", id, err)
 				}
 				return 0
 			}
-			var proto uint8
-			var src, dst net.IP
-			var ihl int
-			if v == IPv4 {
-				if len(raw) < 20 {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
+			ihl = IPv6HeaderLen
+			nextHeader := raw[6]
+			offset := 40
+
+			for {
+				switch nextHeader {
+				case 0, 43, 60:
+					if len(raw) < offset+2 {
+						if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+							log.Tracef("failed to set verdict on packet %d: %v", id, err)
+						}
+						return 0
 					}
-					return 0
-				}
-				ihl = int(raw[0]&0x0f) * 4
-				if len(raw) < ihl {
+					nextHeader = raw[offset]
+					hdrLen := int(raw[offset+1])*8 + 8
+					offset += hdrLen
+				case 44:
 					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
+						log.Tracef("failed to accept fragmented IPv6 packet %d: %v", id, err)
 					}
 					return 0
+				default:
+					goto done
 				}
+			}
+		done:
+			proto = nextHeader
+			ihl = offset
+			src = net.IP(raw[8:24])
+			dst = net.IP(raw[24:40])
+		}
 
-				fragOffset := binary.BigEndian.Uint16(raw[6:8]) & 0x1FFF
-				moreFragments := (binary.BigEndian.Uint16(raw[6:8]) & 0x2000) != 0
+		if src.IsLoopback() || dst.IsLoopback() {
+			if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+				log.Tracef("failed to set verdict on packet %d: %v", id, err)
+			}
+			return 0
+		}
+		srcStr := src.String()
+		dstStr := dst.String()
 
-				if fragOffset != 0 || moreFragments {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to accept fragmented IPv4 packet %d: %v", id, err)
-					}
-					return 0
-				}
+		srcMac := w.getMacByIp(srcStr)
 
-				proto = raw[9]
-				src = net.IP(raw[12:16])
-				dst = net.IP(raw[16:20])
+		matched, st := matcher.MatchIPWithSource(dst, srcMac)
+		if matched {
+			set = st
+		}
+		ctMark, tracked := packetCtMark(a)
+		sticky := stickySet(cfg, ctMark, tracked)
+		if sticky != nil {
+			if !matched {
+				st = sticky
+			}
+			matched, set = true, sticky
+		}
 
-			} else {
-				if len(raw) < IPv6HeaderLen {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
-					return 0
-				}
-				ihl = IPv6HeaderLen
-				nextHeader := raw[6]
-				offset := 40
-
-				for {
-					switch nextHeader {
-					case 0, 43, 60:
-						if len(raw) < offset+2 {
-							if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-								log.Tracef("failed to set verdict on packet %d: %v", id, err)
-							}
-							return 0
-						}
-						nextHeader = raw[offset]
-						hdrLen := int(raw[offset+1])*8 + 8
-						offset += hdrLen
-					case 44:
-						if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-							log.Tracef("failed to accept fragmented IPv6 packet %d: %v", id, err)
-						}
-						return 0
-					default:
-						goto done
-					}
+		if proto == 6 && len(raw) >= ihl+TCPHeaderMinLen {
+			tcp := raw[ihl:]
+			if len(tcp) < TCPHeaderMinLen {
+				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to set verdict on packet %d: %v", id, err)
 				}
-			done:
-				proto = nextHeader
-				ihl = offset
-				src = net.IP(raw[8:24])
-				dst = net.IP(raw[24:40])
+				return 0
 			}
-
-			if src.IsLoopback() || dst.IsLoopback() {
+			datOff := int((tcp[12]>>4)&0x0f) * 4
+			if len(tcp) < datOff {
 				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
 					log.Tracef("failed to set verdict on packet %d: %v", id, err)
 				}
 				return 0
 			}
-			srcStr := src.String()
-			dstStr := dst.String()
-
-			srcMac := w.getMacByIp(srcStr)
+			payload := tcp[datOff:]
+			sport := binary.BigEndian.Uint16(tcp[0:2])
+			dport := binary.BigEndian.Uint16(tcp[2:4])
 
-			matched, st := matcher.MatchIPWithSource(dst, srcMac)
-			if matched {
-				set = st
+			if sport == HTTPSPort {
+				key, _ := conntrack.TupleFromIP(6, dst, dport, src, sport)
+				return w.HandleIncoming(q, id, v, raw, ihl, src, key, payload, sticky)
 			}
 
-			if proto == 6 && len(raw) >= ihl+TCPHeaderMinLen {
-				tcp := raw[ihl:]
-				if len(tcp) < TCPHeaderMinLen {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
-					return 0
+			// Packet duplication path: duplicate ALL outgoing TCP/443 packets
+			// without TLS/SNI parsing. Bypasses DPI evasion entirely.
+			if matched && dport == HTTPSPort && set.TCP.Duplicate.Enabled && set.TCP.Duplicate.Count > 0 {
+				log.Tracef("TCP duplicate to %s:%d (%d copies, set: %s)", dstStr, dport, set.TCP.Duplicate.Count, set.Name)
+
+				m := metrics.GetMetricsCollector()
+				m.RecordConnection("TCP-DUP", "", srcStr, dstStr, true, srcMac, set.Name)
+				m.RecordPacket(uint64(len(raw)))
+
+				if !log.IsDiscoveryActive() {
+					log.Infof(",TCP-DUP,,,%s:%d,%s,%s:%d,%s", srcStr, sport, set.Name, dstStr, dport, srcMac)
 				}
-				datOff := int((tcp[12]>>4)&0x0f) * 4
-				if len(tcp) < datOff {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
+				flow := newFlowEvents("TCP-DUP", srcStr, sport, dstStr, dport, srcMac)
+				flow.connection("", set.Name, "", set.Name)
+				accounting.Track(6, src, sport, dst, dport, accounting.Attribution{Set: set.Name, MAC: srcMac})
+				w.stampSet(cfg, set, ctMark, tracked, 6, src, sport, dst, dport)
+				flow.strategy(set.Name, "duplicate")
+				flow.verdict(set.Name, events.VerdictInject)
+
+				if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 					return 0
 				}
-				payload := tcp[datOff:]
-				sport := binary.BigEndian.Uint16(tcp[0:2])
-				dport := binary.BigEndian.Uint16(tcp[2:4])
 
-				if sport == HTTPSPort {
-					return w.HandleIncoming(q, id, v, raw, ihl, src, dstStr, dport, srcStr, sport, payload)
+				copies := make([][]byte, set.TCP.Duplicate.Count)
+				for i := range copies {
+					copies[i] = raw
 				}
+				w.sendBatch(v, copies, dst)
+				return 0
+			}
 
-				// Packet duplication path: duplicate ALL outgoing TCP/443 packets
-				// without TLS/SNI parsing. Bypasses DPI evasion entirely.
-				if matched && dport == HTTPSPort && set.TCP.Duplicate.Enabled && set.TCP.Duplicate.Count > 0 {
-					log.Tracef("TCP duplicate to %s:%d (%d copies, set: %s)", dstStr, dport, set.TCP.Duplicate.Count, set.Name)
+			tcpFlags := tcp[13]
+			isSyn := (tcpFlags & 0x02) != 0
+			isAck := (tcpFlags & 0x10) != 0
+			isRst := (tcpFlags & 0x04) != 0
+			if isRst && dport == HTTPSPort {
+				log.Tracef("RST received from %s:%d", dstStr, dport)
+			}
 
-					m := metrics.GetMetricsCollector()
-					m.RecordConnection("TCP-DUP", "", srcStr, dstStr, true, srcMac, set.Name)
-					m.RecordPacket(uint64(len(raw)))
+			if isSyn && !isAck && dport == HTTPSPort && matched && !set.TCP.Duplicate.Enabled {
+				log.Tracef("TCP SYN to %s:%d (set: %s)", dstStr, dport, set.Name)
 
-					if !log.IsDiscoveryActive() {
-						log.Infof(",TCP-DUP,,,%s:%d,%s,%s:%d,%s", srcStr, sport, set.Name, dstStr, dport, srcMac)
-					}
+				metrics := metrics.GetMetricsCollector()
+				metrics.RecordConnection("TCP-SYN", "", srcStr, dstStr, true, srcMac, set.Name)
 
-					if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
-						log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
-						return 0
-					}
+				synSet := withAutoTTL(set, dst)
+				if v == IPv4 {
+					modsyn := raw
 
-					for i := 0; i < set.TCP.Duplicate.Count; i++ {
-						if v == IPv4 {
-							_ = w.sock.SendIPv4(raw, dst)
-						} else {
-							_ = w.sock.SendIPv6(raw, dst)
-						}
+					if set.TCP.SynFake {
+						w.sendFakeSyn(synSet, raw, ihl, datOff)
 					}
-					return 0
-				}
 
-				tcpFlags := tcp[13]
-				isSyn := (tcpFlags & 0x02) != 0
-				isAck := (tcpFlags & 0x10) != 0
-				isRst := (tcpFlags & 0x04) != 0
-				if isRst && dport == HTTPSPort {
-					log.Tracef("RST received from %s:%d", dstStr, dport)
-				}
-
-				if isSyn && !isAck && dport == HTTPSPort && matched && !set.TCP.Duplicate.Enabled {
-					log.Tracef("TCP SYN to %s:%d (set: %s)", dstStr, dport, set.Name)
-
-					metrics := metrics.GetMetricsCollector()
-					metrics.RecordConnection("TCP-SYN", "", srcStr, dstStr, true, srcMac, set.Name)
-
-					if v == IPv4 {
-						modsyn := raw
+					if set.Fragmentation.Strategy != config.ConfigNone && set.Faking.TCPMD5 {
+						w.sendFakeSynWithMD5(synSet, raw, ihl, dst)
+					}
 
-						if set.TCP.SynFake {
-							w.sendFakeSyn(set, raw, ihl, datOff)
-						}
+					_ = w.sock.SendIPv4(modsyn, dst)
+				} else {
+					if set.TCP.SynFake {
+						w.sendFakeSynV6(synSet, raw, ihl, datOff)
+					}
 
-						if set.Fragmentation.Strategy != config.ConfigNone && set.Faking.TCPMD5 {
-							w.sendFakeSynWithMD5(set, raw, ihl, dst)
-						}
+					if set.Fragmentation.Strategy != config.ConfigNone && set.Faking.TCPMD5 {
+						w.sendFakeSynWithMD5V6(synSet, raw, dst)
+					}
 
-						_ = w.sock.SendIPv4(modsyn, dst)
-					} else {
-						if set.TCP.SynFake {
-							w.sendFakeSynV6(set, raw, ihl, datOff)
-						}
+					_ = w.sock.SendIPv6(raw, dst)
+				}
 
-						if set.Fragmentation.Strategy != config.ConfigNone && set.Faking.TCPMD5 {
-							w.sendFakeSynWithMD5V6(set, raw, dst)
-						}
+				if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
+				}
+				return 0
+			}
 
-						_ = w.sock.SendIPv6(raw, dst)
+			host := ""
+			matchedIP := matched
+			matchedSNI := false
+			ipTarget := ""
+			sniTarget := ""
+
+			var flight tcpFlightResult
+			var hello sni.ClientHelloInfo
+			if dport == HTTPSPort && len(payload) > 0 {
+				if key, ok := conntrack.TupleFromIP(6, src, sport, dst, dport); ok {
+					// Only worth the wait when a set could pick the flow up
+					var hold time.Duration
+					if matchedIP || cfg.MatchesTCPBySNI() {
+						hold = time.Duration(cfg.Queue.TCPHold) * time.Millisecond
 					}
-
+					flight = tcpFlights.Add(w, key, v, raw, dst, hold)
+				}
+				if flight.held {
 					if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
 						log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 					}
 					return 0
 				}
+				if len(flight.stale) > 0 {
+					w.sendHeld(v, flight.stale, dst)
+				}
+				if flight.hello != nil {
+					// Match and apply the strategy on the whole ClientHello
+					raw = flight.hello
+					payload = raw[ihl+int((raw[ihl+12]>>4)&0x0f)*4:]
+				}
 
-				host := ""
-				matchedIP := matched
-				matchedSNI := false
-				ipTarget := ""
-				sniTarget := ""
+				log.Tracef("TCP payload to %s: len=%d, first5=%x", dstStr, len(payload), payload[:min(5, len(payload))])
+				if len(payload) >= 5 && payload[0] == 0x16 {
+					log.Tracef("TLS record: type=%x ver=%x%x len=%d", payload[0], payload[1], payload[2],
+						int(payload[3])<<8|int(payload[4]))
+				}
+				connKey := fmt.Sprintf(connKeyFormat, srcStr, sport, dstStr, dport)
 
-				if dport == HTTPSPort && len(payload) > 0 {
-					log.Tracef("TCP payload to %s: len=%d, first5=%x", dstStr, len(payload), payload[:min(5, len(payload))])
-					if len(payload) >= 5 && payload[0] == 0x16 {
-						log.Tracef("TLS record: type=%x ver=%x%x len=%d", payload[0], payload[1], payload[2],
-							int(payload[3])<<8|int(payload[4]))
-					}
-					connKey := fmt.Sprintf(connKeyFormat, srcStr, sport, dstStr, dport)
+				hello, _ = sni.ParseTLSClientHello(payload)
+				host = hello.Host()
 
-					host, _ = sni.ParseTLSClientHelloSNI(payload)
+				if captureManager := capture.GetManager(cfg); captureManager != nil {
+					captureManager.CapturePayload(connKey, host, "tls", payload)
+				}
 
-					if captureManager := capture.GetManager(cfg); captureManager != nil {
-						captureManager.CapturePayload(connKey, host, "tls", payload)
+				if host != "" && sticky == nil {
+					if mSNI, stSNI := matcher.MatchSNIWithSource(host, srcMac); mSNI && (!hello.HasECH || stSNI.Targets.ECHByOuterSNI()) {
+						matchedSNI = true
+						matched = true
+						set = stSNI
+						w.learnIPToDomain(matcher, dst, host, stSNI)
 					}
+				}
 
-					if host != "" {
-						if mSNI, stSNI := matcher.MatchSNIWithSource(host, srcMac); mSNI {
+				if hello.HasECH {
+					metrics.GetMetricsCollector().RecordECH(hello.OuterSNI)
+					log.Tracef("ECH ClientHello to %s, outer SNI %q", dstStr, hello.OuterSNI)
+
+					if !matched {
+						if mLearned, learnedSet, learnedDomain := matcher.MatchLearnedIPWithSource(dst, srcMac); mLearned && learnedSet.Targets.ECHByLearnedIP() {
 							matchedSNI = true
 							matched = true
-							set = stSNI
-							matcher.LearnIPToDomain(dst, host, stSNI)
+							set = learnedSet
+							log.Tracef("ECH ClientHello to %s matched learned domain %s (set %s)", dstStr, learnedDomain, learnedSet.Name)
 						}
 					}
 				}
 
-				if matchedIP {
-					ipTarget = st.Name
-				}
-				if matchedSNI {
-					sniTarget = set.Name
+				metrics.GetMetricsCollector().RecordFingerprint(srcMac, host, hello.JA3, hello.JA4)
+				if sticky == nil {
+					if m, fpSet, changed := matchFingerprint(matcher, &hello, matched, set, srcMac); changed {
+						matched, set = m, fpSet
+						matchedIP, matchedSNI = false, false
+					}
 				}
+			}
 
-				if !log.IsDiscoveryActive() {
-					log.Infof(",TCP,%s,%s,%s:%d,%s,%s:%d,%s", sniTarget, host, srcStr, sport, ipTarget, dstStr, dport, srcMac)
-				}
+			if matchedIP {
+				ipTarget = st.Name
+			}
+			if matchedSNI {
+				sniTarget = set.Name
+			}
 
-				{
-					m := metrics.GetMetricsCollector()
-					setName := ""
-					if matched {
-						setName = set.Name
-					}
-					m.RecordConnection("TCP", host, srcStr, dstStr, matched, srcMac, setName)
-					m.RecordPacket(uint64(len(raw)))
-				}
+			if !log.IsDiscoveryActive() {
+				log.Infof(",TCP,%s,%s,%s:%d,%s,%s:%d,%s", sniTarget, host, srcStr, sport, ipTarget, dstStr, dport, srcMac)
+			}
 
-				if matched {
-					if set.TCP.Incoming.Mode != config.ConfigOff {
-						connKey := fmt.Sprintf(connKeyFormat, srcStr, sport, dstStr, dport)
-						connState.RegisterOutgoing(connKey, set)
+			setName := ""
+			if matched {
+				setName = set.Name
+			}
+			flow := newFlowEvents("TCP", srcStr, sport, dstStr, dport, srcMac)
+			flow.fingerprints(hello.JA3, hello.JA4)
+			flow.connection(host, setName, sniTarget, ipTarget)
+
+			{
+				m := metrics.GetMetricsCollector()
+				m.RecordConnection("TCP", host, srcStr, dstStr, matched, srcMac, setName)
+				m.RecordPacket(uint64(len(raw)))
+			}
+
+			if matched {
+				accounting.Track(6, src, sport, dst, dport, accounting.Attribution{Set: set.Name, Domain: host, MAC: srcMac})
+				w.stampSet(cfg, set, ctMark, tracked, 6, src, sport, dst, dport)
+
+				if set.TCP.Incoming.Mode != config.ConfigOff {
+					if key, ok := conntrack.TupleFromIP(6, src, sport, dst, dport); ok {
+						connState.RegisterOutgoing(key, set)
 					}
+				}
 
-					packetCopy := make([]byte, len(raw))
-					copy(packetCopy, raw)
+				packetCopy := make([]byte, len(raw))
+				copy(packetCopy, raw)
 
-					if set.TCP.DropSACK {
-						if v == 4 {
-							packetCopy = sock.StripSACKFromTCP(packetCopy)
-						} else {
-							packetCopy = sock.StripSACKFromTCPv6(packetCopy)
-						}
+				if set.TCP.DropSACK {
+					if v == 4 {
+						packetCopy = sock.StripSACKFromTCP(packetCopy)
+					} else {
+						packetCopy = sock.StripSACKFromTCPv6(packetCopy)
 					}
+				}
 
-					dstCopy := make(net.IP, len(dst))
-					copy(dstCopy, dst)
-					setCopy := set
+				dstCopy := make(net.IP, len(dst))
+				copy(dstCopy, dst)
+				setCopy := withAutoTTL(set, dst)
+				mss := flight.mss
 
-					if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
-						log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
+				if !w.inject.Submit(func(t *injectTask) {
+					if v == 4 {
+						w.dropAndInjectTCP(t, setCopy, packetCopy, dstCopy, mss)
+					} else {
+						w.dropAndInjectTCPv6(t, setCopy, packetCopy, dstCopy, mss)
+					}
+				}) {
+					if !w.passOnOverflow(cfg) {
+						if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+							log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
+						}
+						flow.verdict(set.Name, events.VerdictDrop)
 						return 0
 					}
+					if flight.hello != nil {
+						w.sendHeld(v, flight.segs[:len(flight.segs)-1], dst)
+					}
+					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+						log.Tracef("failed to set verdict on packet %d: %v", id, err)
+					}
+					flow.verdict(set.Name, events.VerdictAccept)
+					return 0
+				}
 
-					w.wg.Add(1)
-					go func(s *config.SetConfig, pkt []byte, d net.IP) {
-						defer w.wg.Done()
-						if v == 4 {
-							w.dropAndInjectTCP(s, pkt, d)
-						} else {
-							w.dropAndInjectTCPv6(s, pkt, d)
-						}
-					}(setCopy, packetCopy, dstCopy)
+				if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 					return 0
 				}
+				flow.strategy(set.Name, set.Fragmentation.Strategy)
+				flow.verdict(set.Name, events.VerdictInject)
+				return 0
+			}
+
+			if flight.hello != nil {
+				// Not a target: the held segments go out as they came, then this one
+				w.sendHeld(v, flight.segs[:len(flight.segs)-1], dst)
+			}
+			if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+				log.Tracef("failed to set verdict on packet %d: %v", id, err)
+			}
+			flow.verdict("", events.VerdictAccept)
+			return 0
+		}
 
+		if proto == 17 && len(raw) >= ihl+8 {
+			udp := raw[ihl:]
+			if len(udp) < 8 {
 				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
 					log.Tracef("failed to set verdict on packet %d: %v", id, err)
 				}
 				return 0
 			}
 
-			if proto == 17 && len(raw) >= ihl+8 {
-				udp := raw[ihl:]
-				if len(udp) < 8 {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
-					return 0
-				}
+			payload := udp[8:]
+			sport := binary.BigEndian.Uint16(udp[0:2])
+			dport := binary.BigEndian.Uint16(udp[2:4])
+			connKey := fmt.Sprintf(connKeyFormat, srcStr, sport, dstStr, dport)
 
-				payload := udp[8:]
-				sport := binary.BigEndian.Uint16(udp[0:2])
-				dport := binary.BigEndian.Uint16(udp[2:4])
-				connKey := fmt.Sprintf(connKeyFormat, srcStr, sport, dstStr, dport)
+			if sport == 53 || dport == 53 {
+				return w.processDnsPacket(v, sport, dport, payload, raw, ihl, id, srcMac)
+			}
 
-				if sport == 53 || dport == 53 {
-					return w.processDnsPacket(v, sport, dport, payload, raw, ihl, id, srcMac)
+			if utils.IsPrivateIP(dst) {
+				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to set verdict on packet %d: %v", id, err)
 				}
+				return 0
+			}
 
-				if utils.IsPrivateIP(dst) {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
-					return 0
-				}
+			matchedIP := matched
+			matchedQUIC := false
+			isSTUN := false
+			host := ""
+			ipTarget := ""
+			sniTarget := ""
 
-				matchedIP := matched
-				matchedQUIC := false
-				isSTUN := false
-				host := ""
-				ipTarget := ""
-				sniTarget := ""
+			if matchedIP {
+				ipTarget = st.Name
+			}
 
-				if matchedIP {
-					ipTarget = st.Name
+			if !matchedIP {
+				if mLearned, learnedSet, learnedDomain := matcher.MatchLearnedIPWithSource(dst, srcMac); mLearned {
+					matchedIP = true
+					matched = true
+					set = learnedSet
+					host = learnedDomain
+					sniTarget = learnedSet.Name
+					ipTarget = learnedSet.Name
 				}
+			}
 
-				if !matchedIP {
-					if mLearned, learnedSet, learnedDomain := matcher.MatchLearnedIPWithSource(dst, srcMac); mLearned {
-						matchedIP = true
-						matched = true
-						set = learnedSet
-						host = learnedDomain
-						sniTarget = learnedSet.Name
-						ipTarget = learnedSet.Name
-					}
-				}
+			isSTUN = stun.IsSTUNMessage(payload)
 
-				isSTUN = stun.IsSTUNMessage(payload)
+			// Inspect before the SNI parser: it drops the reassembly buffer once the hello is complete
+			initial, isInitial := quic.InspectInitial(payload)
+			var flight quicFlightState
+			if isInitial {
+				flight = quicFlights.Observe(initial)
+			}
 
-				if host == "" {
-					if h, ok := sni.ParseQUICClientHelloSNI(payload); ok {
-						host = h
+			hasECH := false
+			var hello sni.ClientHelloInfo
+			if host == "" {
+				if h, ok := sni.ParseQUICClientHello(payload); ok {
+					hello = h
+					host = hello.Host()
+					hasECH = hello.HasECH
+					if hasECH {
+						metrics.GetMetricsCollector().RecordECH(hello.OuterSNI)
+						log.Tracef("ECH QUIC ClientHello to %s, outer SNI %q", dstStr, hello.OuterSNI)
 					}
+				} else if isInitial && initial.SNI != "" {
+					// The hello spans more datagrams but the part seen so far already has the SNI
+					host = initial.SNI
 				}
+			}
 
-				if host != "" {
-					if mSNI, sniSet := matcher.MatchSNIWithSource(host, srcMac); mSNI {
-						matchedQUIC = true
-						set = sniSet
-						sniTarget = sniSet.Name
-						matcher.LearnIPToDomain(dst, host, sniSet)
-					}
+			if host != "" && sticky == nil {
+				if mSNI, sniSet := matcher.MatchSNIWithSource(host, srcMac); mSNI && (!hasECH || sniSet.Targets.ECHByOuterSNI()) {
+					matchedQUIC = true
+					set = sniSet
+					sniTarget = sniSet.Name
+					w.learnIPToDomain(matcher, dst, host, sniSet)
 				}
+			}
 
-				if !matchedQUIC && matchedIP && set.UDP.FilterQUIC == "all" {
-					if quic.IsInitial(payload) {
-						matchedQUIC = true
-					}
+			metrics.GetMetricsCollector().RecordFingerprint(srcMac, host, hello.JA3, hello.JA4)
+			if sticky == nil {
+				if m, fpSet, changed := matchFingerprint(matcher, &hello, matchedIP || matchedQUIC, set, srcMac); changed {
+					matchedIP, matchedQUIC, set = false, m, fpSet
+					ipTarget, sniTarget = "", ""
 				}
+			}
 
-				if captureManager := capture.GetManager(cfg); captureManager != nil {
-					captureManager.CapturePayload(connKey, host, "quic", payload)
+			if !matchedQUIC && host == "" && flight.decided && flight.set != nil {
+				matchedQUIC = true
+				set = flight.set
+				host = flight.host
+				sniTarget = flight.set.Name
+			}
+
+			if !matchedQUIC && matchedIP && set.UDP.FilterQUIC == "all" {
+				if quic.IsInitial(payload) {
+					matchedQUIC = true
 				}
+			}
 
-				shouldHandle := (matchedIP || matchedQUIC) && !(isSTUN && set.UDP.FilterSTUN)
+			if captureManager := capture.GetManager(cfg); captureManager != nil {
+				captureManager.CapturePayload(connKey, host, "quic", payload)
+			}
 
-				matched = shouldHandle
+			shouldHandle := (matchedIP || matchedQUIC) && !(isSTUN && set.UDP.FilterSTUN)
 
-				if !log.IsDiscoveryActive() {
-					log.Infof(",UDP,%s,%s,%s:%d,%s,%s:%d,%s", sniTarget, host, srcStr, sport, ipTarget, dstStr, dport, srcMac)
+			// Only flows a set can still pick up are worth delaying
+			holdable := cfg.Queue.QUICHold > 0 && (matchedIP || cfg.MatchesQUICBySNI())
+			if holdable && isInitial && !flight.decided && flight.sniStart < 0 && len(initial.Spans) > 0 && !initial.Complete() {
+				// SNI is not visible yet, keep the datagram until the rest of the flight shows where it is
+				quicFlights.Hold(w, initial, raw, dst, v, time.Duration(cfg.Queue.QUICHold)*time.Millisecond)
+				if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 				}
+				return 0
+			}
 
-				if isSTUN && set.UDP.FilterSTUN {
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
-					return 0
+			var held []heldInitial
+			sniStart, sniLen := -1, 0
+			if isInitial {
+				var decided *config.SetConfig
+				if shouldHandle {
+					decided = set
 				}
+				held, sniStart, sniLen = quicFlights.Decide(initial.DCID, decided, host)
+			}
+			current := heldInitial{raw: raw, dst: dst, v: v, info: initial}
 
-				if !shouldHandle {
-					m := metrics.GetMetricsCollector()
-					m.RecordConnection("UDP", host, srcStr, dstStr, false, srcMac, "")
-					m.RecordPacket(uint64(len(raw)))
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
+			matched = shouldHandle
+
+			if !log.IsDiscoveryActive() {
+				log.Infof(",UDP,%s,%s,%s:%d,%s,%s:%d,%s", sniTarget, host, srcStr, sport, ipTarget, dstStr, dport, srcMac)
+			}
+
+			flow := newFlowEvents("UDP", srcStr, sport, dstStr, dport, srcMac)
+			flow.fingerprints(hello.JA3, hello.JA4)
+			if shouldHandle {
+				flow.connection(host, set.Name, sniTarget, ipTarget)
+			} else {
+				flow.connection(host, "", sniTarget, ipTarget)
+			}
+
+			if isSTUN && set.UDP.FilterSTUN {
+				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to set verdict on packet %d: %v", id, err)
+				}
+				flow.verdict("", events.VerdictAccept)
+				return 0
+			}
+
+			if !shouldHandle {
+				flow.verdict("", events.VerdictAccept)
+				m := metrics.GetMetricsCollector()
+				m.RecordConnection("UDP", host, srcStr, dstStr, false, srcMac, "")
+				m.RecordPacket(uint64(len(raw)))
+				if len(held) > 0 {
+					w.releaseQUICFlight(q, id, nil, held, current, -1, 0)
 					return 0
 				}
+				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to set verdict on packet %d: %v", id, err)
+				}
+				return 0
+			}
 
-				metrics := metrics.GetMetricsCollector()
-				setName := ""
-				if matched {
-					setName = set.Name
+			metrics := metrics.GetMetricsCollector()
+			setName := ""
+			if matched {
+				setName = set.Name
+			}
+			metrics.RecordConnection("UDP", host, srcStr, dstStr, matched, srcMac, setName)
+			metrics.RecordPacket(uint64(len(raw)))
+			accounting.Track(17, src, sport, dst, dport, accounting.Attribution{Set: setName, Domain: host, MAC: srcMac})
+			w.stampSet(cfg, set, ctMark, tracked, 17, src, sport, dst, dport)
+
+			switch set.UDP.Mode {
+			case "drop":
+				if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
 				}
-				metrics.RecordConnection("UDP", host, srcStr, dstStr, matched, srcMac, setName)
-				metrics.RecordPacket(uint64(len(raw)))
+				flow.verdict(set.Name, events.VerdictDrop)
+				return 0
 
-				switch set.UDP.Mode {
-				case "drop":
-					if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
-						log.Tracef("failed to set drop verdict on packet %d: %v", id, err)
-					}
+			case "fake":
+				flow.strategy(set.Name, set.UDP.Mode)
+				flow.verdict(set.Name, events.VerdictInject)
+				if sniStart >= 0 {
+					// The flight knows which datagram carries the SNI, only that one gets the strategy
+					w.releaseQUICFlight(q, id, withAutoTTL(set, dst), held, current, sniStart, sniLen)
 					return 0
+				}
 
-				case "fake":
-					packetCopy := make([]byte, len(raw))
-					copy(packetCopy, raw)
-					dstCopy := make(net.IP, len(dst))
-					copy(dstCopy, dst)
-					setCopy := set
+				packetCopy := make([]byte, len(raw))
+				copy(packetCopy, raw)
+				dstCopy := make(net.IP, len(dst))
+				copy(dstCopy, dst)
+				setCopy := withAutoTTL(set, dst)
 
-					if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
-						log.Tracef("failed to set drop verdict on UDP packet %d: %v", id, err)
-						return 0
+				if !w.inject.Submit(func(t *injectTask) {
+					s := &sendSeq{}
+					w.sendQUICFlight(s, nil, held, -1, 0)
+					if v == IPv4 {
+						w.dropAndInjectQUIC(s, setCopy, packetCopy, dstCopy, -1)
+					} else {
+						w.dropAndInjectQUICV6(s, setCopy, packetCopy, dstCopy, -1)
 					}
-
-					w.wg.Add(1)
-					go func(s *config.SetConfig, pkt []byte, d net.IP) {
-						defer w.wg.Done()
-						if v == IPv4 {
-							w.dropAndInjectQUIC(s, pkt, d)
-						} else {
-							w.dropAndInjectQUICV6(s, pkt, d)
-						}
-					}(setCopy, packetCopy, dstCopy)
+					s.run(t)
+				}) {
+					w.overflowQUICFlight(q, id, setCopy, held)
 					return 0
+				}
 
-				default:
-					if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-						log.Tracef("failed to set verdict on packet %d: %v", id, err)
-					}
+				if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+					log.Tracef("failed to set drop verdict on UDP packet %d: %v", id, err)
 					return 0
 				}
-			}
+				return 0
 
-			if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
-				log.Tracef("failed to set verdict on packet %d: %v", id, err)
-			}
-			return 0
-		}, func(e error) int {
-			if w.ctx.Err() != nil {
-
-				if errors.Is(e, syscall.ENOBUFS) {
-					now := time.Now().Unix()
-					last := atomic.LoadInt64(&w.lastOverflowLog)
-					if now-last >= 5 {
-						if atomic.CompareAndSwapInt64(&w.lastOverflowLog, last, now) {
-							log.Warnf("nfq queue %d overflow - packets dropped", w.qnum)
-						}
-					}
+			default:
+				flow.verdict(set.Name, events.VerdictAccept)
+				if len(held) > 0 {
+					w.releaseQUICFlight(q, id, nil, held, current, -1, 0)
 					return 0
 				}
-
-				return 0
-			}
-			if errors.Is(e, os.ErrClosed) || errors.Is(e, net.ErrClosed) || errors.Is(e, syscall.EBADF) {
-				return 0
-			}
-			if ne, ok := e.(net.Error); ok && ne.Timeout() {
-				return 0
-			}
-			msg := e.Error()
-			if strings.Contains(msg, "use of closed file") || strings.Contains(msg, "file descriptor") {
+				if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+					log.Tracef("failed to set verdict on packet %d: %v", id, err)
+				}
 				return 0
 			}
-			log.Errorf("nfq: %v", e)
-			return 0
-		})
-	}()
+		}
 
+		if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+			log.Tracef("failed to set verdict on packet %d: %v", id, err)
+		}
+		return 0
+	}), func(e error) int {
+		return w.queueError(ctx, q, e)
+
+	})
+	if err != nil {
+		cancel()
+		_ = nq.Close()
+		return err
+	}
+
+	w.q.Store(q)
 	return nil
 }
 
-func (w *Worker) dropAndInjectQUIC(cfg *config.SetConfig, raw []byte, dst net.IP) {
+// queueError handles an error from the receive loop of q, returning
+// non-zero to end the loop.
+func (w *Worker) queueError(ctx context.Context, q *queueHandle, e error) int {
+	// An overflowing queue is busy, not broken: the watchdog must not
+	// restart it over these
+	if errors.Is(e, syscall.ENOBUFS) {
+		now := time.Now().Unix()
+		last := atomic.LoadInt64(&w.lastOverflowLog)
+		if now-last >= 5 {
+			if atomic.CompareAndSwapInt64(&w.lastOverflowLog, last, now) {
+				log.Warnf("nfq queue %d overflow - packets dropped", w.qnum)
+			}
+		}
+		return 0
+	}
+	if ctx.Err() != nil {
+		return 0
+	}
+	w.live.nlErrors.Add(1)
+	if errors.Is(e, os.ErrClosed) || errors.Is(e, net.ErrClosed) || errors.Is(e, syscall.EBADF) {
+		q.exited.Store(true)
+		return 1
+	}
+	if ne, ok := e.(net.Error); ok && ne.Timeout() {
+		return 0
+	}
+	msg := e.Error()
+	if strings.Contains(msg, "use of closed file") || strings.Contains(msg, "file descriptor") {
+		q.exited.Store(true)
+		return 1
+	}
+	log.Errorf("nfq: %v", e)
+	return 0
+}
+
+// dropAndInjectQUIC queues the UDP fakes and the IP-fragmented datagram on
+// s. splitPos is the cut within the UDP payload when the flight tracker
+// already knows where the SNI sits; otherwise (<= 0) it is located in this
+// datagram alone.
+func (w *Worker) dropAndInjectQUIC(s *sendSeq, cfg *config.SetConfig, raw []byte, dst net.IP, splitPos int) {
 	udpCfg := &cfg.UDP
 	seg2d := config.ResolveSeg2Delay(udpCfg.Seg2Delay, udpCfg.Seg2DelayMax)
 	if udpCfg.Mode != "fake" {
 		return
 	}
-	if udpCfg.FakeSeqLength > 0 {
-		for i := 0; i < udpCfg.FakeSeqLength; i++ {
-			fake, ok := sock.BuildFakeUDPFromOriginalV4(raw, udpCfg.FakeLen, cfg.Faking.TTL)
-			if ok {
-				if udpCfg.FakingStrategy == "checksum" {
-					ipHdrLen := int((fake[0] & 0x0F) * 4)
-					if len(fake) >= ipHdrLen+8 {
-						fake[ipHdrLen+6] ^= 0xFF
-						fake[ipHdrLen+7] ^= 0xFF
-					}
-				}
-				_ = w.sock.SendIPv4(fake, dst)
-				if seg2d > 0 {
-					time.Sleep(time.Duration(seg2d) * time.Millisecond)
-				}
+	ipHdrLen := int((raw[0] & 0x0F) * 4)
+	s.add(func() { w.sendUDPFakes(s, cfg, raw, dst, seg2d) })
+
+	if splitPos <= 0 {
+		splitPos = 24
+		if len(raw) >= ipHdrLen+8 {
+			quicPayload := raw[ipHdrLen+8:]
+			sniOff, sniLen := quic.LocateSNIOffset(quicPayload)
+			if sniOff > 0 && sniLen > 0 {
+				splitPos = sniOff + sniLen/2
 			}
 		}
 	}
 
-	splitPos := 24
-	ipHdrLen := int((raw[0] & 0x0F) * 4)
-	if len(raw) >= ipHdrLen+8 {
-		quicPayload := raw[ipHdrLen+8:]
-		sniOff, sniLen := quic.LocateSNIOffset(quicPayload)
-		if sniOff > 0 && sniLen > 0 {
-			splitPos = sniOff + sniLen/2
+	s.add(func() {
+		frags, ok := sock.IPv4FragmentUDP(raw, splitPos)
+		if !ok {
+			_ = w.sock.SendIPv4(raw, dst)
+			return
 		}
-	}
+		w.SendTwoSegmentsV4(s, frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	})
+}
 
-	frags, ok := sock.IPv4FragmentUDP(raw, splitPos)
-	if !ok {
-		_ = w.sock.SendIPv4(raw, dst)
+// sendUDPFakes sends the set's fake datagrams ahead of raw, each followed
+// by a pause of seg2d
+func (w *Worker) sendUDPFakes(p pauser, cfg *config.SetConfig, raw []byte, dst net.IP, seg2d int) {
+	udpCfg := &cfg.UDP
+	if udpCfg.FakeSeqLength <= 0 {
 		return
 	}
-
-	w.SendTwoSegmentsV4(frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	ipHdrLen := int((raw[0] & 0x0F) * 4)
+	var fakePayload []byte
+	if len(raw) >= ipHdrLen+8 {
+		fakePayload = buildQUICFakePayload(udpCfg, raw[ipHdrLen+8:])
+	}
+	var fakes [][]byte
+	for i := 0; i < udpCfg.FakeSeqLength; i++ {
+		var fake []byte
+		var ok bool
+		if fakePayload != nil {
+			fake, ok = sock.BuildFakeUDPWithPayloadV4(raw, fakePayload, cfg.Faking.TTL)
+		} else {
+			fake, ok = sock.BuildFakeUDPFromOriginalV4(raw, udpCfg.FakeLen, cfg.Faking.TTL)
+		}
+		if ok {
+			if udpCfg.FakingStrategy == "checksum" {
+				ipHdrLen := int((fake[0] & 0x0F) * 4)
+				if len(fake) >= ipHdrLen+8 {
+					fake[ipHdrLen+6] ^= 0xFF
+					fake[ipHdrLen+7] ^= 0xFF
+				}
+			}
+			fakes = append(fakes, fake)
+		}
+	}
+	w.sendSpaced(p, IPv4, dst, fakes, evenGaps(len(fakes)+1, time.Duration(seg2d)*time.Millisecond))
 }
 
-func (w *Worker) dropAndInjectTCP(cfg *config.SetConfig, raw []byte, dst net.IP) {
+// dropAndInjectTCP runs the set's pipeline on a ClientHello. mss is the
+// segment size to send a reassembled hello in, 0 if it came in one packet.
+func (w *Worker) dropAndInjectTCP(t *injectTask, cfg *config.SetConfig, raw []byte, dst net.IP, mss int) {
 
 	if len(raw) < 40 {
 		_ = w.sock.SendIPv4(raw, dst)
@@ -622,55 +886,38 @@ func (w *Worker) dropAndInjectTCP(cfg *config.SetConfig, raw []byte, dst net.IP)
 		return
 	}
 
-	if cfg.Faking.SNIMutation.Mode != config.ConfigOff {
-		raw = w.MutateClientHello(cfg, raw, dst)
-	}
-
-	if cfg.TCP.Desync.Mode != config.ConfigOff {
-		w.ExecuteDesyncIPv4(cfg, raw, dst)
-		time.Sleep(time.Duration(config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)) * time.Millisecond)
-	}
-
-	if cfg.TCP.Win.Mode != config.ConfigOff {
-		w.ManipulateWindowIPv4(cfg, raw, dst)
-	}
-
-	if cfg.Faking.SNI && cfg.Faking.SNISeqLength > 0 {
-		w.sendFakeSNISequence(cfg, raw, dst)
-	}
+	w.runPipeline(t, cfg, IPv4, raw, dst, mss)
+}
 
-	switch cfg.Fragmentation.Strategy {
+// fragmentV4 delivers the packet with one of the fragmentation strategies.
+func (w *Worker) fragmentV4(p pauser, strategy string, cfg *config.SetConfig, raw []byte, dst net.IP) {
+	switch strategy {
 	case "tcp":
-		w.sendTCPFragments(cfg, raw, dst)
+		w.sendTCPFragments(p, cfg, raw, dst)
 	case "ip":
-		w.sendIPFragments(cfg, raw, dst)
+		w.sendIPFragments(p, cfg, raw, dst)
 	case "oob":
-		w.sendOOBFragments(cfg, raw, dst)
+		w.sendOOBFragments(p, cfg, raw, dst)
 	case "tls":
-		w.sendTLSFragments(cfg, raw, dst)
+		w.sendTLSFragments(p, cfg, raw, dst)
 	case "disorder":
-		w.sendDisorderFragments(cfg, raw, dst)
+		w.sendDisorderFragments(p, cfg, raw, dst)
 	case "extsplit":
-		w.sendExtSplitFragments(cfg, raw, dst)
+		w.sendExtSplitFragments(p, cfg, raw, dst)
 	case "firstbyte":
-		w.sendFirstByteDesync(cfg, raw, dst)
+		w.sendFirstByteDesync(p, cfg, raw, dst)
 	case "combo":
-		w.sendComboFragments(cfg, raw, dst)
+		w.sendComboFragments(p, cfg, raw, dst)
 	case "hybrid":
-		w.sendHybridFragments(cfg, raw, dst)
+		w.sendHybridFragments(p, cfg, raw, dst)
 	case config.ConfigNone:
 		_ = w.sock.SendIPv4(raw, dst)
 	default:
-		w.sendComboFragments(cfg, raw, dst)
-	}
-
-	if cfg.TCP.Desync.PostDesync {
-		time.Sleep(50 * time.Millisecond)
-		w.sendPostDesyncRST(cfg, raw, ipHdrLen, dst)
+		w.sendComboFragments(p, cfg, raw, dst)
 	}
 }
 
-func (w *Worker) sendTCPFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendTCPFragments(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 
 	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
 	ipHdrLen := int((packet[0] & 0x0F) * 4)
@@ -750,25 +997,9 @@ func (w *Worker) sendTCPFragments(cfg *config.SetConfig, packet []byte, dst net.
 		sock.FixTCPChecksum(seg3)
 
 		if cfg.Fragmentation.ReverseOrder {
-			_ = w.sock.SendIPv4(seg2, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg1, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg3, dst)
+			w.sendSpaced(p, IPv4, dst, [][]byte{seg2, seg1, seg3}, evenGaps(3, time.Duration(seg2d)*time.Millisecond))
 		} else {
-			_ = w.sock.SendIPv4(seg1, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg2, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv4(seg3, dst)
+			w.sendSpaced(p, IPv4, dst, [][]byte{seg1, seg2, seg3}, evenGaps(3, time.Duration(seg2d)*time.Millisecond))
 		}
 		return
 	}
@@ -798,10 +1029,10 @@ func (w *Worker) sendTCPFragments(cfg *config.SetConfig, packet []byte, dst net.
 	sock.FixIPv4Checksum(seg2[:ipHdrLen])
 	sock.FixTCPChecksum(seg2)
 
-	w.SendTwoSegmentsV4(seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV4(p, seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
-func (w *Worker) sendIPFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendIPFragments(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
 	ipHdrLen := int((packet[0] & 0x0F) * 4)
 	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
@@ -870,7 +1101,7 @@ func (w *Worker) sendIPFragments(cfg *config.SetConfig, packet []byte, dst net.I
 	binary.BigEndian.PutUint16(frag2[2:4], uint16(frag2Len))
 	sock.FixIPv4Checksum(frag2[:ipHdrLen])
 
-	w.SendTwoSegmentsV4(frag1, frag2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV4(p, frag1, frag2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
 func (w *Worker) sendFakeSNISequence(cfg *config.SetConfig, original []byte, dst net.IP) {
@@ -883,8 +1114,9 @@ func (w *Worker) sendFakeSNISequence(cfg *config.SetConfig, original []byte, dst
 	ipHdrLen := int((fake[0] & 0x0F) * 4)
 	tcpHdrLen := int((fake[ipHdrLen+12] >> 4) * 4)
 
+	fakes := make([][]byte, 0, fk.SNISeqLength)
 	for i := 0; i < fk.SNISeqLength; i++ {
-		_ = w.sock.SendIPv4(fake, dst)
+		fakes = append(fakes, slices.Clone(fake))
 
 		if i+1 < fk.SNISeqLength {
 			id := binary.BigEndian.Uint16(fake[4:6])
@@ -899,6 +1131,7 @@ func (w *Worker) sendFakeSNISequence(cfg *config.SetConfig, original []byte, dst
 			}
 		}
 	}
+	_ = w.sock.SendBatchIPv4(fakes, dst)
 }
 
 func (w *Worker) getMacByIp(ip string) string {
@@ -913,8 +1146,8 @@ func (w *Worker) Stop() {
 	if w.cancel != nil {
 		w.cancel()
 	}
-	if w.q != nil {
-		_ = w.q.Close()
+	if q := w.q.Load(); q != nil {
+		_ = q.Close()
 	}
 	done := make(chan struct{})
 	go func() { w.wg.Wait(); close(done) }()
@@ -925,29 +1158,38 @@ func (w *Worker) Stop() {
 	if w.sock != nil {
 		w.sock.Close()
 	}
+	if w.ct != nil {
+		_ = w.ct.Close()
+	}
 }
 
 func (w *Worker) gc(cfg *config.Config) {
 	defer w.wg.Done()
 	t := time.NewTicker(30 * time.Second)
 	defer t.Stop()
+	inj := time.NewTicker(5 * time.Second)
+	defer inj.Stop()
+	workerID := int(w.qnum - uint16(cfg.Queue.StartNum))
 	for {
 		select {
 		case <-w.ctx.Done():
 			return
 		case <-t.C:
-			connState.Cleanup()
-
 			if cfg.System.WebServer.IsEnabled {
 				mtcs := metrics.GetMetricsCollector()
-				workerID := int(w.qnum - uint16(cfg.Queue.StartNum))
 				processed := atomic.LoadUint64(&w.packetsProcessed)
-				mtcs.UpdateSingleWorker(workerID, "active", processed)
+				mtcs.UpdateSingleWorker(workerID, w.getStatus(), processed)
+			}
+		case <-inj.C:
+			if cfg.System.WebServer.IsEnabled {
+				mtcs := metrics.GetMetricsCollector()
+				mtcs.UpdateWorkerInjection(workerID, w.inject.stats())
+				mtcs.UpdateWorkerLiveness(workerID, w.getStatus(), w.live.stats())
 			}
 		}
 	}
 }
 
 func (w *Worker) GetStats() (uint64, string) {
-	return atomic.LoadUint64(&w.packetsProcessed), "active"
+	return atomic.LoadUint64(&w.packetsProcessed), w.getStatus()
 }
@@ -3,6 +3,7 @@ package nfq
 import (
 	"encoding/binary"
 	"net"
+	"slices"
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
@@ -11,53 +12,73 @@ import (
 )
 
 // dropAndInjectQUIV6 handles QUIC (UDP) packet manipulation for IPv6
-func (w *Worker) dropAndInjectQUICV6(cfg *config.SetConfig, raw []byte, dst net.IP) {
+func (w *Worker) dropAndInjectQUICV6(s *sendSeq, cfg *config.SetConfig, raw []byte, dst net.IP, splitPos int) {
 	seg2d := config.ResolveSeg2Delay(cfg.UDP.Seg2Delay, cfg.UDP.Seg2DelayMax)
 	if cfg.UDP.Mode != "fake" {
 		return
 	}
 
-	if cfg.UDP.FakeSeqLength > 0 {
-		for i := 0; i < cfg.UDP.FakeSeqLength; i++ {
-			fake, ok := sock.BuildFakeUDPFromOriginalV6(raw, cfg.UDP.FakeLen, cfg.Faking.TTL)
-			if ok {
-				if cfg.UDP.FakingStrategy == "checksum" {
-					ipv6HdrLen := 40
-					if len(fake) >= ipv6HdrLen+8 {
-						fake[ipv6HdrLen+6] ^= 0xFF
-						fake[ipv6HdrLen+7] ^= 0xFF
-					}
-				}
-				_ = w.sock.SendIPv6(fake, dst)
-				if seg2d > 0 {
-					time.Sleep(time.Duration(seg2d) * time.Millisecond)
-				}
+	s.add(func() { w.sendUDPFakesV6(s, cfg, raw, dst, seg2d) })
+
+	// Try to locate SNI within encrypted QUIC payload unless the flight tracker already did
+	ipv6HdrLen := 40
+	if splitPos <= 0 {
+		splitPos = 24 // fallback
+		if len(raw) >= ipv6HdrLen+8 {
+			quicPayload := raw[ipv6HdrLen+8:] // skip IPv6 + UDP headers
+			sniOff, sniLen := quic.LocateSNIOffset(quicPayload)
+			if sniOff > 0 && sniLen > 0 {
+				splitPos = sniOff + sniLen/2
 			}
 		}
 	}
 
-	// Try to locate SNI within encrypted QUIC payload
-	splitPos := 24 // fallback
-	ipv6HdrLen := 40
-	if len(raw) >= ipv6HdrLen+8 {
-		quicPayload := raw[ipv6HdrLen+8:] // skip IPv6 + UDP headers
-		sniOff, sniLen := quic.LocateSNIOffset(quicPayload)
-		if sniOff > 0 && sniLen > 0 {
-			splitPos = sniOff + sniLen/2
+	s.add(func() {
+		frags, ok := sock.IPv6FragmentUDP(raw, splitPos)
+		if !ok {
+			_ = w.sock.SendIPv6(raw, dst)
+			return
 		}
-	}
+		w.SendTwoSegmentsV6(s, frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	})
+}
 
-	frags, ok := sock.IPv6FragmentUDP(raw, splitPos)
-	if !ok {
-		_ = w.sock.SendIPv6(raw, dst)
+// sendUDPFakesV6 sends the set's fake datagrams ahead of raw, each followed
+// by a pause of seg2d
+func (w *Worker) sendUDPFakesV6(p pauser, cfg *config.SetConfig, raw []byte, dst net.IP, seg2d int) {
+	if cfg.UDP.FakeSeqLength <= 0 {
 		return
 	}
 
-	w.SendTwoSegmentsV6(frags[0], frags[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	var fakePayload []byte
+	if len(raw) >= 48 {
+		fakePayload = buildQUICFakePayload(&cfg.UDP, raw[48:])
+	}
+	var fakes [][]byte
+	for i := 0; i < cfg.UDP.FakeSeqLength; i++ {
+		var fake []byte
+		var ok bool
+		if fakePayload != nil {
+			fake, ok = sock.BuildFakeUDPWithPayloadV6(raw, fakePayload, cfg.Faking.TTL)
+		} else {
+			fake, ok = sock.BuildFakeUDPFromOriginalV6(raw, cfg.UDP.FakeLen, cfg.Faking.TTL)
+		}
+		if ok {
+			if cfg.UDP.FakingStrategy == "checksum" {
+				ipv6HdrLen := 40
+				if len(fake) >= ipv6HdrLen+8 {
+					fake[ipv6HdrLen+6] ^= 0xFF
+					fake[ipv6HdrLen+7] ^= 0xFF
+				}
+			}
+			fakes = append(fakes, fake)
+		}
+	}
+	w.sendSpaced(p, IPv6, dst, fakes, evenGaps(len(fakes)+1, time.Duration(seg2d)*time.Millisecond))
 }
 
 // dropAndInjectTCPv6 handles TCP packet manipulation for IPv6
-func (w *Worker) dropAndInjectTCPv6(cfg *config.SetConfig, raw []byte, dst net.IP) {
+func (w *Worker) dropAndInjectTCPv6(t *injectTask, cfg *config.SetConfig, raw []byte, dst net.IP, mss int) {
 	if len(raw) < 60 { // IPv6 header (40) + TCP header (20 min)
 		_ = w.sock.SendIPv6(raw, dst)
 		return
@@ -73,55 +94,38 @@ func (w *Worker) dropAndInjectTCPv6(cfg *config.SetConfig, raw []byte, dst net.I
 		return
 	}
 
-	if cfg.Faking.SNIMutation.Mode != config.ConfigOff {
-		raw = w.MutateClientHelloV6(cfg, raw, dst)
-	}
-
-	if cfg.TCP.Desync.Mode != config.ConfigOff {
-		w.ExecuteDesyncIPv6(cfg, raw, dst)
-		time.Sleep(time.Duration(config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)) * time.Millisecond)
-	}
-
-	if cfg.TCP.Win.Mode != config.ConfigOff {
-		w.ManipulateWindowIPv6(cfg, raw, dst)
-	}
-
-	if cfg.Faking.SNI && cfg.Faking.SNISeqLength > 0 {
-		w.sendFakeSNISequencev6(cfg, raw, dst)
-	}
+	w.runPipeline(t, cfg, IPv6, raw, dst, mss)
+}
 
-	switch cfg.Fragmentation.Strategy {
+// fragmentV6 delivers the packet with one of the fragmentation strategies.
+func (w *Worker) fragmentV6(p pauser, strategy string, cfg *config.SetConfig, raw []byte, dst net.IP) {
+	switch strategy {
 	case "tcp":
-		w.sendTCPSegmentsv6(cfg, raw, dst)
+		w.sendTCPSegmentsv6(p, cfg, raw, dst)
 	case "ip":
-		w.sendIPFragmentsv6(cfg, raw, dst)
+		w.sendIPFragmentsv6(p, cfg, raw, dst)
 	case "oob":
-		w.sendOOBFragmentsV6(cfg, raw, dst)
+		w.sendOOBFragmentsV6(p, cfg, raw, dst)
 	case "tls":
-		w.sendTLSFragmentsV6(cfg, raw, dst)
+		w.sendTLSFragmentsV6(p, cfg, raw, dst)
 	case "disorder":
-		w.sendDisorderFragmentsV6(cfg, raw, dst)
+		w.sendDisorderFragmentsV6(p, cfg, raw, dst)
 	case "extsplit":
-		w.sendExtSplitFragmentsV6(cfg, raw, dst)
+		w.sendExtSplitFragmentsV6(p, cfg, raw, dst)
 	case "firstbyte":
-		w.sendFirstByteDesyncV6(cfg, raw, dst)
+		w.sendFirstByteDesyncV6(p, cfg, raw, dst)
 	case "combo":
-		w.sendComboFragmentsV6(cfg, raw, dst)
+		w.sendComboFragmentsV6(p, cfg, raw, dst)
 	case "hybrid":
-		w.sendHybridFragmentsV6(cfg, raw, dst)
+		w.sendHybridFragmentsV6(p, cfg, raw, dst)
 	case "none":
 		_ = w.sock.SendIPv6(raw, dst)
 	default:
-		w.sendComboFragmentsV6(cfg, raw, dst)
-	}
-
-	if cfg.TCP.Desync.PostDesync {
-		time.Sleep(50 * time.Millisecond)
-		w.sendPostDesyncRSTv6(cfg, raw, dst)
+		w.sendComboFragmentsV6(p, cfg, raw, dst)
 	}
 }
 
-func (w *Worker) sendTCPSegmentsv6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendTCPSegmentsv6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	ipv6HdrLen := 40
 	tcpHdrLen := int((packet[ipv6HdrLen+12] >> 4) * 4)
 	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
@@ -200,25 +204,9 @@ func (w *Worker) sendTCPSegmentsv6(cfg *config.SetConfig, packet []byte, dst net
 		sock.FixTCPChecksumV6(seg3)
 
 		if cfg.Fragmentation.ReverseOrder {
-			_ = w.sock.SendIPv6(seg2, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv6(seg1, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv6(seg3, dst)
+			w.sendSpaced(p, IPv6, dst, [][]byte{seg2, seg1, seg3}, evenGaps(3, time.Duration(seg2d)*time.Millisecond))
 		} else {
-			_ = w.sock.SendIPv6(seg1, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv6(seg2, dst)
-			if seg2d > 0 {
-				time.Sleep(time.Duration(seg2d) * time.Millisecond)
-			}
-			_ = w.sock.SendIPv6(seg3, dst)
+			w.sendSpaced(p, IPv6, dst, [][]byte{seg1, seg2, seg3}, evenGaps(3, time.Duration(seg2d)*time.Millisecond))
 		}
 		return
 	}
@@ -247,10 +235,10 @@ func (w *Worker) sendTCPSegmentsv6(cfg *config.SetConfig, packet []byte, dst net
 	binary.BigEndian.PutUint32(seg2[ipv6HdrLen+4:ipv6HdrLen+8], seq+uint32(splitPos))
 	binary.BigEndian.PutUint16(seg2[4:6], uint16(seg2Len-ipv6HdrLen))
 	sock.FixTCPChecksumV6(seg2)
-	w.SendTwoSegmentsV6(seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV6(p, seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
-func (w *Worker) sendIPFragmentsv6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendIPFragmentsv6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
 	ipv6HdrLen := 40
 
@@ -313,7 +301,7 @@ func (w *Worker) sendIPFragmentsv6(cfg *config.SetConfig, packet []byte, dst net
 		return
 	}
 
-	w.SendTwoSegmentsV6(fragments[0], fragments[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV6(p, fragments[0], fragments[1], dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
 // sendFakeSNISequencev6 sends a sequence of fake SNI packets for IPv6
@@ -330,8 +318,9 @@ func (w *Worker) sendFakeSNISequencev6(cfg *config.SetConfig, original []byte, d
 
 	ipv6HdrLen := 40
 
+	fakes := make([][]byte, 0, faking.SNISeqLength)
 	for i := 0; i < faking.SNISeqLength; i++ {
-		_ = w.sock.SendIPv6(fake, dst)
+		fakes = append(fakes, slices.Clone(fake))
 
 		// Update for next iteration
 		if i+1 < faking.SNISeqLength {
@@ -345,4 +334,5 @@ func (w *Worker) sendFakeSNISequencev6(cfg *config.SetConfig, original []byte, d
 			}
 		}
 	}
+	_ = w.sock.SendBatchIPv6(fakes, dst)
 }
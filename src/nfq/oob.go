@@ -10,7 +10,7 @@ import (
 	"github.com/daniellavrushin/b4/sock"
 )
 
-func (w *Worker) sendOOBFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendOOBFragments(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	ipHdrLen := int((packet[0] & 0x0F) * 4)
 	if len(packet) < ipHdrLen+20 {
 		_ = w.sock.SendIPv4(packet, dst)
@@ -128,33 +128,17 @@ func (w *Worker) sendOOBFragments(cfg *config.SetConfig, packet []byte, dst net.
 	// ===== Send order =====
 	if cfg.Fragmentation.ReverseOrder {
 		// Reverse: seg2, fake, seg1
-		_ = w.sock.SendIPv4(seg2, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg1, dst)
+		w.sendSpaced(p, IPv4, dst, [][]byte{seg2, fake, seg1}, evenGaps(3, time.Duration(seg2delay)*time.Millisecond))
 	} else {
 		// Normal: seg1, fake, seg2
-		_ = w.sock.SendIPv4(seg1, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv4(seg2, dst)
+		w.sendSpaced(p, IPv4, dst, [][]byte{seg1, fake, seg2}, evenGaps(3, time.Duration(seg2delay)*time.Millisecond))
 	}
 
 	log.Tracef("OOB: Sent seg1=%d, fake=%d (TTL=%d), seg2=%d bytes", seg1Len, fakeLen, fake[8], seg2Len)
 }
 
 // sendOOBFragmentsV6 is the IPv6 version of OOB injection
-func (w *Worker) sendOOBFragmentsV6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendOOBFragmentsV6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	const ipv6HdrLen = 40
 
 	if len(packet) < ipv6HdrLen+20 {
@@ -251,25 +235,9 @@ func (w *Worker) sendOOBFragmentsV6(cfg *config.SetConfig, packet []byte, dst ne
 
 	// ===== Send =====
 	if cfg.Fragmentation.ReverseOrder {
-		_ = w.sock.SendIPv6(seg2, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(seg1, dst)
+		w.sendSpaced(p, IPv6, dst, [][]byte{seg2, fake, seg1}, evenGaps(3, time.Duration(seg2delay)*time.Millisecond))
 	} else {
-		_ = w.sock.SendIPv6(seg1, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(fake, dst)
-		if seg2delay > 0 {
-			time.Sleep(time.Duration(seg2delay) * time.Millisecond)
-		}
-		_ = w.sock.SendIPv6(seg2, dst)
+		w.sendSpaced(p, IPv6, dst, [][]byte{seg1, fake, seg2}, evenGaps(3, time.Duration(seg2delay)*time.Millisecond))
 	}
 
 	log.Tracef("OOB v6: Sent seg1=%d, fake=%d (hop=%d), seg2=%d bytes", seg1Len, fakeLen, fake[7], seg2Len)
@@ -0,0 +1,417 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// pipelineState is the ClientHello a pipeline works on: the packet as
+// rewritten so far and, once split, its segments not sent yet.
+type pipelineState struct {
+	cfg    *config.SetConfig
+	v      byte
+	dst    net.IP
+	packet []byte
+	mss    int // segment size of a reassembled ClientHello, 0 if it came whole
+
+	original []byte // the packet as queued, before any step changed it
+
+	split   bool     // the packet is now delivered through pending
+	pending [][]byte // split segments in payload order
+	sent    bool     // the whole packet went out
+
+	pacing // pause before the next step
+}
+
+type stepAction func(w *Worker, st *pipelineState, step *config.StrategyStep)
+
+var stepActions = map[string]stepAction{
+	config.StepMutate:     stepMutate,
+	config.StepTLSSplit:   stepTLSSplit,
+	config.StepDesync:     stepDesync,
+	config.StepWindow:     stepWindow,
+	config.StepFake:       stepFake,
+	config.StepSplit:      stepSplit,
+	config.StepSend:       stepSend,
+	config.StepFragment:   stepFragment,
+	config.StepDelay:      stepDelay,
+	config.StepPostDesync: stepPostDesync,
+}
+
+var defaultPipeline = config.DefaultPipeline()
+
+// runPipeline runs the set's strategy steps over a ClientHello packet, then
+// sends whatever of it no step delivered.
+func (w *Worker) runPipeline(t *injectTask, cfg *config.SetConfig, v byte, raw []byte, dst net.IP, mss int) {
+	steps := cfg.Pipeline
+	if len(steps) == 0 {
+		steps = defaultPipeline
+	}
+
+	st := &pipelineState{cfg: cfg, v: v, dst: dst, packet: raw, mss: mss}
+	if cfg.TCP.Desync.PostDesync {
+		st.original = bytes.Clone(raw)
+	}
+	w.resumePipeline(t, st, steps, 0)
+}
+
+// resumePipeline runs the steps from next on. When a step pauses, the task
+// is parked on the scheduler's timer wheel and resumes here afterwards;
+// without a task the pause is slept.
+func (w *Worker) resumePipeline(t *injectTask, st *pipelineState, steps []config.StrategyStep, next int) {
+	for {
+		if st.wait > 0 {
+			if st.hold(t, func(t *injectTask) { w.resumePipeline(t, st, steps, next) }) {
+				return
+			}
+			continue
+		}
+
+		switch {
+		case next < len(steps):
+			if action, ok := stepActions[steps[next].Action]; ok {
+				action(w, st, &steps[next])
+			}
+			next++
+		case st.split && len(st.pending) > 0:
+			segs := st.pending
+			st.pending = nil
+			w.sendSegments(st, segs, false)
+		case !st.split && !st.sent:
+			w.send(st, st.packet)
+			st.sent = true
+		default:
+			return
+		}
+	}
+}
+
+// rewritable reports whether steps may still change the packet.
+func (st *pipelineState) rewritable() bool {
+	return !st.split && !st.sent
+}
+
+// send sends a packet, cut back to the client's segment size when it
+// carries more of a reassembled ClientHello than one segment held.
+func (w *Worker) send(st *pipelineState, pkt []byte) {
+	w.sendBatch(st.v, resegment(st.v, pkt, st.mss), st.dst)
+}
+
+func resegment(v byte, pkt []byte, mss int) [][]byte {
+	if mss <= 0 {
+		return [][]byte{pkt}
+	}
+	pi, ok := packetInfo(v, pkt)
+	if !ok || pi.PayloadLen <= mss {
+		return [][]byte{pkt}
+	}
+	segs := make([][]byte, 0, (pi.PayloadLen+mss-1)/mss)
+	for off := 0; off < pi.PayloadLen; off += mss {
+		slice := pi.Payload[off:min(off+mss, pi.PayloadLen)]
+		if v == IPv4 {
+			segs = append(segs, BuildSegmentV4(pkt, pi, slice, uint32(off), uint16(len(segs))))
+		} else {
+			segs = append(segs, BuildSegmentV6(pkt, pi, slice, uint32(off)))
+		}
+	}
+	return segs
+}
+
+func (w *Worker) sendSegments(st *pipelineState, segs [][]byte, reverse bool) {
+	if reverse {
+		segs = slices.Clone(segs)
+		slices.Reverse(segs)
+	}
+	delay := config.ResolveSeg2Delay(st.cfg.TCP.Seg2Delay, st.cfg.TCP.Seg2DelayMax)
+	w.sendPaced(st, segs, time.Duration(delay)*time.Millisecond)
+}
+
+// sendPaced sends segs with a pause of delay between them, or all in one
+// batch without one.
+func (w *Worker) sendPaced(st *pipelineState, segs [][]byte, delay time.Duration) {
+	if delay <= 0 {
+		var out [][]byte
+		for _, seg := range segs {
+			out = append(out, resegment(st.v, seg, st.mss)...)
+		}
+		w.sendBatch(st.v, out, st.dst)
+		return
+	}
+	for i, seg := range segs {
+		w.send(st, seg)
+		if rest := segs[i+1:]; len(rest) > 0 && delay > 0 {
+			st.pause(delay, func() { w.sendPaced(st, rest, delay) })
+			return
+		}
+	}
+}
+
+func stepMutate(w *Worker, st *pipelineState, _ *config.StrategyStep) {
+	if st.cfg.Faking.SNIMutation.Mode == config.ConfigOff || !st.rewritable() {
+		return
+	}
+	if st.v == IPv4 {
+		st.packet = w.MutateClientHello(st.cfg, st.packet, st.dst)
+	} else {
+		st.packet = w.MutateClientHelloV6(st.cfg, st.packet, st.dst)
+	}
+}
+
+func stepTLSSplit(_ *Worker, st *pipelineState, step *config.StrategyStep) {
+	if !st.rewritable() {
+		return
+	}
+	pi, ok := packetInfo(st.v, st.packet)
+	if !ok {
+		return
+	}
+	pos := st.cfg.Fragmentation.TLSRecordPosition
+	if len(step.At) > 0 {
+		if p := splitPositions(pi.Payload, step.At[:1]); len(p) > 0 {
+			pos = p[0] - 5 // offsets count from the payload start, records from their body
+		}
+	}
+	if pos <= 0 {
+		pos = 1
+	}
+	if pkt, ok := splitTLSRecord(st.v, st.packet, pi, pos); ok {
+		st.packet = pkt
+	}
+}
+
+func stepDesync(w *Worker, st *pipelineState, _ *config.StrategyStep) {
+	cfg := st.cfg
+	if cfg.TCP.Desync.Mode == config.ConfigOff {
+		return
+	}
+	if st.v == IPv4 {
+		w.ExecuteDesyncIPv4(cfg, st.packet, st.dst)
+	} else {
+		w.ExecuteDesyncIPv6(cfg, st.packet, st.dst)
+	}
+	st.pause(time.Duration(config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax))*time.Millisecond, nil)
+}
+
+func stepWindow(w *Worker, st *pipelineState, _ *config.StrategyStep) {
+	if st.cfg.TCP.Win.Mode == config.ConfigOff {
+		return
+	}
+	if st.v == IPv4 {
+		w.ManipulateWindowIPv4(st.cfg, st.packet, st.dst)
+	} else {
+		w.ManipulateWindowIPv6(st.cfg, st.packet, st.dst)
+	}
+}
+
+func stepFake(w *Worker, st *pipelineState, step *config.StrategyStep) {
+	cfg := st.cfg
+	if !cfg.Faking.SNI {
+		return
+	}
+	if step.Count > 0 && step.Count != cfg.Faking.SNISeqLength {
+		c := *cfg
+		c.Faking.SNISeqLength = step.Count
+		cfg = &c
+	}
+	if cfg.Faking.SNISeqLength <= 0 {
+		return
+	}
+	if st.v == IPv4 {
+		w.sendFakeSNISequence(cfg, st.packet, st.dst)
+	} else {
+		w.sendFakeSNISequencev6(cfg, st.packet, st.dst)
+	}
+}
+
+func stepSplit(_ *Worker, st *pipelineState, step *config.StrategyStep) {
+	if !st.rewritable() {
+		log.Tracef("Pipeline of '%s': split after the packet was split or sent, skipped", st.cfg.Name)
+		return
+	}
+	pi, ok := packetInfo(st.v, st.packet)
+	if !ok || pi.PayloadLen < 2 {
+		return
+	}
+	at := step.At
+	if len(at) == 0 {
+		at = []string{strconv.Itoa(max(st.cfg.Fragmentation.SNIPosition, 1))}
+	}
+	positions := splitPositions(pi.Payload, at)
+	if len(positions) == 0 {
+		return
+	}
+
+	bounds := append(append([]int{0}, positions...), pi.PayloadLen)
+	segs := make([][]byte, 0, len(bounds)-1)
+	for i := 0; i+1 < len(bounds); i++ {
+		slice := pi.Payload[bounds[i]:bounds[i+1]]
+		if st.v == IPv4 {
+			segs = append(segs, BuildSegmentV4(st.packet, pi, slice, uint32(bounds[i]), uint16(i)))
+		} else {
+			segs = append(segs, BuildSegmentV6(st.packet, pi, slice, uint32(bounds[i])))
+		}
+	}
+	st.split, st.pending = true, segs
+}
+
+func stepSend(w *Worker, st *pipelineState, step *config.StrategyStep) {
+	if !st.split {
+		if !st.sent {
+			w.send(st, st.packet)
+			st.sent = true
+		}
+		return
+	}
+	n := len(st.pending)
+	if step.Count > 0 && step.Count < n {
+		n = step.Count
+	}
+	w.sendSegments(st, st.pending[:n], step.Reverse)
+	st.pending = st.pending[n:]
+}
+
+func stepFragment(w *Worker, st *pipelineState, step *config.StrategyStep) {
+	if !st.rewritable() {
+		return
+	}
+	strategy := step.Strategy
+	if strategy == "" {
+		strategy = st.cfg.Fragmentation.Strategy
+	}
+	if pi, ok := packetInfo(st.v, st.packet); ok && st.mss > 0 && pi.PayloadLen > st.mss {
+		// The strategies cut their packets for a hello that fit in one
+		// segment; a reassembled one is split here and sent in MSS pieces.
+		log.Tracef("Pipeline of '%s': reassembled ClientHello, %s done with native splits", st.cfg.Name, strategy)
+		fragmentReassembled(w, st, strategy)
+		return
+	}
+	if st.v == IPv4 {
+		w.fragmentV4(st, strategy, st.cfg, st.packet, st.dst)
+	} else {
+		w.fragmentV6(st, strategy, st.cfg, st.packet, st.dst)
+	}
+	st.sent = true
+}
+
+func fragmentReassembled(w *Worker, st *pipelineState, strategy string) {
+	switch strategy {
+	case config.ConfigNone:
+	case "tls":
+		stepTLSSplit(w, st, &config.StrategyStep{})
+	default:
+		at := []string{strconv.Itoa(max(st.cfg.Fragmentation.SNIPosition, 1))}
+		if st.cfg.Fragmentation.MiddleSNI {
+			at = append(at, config.SplitAtMidSNI)
+		}
+		stepSplit(w, st, &config.StrategyStep{At: at})
+		stepSend(w, st, &config.StrategyStep{Reverse: st.cfg.Fragmentation.ReverseOrder})
+		return
+	}
+	w.send(st, st.packet)
+	st.sent = true
+}
+
+func stepDelay(_ *Worker, st *pipelineState, step *config.StrategyStep) {
+	st.pause(time.Duration(step.Delay)*time.Millisecond, nil)
+}
+
+func stepPostDesync(w *Worker, st *pipelineState, _ *config.StrategyStep) {
+	if !st.cfg.TCP.Desync.PostDesync {
+		return
+	}
+	st.pause(50*time.Millisecond, func() {
+		// Earlier steps may have mutated or re-split st.packet
+		if st.v == IPv4 {
+			w.sendPostDesyncRST(st.cfg, st.original, int((st.original[0]&0x0F)*4), st.dst)
+		} else {
+			w.sendPostDesyncRSTv6(st.cfg, st.original, st.dst)
+		}
+	})
+}
+
+func packetInfo(v byte, packet []byte) (PacketInfo, bool) {
+	if v == IPv4 {
+		return ExtractPacketInfoV4(packet)
+	}
+	return ExtractPacketInfoV6(packet)
+}
+
+// splitPositions resolves split positions to sorted, distinct payload
+// offsets inside the payload. SNI positions are dropped when the payload
+// carries no SNI.
+func splitPositions(payload []byte, at []string) []int {
+	sniStart, sniEnd, hasSNI := locateSNI(payload)
+	out := make([]int, 0, len(at))
+	for _, a := range at {
+		var p int
+		switch a {
+		case config.SplitAtSNI:
+			if !hasSNI {
+				continue
+			}
+			p = sniStart
+		case config.SplitAtMidSNI:
+			if !hasSNI {
+				continue
+			}
+			p = sniStart + (sniEnd-sniStart)/2
+		case config.SplitAtSNIEnd:
+			if !hasSNI {
+				continue
+			}
+			p = sniEnd
+		default:
+			n, err := strconv.Atoi(a)
+			if err != nil {
+				continue
+			}
+			p = n
+		}
+		if p > 0 && p < len(payload) {
+			out = append(out, p)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// splitTLSRecord rewrites the packet's first TLS record as two records, the
+// first carrying pos bytes of its body. The second record keeps the rest of
+// the original length, so a ClientHello spanning packets stays valid.
+func splitTLSRecord(v byte, packet []byte, pi PacketInfo, pos int) ([]byte, bool) {
+	payload := pi.Payload
+	if len(payload) < 6 || payload[0] != 0x16 {
+		return nil, false
+	}
+	recLen := int(binary.BigEndian.Uint16(payload[3:5]))
+	if pos >= recLen || 5+pos >= len(payload) {
+		return nil, false
+	}
+
+	out := make([]byte, 0, len(packet)+5)
+	out = append(out, packet[:pi.PayloadStart]...)
+	out = append(out, payload[0], payload[1], payload[2])
+	out = binary.BigEndian.AppendUint16(out, uint16(pos))
+	out = append(out, payload[5:5+pos]...)
+	out = append(out, payload[0], payload[1], payload[2])
+	out = binary.BigEndian.AppendUint16(out, uint16(recLen-pos))
+	out = append(out, payload[5+pos:]...)
+
+	if v == IPv4 {
+		binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+		sock.FixIPv4Checksum(out[:pi.IPHdrLen])
+		sock.FixTCPChecksum(out)
+	} else {
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(out)-40))
+		sock.FixTCPChecksumV6(out)
+	}
+	return out, true
+}
@@ -0,0 +1,106 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// testClientHelloV4 wraps a ClientHello for www.google.com in an IPv4/TCP
+// packet.
+func testClientHelloV4() []byte {
+	pkt := make([]byte, 40, 40+len(sock.FakeSNI1))
+	pkt[0] = 0x45
+	pkt[8] = 64
+	pkt[9] = 6
+	copy(pkt[12:16], []byte{192, 168, 1, 10})
+	copy(pkt[16:20], []byte{142, 250, 74, 14})
+	binary.BigEndian.PutUint16(pkt[20:22], 50000)
+	binary.BigEndian.PutUint16(pkt[22:24], 443)
+	binary.BigEndian.PutUint32(pkt[24:28], 1000)
+	pkt[32] = 0x50
+	pkt = append(pkt, sock.FakeSNI1...)
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	return pkt
+}
+
+func TestSplitPositions(t *testing.T) {
+	payload := sock.FakeSNI1
+	s, e, ok := locateSNI(payload)
+	if !ok {
+		t.Fatal("test ClientHello has no SNI")
+	}
+
+	got := splitPositions(payload, []string{"sniend", "1", "sni", "1", "midsni", "100000"})
+	want := []int{1, s, s + (e-s)/2, e}
+	if !slices.Equal(got, want) {
+		t.Errorf("splitPositions = %v, want %v", got, want)
+	}
+
+	if got := splitPositions([]byte("GET / HTTP/1.1\r\n"), []string{"sni", "4"}); !slices.Equal(got, []int{4}) {
+		t.Errorf("without SNI = %v, want [4]", got)
+	}
+}
+
+func TestStepSplit(t *testing.T) {
+	pkt := testClientHelloV4()
+	st := &pipelineState{cfg: &config.SetConfig{}, v: IPv4, packet: pkt}
+	stepSplit(nil, st, &config.StrategyStep{Action: config.StepSplit, At: []string{"1", "sni"}})
+
+	if !st.split || len(st.pending) != 3 {
+		t.Fatalf("split into %d segments, want 3", len(st.pending))
+	}
+	var payload []byte
+	for _, seg := range st.pending {
+		pi, _ := ExtractPacketInfoV4(seg)
+		if int(binary.BigEndian.Uint16(seg[2:4])) != len(seg) {
+			t.Error("segment IP length not updated")
+		}
+		if pi.Seq0 != 1000+uint32(len(payload)) {
+			t.Errorf("segment seq = %d, want %d", pi.Seq0, 1000+len(payload))
+		}
+		payload = append(payload, pi.Payload...)
+	}
+	if !bytes.Equal(payload, sock.FakeSNI1) {
+		t.Error("segments do not add up to the payload")
+	}
+
+	// The packet now travels as segments, later rewrites are skipped
+	if st.rewritable() {
+		t.Error("split packet should not be rewritable")
+	}
+}
+
+func TestSplitTLSRecord(t *testing.T) {
+	pkt := testClientHelloV4()
+	pi, _ := ExtractPacketInfoV4(pkt)
+	recLen := int(binary.BigEndian.Uint16(pi.Payload[3:5]))
+
+	out, ok := splitTLSRecord(IPv4, pkt, pi, 10)
+	if !ok {
+		t.Fatal("splitTLSRecord failed")
+	}
+	if len(out) != len(pkt)+5 || int(binary.BigEndian.Uint16(out[2:4])) != len(out) {
+		t.Fatalf("packet length %d, want %d", len(out), len(pkt)+5)
+	}
+	opi, _ := ExtractPacketInfoV4(out)
+	p := opi.Payload
+	if p[0] != 0x16 || binary.BigEndian.Uint16(p[3:5]) != 10 {
+		t.Errorf("first record header % x", p[:5])
+	}
+	second := p[15:20]
+	if second[0] != 0x16 || int(binary.BigEndian.Uint16(second[3:5])) != recLen-10 {
+		t.Errorf("second record header % x, want length %d", second, recLen-10)
+	}
+	if !bytes.Equal(append(append([]byte{}, p[5:15]...), p[20:]...), pi.Payload[5:]) {
+		t.Error("record bodies changed")
+	}
+
+	if _, ok := splitTLSRecord(IPv4, pkt, pi, recLen); ok {
+		t.Error("split at the record end should fail")
+	}
+}
@@ -2,6 +2,7 @@ package nfq
 
 import (
 	"context"
+	"net"
 	"sync"
 	"time"
 
@@ -44,7 +45,13 @@ func NewPool(cfg *config.Config) *Pool {
 		ws = append(ws, w)
 	}
 
-	pool := &Pool{Workers: ws, Dhcp: dhcpMgr}
+	pool := &Pool{
+		Workers:      ws,
+		Dhcp:         dhcpMgr,
+		scheduleWake: make(chan struct{}, 1),
+		scheduleStop: make(chan struct{}),
+		watchdogStop: make(chan struct{}),
+	}
 
 	dhcpMgr.OnUpdate(func(ipToMAC map[string]string) {
 		for _, w := range pool.Workers {
@@ -66,12 +73,24 @@ func NewPool(cfg *config.Config) *Pool {
 		defer ticker.Stop()
 		for range ticker.C {
 			connState.Cleanup()
+			quicFlights.Cleanup()
+			hopDistances.Cleanup()
 		}
 	}()
 
+	go pool.runScheduler()
+
 	return pool
 }
 
+// OnTargetIP registers fn to receive destination IPs learned at runtime (SNI
+// matches and DNS answers for target domains). Must be called before Start.
+func (p *Pool) OnTargetIP(fn func(...net.IP)) {
+	for _, w := range p.Workers {
+		w.onTargetIP = fn
+	}
+}
+
 func (p *Pool) Start() error {
 	for _, w := range p.Workers {
 		if err := w.Start(); err != nil {
@@ -81,10 +100,15 @@ func (p *Pool) Start() error {
 			return err
 		}
 	}
+	p.watchdogDone = make(chan struct{})
+	go p.runWatchdog()
 	return nil
 }
 
 func (p *Pool) Stop() {
+	p.stopScheduler()
+	p.stopWatchdog()
+
 	var wg sync.WaitGroup
 	for _, w := range p.Workers {
 		wg.Add(1)
@@ -126,6 +150,7 @@ func (w *Worker) UpdateConfig(newCfg *config.Config) {
 func buildMatcher(cfg *config.Config) *sni.SuffixSet {
 	if len(cfg.Sets) > 0 {
 		m := sni.NewSuffixSet(cfg.Sets)
+		m.SetDeviceSets(cfg.DeviceSetAssignments())
 		totalDomains := 0
 		totalIPs := 0
 		for _, set := range cfg.Sets {
@@ -140,6 +165,14 @@ func buildMatcher(cfg *config.Config) *sni.SuffixSet {
 	return sni.NewSuffixSet([]*config.SetConfig{})
 }
 
+// learnIPToDomain records that ip serves host and hands it to the target IP hook.
+func (w *Worker) learnIPToDomain(matcher *sni.SuffixSet, ip net.IP, host string, set *config.SetConfig) {
+	matcher.LearnIPToDomain(ip, host, set)
+	if w.onTargetIP != nil {
+		w.onTargetIP(ip)
+	}
+}
+
 func (p *Pool) UpdateConfig(newCfg *config.Config) error {
 	p.configMu.Lock()
 	defer p.configMu.Unlock()
@@ -155,6 +188,11 @@ func (p *Pool) UpdateConfig(newCfg *config.Config) error {
 		w.cfg.Store(newCfg)
 		w.matcher.Store(matcher)
 	}
+
+	select {
+	case p.scheduleWake <- struct{}{}:
+	default:
+	}
 	return nil
 }
 
@@ -0,0 +1,41 @@
+package nfq
+
+import (
+	"encoding/binary"
+
+	"github.com/daniellavrushin/b4/capture"
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/quic"
+)
+
+// buildQUICFakePayload returns the UDP payload for QUIC fakes, or nil when the
+// set uses plain zero-filled fakes. In "initial" mode the fake is a properly
+// protected client Initial for the decoy SNI. It reuses the version and
+// connection IDs of the real datagram, so a DPI that derives the Initial keys
+// reads a valid handshake for the decoy on the same connection.
+func buildQUICFakePayload(udpCfg *config.UDPConfig, orig []byte) []byte {
+	if udpCfg.FakePayload != config.UDPFakeInitial {
+		return nil
+	}
+
+	decoy := udpCfg.FakeSNI
+	if decoy == "" {
+		decoy = config.DefaultUDPFakeSNI
+	}
+
+	version := uint32(quic.VersionV1)
+	var dcid, scid []byte
+	if quic.IsInitial(orig) {
+		version = binary.BigEndian.Uint32(orig[1:5])
+		dcid = quic.ParseDCID(orig)
+		scid = quic.ParseSCID(orig)
+	}
+
+	fake, err := capture.GenerateQUICInitial(decoy, version, dcid, scid, len(orig))
+	if err != nil {
+		log.Tracef("QUIC fake Initial for %s failed: %v", decoy, err)
+		return nil
+	}
+	return fake
+}
@@ -0,0 +1,227 @@
+package nfq
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/quic"
+	"github.com/florianl/go-nfqueue"
+)
+
+const quicFlightTTL = 10 * time.Second
+
+// heldInitial is a client Initial dropped from the queue while the rest of
+// its flight is awaited.
+type heldInitial struct {
+	raw  []byte
+	dst  net.IP
+	v    byte
+	info quic.InitialInfo
+}
+
+// quicFlight coalesces the datagrams of one client Initial flight (same DCID)
+// so they share a single matching decision.
+type quicFlight struct {
+	held     []heldInitial
+	timer    *time.Timer
+	decided  bool
+	set      *config.SetConfig // nil when the flight is not a target
+	host     string
+	sniStart int
+	sniLen   int
+	packets  int
+	lastSeen time.Time
+}
+
+// quicFlightState is a copy of the flight fields the packet callback needs.
+type quicFlightState struct {
+	decided  bool
+	set      *config.SetConfig
+	host     string
+	sniStart int
+	sniLen   int
+}
+
+type quicFlightTracker struct {
+	mu      sync.Mutex
+	flights map[string]*quicFlight
+}
+
+var quicFlights = &quicFlightTracker{
+	flights: make(map[string]*quicFlight),
+}
+
+// Observe records an Initial of the flight and returns its current state.
+func (t *quicFlightTracker) Observe(info quic.InitialInfo) quicFlightState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := string(info.DCID)
+	fl, ok := t.flights[key]
+	if !ok {
+		fl = &quicFlight{sniStart: -1}
+		t.flights[key] = fl
+	}
+	fl.packets++
+	fl.lastSeen = time.Now()
+	if info.SNIStart >= 0 && fl.sniStart < 0 {
+		fl.sniStart = info.SNIStart
+		fl.sniLen = info.SNILen
+	}
+
+	log.Tracef("QUIC flight %x: pkt=%d crypto=%d/%d sni=%d+%d held=%d decided=%v",
+		info.DCID, fl.packets, info.Assembled, info.HelloLen, fl.sniStart, fl.sniLen, len(fl.held), fl.decided)
+
+	return fl.state()
+}
+
+// Hold keeps a copy of an Initial until the flight is decided. If nothing
+// decides it within hold the held datagrams are released unchanged.
+func (t *quicFlightTracker) Hold(w *Worker, info quic.InitialInfo, raw []byte, dst net.IP, v byte, hold time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := string(info.DCID)
+	fl, ok := t.flights[key]
+	if !ok {
+		fl = &quicFlight{sniStart: -1, lastSeen: time.Now()}
+		t.flights[key] = fl
+	}
+
+	pkt := make([]byte, len(raw))
+	copy(pkt, raw)
+	d := make(net.IP, len(dst))
+	copy(d, dst)
+	fl.held = append(fl.held, heldInitial{raw: pkt, dst: d, v: v, info: info})
+
+	if fl.timer == nil {
+		fl.timer = time.AfterFunc(hold, func() {
+			held, _, _ := t.Decide(info.DCID, nil, "")
+			if len(held) > 0 {
+				log.Tracef("QUIC flight %x: hold expired, releasing %d datagram(s)", info.DCID, len(held))
+				s := &sendSeq{}
+				w.sendQUICFlight(s, nil, held, -1, 0)
+				s.run(nil)
+			}
+		})
+	}
+}
+
+// Decide fixes the flight's set (nil for pass-through) and hands back any held
+// datagrams together with the SNI range they should be split around.
+func (t *quicFlightTracker) Decide(dcid []byte, set *config.SetConfig, host string) ([]heldInitial, int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fl, ok := t.flights[string(dcid)]
+	if !ok {
+		return nil, -1, 0
+	}
+	if !fl.decided || (fl.set == nil && set != nil) {
+		fl.decided = true
+		fl.set = set
+		fl.host = host
+	}
+	if fl.timer != nil {
+		fl.timer.Stop()
+		fl.timer = nil
+	}
+	held := fl.held
+	fl.held = nil
+	return held, fl.sniStart, fl.sniLen
+}
+
+func (t *quicFlightTracker) Cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for k, fl := range t.flights {
+		if len(fl.held) == 0 && now.Sub(fl.lastSeen) > quicFlightTTL {
+			delete(t.flights, k)
+		}
+	}
+}
+
+func (fl *quicFlight) state() quicFlightState {
+	return quicFlightState{
+		decided:  fl.decided,
+		set:      fl.set,
+		host:     fl.host,
+		sniStart: fl.sniStart,
+		sniLen:   fl.sniLen,
+	}
+}
+
+// sendQUICFlight queues the datagrams of a decided flight on s in order.
+// Datagrams carrying part of the SNI go through the set's UDP strategy, the
+// rest are reinjected unchanged.
+func (w *Worker) sendQUICFlight(s *sendSeq, set *config.SetConfig, pkts []heldInitial, sniStart, sniLen int) {
+	if set != nil && set.UDP.Mode == "drop" {
+		return
+	}
+	if set != nil && set.UDP.Mode == "fake" && set.UDP.CryptoSplit != config.ConfigOff && sniStart >= 0 {
+		if w.sendQUICCryptoSplit(s, set, pkts, sniStart, sniLen) {
+			return
+		}
+	}
+	for _, p := range pkts {
+		split := -1
+		if set != nil && set.UDP.Mode == "fake" {
+			split = p.info.SNISplit(sniStart, sniLen)
+		}
+		switch {
+		case split < 0 && p.v == IPv4:
+			s.add(func() { _ = w.sock.SendIPv4(p.raw, p.dst) })
+		case split < 0:
+			s.add(func() { _ = w.sock.SendIPv6(p.raw, p.dst) })
+		case p.v == IPv4:
+			w.dropAndInjectQUIC(s, set, p.raw, p.dst, split)
+		default:
+			w.dropAndInjectQUICV6(s, set, p.raw, p.dst, split)
+		}
+	}
+}
+
+// releaseQUICFlight drops the queued datagram and sends it after the held
+// datagrams of its flight so the server sees them in the original order.
+func (w *Worker) releaseQUICFlight(q *queueHandle, id uint32, set *config.SetConfig, held []heldInitial, cur heldInitial, sniStart, sniLen int) {
+	pkt := make([]byte, len(cur.raw))
+	copy(pkt, cur.raw)
+	d := make(net.IP, len(cur.dst))
+	copy(d, cur.dst)
+	cur.raw, cur.dst = pkt, d
+
+	if !w.inject.Submit(func(t *injectTask) {
+		s := &sendSeq{}
+		w.sendQUICFlight(s, set, append(held, cur), sniStart, sniLen)
+		s.run(t)
+	}) {
+		w.overflowQUICFlight(q, id, set, held)
+		return
+	}
+
+	if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+		log.Tracef("failed to set drop verdict on UDP packet %d: %v", id, err)
+	}
+}
+
+// overflowQUICFlight settles a datagram the injection queue had no room
+// for. Held datagrams of its flight go out first unless the set's datagram
+// is dropped by the overflow policy; flights of no set always pass.
+func (w *Worker) overflowQUICFlight(q *queueHandle, id uint32, set *config.SetConfig, held []heldInitial) {
+	if set != nil && !w.passOnOverflow(w.getConfig()) {
+		if err := q.SetVerdict(id, nfqueue.NfDrop); err != nil {
+			log.Tracef("failed to set drop verdict on UDP packet %d: %v", id, err)
+		}
+		return
+	}
+	s := &sendSeq{}
+	w.sendQUICFlight(s, nil, held, -1, 0)
+	s.run(nil)
+	if err := q.SetVerdict(id, nfqueue.NfAccept); err != nil {
+		log.Tracef("failed to set verdict on UDP packet %d: %v", id, err)
+	}
+}
@@ -0,0 +1,80 @@
+package nfq
+
+import (
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/quic"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// sendQUICCryptoSplit re-packs the ClientHello of a flight so the CRYPTO
+// stream is cut inside the SNI, then queues the fakes and the re-encrypted
+// datagrams on s. It returns false when the flight cannot be re-packed and
+// the caller should fall back to IP fragmentation.
+func (w *Worker) sendQUICCryptoSplit(s *sendSeq, set *config.SetConfig, pkts []heldInitial, sniStart, sniLen int) bool {
+	payloads := make([][]byte, len(pkts))
+	for i, p := range pkts {
+		off := 40 + 8
+		if p.v == IPv4 {
+			off = int((p.raw[0]&0x0F)*4) + 8
+		}
+		if len(p.raw) <= off {
+			return false
+		}
+		payloads[i] = p.raw[off:]
+	}
+
+	cut := sniStart + sniLen/2
+	repacked, err := quic.RepackInitials(payloads, cut, quic.RepackOptions{
+		Reorder: set.UDP.CryptoSplit == config.CryptoSplitReorder,
+		Padding: set.UDP.CryptoPadding,
+	})
+	if err != nil {
+		log.Tracef("QUIC crypto split: %v, falling back to IP fragmentation", err)
+		return false
+	}
+
+	log.Tracef("QUIC crypto split: %d datagram(s) re-packed at stream offset %d", len(repacked), cut)
+
+	seg2d := config.ResolveSeg2Delay(set.UDP.Seg2Delay, set.UDP.Seg2DelayMax)
+	first := pkts[0]
+	s.add(func() {
+		if first.v == IPv4 {
+			w.sendUDPFakes(s, set, first.raw, first.dst, seg2d)
+		} else {
+			w.sendUDPFakesV6(s, set, first.raw, first.dst, seg2d)
+		}
+	})
+
+	order := make([]int, len(repacked))
+	for i := range order {
+		order[i] = i
+		if set.UDP.CryptoSplit == config.CryptoSplitReorder {
+			order[i] = len(repacked) - 1 - i
+		}
+	}
+
+	for n, i := range order {
+		p := pkts[i]
+		gap := time.Duration(seg2d) * time.Millisecond
+		if n == 0 {
+			gap = 0
+		}
+		s.add(func() {
+			s.pause(gap, func() {
+				if p.v == IPv4 {
+					if pkt, ok := sock.BuildFakeUDPWithPayloadV4(p.raw, repacked[i], p.raw[8]); ok {
+						_ = w.sock.SendIPv4(pkt, p.dst)
+					}
+				} else {
+					if pkt, ok := sock.BuildFakeUDPWithPayloadV6(p.raw, repacked[i], p.raw[7]); ok {
+						_ = w.sock.SendIPv6(pkt, p.dst)
+					}
+				}
+			})
+		})
+	}
+	return true
+}
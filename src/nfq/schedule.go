@@ -0,0 +1,116 @@
+package nfq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/events"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+)
+
+// scheduleMaxSleep bounds how long the scheduler trusts a computed boundary,
+// so wall clock jumps (NTP sync on boot, manual changes) are picked up.
+const scheduleMaxSleep = 10 * time.Minute
+
+// OnScheduleChange registers fn to receive the config after a set schedule
+// opened or closed and the workers' matchers were rebuilt.
+func (p *Pool) OnScheduleChange(fn func(*config.Config)) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+	p.onScheduleChange = fn
+}
+
+// runScheduler rebuilds the matchers whenever an enabled set with a schedule
+// enters or leaves one of its windows.
+func (p *Pool) runScheduler() {
+	active := scheduledSetStates(p.GetFirstWorkerConfig(), time.Now())
+
+	for {
+		wait := scheduleMaxSleep
+		if cfg := p.GetFirstWorkerConfig(); cfg != nil {
+			if next := cfg.NextScheduleBoundary(time.Now()); !next.IsZero() {
+				// Wake just after the boundary so ActiveAt sees the new window
+				wait = min(time.Until(next)+100*time.Millisecond, scheduleMaxSleep)
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-p.scheduleStop:
+			timer.Stop()
+			return
+		case <-p.scheduleWake:
+			// The config was replaced and its matcher already reflects the
+			// current schedule state.
+			timer.Stop()
+			active = scheduledSetStates(p.GetFirstWorkerConfig(), time.Now())
+			continue
+		case <-timer.C:
+		}
+
+		cfg := p.GetFirstWorkerConfig()
+		current := scheduledSetStates(cfg, time.Now())
+		changed := false
+		for id, on := range current {
+			if was, ok := active[id]; ok && was == on {
+				continue
+			}
+			changed = true
+			reportScheduleTransition(cfg.GetSetById(id), on)
+		}
+		active = current
+		if !changed {
+			continue
+		}
+
+		p.applyScheduledConfig(cfg)
+	}
+}
+
+func (p *Pool) applyScheduledConfig(cfg *config.Config) {
+	if err := p.UpdateConfig(cfg); err != nil {
+		log.Errorf("Failed to apply set schedule change: %v", err)
+		return
+	}
+
+	p.configMu.Lock()
+	fn := p.onScheduleChange
+	p.configMu.Unlock()
+	if fn != nil {
+		fn(cfg)
+	}
+}
+
+func (p *Pool) stopScheduler() {
+	p.scheduleStopOnce.Do(func() { close(p.scheduleStop) })
+}
+
+// scheduledSetStates maps the ID of every enabled set with a schedule to
+// whether it is active at t.
+func scheduledSetStates(cfg *config.Config, t time.Time) map[string]bool {
+	states := make(map[string]bool)
+	if cfg == nil {
+		return states
+	}
+	for _, set := range cfg.Sets {
+		if set.Enabled && set.Schedule.Enabled {
+			states[set.Id] = set.Schedule.ActiveAt(t)
+		}
+	}
+	return states
+}
+
+func reportScheduleTransition(set *config.SetConfig, active bool) {
+	if set == nil {
+		return
+	}
+	state := "inactive"
+	if active {
+		state = "active"
+	}
+	log.Infof("Set '%s' schedule is now %s", set.Name, state)
+	metrics.GetMetricsCollector().RecordEvent("info", fmt.Sprintf("Set '%s' schedule %s", set.Name, state))
+	events.Publish(events.Event{Type: events.TypeSchedule, Set: set.Name, Active: &active})
+}
@@ -0,0 +1,162 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/conntrack"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+const tcpFlightMaxLen = 16384 + 5 // largest TLS record
+
+// tcpFlight holds the segments of a ClientHello larger than one segment
+// until its TLS record is complete.
+type tcpFlight struct {
+	segs   [][]byte
+	dst    net.IP
+	v      byte
+	next   uint32 // sequence number of the next expected segment
+	need   int    // record length including its header
+	have   int
+	maxSeg int // largest payload, the client's MSS for this path
+	timer  *time.Timer
+}
+
+type tcpFlightTracker struct {
+	mu      sync.Mutex
+	flights map[conntrack.Tuple]*tcpFlight
+}
+
+var tcpFlights = &tcpFlightTracker{
+	flights: make(map[conntrack.Tuple]*tcpFlight),
+}
+
+// partialClientHello returns the length of the TLS record a payload starts,
+// when it opens a ClientHello that does not fit in it.
+func partialClientHello(payload []byte) (int, bool) {
+	if len(payload) < 6 || payload[0] != TLSHandshakeType || payload[5] != TLSClientHello {
+		return 0, false
+	}
+	need := 5 + int(binary.BigEndian.Uint16(payload[3:5]))
+	return need, need > len(payload) && need <= tcpFlightMaxLen
+}
+
+// tcpFlightResult tells the packet callback what became of a segment.
+type tcpFlightResult struct {
+	held  bool     // the segment was kept, drop it from the queue
+	hello []byte   // the reassembled ClientHello packet, once complete
+	segs  [][]byte // the segments it was joined from
+	mss   int
+	stale [][]byte // held segments to send unchanged before this one
+}
+
+// Add feeds a TCP segment with payload of a flow to its pending ClientHello.
+// A segment opening a new one is held for at most hold, or passed on when
+// hold is 0.
+func (t *tcpFlightTracker) Add(w *Worker, key conntrack.Tuple, v byte, raw []byte, dst net.IP, hold time.Duration) tcpFlightResult {
+	pi, ok := packetInfo(v, raw)
+	if !ok || pi.PayloadLen == 0 {
+		return tcpFlightResult{}
+	}
+	seq := pi.Seq0
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fl, ok := t.flights[key]
+	if !ok {
+		need, partial := partialClientHello(pi.Payload)
+		if !partial || hold <= 0 {
+			return tcpFlightResult{}
+		}
+		fl = &tcpFlight{dst: append(net.IP(nil), dst...), v: v, need: need}
+		t.flights[key] = fl
+		fl.add(raw, seq, pi.PayloadLen)
+		fl.timer = time.AfterFunc(hold, func() {
+			if segs := t.remove(key, fl); len(segs) > 0 {
+				log.Tracef("TCP flight to %v: hold expired, releasing %d segment(s)", key.Dst, len(segs))
+				w.sendHeld(v, segs, fl.dst)
+			}
+		})
+		return tcpFlightResult{held: true}
+	}
+
+	if seq != fl.next {
+		// Retransmission or reordering: give up on this flight
+		delete(t.flights, key)
+		fl.timer.Stop()
+		return tcpFlightResult{stale: fl.segs}
+	}
+
+	fl.add(raw, seq, pi.PayloadLen)
+	if fl.have < fl.need {
+		return tcpFlightResult{held: true}
+	}
+
+	delete(t.flights, key)
+	fl.timer.Stop()
+	hello, ok := joinSegments(v, fl.segs)
+	if !ok {
+		return tcpFlightResult{stale: fl.segs[:len(fl.segs)-1]}
+	}
+	log.Tracef("TCP flight to %v: ClientHello of %d bytes reassembled from %d segments", key.Dst, fl.need, len(fl.segs))
+	return tcpFlightResult{hello: hello, segs: fl.segs, mss: fl.maxSeg}
+}
+
+func (fl *tcpFlight) add(raw []byte, seq uint32, payloadLen int) {
+	fl.segs = append(fl.segs, append([]byte(nil), raw...))
+	fl.next = seq + uint32(payloadLen)
+	fl.have += payloadLen
+	fl.maxSeg = max(fl.maxSeg, payloadLen)
+}
+
+func (t *tcpFlightTracker) remove(key conntrack.Tuple, fl *tcpFlight) [][]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.flights[key] != fl {
+		return nil
+	}
+	delete(t.flights, key)
+	return fl.segs
+}
+
+// joinSegments builds one packet carrying the payloads of consecutive
+// segments, with the headers of the first.
+func joinSegments(v byte, segs [][]byte) ([]byte, bool) {
+	first, ok := packetInfo(v, segs[0])
+	if !ok {
+		return nil, false
+	}
+	out := append([]byte(nil), segs[0]...)
+	for _, seg := range segs[1:] {
+		pi, ok := packetInfo(v, seg)
+		if !ok {
+			return nil, false
+		}
+		out = append(out, pi.Payload...)
+	}
+	if len(out) > 0xffff {
+		return nil, false
+	}
+
+	if v == IPv4 {
+		binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+		sock.FixIPv4Checksum(out[:first.IPHdrLen])
+		sock.FixTCPChecksum(out)
+	} else {
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(out)-IPv6HeaderLen))
+		sock.FixTCPChecksumV6(out)
+	}
+	return out, true
+}
+
+// sendHeld reinjects held segments unchanged.
+func (w *Worker) sendHeld(v byte, segs [][]byte, dst net.IP) {
+	if len(segs) > 0 {
+		w.sendBatch(v, segs, dst)
+	}
+}
@@ -0,0 +1,87 @@
+package nfq
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/daniellavrushin/b4/conntrack"
+	"github.com/daniellavrushin/b4/sock"
+)
+
+// testHelloSegmentsV4 cuts the test ClientHello into segments of size bytes.
+func testHelloSegmentsV4(size int) [][]byte {
+	return resegment(IPv4, testClientHelloV4(), size)
+}
+
+func TestPartialClientHello(t *testing.T) {
+	hello := sock.FakeSNI1
+	if _, ok := partialClientHello(hello); ok {
+		t.Error("complete ClientHello reported partial")
+	}
+	need, ok := partialClientHello(hello[:100])
+	if !ok || need != len(hello) {
+		t.Errorf("partial ClientHello: need = %d, %v, want %d", need, ok, len(hello))
+	}
+	if _, ok := partialClientHello([]byte{0x17, 0x03, 0x03, 0x10, 0x00, 0x01}); ok {
+		t.Error("application data reported as ClientHello")
+	}
+}
+
+func TestJoinSegments(t *testing.T) {
+	segs := testHelloSegmentsV4(200)
+	if len(segs) < 2 {
+		t.Fatal("test ClientHello fits in one segment")
+	}
+	joined, ok := joinSegments(IPv4, segs)
+	if !ok {
+		t.Fatal("joinSegments failed")
+	}
+	want := testClientHelloV4()
+	sock.FixIPv4Checksum(want[:20])
+	sock.FixTCPChecksum(want)
+	if !bytes.Equal(joined, want) {
+		t.Error("joined segments differ from the whole ClientHello")
+	}
+}
+
+func TestTCPFlightAdd(t *testing.T) {
+	const hold = 150 * time.Millisecond
+	tr := &tcpFlightTracker{flights: make(map[conntrack.Tuple]*tcpFlight)}
+	key, _ := conntrack.TupleFromIP(6, net.IPv4(192, 168, 1, 10), 50000, net.IPv4(142, 250, 74, 14), 443)
+	dst := net.IPv4(142, 250, 74, 14)
+	segs := testHelloSegmentsV4(200)
+
+	for i, seg := range segs[:len(segs)-1] {
+		if r := tr.Add(nil, key, IPv4, seg, dst, hold); !r.held {
+			t.Fatalf("segment %d not held", i)
+		}
+	}
+	r := tr.Add(nil, key, IPv4, segs[len(segs)-1], dst, hold)
+	if r.hello == nil || len(r.segs) != len(segs) || r.mss != 200 {
+		t.Fatalf("last segment: hello=%v segs=%d mss=%d", r.hello != nil, len(r.segs), r.mss)
+	}
+	pi, _ := ExtractPacketInfoV4(r.hello)
+	if !bytes.Equal(pi.Payload, sock.FakeSNI1) {
+		t.Error("reassembled ClientHello differs")
+	}
+	if len(tr.flights) != 0 {
+		t.Error("completed flight still tracked")
+	}
+
+	// A segment out of order gives the held ones back
+	tr.Add(nil, key, IPv4, segs[0], dst, hold)
+	r = tr.Add(nil, key, IPv4, segs[len(segs)-1], dst, hold)
+	if r.held || len(r.stale) != 1 {
+		t.Errorf("out of order segment: held=%v stale=%d", r.held, len(r.stale))
+	}
+	if len(tr.flights) != 0 {
+		t.Error("aborted flight still tracked")
+	}
+
+	// With the hold off a partial ClientHello passes untouched
+	if r := tr.Add(nil, key, IPv4, segs[0], dst, 0); r.held || len(tr.flights) != 0 {
+		t.Errorf("hold off: held=%v flights=%d", r.held, len(tr.flights))
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/daniellavrushin/b4/sock"
 )
 
-func (w *Worker) sendTLSFragments(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendTLSFragments(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	ipHdrLen := int((packet[0] & 0x0F) * 4)
 	tcpHdrLen := int((packet[ipHdrLen+12] >> 4) * 4)
 	payloadStart := ipHdrLen + tcpHdrLen
@@ -57,10 +57,10 @@ func (w *Worker) sendTLSFragments(cfg *config.SetConfig, packet []byte, dst net.
 	sock.FixTCPChecksum(seg2)
 
 	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
-	w.SendTwoSegmentsV4(seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV4(p, seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
 
-func (w *Worker) sendTLSFragmentsV6(cfg *config.SetConfig, packet []byte, dst net.IP) {
+func (w *Worker) sendTLSFragmentsV6(p pauser, cfg *config.SetConfig, packet []byte, dst net.IP) {
 	ipv6HdrLen := 40
 	tcpHdrLen := int((packet[ipv6HdrLen+12] >> 4) * 4)
 	payloadStart := ipv6HdrLen + tcpHdrLen
@@ -105,5 +105,5 @@ func (w *Worker) sendTLSFragmentsV6(cfg *config.SetConfig, packet []byte, dst ne
 	sock.FixTCPChecksumV6(seg2)
 
 	seg2d := config.ResolveSeg2Delay(cfg.TCP.Seg2Delay, cfg.TCP.Seg2DelayMax)
-	w.SendTwoSegmentsV6(seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
+	w.SendTwoSegmentsV6(p, seg1, seg2, dst, seg2d, cfg.Fragmentation.ReverseOrder)
 }
@@ -2,12 +2,14 @@ package nfq
 
 import (
 	"context"
+	"net"
 	"sync"
 	"sync/atomic"
 
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/conntrack"
 	"github.com/daniellavrushin/b4/dhcp"
 	"github.com/daniellavrushin/b4/sock"
-	"github.com/florianl/go-nfqueue"
 )
 
 type Segment struct {
@@ -19,6 +21,16 @@ type Pool struct {
 	Workers  []*Worker
 	configMu sync.Mutex
 	Dhcp     *dhcp.Manager
+
+	scheduleWake     chan struct{}
+	scheduleStop     chan struct{}
+	scheduleStopOnce sync.Once
+	onScheduleChange func(*config.Config)
+
+	watchdogStop chan struct{}
+	watchdogDone chan struct{}
+	removeRules  func(*config.Config) error // nil when b4 does not manage the rules
+	restoreRules func(*config.Config) error
 }
 
 type PacketInfo struct {
@@ -35,14 +47,20 @@ type PacketInfo struct {
 type Worker struct {
 	packetsProcessed uint64
 	lastOverflowLog  int64
+	lastInjectLog    int64 // last injection queue overflow warning, unix seconds
 	cfg              atomic.Value
 	qnum             uint16
 	ctx              context.Context
 	cancel           context.CancelFunc
-	q                *nfqueue.Nfqueue
+	q                atomic.Pointer[queueHandle] // replaced by the watchdog
+	live             liveness
+	status           atomic.Value // string, see workerActive
 	wg               sync.WaitGroup
 	matcher          atomic.Value
 	sock             *sock.Sender
 	ipToMac          atomic.Value
 	connState        sync.Map
+	onTargetIP       func(...net.IP) // set before Start, nil when the prefilter is not wired
+	ct               *conntrack.Conn
+	inject           *injectScheduler
 }
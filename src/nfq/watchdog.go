@@ -0,0 +1,316 @@
+package nfq
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/events"
+	"github.com/daniellavrushin/b4/log"
+	"github.com/daniellavrushin/b4/metrics"
+	"github.com/florianl/go-nfqueue"
+)
+
+const (
+	watchdogInterval = time.Second
+	// Netlink errors within one watchdogInterval that get a handle replaced
+	watchdogErrorBurst = 100
+	// The rules stay down this long after failing open, doubling each time
+	// the workers fail again right after they are restored.
+	failOpenHold    = 30 * time.Second
+	failOpenMaxHold = 10 * time.Minute
+)
+
+const (
+	workerActive     = "active"
+	workerStalled    = "stalled"
+	workerFailedOpen = "failed_open" // queue rules removed by the watchdog
+)
+
+// queueHandle is one binding of a worker to its queue. Verdicts go through
+// it so the watchdog can tell a worker answering its queue from a stuck one.
+type queueHandle struct {
+	*nfqueue.Nfqueue
+	live      *liveness
+	cancel    context.CancelFunc
+	busySince atomic.Int64 // unix nanoseconds the running callback started, 0 when idle
+	exited    atomic.Bool  // the receive loop gave up or the handle was replaced
+}
+
+// SetVerdict issues a verdict and counts it towards the worker's liveness.
+func (q *queueHandle) SetVerdict(id uint32, verdict int) error {
+	q.live.verdicts.Add(1)
+	q.live.lastVerdict.Store(time.Now().UnixNano())
+	return q.Nfqueue.SetVerdict(id, verdict)
+}
+
+// hook wraps the packet callback to count packets and time each call.
+func (q *queueHandle) hook(fn nfqueue.HookFunc) nfqueue.HookFunc {
+	return func(a nfqueue.Attribute) int {
+		q.live.received.Add(1)
+		q.busySince.Store(time.Now().UnixNano())
+		defer q.busySince.Store(0)
+		return fn(a)
+	}
+}
+
+// liveness counts what a worker reads from its queue and answers, across
+// all the handles it has had.
+type liveness struct {
+	received    atomic.Uint64
+	verdicts    atomic.Uint64
+	lastVerdict atomic.Int64 // unix nanoseconds, 0 before the first verdict
+	nlErrors    atomic.Uint64
+	restarts    atomic.Uint64
+}
+
+func (l *liveness) stats() metrics.LivenessStats {
+	st := metrics.LivenessStats{
+		Received:         l.received.Load(),
+		Verdicts:         l.verdicts.Load(),
+		LastVerdictAgeMs: -1,
+		NetlinkErrors:    l.nlErrors.Load(),
+		Restarts:         l.restarts.Load(),
+	}
+	if last := l.lastVerdict.Load(); last != 0 {
+		st.LastVerdictAgeMs = time.Since(time.Unix(0, last)).Milliseconds()
+	}
+	return st
+}
+
+func (w *Worker) getStatus() string {
+	if s, ok := w.status.Load().(string); ok {
+		return s
+	}
+	return workerActive
+}
+
+// Bound reports whether the worker holds an open handle on its queue.
+func (w *Worker) Bound() bool {
+	q := w.q.Load()
+	return q != nil && !q.exited.Load()
+}
+
+// QueueNum is the netfilter queue the worker reads.
+func (w *Worker) QueueNum() uint16 {
+	return w.qnum
+}
+
+// workerWatch is what the watchdog remembers about a worker between checks.
+type workerWatch struct {
+	received uint64 // counters when verdicts last moved
+	verdicts uint64
+	waiting  time.Time // first check that saw packets waiting since then
+	errors   uint64    // netlink errors at the previous check
+
+	restarted       bool // restarted and not seen issuing a verdict since
+	restartVerdicts uint64
+	failures        int // restarts in a row that brought no verdicts back
+	retryAt         time.Time
+}
+
+// stallReason reports why the worker looks stuck, or "" while it keeps up
+// with its queue.
+func (w *Worker) stallReason(st *workerWatch, now time.Time, timeout time.Duration) string {
+	q := w.q.Load()
+	if q == nil || q.exited.Load() {
+		return "queue handle closed"
+	}
+	if since := q.busySince.Load(); since != 0 && now.Sub(time.Unix(0, since)) > timeout {
+		return "packet handler blocked"
+	}
+
+	errs := w.live.nlErrors.Load()
+	burst := errs - st.errors
+	st.errors = errs
+	if burst >= watchdogErrorBurst {
+		return fmt.Sprintf("%d netlink errors", burst)
+	}
+
+	received, verdicts := w.live.received.Load(), w.live.verdicts.Load()
+	switch {
+	case verdicts != st.verdicts:
+		st.received, st.verdicts, st.waiting = received, verdicts, time.Time{}
+	case received == st.received:
+	case st.waiting.IsZero():
+		st.waiting = now
+	case now.Sub(st.waiting) > timeout:
+		return fmt.Sprintf("%d packets without a verdict", received-st.received)
+	}
+	return ""
+}
+
+// check looks the worker over and restarts its queue handle when it has
+// stalled. It reports whether the worker keeps up and, when it restarted
+// it, how many restarts in a row have failed.
+func (w *Worker) check(st *workerWatch, now time.Time, timeout time.Duration) (healthy bool, failures int) {
+	reason := w.stallReason(st, now, timeout)
+	verdicts := w.live.verdicts.Load()
+	if reason == "" {
+		if st.restarted && verdicts != st.restartVerdicts {
+			st.restarted, st.failures = false, 0
+		}
+		return true, 0
+	}
+	if now.Before(st.retryAt) {
+		return false, 0
+	}
+
+	if st.restarted && verdicts == st.restartVerdicts {
+		st.failures++
+	} else {
+		st.failures = 0
+	}
+	st.restarted, st.restartVerdicts = true, verdicts
+	st.retryAt = now.Add(time.Duration(st.failures+1) * watchdogInterval)
+	st.received, st.waiting = w.live.received.Load(), time.Time{}
+	w.live.restarts.Add(1)
+
+	log.Warnf("nfq queue %d stalled (%s), restarting it", w.qnum, reason)
+	action := events.WatchdogRestart
+	if err := w.restartQueue(); err != nil {
+		log.Errorf("nfq queue %d restart failed: %v", w.qnum, err)
+		action = events.WatchdogRestartFailed
+	}
+	metrics.GetMetricsCollector().RecordEvent("warning", fmt.Sprintf("Queue %d stalled (%s), %s", w.qnum, reason, action))
+	events.Publish(events.Event{Type: events.TypeWatchdog, Queue: int(w.qnum), Action: action})
+	return false, st.failures
+}
+
+// restartQueue replaces the worker's queue handle. A handle stuck in its
+// callback is abandoned rather than waited for.
+func (w *Worker) restartQueue() error {
+	if old := w.q.Load(); old != nil {
+		old.exited.Store(true)
+		old.cancel()
+		closed := make(chan struct{})
+		go func() {
+			_ = old.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(2 * time.Second):
+			log.Warnf("nfq queue %d: old handle did not close, abandoning it", w.qnum)
+		}
+	}
+	return w.openQueue()
+}
+
+// OnFailOpen registers how the watchdog takes the queue rules down while a
+// worker's restarts keep failing, and how it puts them back. Must be called
+// before Start; without it the watchdog only restarts workers.
+func (p *Pool) OnFailOpen(remove, restore func(*config.Config) error) {
+	p.removeRules = remove
+	p.restoreRules = restore
+}
+
+// runWatchdog checks the workers every watchdogInterval until stopped.
+func (p *Pool) runWatchdog() {
+	defer close(p.watchdogDone)
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	watches := make([]workerWatch, len(p.Workers))
+	var openUntil time.Time // rules down until then, zero while they are up
+	hold := failOpenHold
+	for {
+		select {
+		case <-p.watchdogStop:
+			return
+		case now := <-ticker.C:
+			cfg := p.GetFirstWorkerConfig()
+			wd := cfg.Queue.Watchdog
+			if !wd.Enabled {
+				if !openUntil.IsZero() && p.recoverRules(cfg) {
+					openUntil = time.Time{}
+				}
+				continue
+			}
+
+			timeout := time.Duration(wd.StallTimeout) * time.Second
+			healthy, exhausted, settled := true, false, true
+			for i, w := range p.Workers {
+				ok, failures := w.check(&watches[i], now, timeout)
+				healthy = healthy && ok
+				exhausted = exhausted || failures >= wd.MaxRestarts
+				settled = settled && !watches[i].restarted
+				if ok {
+					w.status.Store(workerActive)
+				} else {
+					w.status.Store(workerStalled)
+				}
+			}
+
+			switch {
+			case openUntil.IsZero() && exhausted:
+				p.failOpen(cfg)
+				openUntil = now.Add(hold)
+				hold = min(hold*2, failOpenMaxHold)
+			case !openUntil.IsZero() && healthy && now.After(openUntil):
+				if p.recoverRules(cfg) {
+					openUntil = time.Time{}
+				}
+			case openUntil.IsZero() && settled:
+				hold = failOpenHold
+			}
+			if !openUntil.IsZero() {
+				for _, w := range p.Workers {
+					w.status.Store(workerFailedOpen)
+				}
+			}
+		}
+	}
+}
+
+// stopWatchdog stops the watchdog and waits out a restart in progress.
+func (p *Pool) stopWatchdog() {
+	select {
+	case <-p.watchdogStop:
+		return
+	default:
+	}
+	close(p.watchdogStop)
+	if p.watchdogDone == nil {
+		return
+	}
+	select {
+	case <-p.watchdogDone:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// failOpen removes the queue rules so traffic bypasses b4 while the
+// workers cannot be restarted.
+func (p *Pool) failOpen(cfg *config.Config) {
+	m := metrics.GetMetricsCollector()
+	if p.removeRules == nil {
+		log.Errorf("Watchdog: queue workers keep failing and b4 does not manage the rules, traffic may stall")
+		m.RecordEvent("error", "Queue workers keep failing, rules not managed by b4")
+		return
+	}
+	if err := p.removeRules(cfg); err != nil {
+		log.Errorf("Watchdog: failed to remove queue rules: %v", err)
+	}
+	log.Errorf("Watchdog: queue workers keep failing, queue rules removed so traffic bypasses b4")
+	m.RecordEvent("error", "Queue workers keep failing, failing open")
+	events.Publish(events.Event{Type: events.TypeWatchdog, Action: events.WatchdogFailOpen})
+}
+
+// recoverRules puts back the rules failOpen removed, reporting false when
+// that failed and should be retried.
+func (p *Pool) recoverRules(cfg *config.Config) bool {
+	if p.restoreRules == nil {
+		return true
+	}
+	if err := p.restoreRules(cfg); err != nil {
+		log.Errorf("Watchdog: failed to restore queue rules: %v", err)
+		return false
+	}
+	log.Infof("Watchdog: queue rules restored")
+	metrics.GetMetricsCollector().RecordEvent("info", "Queue rules restored after failing open")
+	events.Publish(events.Event{Type: events.TypeWatchdog, Action: events.WatchdogRecover})
+	return true
+}
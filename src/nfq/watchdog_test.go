@@ -0,0 +1,88 @@
+package nfq
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchdogStallReason(t *testing.T) {
+	w := &Worker{}
+	q := &queueHandle{live: &w.live}
+	w.q.Store(q)
+	st := &workerWatch{}
+	timeout := 10 * time.Second
+	now := time.Now()
+
+	w.live.received.Store(5)
+	w.live.verdicts.Store(5)
+	if r := w.stallReason(st, now, timeout); r != "" {
+		t.Fatalf("keeping up reported as %q", r)
+	}
+
+	// Packets arrive and nothing answers them
+	w.live.received.Store(8)
+	if r := w.stallReason(st, now.Add(time.Second), timeout); r != "" {
+		t.Fatalf("stall reported before the timeout: %q", r)
+	}
+	if r := w.stallReason(st, now.Add(5*time.Second), timeout); r != "" {
+		t.Fatalf("stall reported before the timeout: %q", r)
+	}
+	if r := w.stallReason(st, now.Add(12*time.Second), timeout); r == "" {
+		t.Fatal("packets waiting past the timeout not reported")
+	}
+
+	w.live.verdicts.Add(1)
+	if r := w.stallReason(st, now.Add(13*time.Second), timeout); r != "" {
+		t.Fatalf("a verdict did not clear the stall: %q", r)
+	}
+
+	q.busySince.Store(now.Add(-time.Minute).UnixNano())
+	if r := w.stallReason(st, now.Add(14*time.Second), timeout); r != "packet handler blocked" {
+		t.Errorf("blocked handler reported as %q", r)
+	}
+	q.busySince.Store(0)
+
+	w.live.nlErrors.Add(watchdogErrorBurst)
+	if r := w.stallReason(st, now.Add(15*time.Second), timeout); r == "" {
+		t.Error("netlink error burst not reported")
+	}
+
+	q.exited.Store(true)
+	if r := w.stallReason(st, now.Add(16*time.Second), timeout); r != "queue handle closed" {
+		t.Errorf("exited handle reported as %q", r)
+	}
+}
+
+func TestLivenessStats(t *testing.T) {
+	var l liveness
+	if st := l.stats(); st.LastVerdictAgeMs != -1 {
+		t.Errorf("age before any verdict = %d, want -1", st.LastVerdictAgeMs)
+	}
+	l.lastVerdict.Store(time.Now().Add(-2 * time.Second).UnixNano())
+	if st := l.stats(); st.LastVerdictAgeMs < 2000 {
+		t.Errorf("age = %dms, want at least 2000", st.LastVerdictAgeMs)
+	}
+}
+
+func TestQueueErrorOverflowNotCounted(t *testing.T) {
+	w := &Worker{}
+	q := &queueHandle{live: &w.live}
+	ctx := context.Background()
+
+	for range watchdogErrorBurst * 2 {
+		if w.queueError(ctx, q, syscall.ENOBUFS) != 0 {
+			t.Fatal("queue overflow ended the receive loop")
+		}
+	}
+	if n := w.live.nlErrors.Load(); n != 0 {
+		t.Errorf("queue overflows counted as %d netlink errors", n)
+	}
+
+	w.queueError(ctx, q, errors.New("netlink receive: bad message"))
+	if n := w.live.nlErrors.Load(); n != 1 {
+		t.Errorf("netlink errors = %d, want 1", n)
+	}
+}
@@ -0,0 +1,108 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	VersionV1 = versionV1
+	VersionV2 = versionV2
+
+	// MinInitialSize is the smallest datagram a client may carry an Initial in (RFC 9000 §14.1).
+	MinInitialSize = 1200
+
+	initialPNLen = 4
+	aeadTagLen   = 16
+)
+
+// AppendVarint appends v encoded as a QUIC variable-length integer.
+func AppendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// AppendCryptoFrame appends a CRYPTO frame carrying data at the given stream offset.
+func AppendCryptoFrame(b []byte, offset uint64, data []byte) []byte {
+	b = append(b, 0x06)
+	b = AppendVarint(b, offset)
+	b = AppendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// BuildInitial assembles a client Initial packet carrying frames and applies
+// packet and header protection with keys derived from dcid. The plaintext is
+// padded with PADDING frames so the packet is at least minSize bytes long.
+func BuildInitial(version uint32, dcid, scid []byte, pn uint32, frames []byte, minSize int) ([]byte, error) {
+	return buildInitial(version, dcid, scid, nil, pn, frames, minSize)
+}
+
+func buildInitial(version uint32, dcid, scid, token []byte, pn uint32, frames []byte, minSize int) ([]byte, error) {
+	if len(dcid) > 20 || len(scid) > 20 {
+		return nil, errors.New("connection ID too long")
+	}
+	hp, aead, iv, err := deriveInitial(dcid, version)
+	if err != nil {
+		return nil, err
+	}
+
+	var ptype byte
+	if version == versionV2 {
+		ptype = 0x01
+	}
+
+	hdr := make([]byte, 0, 64+len(token))
+	hdr = append(hdr, longHdrBit|0x40|ptype<<4|(initialPNLen-1))
+	hdr = binary.BigEndian.AppendUint32(hdr, version)
+	hdr = append(hdr, byte(len(dcid)))
+	hdr = append(hdr, dcid...)
+	hdr = append(hdr, byte(len(scid)))
+	hdr = append(hdr, scid...)
+	hdr = AppendVarint(hdr, uint64(len(token)))
+	hdr = append(hdr, token...)
+
+	// Length field is always written in its 2-byte form so its size is known
+	// before the padding is computed.
+	payloadLen := len(frames)
+	overhead := len(hdr) + 2 + initialPNLen + aeadTagLen
+	if overhead+payloadLen < minSize {
+		payloadLen = minSize - overhead
+	}
+	if initialPNLen+payloadLen+aeadTagLen >= 1<<14 {
+		return nil, errors.New("initial payload too large")
+	}
+
+	plain := make([]byte, payloadLen)
+	copy(plain, frames)
+
+	hdr = append(hdr, 0x40|byte((initialPNLen+payloadLen+aeadTagLen)>>8), byte(initialPNLen+payloadLen+aeadTagLen))
+	pnOff := len(hdr)
+	hdr = binary.BigEndian.AppendUint32(hdr, pn)
+
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(uint64(pn) >> (8 * i))
+	}
+
+	aad := append([]byte(nil), hdr...)
+	packet := aead.Seal(hdr, nonce, plain, aad)
+
+	var mask [16]byte
+	hp.Encrypt(mask[:], packet[pnOff+4:pnOff+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < initialPNLen; i++ {
+		packet[pnOff+i] ^= mask[1+i]
+	}
+
+	return packet, nil
+}
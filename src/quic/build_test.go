@@ -0,0 +1,46 @@
+package quic
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildInitialRoundTrip(t *testing.T) {
+	dcid := []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+	scid := []byte{0x01, 0x02, 0x03, 0x04}
+	data := bytes.Repeat([]byte{0xab}, 300)
+	frames := AppendCryptoFrame(nil, 0, data)
+
+	for _, ver := range []uint32{VersionV1, VersionV2} {
+		pkt, err := BuildInitial(ver, dcid, scid, 2, frames, MinInitialSize)
+		if err != nil {
+			t.Fatalf("BuildInitial(%x): %v", ver, err)
+		}
+		if len(pkt) < MinInitialSize {
+			t.Errorf("packet too short: %d", len(pkt))
+		}
+		if !IsInitial(pkt) {
+			t.Fatalf("version %x: built packet is not recognized as Initial", ver)
+		}
+		if got := ParseDCID(pkt); !bytes.Equal(got, dcid) {
+			t.Errorf("DCID = %x, want %x", got, dcid)
+		}
+		plain, ok := DecryptInitial(dcid, pkt)
+		if !ok {
+			t.Fatalf("version %x: DecryptInitial failed", ver)
+		}
+		if !bytes.HasPrefix(plain, frames) {
+			t.Errorf("version %x: decrypted payload does not start with the frames", ver)
+		}
+	}
+}
+
+func TestAppendVarint(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30} {
+		b := AppendVarint(nil, v)
+		got, n := readVar(b)
+		if n != len(b) || got != v {
+			t.Errorf("varint %d: decoded %d (%d bytes of %d)", v, got, n, len(b))
+		}
+	}
+}
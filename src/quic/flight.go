@@ -0,0 +1,118 @@
+package quic
+
+// CryptoSpan is one CRYPTO frame carried by an Initial packet.
+type CryptoSpan struct {
+	Off       int // offset within the CRYPTO stream
+	Len       int
+	PacketOff int // where the frame data starts in the protected packet
+}
+
+// InitialInfo describes a client Initial and the state of the flight it
+// belongs to. ClientHellos with post-quantum key shares span several Initial
+// datagrams, so the SNI is often carried by a packet other than the one that
+// completes the CRYPTO stream.
+type InitialInfo struct {
+	DCID      []byte
+	Spans     []CryptoSpan
+	HelloLen  int    // ClientHello length including the handshake header, 0 until known
+	Assembled int    // contiguous CRYPTO stream bytes seen so far for this DCID
+	SNI       string // server name once the stream prefix reaches it
+	SNIStart  int    // SNI offset within the CRYPTO stream, -1 until located
+	SNILen    int
+}
+
+// Complete reports whether the whole ClientHello has been received.
+func (i InitialInfo) Complete() bool {
+	return i.HelloLen > 0 && i.Assembled >= i.HelloLen
+}
+
+// SNISplit returns the position within the packet (UDP payload) that falls in
+// the middle of the SNI bytes carried by this packet, or -1 if it carries none.
+func (i InitialInfo) SNISplit(sniStart, sniLen int) int {
+	if sniStart < 0 || sniLen <= 0 {
+		return -1
+	}
+	for _, s := range i.Spans {
+		lo := max(s.Off, sniStart)
+		hi := min(s.Off+s.Len, sniStart+sniLen)
+		if lo < hi {
+			return s.PacketOff + (lo - s.Off) + (hi-lo)/2
+		}
+	}
+	return -1
+}
+
+// InspectInitial decrypts a client Initial, feeds its CRYPTO frames into the
+// per-DCID reassembly buffer and reports where the packet sits in the flight.
+// The buffer is left in place so AssembleCrypto callers see the same stream.
+func InspectInitial(packet []byte) (InitialInfo, bool) {
+	if !IsInitial(packet) {
+		return InitialInfo{}, false
+	}
+	dcid := ParseDCID(packet)
+	if len(dcid) == 0 {
+		return InitialInfo{}, false
+	}
+	hdrLen, pnLen, ok := parseHeaderLength(packet)
+	if !ok {
+		return InitialInfo{}, false
+	}
+	plain, ok := DecryptInitial(dcid, packet)
+	if !ok {
+		return InitialInfo{}, false
+	}
+
+	info := InitialInfo{
+		DCID:     append([]byte(nil), dcid...),
+		Spans:    cryptoSpans(plain, hdrLen+pnLen),
+		SNIStart: -1,
+	}
+	if len(info.Spans) == 0 {
+		return info, true
+	}
+
+	crypto, ok := AssembleCrypto(dcid, plain)
+	if !ok {
+		return info, true
+	}
+	info.Assembled = len(crypto)
+	if len(crypto) >= 4 && crypto[0] == 0x01 {
+		info.HelloLen = 4 + (int(crypto[1])<<16 | int(crypto[2])<<8 | int(crypto[3]))
+	}
+	if off, n := locateSNIInClientHello(crypto); off >= 0 && n > 0 && off+n <= len(crypto) {
+		info.SNIStart = off
+		info.SNILen = n
+		info.SNI = string(crypto[off : off+n])
+	}
+	return info, true
+}
+
+// cryptoSpans lists CRYPTO frames in a decrypted Initial payload; base is the
+// offset of the payload within the packet.
+func cryptoSpans(plain []byte, base int) []CryptoSpan {
+	var spans []CryptoSpan
+	i := 0
+	for i < len(plain) {
+		switch plain[i] {
+		case 0x00, 0x01: // PADDING, PING
+			i++
+		case 0x06:
+			i++
+			off, n := readVarint(plain[i:])
+			if n == 0 {
+				return spans
+			}
+			i += n
+			ln, m := readVarint(plain[i:])
+			if m == 0 || int(ln) > len(plain)-i-m {
+				return spans
+			}
+			i += m
+			spans = append(spans, CryptoSpan{Off: int(off), Len: int(ln), PacketOff: base + i})
+			i += int(ln)
+		default:
+			return spans
+		}
+	}
+	return spans
+}
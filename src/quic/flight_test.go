@@ -0,0 +1,86 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testClientHello builds a ClientHello whose server_name extension sits behind
+// padding large enough to push the hello past a single Initial.
+func testClientHello(host string, padding int) []byte {
+	var ext []byte
+	ext = binary.BigEndian.AppendUint16(ext, 0x0015)
+	ext = binary.BigEndian.AppendUint16(ext, uint16(padding))
+	ext = append(ext, make([]byte, padding)...)
+
+	ext = binary.BigEndian.AppendUint16(ext, 0x0000)
+	ext = binary.BigEndian.AppendUint16(ext, uint16(len(host)+5))
+	ext = binary.BigEndian.AppendUint16(ext, uint16(len(host)+3))
+	ext = append(ext, 0)
+	ext = binary.BigEndian.AppendUint16(ext, uint16(len(host)))
+	ext = append(ext, host...)
+
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0)                   // session id
+	body = append(body, 0x00, 0x02, 0x13, 0x01)
+	body = append(body, 0x01, 0x00) // compression
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ext)))
+	body = append(body, ext...)
+
+	hello := []byte{0x01, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	return append(hello, body...)
+}
+
+func TestInspectInitialTwoDatagramFlight(t *testing.T) {
+	dcid := []byte{0xf1, 0x1e, 0x47, 0x00, 0x00, 0x00, 0x00, 0x01}
+	defer ClearDCID(dcid)
+
+	host := "split.example.com"
+	hello := testClientHello(host, 1400)
+	cut := 1000
+
+	// Second half (with the SNI) is sent first, like Chrome's scrambled CRYPTO frames
+	first, err := BuildInitial(VersionV1, dcid, nil, 0, AppendCryptoFrame(nil, uint64(cut), hello[cut:]), MinInitialSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := BuildInitial(VersionV1, dcid, nil, 1, AppendCryptoFrame(nil, 0, hello[:cut]), MinInitialSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ok := InspectInitial(first)
+	if !ok {
+		t.Fatal("first datagram not inspected")
+	}
+	if a.Complete() || a.SNIStart >= 0 {
+		t.Fatalf("first datagram should not expose the SNI yet: %+v", a)
+	}
+
+	b, ok := InspectInitial(second)
+	if !ok {
+		t.Fatal("second datagram not inspected")
+	}
+	if !b.Complete() || b.SNI != host {
+		t.Fatalf("flight not assembled: complete=%v sni=%q", b.Complete(), b.SNI)
+	}
+
+	if b.SNISplit(b.SNIStart, b.SNILen) >= 0 {
+		t.Error("second datagram does not carry SNI bytes but reported a split")
+	}
+	split := a.SNISplit(b.SNIStart, b.SNILen)
+	if split < 0 {
+		t.Fatal("first datagram carries the SNI but no split was found")
+	}
+
+	plain, ok := DecryptInitial(dcid, first)
+	if !ok {
+		t.Fatal("decrypt first")
+	}
+	hdr := len(first) - len(plain) - aeadTagLen
+	if !bytes.Contains(plain[split-hdr-len(host)/2:split-hdr+len(host)], []byte(host)) {
+		t.Errorf("split %d does not fall inside %q", split, host)
+	}
+}
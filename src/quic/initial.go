@@ -47,55 +47,80 @@ func IsInitial(b []byte) bool {
 	}
 }
 
+// InitialHeader holds the long-header fields needed to rebuild a client Initial.
+type InitialHeader struct {
+	Version uint32
+	DCID    []byte
+	SCID    []byte
+	Token   []byte
+	PN      uint32 // as carried on the wire (truncated to its encoded length)
+}
+
 func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
+	_, plain, ok := openInitial(dcid, packet)
+	return plain, ok
+}
+
+// OpenInitial removes packet protection from a client Initial using keys
+// derived from its own DCID and returns the header fields and payload.
+func OpenInitial(packet []byte) (InitialHeader, []byte, bool) {
+	return openInitial(ParseDCID(packet), packet)
+}
+
+func openInitial(dcid, packet []byte) (InitialHeader, []byte, bool) {
+	var hdr InitialHeader
 	if len(packet) < 7 || packet[0]&0x80 == 0 {
-		return nil, false
+		return hdr, nil, false
 	}
 	ver := binary.BigEndian.Uint32(packet[1:5])
 	hp, aead, iv, err := deriveInitial(dcid, ver)
 	if err != nil {
-		return nil, false
+		return hdr, nil, false
 	}
+	hdr.Version = ver
 
 	// flags+ver
 	off := 1 + 4
 
 	// DCID len + DCID
 	if len(packet) < off+1 {
-		return nil, false
+		return hdr, nil, false
 	}
 	dlen := int(packet[off])
 	off++
 	if len(packet) < off+dlen+1 {
-		return nil, false
+		return hdr, nil, false
 	}
+	hdr.DCID = packet[off : off+dlen]
 	off += dlen
 
 	// SCID len + SCID
 	slen := int(packet[off])
 	off++
 	if len(packet) < off+slen {
-		return nil, false
+		return hdr, nil, false
 	}
+	hdr.SCID = packet[off : off+slen]
 	off += slen
 
 	// Token (varint + bytes)
 	tlen, n := readVar(packet[off:])
 	if n == 0 || len(packet) < off+n+int(tlen) {
-		return nil, false
+		return hdr, nil, false
 	}
+	hdr.Token = packet[off+n : off+n+int(tlen)]
 	off += n + int(tlen)
 
 	// Length (varint) -> PN offset
 	_, m := readVar(packet[off:])
 	if m == 0 {
-		return nil, false
+		return hdr, nil, false
 	}
 	pnOff := off + m
 
 	// HP sample (pnOff + 4)
 	if pnOff+4+16 > len(packet) {
-		return nil, false
+		return hdr, nil, false
 	}
 	var sample [16]byte
 	copy(sample[:], packet[pnOff+4:pnOff+4+16])
@@ -107,7 +132,7 @@ func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
 	first := packet[0] ^ (mask[0] & 0x0f)
 	pnLen := int((first & 0x03) + 1)
 	if pnOff+pnLen > len(packet) {
-		return nil, false
+		return hdr, nil, false
 	}
 
 	// Unmasked PN bytes (don’t write back)
@@ -138,9 +163,10 @@ func DecryptInitial(dcid, packet []byte) ([]byte, bool) {
 	ct := packet[pnOff+pnLen:]
 	plain, err := aead.Open(nil, nonce, ct, aad)
 	if err != nil {
-		return nil, false
+		return hdr, nil, false
 	}
-	return plain, true
+	hdr.PN = uint32(pn)
+	return hdr, plain, true
 }
 
 func deriveInitial(dcid []byte, version uint32) (cipher.Block, cipher.AEAD, []byte, error) {
@@ -0,0 +1,230 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+// RepackOptions controls how RepackInitials re-cuts a ClientHello.
+type RepackOptions struct {
+	Reorder bool // put later stream data first inside each datagram
+	Padding bool // interleave PING and PADDING frames between CRYPTO frames
+}
+
+const (
+	repackFrameOverhead = 1 + 4 + 2 // type, offset varint, length varint
+	repackPadLen        = 8         // PING followed by PADDING bytes
+)
+
+type cryptoPiece struct {
+	off  int
+	data []byte
+}
+
+// RepackInitials re-packs the CRYPTO stream of a client Initial flight so that
+// the ClientHello is cut at stream offset cut. Every datagram keeps its packet
+// number, connection IDs, token and size: no new packet numbers are invented,
+// since a server ACK for a packet the client never sent aborts the handshake.
+// With two or more datagrams the cut falls on a datagram boundary; with one,
+// the stream is split into separate CRYPTO frames inside it. Datagrams are
+// returned in the order given.
+func RepackInitials(packets [][]byte, cut int, opts RepackOptions) ([][]byte, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("no packets")
+	}
+
+	hdrs := make([]InitialHeader, len(packets))
+	var pieces []cryptoPiece
+	for i, pkt := range packets {
+		if !IsInitial(pkt) {
+			return nil, errors.New("not a client Initial")
+		}
+		hdr, plain, ok := OpenInitial(pkt)
+		if !ok {
+			return nil, errors.New("cannot decrypt Initial")
+		}
+		if i > 0 && (hdr.Version != hdrs[0].Version || !bytes.Equal(hdr.DCID, hdrs[0].DCID)) {
+			return nil, errors.New("packets belong to different flights")
+		}
+		hdrs[i] = hdr
+
+		spans := cryptoSpans(plain, 0)
+		if !onlyCryptoFrames(plain) {
+			return nil, errors.New("Initial carries frames other than CRYPTO, PADDING and PING")
+		}
+		for _, s := range spans {
+			pieces = append(pieces, cryptoPiece{off: s.Off, data: plain[s.PacketOff : s.PacketOff+s.Len]})
+		}
+	}
+
+	pieces = normalizePieces(pieces)
+	pieces, ok := cutPieces(pieces, cut)
+	if !ok {
+		return nil, errors.New("cut is not inside the CRYPTO data of these packets")
+	}
+
+	overhead := repackFrameOverhead
+	if opts.Padding {
+		overhead += repackPadLen
+	}
+
+	dgrams := make([][]cryptoPiece, len(packets))
+	used := make([]int, len(packets))
+	room := func(slot int) int {
+		return initialCapacity(hdrs[slot], len(packets[slot])) - used[slot] - overhead
+	}
+
+	var before, after []cryptoPiece
+	for _, p := range pieces {
+		if p.off < cut {
+			before = append(before, p)
+		} else {
+			after = append(after, p)
+		}
+	}
+
+	// Data before the cut is laid out backwards from the first datagram and data
+	// after it forwards from the last one, so the two halves of the SNI always
+	// land in different datagrams even when the SNI sits deep in the stream.
+	slot := 0
+	for i := len(before) - 1; i >= 0; i-- {
+		p := before[i]
+		for len(p.data) > 0 {
+			if slot >= len(packets) {
+				return nil, errors.New("CRYPTO data does not fit the flight")
+			}
+			r := room(slot)
+			if r <= 0 {
+				slot++
+				continue
+			}
+			take := min(r, len(p.data))
+			head := len(p.data) - take
+			dgrams[slot] = append([]cryptoPiece{{off: p.off + head, data: p.data[head:]}}, dgrams[slot]...)
+			used[slot] += overhead + take
+			p = cryptoPiece{off: p.off, data: p.data[:head]}
+		}
+	}
+
+	slot = len(packets) - 1
+	for _, p := range after {
+		for len(p.data) > 0 {
+			if slot < 0 {
+				return nil, errors.New("CRYPTO data does not fit the flight")
+			}
+			r := room(slot)
+			if r <= 0 {
+				slot--
+				continue
+			}
+			take := min(r, len(p.data))
+			dgrams[slot] = append(dgrams[slot], cryptoPiece{off: p.off, data: p.data[:take]})
+			used[slot] += overhead + take
+			p = cryptoPiece{off: p.off + take, data: p.data[take:]}
+		}
+	}
+
+	for i := range dgrams {
+		sort.SliceStable(dgrams[i], func(a, b int) bool { return dgrams[i][a].off < dgrams[i][b].off })
+	}
+
+	out := make([][]byte, len(packets))
+	for i, ps := range dgrams {
+		if opts.Reorder {
+			for l, r := 0, len(ps)-1; l < r; l, r = l+1, r-1 {
+				ps[l], ps[r] = ps[r], ps[l]
+			}
+		}
+
+		var frames []byte
+		for _, p := range ps {
+			if opts.Padding {
+				frames = append(frames, 0x01)
+				frames = append(frames, make([]byte, repackPadLen-1)...)
+			}
+			frames = AppendCryptoFrame(frames, uint64(p.off), p.data)
+		}
+		if len(frames) == 0 {
+			frames = []byte{0x01} // keep the datagram ack-eliciting
+		}
+
+		h := hdrs[i]
+		pkt, err := buildInitial(h.Version, h.DCID, h.SCID, h.Token, h.PN, frames, len(packets[i]))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = pkt
+	}
+	return out, nil
+}
+
+// initialCapacity is the frame space of a rebuilt Initial of size bytes.
+func initialCapacity(h InitialHeader, size int) int {
+	hdr := 1 + 4 + 1 + len(h.DCID) + 1 + len(h.SCID) + len(AppendVarint(nil, uint64(len(h.Token)))) + len(h.Token) + 2
+	return size - hdr - initialPNLen - aeadTagLen
+}
+
+func onlyCryptoFrames(plain []byte) bool {
+	i := 0
+	for i < len(plain) {
+		switch plain[i] {
+		case 0x00, 0x01:
+			i++
+		case 0x06:
+			i++
+			_, n := readVarint(plain[i:])
+			if n == 0 {
+				return false
+			}
+			i += n
+			ln, m := readVarint(plain[i:])
+			if m == 0 || int(ln) > len(plain)-i-m {
+				return false
+			}
+			i += m + int(ln)
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// normalizePieces sorts CRYPTO data by offset and trims retransmitted overlaps.
+func normalizePieces(pieces []cryptoPiece) []cryptoPiece {
+	sort.SliceStable(pieces, func(i, j int) bool { return pieces[i].off < pieces[j].off })
+	out := pieces[:0]
+	next := 0
+	for _, p := range pieces {
+		end := p.off + len(p.data)
+		if end <= next {
+			continue
+		}
+		if p.off < next {
+			p = cryptoPiece{off: next, data: p.data[next-p.off:]}
+		}
+		out = append(out, p)
+		next = end
+	}
+	return out
+}
+
+// cutPieces splits the piece containing cut so a new piece starts there.
+func cutPieces(pieces []cryptoPiece, cut int) ([]cryptoPiece, bool) {
+	for i, p := range pieces {
+		if cut == p.off && i > 0 {
+			return pieces, true
+		}
+		if cut <= p.off || cut >= p.off+len(p.data) {
+			continue
+		}
+		at := cut - p.off
+		head := cryptoPiece{off: p.off, data: p.data[:at]}
+		tail := cryptoPiece{off: cut, data: p.data[at:]}
+		out := make([]cryptoPiece, 0, len(pieces)+1)
+		out = append(out, pieces[:i]...)
+		out = append(out, head, tail)
+		return append(out, pieces[i+1:]...), true
+	}
+	return pieces, false
+}
@@ -0,0 +1,108 @@
+package quic
+
+import (
+	"bytes"
+	"testing"
+)
+
+// reassemble decrypts the datagrams and rebuilds the CRYPTO stream.
+func reassemble(t *testing.T, pkts [][]byte) ([]byte, [][]CryptoSpan) {
+	t.Helper()
+	var stream []byte
+	var spans [][]CryptoSpan
+	for _, pkt := range pkts {
+		_, plain, ok := OpenInitial(pkt)
+		if !ok {
+			t.Fatal("repacked datagram does not decrypt")
+		}
+		ss := cryptoSpans(plain, 0)
+		spans = append(spans, ss)
+		for _, s := range ss {
+			if end := s.Off + s.Len; end > len(stream) {
+				stream = append(stream, make([]byte, end-len(stream))...)
+			}
+			copy(stream[s.Off:], plain[s.PacketOff:s.PacketOff+s.Len])
+		}
+	}
+	return stream, spans
+}
+
+func TestRepackInitialsSingleDatagram(t *testing.T) {
+	dcid := []byte{0x7e, 0x9a, 0xc4, 0x00, 0x00, 0x00, 0x00, 0x01}
+	hello := testClientHello("single.example.com", 200)
+	orig, err := BuildInitial(VersionV1, dcid, []byte{1, 2, 3, 4}, 0, AppendCryptoFrame(nil, 0, hello), MinInitialSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sniOff, sniLen := locateSNIInClientHello(hello)
+	cut := sniOff + sniLen/2
+
+	out, err := RepackInitials([][]byte{orig}, cut, RepackOptions{Reorder: true, Padding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || len(out[0]) != len(orig) {
+		t.Fatalf("datagram count/size changed: %d datagrams, %d bytes", len(out), len(out[0]))
+	}
+
+	hdr, _, _ := OpenInitial(out[0])
+	if hdr.PN != 0 {
+		t.Errorf("packet number changed to %d", hdr.PN)
+	}
+
+	stream, spans := reassemble(t, out)
+	if !bytes.Equal(stream, hello) {
+		t.Fatal("reassembled ClientHello differs from the original")
+	}
+	if len(spans[0]) < 2 || spans[0][0].Off != cut {
+		t.Errorf("expected reordered frames starting at the cut, got %+v", spans[0])
+	}
+}
+
+func TestRepackInitialsAcrossFlight(t *testing.T) {
+	dcid := []byte{0x7e, 0x9a, 0xc4, 0x00, 0x00, 0x00, 0x00, 0x02}
+	host := "flight.example.com"
+	hello := testClientHello(host, 1400)
+	split := 1100
+
+	a, _ := BuildInitial(VersionV1, dcid, nil, 0, AppendCryptoFrame(nil, 0, hello[:split]), MinInitialSize)
+	b, _ := BuildInitial(VersionV1, dcid, nil, 1, AppendCryptoFrame(nil, uint64(split), hello[split:]), MinInitialSize)
+
+	sniOff, sniLen := locateSNIInClientHello(hello)
+	cut := sniOff + sniLen/2
+
+	out, err := RepackInitials([][]byte{a, b}, cut, RepackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, spans := reassemble(t, out)
+	if !bytes.Equal(stream, hello) {
+		t.Fatal("reassembled ClientHello differs from the original")
+	}
+	for i, pkt := range out {
+		if bytes.Contains(pkt, []byte(host)) {
+			t.Errorf("datagram %d leaks the SNI in cleartext", i)
+		}
+		hdr, _, _ := OpenInitial(pkt)
+		if hdr.PN != uint32(i) {
+			t.Errorf("datagram %d: packet number %d", i, hdr.PN)
+		}
+	}
+	endsAtCut, startsAtCut := false, false
+	for _, s := range spans[0] {
+		endsAtCut = endsAtCut || s.Off+s.Len == cut
+		if s.Off >= cut {
+			t.Errorf("first datagram carries data after the cut: %+v", s)
+		}
+	}
+	for _, s := range spans[1] {
+		startsAtCut = startsAtCut || s.Off == cut
+		if s.Off < cut && s.Off+s.Len > cut {
+			t.Errorf("second datagram straddles the cut: %+v", s)
+		}
+	}
+	if !endsAtCut || !startsAtCut {
+		t.Errorf("SNI halves around cut %d are not in different datagrams: %+v / %+v", cut, spans[0], spans[1])
+	}
+}
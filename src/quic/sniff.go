@@ -96,3 +96,20 @@ func ExtractCrypto(initial []byte) ([]byte, bool) {
 	}
 	return nil, false
 }
+
+func ParseSCID(b []byte) []byte {
+	dcid := ParseDCID(b)
+	if dcid == nil {
+		return nil
+	}
+	off := 1 + 4 + 1 + len(dcid)
+	if len(b) < off+1 {
+		return nil
+	}
+	slen := int(b[off])
+	off++
+	if len(b) < off+slen {
+		return nil
+	}
+	return b[off : off+slen]
+}
@@ -0,0 +1,170 @@
+package sni
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// isGREASE reports whether v is one of the RFC 8701 reserved values clients
+// sprinkle into their lists. Fingerprints leave them out.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// uint16s reads a list of two byte values, dropping GREASE.
+func uint16s(b []byte) []uint16 {
+	out := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		if v := uint16(b[i])<<8 | uint16(b[i+1]); !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func withoutGREASE(vs []uint16) []uint16 {
+	out := make([]uint16, 0, len(vs))
+	for _, v := range vs {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ja3 returns the MD5 of the JA3 string
+// "version,ciphers,extensions,groups,point formats".
+func (m *helloMeta) ja3() string {
+	if m.version == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(int(m.version)))
+	for _, list := range [][]uint16{uint16s(m.ciphers), withoutGREASE(m.exts), uint16s(m.groups)} {
+		sb.WriteByte(',')
+		for i, v := range list {
+			if i > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteString(strconv.Itoa(int(v)))
+		}
+	}
+	sb.WriteByte(',')
+	for i, v := range m.points {
+		if i > 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteString(strconv.Itoa(int(v)))
+	}
+	sum := md5.Sum([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4 returns the JA4 fingerprint: protocol, TLS version, SNI presence,
+// cipher and extension counts and ALPN, then truncated hashes of the sorted
+// ciphers and of the sorted extensions with the signature algorithms.
+func (m *helloMeta) ja4(quic bool) string {
+	if m.version == 0 {
+		return ""
+	}
+	ciphers := uint16s(m.ciphers)
+	exts := withoutGREASE(m.exts)
+
+	proto := byte('t')
+	if quic {
+		proto = 'q'
+	}
+	sni := byte('i')
+	if slices.Contains(exts, tlsExtServerName) {
+		sni = 'd'
+	}
+	version := m.version
+	if vs := uint16s(m.versions); len(vs) > 0 {
+		version = slices.Max(vs)
+	}
+	a := fmt.Sprintf("%c%s%c%02d%02d%s", proto, ja4Version(version), sni,
+		min(len(ciphers), 99), min(len(exts), 99), ja4ALPN(m.alpns))
+
+	slices.Sort(ciphers)
+	b := ja4Hash(hexList(ciphers))
+
+	sorted := make([]uint16, 0, len(exts))
+	for _, e := range exts {
+		if e != tlsExtServerName && e != 16 {
+			sorted = append(sorted, e)
+		}
+	}
+	slices.Sort(sorted)
+	c := hexList(sorted)
+	if sigAlgs := uint16s(m.sigAlgs); len(sigAlgs) > 0 {
+		c += "_" + hexList(sigAlgs)
+	}
+	return a + "_" + b + "_" + ja4Hash(c)
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	case 0xfeff:
+		return "d1"
+	case 0xfefd:
+		return "d2"
+	case 0xfefc:
+		return "d3"
+	}
+	return "00"
+}
+
+// ja4ALPN returns the first and last characters of the first ALPN value,
+// or of its hex form when either is not alphanumeric.
+func ja4ALPN(alpns []string) string {
+	if len(alpns) == 0 || alpns[0] == "" {
+		return "00"
+	}
+	p := alpns[0]
+	first, last := p[0], p[len(p)-1]
+	if !isAlnum(first) || !isAlnum(last) {
+		h := hex.EncodeToString([]byte(p))
+		return h[:1] + h[len(h)-1:]
+	}
+	return string([]byte{first, last})
+}
+
+func isAlnum(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func hexList(vs []uint16) string {
+	var sb strings.Builder
+	for i, v := range vs {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%04x", v)
+	}
+	return sb.String()
+}
+
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
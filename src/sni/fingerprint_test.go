@@ -0,0 +1,73 @@
+package sni
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// testHelloBody builds a ClientHello body with GREASE in its cipher,
+// extension and group lists.
+func testHelloBody() []byte {
+	ext := func(typ uint16, data ...byte) []byte {
+		return append([]byte{byte(typ >> 8), byte(typ), 0, byte(len(data))}, data...)
+	}
+	host := "example.com"
+	sniExt := append([]byte{0, byte(len(host) + 3), 0, 0, byte(len(host))}, host...)
+
+	var exts []byte
+	exts = append(exts, ext(0x1a1a)...)
+	exts = append(exts, ext(0, sniExt...)...)
+	exts = append(exts, ext(10, 0, 6, 0x2a, 0x2a, 0x00, 0x1d, 0x00, 0x17)...)
+	exts = append(exts, ext(11, 1, 0)...)
+	exts = append(exts, ext(13, 0, 4, 0x04, 0x03, 0x08, 0x04)...)
+	exts = append(exts, ext(16, 0, 3, 2, 'h', '2')...)
+	exts = append(exts, ext(43, 4, 0x03, 0x04, 0x03, 0x03)...)
+
+	body := []byte{0x03, 0x03}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0)                                        // session id
+	body = append(body, 0, 6, 0x0a, 0x0a, 0x13, 0x01, 0xc0, 0x2b) // ciphers
+	body = append(body, 1, 0)                                     // compression
+	body = append(body, byte(len(exts)>>8), byte(len(exts)))
+	return append(body, exts...)
+}
+
+func TestFingerprints(t *testing.T) {
+	m := parseTLSClientHelloMeta(testHelloBody())
+	if m.sni != "example.com" || len(m.alpns) != 1 {
+		t.Fatalf("parsed sni %q alpn %v", m.sni, m.alpns)
+	}
+
+	ja3 := md5.Sum([]byte("771,4865-49195,0-10-11-13-16-43,29-23,0"))
+	if got := m.ja3(); got != hex.EncodeToString(ja3[:]) {
+		t.Errorf("JA3 = %s, want %x", got, ja3)
+	}
+
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:6])
+	}
+	want := "t13d0206h2_" + hash("1301,c02b") + "_" + hash("000a,000b,000d,002b_0403,0804")
+	if got := m.ja4(false); got != want {
+		t.Errorf("JA4 = %s, want %s", got, want)
+	}
+	if got := m.ja4(true); !strings.HasPrefix(got, "q13d") {
+		t.Errorf("QUIC JA4 = %s, want q13d prefix", got)
+	}
+}
+
+func TestJA4ALPN(t *testing.T) {
+	tests := map[string]string{"": "00", "h2": "h2", "http/1.1": "h1", "h2\xff": "6f"}
+	for alpn, want := range tests {
+		var alpns []string
+		if alpn != "" {
+			alpns = []string{alpn}
+		}
+		if got := ja4ALPN(alpns); got != want {
+			t.Errorf("ja4ALPN(%q) = %s, want %s", alpn, got, want)
+		}
+	}
+}
@@ -30,6 +30,7 @@ type SuffixSet struct {
 	multiSets  map[string][]*config.SetConfig // domain -> multiple sets (for source device priority)
 	regexes    []*regexWithSet
 	regexCache sync.Map
+	fpSets     []*config.SetConfig // sets targeting ClientHellos by fingerprint alone
 	ipRanger   cidranger.Ranger
 	portRanges []portRange
 
@@ -50,6 +51,10 @@ type SuffixSet struct {
 	learnedIPTTL        time.Duration
 
 	regexCacheSize int32
+
+	// deviceSets restricts devices with a policy profile to their assigned
+	// set IDs, keyed by upper-case MAC. An empty map matches no set.
+	deviceSets map[string]map[string]bool
 }
 
 type cacheEntry struct {
@@ -96,11 +101,15 @@ func NewSuffixSet(sets []*config.SetConfig) *SuffixSet {
 	}
 
 	seenRegexes := make(map[string]bool)
+	now := time.Now()
 
 	for _, set := range sets {
-		if !set.Enabled {
+		if !set.IsActive(now) {
 			continue
 		}
+		if set.Targets.FingerprintOnly() {
+			s.fpSets = append(s.fpSets, set)
+		}
 		for _, d := range set.Targets.DomainsToMatch {
 			d = strings.ToLower(strings.TrimSpace(d))
 			if d == "" {
@@ -536,9 +545,31 @@ func (s *SuffixSet) MatchUDPPortOnly(dport uint16) (bool, *config.SetConfig) {
 	return false, nil
 }
 
+// SetDeviceSets installs per-device set assignments (see
+// config.Config.DeviceSetAssignments). Call before the matcher is shared.
+func (s *SuffixSet) SetDeviceSets(assignments map[string]map[string]bool) {
+	if len(assignments) == 0 {
+		s.deviceSets = nil
+		return
+	}
+	s.deviceSets = assignments
+}
+
+// deviceAllows reports whether the device profile of srcMAC permits set.
+func (s *SuffixSet) deviceAllows(set *config.SetConfig, srcMAC string) bool {
+	if s.deviceSets == nil || srcMAC == "" {
+		return true
+	}
+	allowed, ok := s.deviceSets[strings.ToUpper(srcMAC)]
+	return !ok || allowed[set.Id]
+}
+
 // setMatchesSource checks if srcMAC is allowed by the set's source device filter.
 // Returns true if the set has no source devices (matches any source) or if srcMAC is in the list.
-func setMatchesSource(set *config.SetConfig, srcMAC string) bool {
+func (s *SuffixSet) setMatchesSource(set *config.SetConfig, srcMAC string) bool {
+	if !s.deviceAllows(set, srcMAC) {
+		return false
+	}
 	if len(set.Targets.SourceDevices) == 0 {
 		return true
 	}
@@ -585,7 +616,7 @@ func (s *SuffixSet) matchDomainWithSource(host string, srcMAC string) (bool, *co
 		return false, nil
 	}
 
-	return selectSetBySource(candidates, srcMAC)
+	return s.selectSetBySource(candidates, srcMAC)
 }
 
 // findDomainCandidates returns all sets that match the given host via exact or suffix match.
@@ -621,7 +652,7 @@ func (s *SuffixSet) matchRegexWithSource(host string, srcMAC string) (bool, *con
 	if len(candidates) == 0 {
 		return false, nil
 	}
-	return selectSetBySource(candidates, srcMAC)
+	return s.selectSetBySource(candidates, srcMAC)
 }
 
 // MatchIPWithSource matches an IP with source device priority.
@@ -640,7 +671,7 @@ func (s *SuffixSet) MatchIPWithSource(ip net.IP, srcMAC string) (bool, *config.S
 		candidates = append(candidates, e.(*ipRange).set)
 	}
 
-	return selectSetBySource(candidates, srcMAC)
+	return s.selectSetBySource(candidates, srcMAC)
 }
 
 // MatchLearnedIPWithSource matches a learned IP with source device priority.
@@ -675,7 +706,7 @@ func (s *SuffixSet) MatchLearnedIPWithSource(ip net.IP, srcMAC string) (bool, *c
 		return false, nil, ""
 	}
 
-	if !setMatchesSource(entry.set, srcMAC) {
+	if !s.setMatchesSource(entry.set, srcMAC) {
 		// The learned set doesn't match this source device.
 		// Try to find another set that matches this domain + source.
 		if matched, altSet := s.MatchSNIWithSource(entry.domain, srcMAC); matched {
@@ -691,23 +722,39 @@ func (s *SuffixSet) MatchLearnedIPWithSource(ip net.IP, srcMAC string) (bool, *c
 	return true, entry.set, entry.domain
 }
 
+// MatchFingerprintWithSource matches a ClientHello's fingerprints against
+// the sets that target fingerprints regardless of destination.
+func (s *SuffixSet) MatchFingerprintWithSource(ja3, ja4, srcMAC string) (bool, *config.SetConfig) {
+	if s == nil || len(s.fpSets) == 0 || (ja3 == "" && ja4 == "") {
+		return false, nil
+	}
+	var candidates []*config.SetConfig
+	for _, set := range s.fpSets {
+		if set.Targets.FingerprintAllowed(ja3, ja4) {
+			candidates = append(candidates, set)
+		}
+	}
+	return s.selectSetBySource(candidates, srcMAC)
+}
+
 // selectSetBySource picks the best matching set from candidates using source device priority.
 // Sets with source_devices that match srcMAC take priority over sets without source_devices.
-func selectSetBySource(candidates []*config.SetConfig, srcMAC string) (bool, *config.SetConfig) {
+// Sets outside the device's profile assignment are never selected.
+func (s *SuffixSet) selectSetBySource(candidates []*config.SetConfig, srcMAC string) (bool, *config.SetConfig) {
 	if len(candidates) == 0 {
 		return false, nil
 	}
 
 	// First pass: prefer sets WITH source devices that match this srcMAC
 	for _, set := range candidates {
-		if len(set.Targets.SourceDevices) > 0 && setMatchesSource(set, srcMAC) {
+		if len(set.Targets.SourceDevices) > 0 && s.setMatchesSource(set, srcMAC) {
 			return true, set
 		}
 	}
 
 	// Second pass: fall back to sets WITHOUT source devices (general sets)
 	for _, set := range candidates {
-		if len(set.Targets.SourceDevices) == 0 {
+		if len(set.Targets.SourceDevices) == 0 && s.deviceAllows(set, srcMAC) {
 			return true, set
 		}
 	}
@@ -6,25 +6,40 @@ import (
 )
 
 func ParseQUICClientHelloSNI(payload []byte) (string, bool) {
-	if !quic.IsInitial(payload) {
+	info, ok := ParseQUICClientHello(payload)
+	if !ok {
 		return "", false
 	}
+	return info.Host(), true
+}
+
+// ParseQUICClientHello decrypts a client Initial and returns the ClientHello
+// details once the CRYPTO stream carries the server name.
+func ParseQUICClientHello(payload []byte) (ClientHelloInfo, bool) {
+	if !quic.IsInitial(payload) {
+		return ClientHelloInfo{}, false
+	}
 	dcid := quic.ParseDCID(payload)
 
 	plain, ok := quic.DecryptInitial(dcid, payload)
 	if !ok {
-		return "", false
+		return ClientHelloInfo{}, false
 	}
 	crypto, ok := assembleSafe(dcid, plain)
 	if !ok || len(crypto) == 0 {
-		return "", false
+		return ClientHelloInfo{}, false
 	}
 	host, err := extractSNIFromQUIC(crypto)
 	if err != nil || host == nil || len(host) == 0 {
-		return "", false
+		return ClientHelloInfo{}, false
 	}
 	quic.ClearDCID(dcid)
-	return string(host), true
+
+	var m helloMeta
+	if len(crypto) >= 4 && crypto[0] == tlsHandshakeClientHello {
+		m = parseTLSClientHelloMeta(crypto[4:])
+	}
+	return newClientHelloInfo(string(host), &m, true), true
 }
 
 func assembleSafe(dcid, plain []byte) ([]byte, bool) {
@@ -56,7 +56,49 @@ func contains(s string, char byte) bool {
 	return false
 }
 
+// ClientHelloInfo is what b4 extracts from a ClientHello.
+// When an encrypted_client_hello extension is present the cleartext
+// server_name belongs to the ClientHelloOuter and is reported in OuterSNI
+// instead of SNI. GREASE ECH looks the same on the wire, so OuterSNI may
+// still hold the real destination.
+type ClientHelloInfo struct {
+	SNI      string
+	OuterSNI string
+	HasECH   bool
+	ALPN     []string
+	JA3      string // MD5 of the JA3 string
+	JA4      string
+}
+
+// Host returns the cleartext server name regardless of ECH.
+func (i ClientHelloInfo) Host() string {
+	if i.HasECH {
+		return i.OuterSNI
+	}
+	return i.SNI
+}
+
+func newClientHelloInfo(sni string, m *helloMeta, quic bool) ClientHelloInfo {
+	info := ClientHelloInfo{HasECH: m.hasECH, ALPN: m.alpns, JA3: m.ja3(), JA4: m.ja4(quic)}
+	if m.hasECH {
+		info.OuterSNI = sni
+	} else {
+		info.SNI = sni
+	}
+	return info
+}
+
 func ParseTLSClientHelloSNI(b []byte) (string, bool) {
+	info, ok := ParseTLSClientHello(b)
+	if !ok {
+		return "", false
+	}
+	return info.Host(), true
+}
+
+// ParseTLSClientHello locates the first ClientHello in b and returns its
+// server name, ECH presence and ALPN list.
+func ParseTLSClientHello(b []byte) (ClientHelloInfo, bool) {
 	i := 0
 	for i+5 <= len(b) {
 		if b[i] != 0x16 {
@@ -99,9 +141,10 @@ func ParseTLSClientHelloSNI(b []byte) (string, bool) {
 			}
 
 			ch := rec[4 : 4+hl]
-			sni, hasECH, _ := parseTLSClientHelloMeta(ch)
+			m := parseTLSClientHelloMeta(ch)
+			sni := m.sni
 			if sni == "" {
-				if hasECH {
+				if m.hasECH {
 					log.Tracef("TLS: ECH present, no clear SNI")
 				} else {
 					log.Tracef("TLS: SNI missing")
@@ -116,15 +159,15 @@ func ParseTLSClientHelloSNI(b []byte) (string, bool) {
 				continue
 			}
 
-			return sni, true
+			return newClientHelloInfo(sni, &m, false), true
 		}
 		i += 5 + recLen
 	}
-	return "", false
+	return ClientHelloInfo{}, false
 }
 
 func ParseTLSClientHelloBodySNI(ch []byte) (string, bool) {
-	sni, _, _ := parseTLSClientHelloMeta(ch)
+	sni := parseTLSClientHelloMeta(ch).sni
 	if sni == "" {
 		return "", false
 	}
@@ -136,80 +179,95 @@ func ParseTLSClientHelloBodySNI(ch []byte) (string, bool) {
 	return sni, true
 }
 
-func parseTLSClientHelloMeta(ch []byte) (string, bool, []string) {
+// helloMeta is what parseTLSClientHelloMeta reads from a ClientHello body:
+// the fields b4 matches on and the ones its fingerprints are made of.
+type helloMeta struct {
+	sni    string
+	hasECH bool
+	alpns  []string
+
+	version  uint16
+	ciphers  []byte   // cipher_suites, two bytes each
+	exts     []uint16 // extension types in order
+	groups   []byte   // supported_groups, two bytes each
+	points   []byte   // ec_point_formats
+	sigAlgs  []byte   // signature_algorithms, two bytes each
+	versions []byte   // supported_versions, two bytes each
+}
+
+func parseTLSClientHelloMeta(ch []byte) helloMeta {
+	var m helloMeta
 	p := 0
 	chLen := len(ch)
 
 	// Version (2 bytes)
 	if p+2 > chLen {
-		return "", false, nil
+		return m
 	}
+	m.version = uint16(ch[p])<<8 | uint16(ch[p+1])
 	p += 2
 
 	// Random (32 bytes)
 	if p+32 > chLen {
-		return "", false, nil
+		return m
 	}
 	p += 32
 
 	// Session ID
 	if p+1 > chLen {
-		return "", false, nil
+		return m
 	}
 	sidLen := int(ch[p])
 	p++
 	if p+sidLen > chLen {
-		return "", false, nil
+		return m
 	}
 	p += sidLen
 
 	// Cipher suites
 	if p+2 > chLen {
-		return "", false, nil
+		return m
 	}
 	csLen := int(ch[p])<<8 | int(ch[p+1])
 	p += 2
 	if p+csLen > chLen {
-		return "", false, nil
+		return m
 	}
+	m.ciphers = ch[p : p+csLen]
 	p += csLen
 
 	// Compression methods
 	if p+1 > chLen {
-		return "", false, nil
+		return m
 	}
 	cmLen := int(ch[p])
 	p++
 	if p+cmLen > chLen {
-		return "", false, nil
+		return m
 	}
 	p += cmLen
 
 	// Extensions - be tolerant if truncated
 	if p+2 > chLen {
-		return "", false, nil
+		return m
 	}
 	extLen := int(ch[p])<<8 | int(ch[p+1])
 	p += 2
 	if extLen == 0 {
-		return "", false, nil
+		return m
 	}
 
 	// Handle truncated extensions
 	if p+extLen > chLen {
 		extLen = chLen - p
 		if extLen <= 0 {
-			return "", false, nil
+			return m
 		}
 	}
 
 	exts := ch[p : p+extLen]
 	extEnd := len(exts)
 
-	var sni string
-	var hasECH bool
-	var alpns []string
-
 	q := 0
 	for q+4 <= extEnd {
 		// Extension type (2 bytes)
@@ -224,26 +282,52 @@ func parseTLSClientHelloMeta(ch []byte) (string, bool, []string) {
 		}
 
 		ed := exts[q : q+el]
+		m.exts = append(m.exts, uint16(et))
 
 		switch et {
 		case 0: // Server Name extension
 			sniStr := extractSNIFromExtension(ed)
 			if sniStr != "" {
-				sni = sniStr
+				m.sni = sniStr
 			}
 
 		case 16: // ALPN extension
-			alpns = extractALPNFromExtension(ed)
+			m.alpns = extractALPNFromExtension(ed)
+
+		case 10: // Supported groups
+			m.groups = vectorBody(ed, 2)
+
+		case 11: // EC point formats
+			m.points = vectorBody(ed, 1)
+
+		case 13: // Signature algorithms
+			m.sigAlgs = vectorBody(ed, 2)
+
+		case 43: // Supported versions
+			m.versions = vectorBody(ed, 1)
 
 		default:
 			if et == 0xfe0d || et == 0xfe0e || et == 0xfe0f {
-				hasECH = true
+				m.hasECH = true
 			}
 		}
 		q += el
 	}
 
-	return sni, hasECH, alpns
+	return m
+}
+
+// vectorBody returns the items of a TLS vector with a lenBytes long length
+// prefix, cut to what the data holds.
+func vectorBody(ed []byte, lenBytes int) []byte {
+	if len(ed) < lenBytes {
+		return nil
+	}
+	n := int(ed[0])
+	if lenBytes == 2 {
+		n = n<<8 | int(ed[1])
+	}
+	return ed[lenBytes:min(lenBytes+n, len(ed))]
 }
 
 func extractSNIFromExtension(ed []byte) string {
@@ -0,0 +1,107 @@
+package sock
+
+import (
+	"net"
+	"unsafe"
+
+	"github.com/daniellavrushin/b4/log"
+	"golang.org/x/sys/unix"
+)
+
+// maxBatch caps the messages handed to one sendmmsg call.
+const maxBatch = 64
+
+// mmsghdr is struct mmsghdr, which x/sys/unix does not define.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// SendBatchIPv4 sends packets to destIP back to back, in one sendmmsg call
+// per maxBatch packets, or one sendto each where sendmmsg is unavailable.
+// A packet failing does not stop the rest; the first error is returned.
+func (s *Sender) SendBatchIPv4(packets [][]byte, destIP net.IP) error {
+	if len(packets) == 1 {
+		return s.SendIPv4(packets[0], destIP)
+	}
+	log.Tracef("Sending %d IPv4 packets to %s", len(packets), destIP.String())
+	var addr unix.RawSockaddrInet4
+	addr.Family = unix.AF_INET
+	copy(addr.Addr[:], destIP.To4())
+	return s.sendBatch(s.fd4, packets, unsafe.Pointer(&addr), unix.SizeofSockaddrInet4, func(p []byte) error {
+		return s.SendIPv4(p, destIP)
+	})
+}
+
+// SendBatchIPv6 is SendBatchIPv4 for IPv6 packets.
+func (s *Sender) SendBatchIPv6(packets [][]byte, destIP net.IP) error {
+	if s.fd6 < 0 {
+		return nil
+	}
+	if len(packets) == 1 {
+		return s.SendIPv6(packets[0], destIP)
+	}
+	log.Tracef("Sending %d IPv6 packets to %s", len(packets), destIP.String())
+	var addr unix.RawSockaddrInet6
+	addr.Family = unix.AF_INET6
+	copy(addr.Addr[:], destIP.To16())
+	return s.sendBatch(s.fd6, packets, unsafe.Pointer(&addr), unix.SizeofSockaddrInet6, func(p []byte) error {
+		return s.SendIPv6(p, destIP)
+	})
+}
+
+func (s *Sender) sendBatch(fd int, packets [][]byte, addr unsafe.Pointer, addrLen uint32, sendOne func([]byte) error) error {
+	var firstErr error
+	note := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if s.noMmsg.Load() {
+		for _, p := range packets {
+			note(sendOne(p))
+		}
+		return firstErr
+	}
+
+	n := min(len(packets), maxBatch)
+	hdrs := make([]mmsghdr, n)
+	iovs := make([]unix.Iovec, n)
+
+	for len(packets) > 0 {
+		batch := packets[:min(len(packets), maxBatch)]
+		for i, p := range batch {
+			iovs[i] = unix.Iovec{}
+			if len(p) > 0 {
+				iovs[i].Base = &p[0]
+			}
+			iovs[i].SetLen(len(p))
+			hdrs[i] = mmsghdr{}
+			hdrs[i].hdr.Name = (*byte)(addr)
+			hdrs[i].hdr.Namelen = addrLen
+			hdrs[i].hdr.Iov = &iovs[i]
+			hdrs[i].hdr.SetIovlen(1)
+		}
+
+		s.syscalls.Add(1)
+		r, _, errno := unix.Syscall6(unix.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(batch)), 0, 0, 0)
+		switch {
+		case errno == unix.ENOSYS:
+			log.Warnf("sendmmsg unavailable, sending packets one at a time")
+			s.noMmsg.Store(true)
+			for _, p := range packets {
+				note(sendOne(p))
+			}
+			return firstErr
+		case errno != 0 || r == 0:
+			// Nothing went out: the first packet failed, skip past it
+			if errno != 0 {
+				note(errno)
+			}
+			r = 1
+		}
+		packets = packets[int(r):]
+	}
+	return firstErr
+}
@@ -0,0 +1,36 @@
+package sock
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSendBatchIPv4(t *testing.T) {
+	s, err := NewSenderWithMark(0)
+	if err != nil {
+		t.Skipf("raw socket unavailable: %v", err)
+	}
+	defer s.Close()
+
+	dst := net.IPv4(127, 0, 0, 1)
+	pkts := make([][]byte, 5)
+	for i := range pkts {
+		pkts[i] = buildMinimalIPv4TCPPacket(100)
+		copy(pkts[i][16:20], dst.To4())
+	}
+
+	if err := s.SendBatchIPv4(pkts, dst); err != nil {
+		t.Fatalf("SendBatchIPv4: %v", err)
+	}
+	if n := s.syscalls.Load(); n != 1 {
+		t.Errorf("batch of %d took %d syscalls, want 1", len(pkts), n)
+	}
+
+	s.noMmsg.Store(true)
+	if err := s.SendBatchIPv4(pkts, dst); err != nil {
+		t.Fatalf("SendBatchIPv4 without sendmmsg: %v", err)
+	}
+	if n := s.syscalls.Load(); n != 1+uint64(len(pkts)) {
+		t.Errorf("fallback took %d syscalls, want %d", n-1, len(pkts))
+	}
+}
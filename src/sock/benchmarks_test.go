@@ -1,6 +1,7 @@
 package sock
 
 import (
+	"net"
 	"testing"
 
 	"github.com/daniellavrushin/b4/config"
@@ -44,3 +45,45 @@ func BenchmarkStripSACKFromTCP(b *testing.B) {
 		StripSACKFromTCP(pkt)
 	}
 }
+
+func newBenchSender(b *testing.B) *Sender {
+	s, err := NewSenderWithMark(0)
+	if err != nil {
+		b.Skipf("raw socket unavailable: %v", err)
+	}
+	b.Cleanup(s.Close)
+	return s
+}
+
+// A fake sequence or duplication burst of 10 packets, sent one by one and
+// batched; syscalls/op shows what sendmmsg saves.
+func benchmarkBurst(b *testing.B, send func(s *Sender, pkts [][]byte, dst net.IP)) {
+	s := newBenchSender(b)
+	dst := net.IPv4(127, 0, 0, 1)
+	pkts := make([][]byte, 10)
+	for i := range pkts {
+		pkts[i] = buildMinimalIPv4TCPPacket(500)
+		copy(pkts[i][16:20], dst.To4())
+	}
+
+	start := s.syscalls.Load()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		send(s, pkts, dst)
+	}
+	b.ReportMetric(float64(s.syscalls.Load()-start)/float64(b.N), "syscalls/op")
+}
+
+func BenchmarkSendIPv4Burst(b *testing.B) {
+	benchmarkBurst(b, func(s *Sender, pkts [][]byte, dst net.IP) {
+		for _, p := range pkts {
+			_ = s.SendIPv4(p, dst)
+		}
+	})
+}
+
+func BenchmarkSendBatchIPv4Burst(b *testing.B) {
+	benchmarkBurst(b, func(s *Sender, pkts [][]byte, dst net.IP) {
+		_ = s.SendBatchIPv4(pkts, dst)
+	})
+}
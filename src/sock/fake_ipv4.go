@@ -21,7 +21,7 @@ func BuildFakeSNIPacketV4(original []byte, cfg *config.SetConfig) []byte {
 		originalTLS = original[payloadStart:]
 	}
 
-	var fakePayload = GetPayload(&cfg.Faking)
+	var fakePayload = GetPayloadFor(&cfg.Faking, originalTLS)
 
 	if len(cfg.Faking.TLSMod) > 0 {
 		flags := ParseTLSMod(cfg.Faking.TLSMod)
@@ -22,7 +22,7 @@ func BuildFakeSNIPacketV6(original []byte, cfg *config.SetConfig) []byte {
 		originalTLS = original[ipv6HdrLen+tcpHdrLen:]
 	}
 
-	var fakePayload = GetPayload(&cfg.Faking)
+	var fakePayload = GetPayloadFor(&cfg.Faking, originalTLS)
 
 	if len(cfg.Faking.TLSMod) > 0 {
 		flags := ParseTLSMod(cfg.Faking.TLSMod)
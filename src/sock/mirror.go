@@ -0,0 +1,184 @@
+package sock
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	mrand "math/rand/v2"
+
+	"github.com/daniellavrushin/b4/capture"
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+)
+
+const (
+	tlsExtSNI      = 0x0000
+	tlsExtPadding  = 0x0015
+	tlsExtKeyShare = 0x0033
+)
+
+// MirrorClientHello rebuilds the client's ClientHello record as a fake: the
+// cipher suites, extensions and their order, key share groups and padding
+// stay, the server name becomes one of decoys, and random, session ID and
+// key shares are fresh. The padding extension absorbs the SNI length change
+// so the fake keeps the original length when it can. It returns false for
+// anything that is not one whole ClientHello record.
+func MirrorClientHello(payload []byte, decoys []string) ([]byte, bool) {
+	if len(payload) < 5 || payload[0] != 0x16 {
+		return nil, false
+	}
+	recLen := int(binary.BigEndian.Uint16(payload[3:5]))
+	if len(payload) < 5+recLen {
+		return nil, false
+	}
+	hs := payload[5 : 5+recLen]
+	if len(hs) < 4+2+32+1 || hs[0] != 0x01 {
+		return nil, false
+	}
+	body := hs[4:]
+	if hsLen := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3]); hsLen != len(body) {
+		return nil, false
+	}
+
+	p := 2 + 32
+	sidLen := int(body[p])
+	p += 1 + sidLen
+	if p+2 > len(body) {
+		return nil, false
+	}
+	p += 2 + int(binary.BigEndian.Uint16(body[p:]))
+	if p+1 > len(body) {
+		return nil, false
+	}
+	p += 1 + int(body[p])
+	if p+2 > len(body) {
+		return nil, false
+	}
+	fixed := body[:p] // version through compression methods
+	exts := body[p+2:]
+	if int(binary.BigEndian.Uint16(body[p:])) != len(exts) {
+		return nil, false
+	}
+
+	var out [][]byte
+	padding := -1
+	grow := 0
+	for q := 0; q < len(exts); {
+		if q+4 > len(exts) {
+			return nil, false
+		}
+		typ := binary.BigEndian.Uint16(exts[q:])
+		l := int(binary.BigEndian.Uint16(exts[q+2:]))
+		if q+4+l > len(exts) {
+			return nil, false
+		}
+		ext := append([]byte(nil), exts[q:q+4+l]...)
+		q += 4 + l
+
+		switch typ {
+		case tlsExtSNI:
+			real := ""
+			if l >= 5 {
+				real = string(ext[9:])
+			}
+			decoy := pickMirrorSNI(decoys, real)
+			sni := make([]byte, 0, 9+len(decoy))
+			sni = binary.BigEndian.AppendUint16(sni, tlsExtSNI)
+			sni = binary.BigEndian.AppendUint16(sni, uint16(5+len(decoy)))
+			sni = binary.BigEndian.AppendUint16(sni, uint16(3+len(decoy)))
+			sni = append(sni, 0)
+			sni = binary.BigEndian.AppendUint16(sni, uint16(len(decoy)))
+			sni = append(sni, decoy...)
+			grow += len(sni) - len(ext)
+			ext = sni
+		case tlsExtPadding:
+			padding = len(out)
+		case tlsExtKeyShare:
+			randomizeKeyShares(ext[4:])
+		}
+		out = append(out, ext)
+	}
+
+	if padding >= 0 && grow != 0 {
+		n := max(len(out[padding])-4-grow, 0)
+		ext := make([]byte, 4+n)
+		binary.BigEndian.PutUint16(ext, tlsExtPadding)
+		binary.BigEndian.PutUint16(ext[2:], uint16(n))
+		out[padding] = ext
+	}
+
+	extLen := 0
+	for _, ext := range out {
+		extLen += len(ext)
+	}
+	bodyLen := len(fixed) + 2 + extLen
+	if 4+bodyLen > 0xffff {
+		return nil, false
+	}
+
+	fake := make([]byte, 0, 9+bodyLen)
+	fake = append(fake, payload[:3]...)
+	fake = binary.BigEndian.AppendUint16(fake, uint16(4+bodyLen))
+	fake = append(fake, 0x01, byte(bodyLen>>16), byte(bodyLen>>8), byte(bodyLen))
+	start := len(fake)
+	fake = append(fake, fixed...)
+	rand.Read(fake[start+2 : start+34])         // random
+	rand.Read(fake[start+35 : start+35+sidLen]) // session ID
+	fake = binary.BigEndian.AppendUint16(fake, uint16(extLen))
+	for _, ext := range out {
+		fake = append(fake, ext...)
+	}
+	return fake, true
+}
+
+// randomizeKeyShares overwrites the key_exchange of each client share,
+// keeping its group and length.
+func randomizeKeyShares(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	shares := data[2:min(2+int(binary.BigEndian.Uint16(data)), len(data))]
+	for len(shares) >= 4 {
+		l := int(binary.BigEndian.Uint16(shares[2:]))
+		if 4+l > len(shares) {
+			return
+		}
+		rand.Read(shares[4 : 4+l])
+		shares = shares[4+l:]
+	}
+}
+
+// pickMirrorSNI chooses a decoy for a mirrored fake, preferring one as long
+// as the real server name so the fake's length does not give it away.
+func pickMirrorSNI(decoys []string, real string) string {
+	if len(decoys) == 0 {
+		return config.DefaultUDPFakeSNI
+	}
+	var same []string
+	for _, d := range decoys {
+		if len(d) == len(real) {
+			same = append(same, d)
+		}
+	}
+	if len(same) > 0 {
+		return same[mrand.IntN(len(same))]
+	}
+	return decoys[mrand.IntN(len(decoys))]
+}
+
+// mirrorPayload builds a mirrored fake for the original ClientHello, or a
+// generated one for the decoy when the original cannot be mirrored.
+func mirrorPayload(faking *config.FakingConfig, original []byte) []byte {
+	if fake, ok := MirrorClientHello(original, faking.MirrorSNIs); ok {
+		return fake
+	}
+
+	decoy := pickMirrorSNI(faking.MirrorSNIs, "")
+	log.Tracef("Original ClientHello cannot be mirrored, generating one for %s", decoy)
+	hello, err := capture.GenerateTLSClientHello(decoy)
+	if err != nil {
+		fake := make([]byte, len(FakeSNI1))
+		copy(fake, FakeSNI1)
+		return fake
+	}
+	return hello
+}
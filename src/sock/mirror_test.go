@@ -0,0 +1,83 @@
+package sock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/sni"
+)
+
+func TestMirrorClientHello(t *testing.T) {
+	orig, ok := sni.ParseTLSClientHello(FakeSNI1)
+	if !ok {
+		t.Fatal("FakeSNI1 is not a ClientHello")
+	}
+
+	fake, ok := MirrorClientHello(FakeSNI1, []string{"example.org", "www.example.co"})
+	if !ok {
+		t.Fatal("MirrorClientHello failed")
+	}
+	if len(fake) != len(FakeSNI1) {
+		t.Errorf("fake is %d bytes, want %d", len(fake), len(FakeSNI1))
+	}
+
+	got, ok := sni.ParseTLSClientHello(fake)
+	if !ok {
+		t.Fatal("mirrored fake does not parse")
+	}
+	if got.Host() != "www.example.co" {
+		t.Errorf("SNI = %q, want the decoy of the same length", got.Host())
+	}
+	if got.JA3 != orig.JA3 || got.JA4 != orig.JA4 {
+		t.Errorf("fingerprint changed: %s / %s, want %s / %s", got.JA4, got.JA3, orig.JA4, orig.JA3)
+	}
+	if bytes.Equal(fake[11:43], FakeSNI1[11:43]) {
+		t.Error("random not regenerated")
+	}
+	if bytes.Equal(fake[44:76], FakeSNI1[44:76]) {
+		t.Error("session ID not regenerated")
+	}
+}
+
+// withPadding appends a padding extension of n bytes to a ClientHello record.
+func withPadding(hello []byte, n int) []byte {
+	out := append([]byte(nil), hello...)
+	out = binary.BigEndian.AppendUint16(out, tlsExtPadding)
+	out = binary.BigEndian.AppendUint16(out, uint16(n))
+	out = append(out, make([]byte, n)...)
+
+	grow := 4 + n
+	binary.BigEndian.PutUint16(out[3:], binary.BigEndian.Uint16(out[3:])+uint16(grow))
+	binary.BigEndian.PutUint16(out[7:], binary.BigEndian.Uint16(out[7:])+uint16(grow))
+	p := 9 + 2 + 32
+	p += 1 + int(out[p])
+	p += 2 + int(binary.BigEndian.Uint16(out[p:]))
+	p += 1 + int(out[p])
+	binary.BigEndian.PutUint16(out[p:], binary.BigEndian.Uint16(out[p:])+uint16(grow))
+	return out
+}
+
+func TestMirrorClientHelloPadding(t *testing.T) {
+	padded := withPadding(FakeSNI1, 64)
+	fake, ok := MirrorClientHello(padded, []string{"a.io"})
+	if !ok {
+		t.Fatal("MirrorClientHello failed")
+	}
+	got, ok := sni.ParseTLSClientHello(fake)
+	if !ok || got.Host() != "a.io" {
+		t.Fatalf("mirrored fake SNI = %q, want a.io", got.Host())
+	}
+	if len(fake) != len(padded) {
+		t.Errorf("padding did not absorb the shorter SNI: %d bytes, want %d", len(fake), len(padded))
+	}
+}
+
+func TestMirrorPayloadFallback(t *testing.T) {
+	faking := &config.FakingConfig{SNIType: config.FakePayloadMirror, MirrorSNIs: []string{"example.org"}}
+	got, ok := sni.ParseTLSClientHello(GetPayloadFor(faking, []byte("GET / HTTP/1.1\r\n")))
+	if !ok || got.Host() != "example.org" {
+		t.Errorf("fallback fake SNI = %q, want example.org", got.Host())
+	}
+}
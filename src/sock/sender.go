@@ -2,6 +2,7 @@ package sock
 
 import (
 	"net"
+	"sync/atomic"
 	"syscall"
 
 	"github.com/daniellavrushin/b4/log"
@@ -12,6 +13,9 @@ type Sender struct {
 	fd4  int
 	fd6  int
 	mark int
+
+	noMmsg   atomic.Bool   // sendmmsg failed with ENOSYS, batches go one by one
+	syscalls atomic.Uint64 // send syscalls made
 }
 
 func NewSenderWithMark(mark int) (*Sender, error) {
@@ -59,6 +63,7 @@ func (s *Sender) SendIPv4(packet []byte, destIP net.IP) error {
 	log.Tracef("Sending IPv4 packet to %s, len=%d", destIP.String(), len(packet))
 	addr := syscall.SockaddrInet4{}
 	copy(addr.Addr[:], destIP.To4())
+	s.syscalls.Add(1)
 	return syscall.Sendto(s.fd4, packet, 0, &addr)
 }
 
@@ -69,6 +74,7 @@ func (s *Sender) SendIPv6(packet []byte, destIP net.IP) error {
 	log.Tracef("Sending IPv6 packet to %s, len=%d", destIP.String(), len(packet))
 	addr := syscall.SockaddrInet6{}
 	copy(addr.Addr[:], destIP.To16())
+	s.syscalls.Add(1)
 	return syscall.Sendto(s.fd6, packet, 0, &addr)
 }
 
@@ -132,3 +132,78 @@ func injectSessionID(fakePayload, newSID []byte) []byte {
 
 	return newPayload
 }
+
+// StripECH removes encrypted_client_hello (and the draft ech_outer_extensions /
+// ech_is_inner codepoints) from a ClientHello record and fixes up the record,
+// handshake and extensions lengths. Anything that does not parse is returned as is.
+func StripECH(payload []byte) []byte {
+	if len(payload) < 5 || payload[0] != 0x16 {
+		return payload
+	}
+
+	recLen := int(binary.BigEndian.Uint16(payload[3:5]))
+	if len(payload) < 5+recLen {
+		return payload
+	}
+
+	hs := payload[5 : 5+recLen]
+	if len(hs) < 4+2+32+1 || hs[0] != 0x01 {
+		return payload
+	}
+
+	pos := 4 + 2 + 32
+	pos += 1 + int(hs[pos])
+	if pos+2 > len(hs) {
+		return payload
+	}
+	pos += 2 + int(binary.BigEndian.Uint16(hs[pos:]))
+	if pos+1 > len(hs) {
+		return payload
+	}
+	pos += 1 + int(hs[pos])
+	if pos+2 > len(hs) {
+		return payload
+	}
+
+	extLenPos := pos
+	extLen := int(binary.BigEndian.Uint16(hs[pos:]))
+	pos += 2
+	if pos+extLen > len(hs) {
+		return payload
+	}
+
+	kept := make([]byte, 0, extLen)
+	exts := hs[pos : pos+extLen]
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts[0:2])
+		l := int(binary.BigEndian.Uint16(exts[2:4]))
+		if 4+l > len(exts) {
+			return payload
+		}
+		switch typ {
+		case 0xfe0d, 0xfe0e, 0xfe0f:
+		default:
+			kept = append(kept, exts[:4+l]...)
+		}
+		exts = exts[4+l:]
+	}
+
+	removed := extLen - len(kept)
+	if removed == 0 {
+		return payload
+	}
+
+	out := make([]byte, 0, len(payload)-removed)
+	out = append(out, payload[:5+extLenPos]...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(kept)))
+	out = append(out, kept...)
+	out = append(out, payload[5+pos+extLen:]...)
+
+	binary.BigEndian.PutUint16(out[3:5], uint16(recLen-removed))
+	hsLen := (int(out[6])<<16 | int(out[7])<<8 | int(out[8])) - removed
+	out[6] = byte(hsLen >> 16)
+	out[7] = byte(hsLen >> 8)
+	out[8] = byte(hsLen)
+
+	return out
+}
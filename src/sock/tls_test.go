@@ -0,0 +1,42 @@
+package sock
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/daniellavrushin/b4/sni"
+)
+
+func TestStripECH(t *testing.T) {
+	before, ok := sni.ParseTLSClientHello(FakeSNI1)
+	if !ok || !before.HasECH {
+		t.Fatalf("FakeSNI1 should carry ECH, got %+v ok=%v", before, ok)
+	}
+
+	stripped := StripECH(FakeSNI1)
+	if len(stripped) >= len(FakeSNI1) {
+		t.Fatalf("expected payload to shrink, got %d >= %d", len(stripped), len(FakeSNI1))
+	}
+
+	after, ok := sni.ParseTLSClientHello(stripped)
+	if !ok {
+		t.Fatal("stripped ClientHello no longer parses")
+	}
+	if after.HasECH {
+		t.Error("ECH extension still present after strip")
+	}
+	if after.Host() != before.Host() {
+		t.Errorf("SNI changed: %q -> %q", before.Host(), after.Host())
+	}
+
+	if again := StripECH(stripped); !bytes.Equal(again, stripped) {
+		t.Error("stripping twice should be a no-op")
+	}
+}
+
+func TestStripECHNonTLS(t *testing.T) {
+	in := []byte{0x17, 0x03, 0x03, 0x00, 0x01, 0x00}
+	if out := StripECH(in); !bytes.Equal(out, in) {
+		t.Error("non-handshake record must be returned unchanged")
+	}
+}
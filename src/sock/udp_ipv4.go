@@ -36,6 +36,11 @@ func udpChecksumIPv4(pkt []byte) {
 }
 
 func BuildFakeUDPFromOriginalV4(orig []byte, fakeLen int, ttl uint8) ([]byte, bool) {
+	return BuildFakeUDPWithPayloadV4(orig, make([]byte, fakeLen), ttl)
+}
+
+// BuildFakeUDPWithPayloadV4 copies the IP and UDP headers of orig around payload
+func BuildFakeUDPWithPayloadV4(orig, payload []byte, ttl uint8) ([]byte, bool) {
 	if len(orig) < 20 || orig[0]>>4 != 4 {
 		return nil, false
 	}
@@ -43,6 +48,7 @@ func BuildFakeUDPFromOriginalV4(orig []byte, fakeLen int, ttl uint8) ([]byte, bo
 	if len(orig) < ihl+8 {
 		return nil, false
 	}
+	fakeLen := len(payload)
 	out := make([]byte, 20+8+fakeLen)
 	copy(out, orig[:20])
 	out[8] = ttl
@@ -52,9 +58,7 @@ func BuildFakeUDPFromOriginalV4(orig []byte, fakeLen int, ttl uint8) ([]byte, bo
 	binary.BigEndian.PutUint16(out[2:4], uint16(20+8+fakeLen))
 	copy(out[20:], orig[ihl:ihl+8])
 	binary.BigEndian.PutUint16(out[20+4:20+6], uint16(8+fakeLen))
-	for i := 0; i < fakeLen; i++ {
-		out[28+i] = 0
-	}
+	copy(out[28:], payload)
 	FixIPv4Checksum(out[:20])
 	udpChecksumIPv4(out)
 	return out, true
@@ -53,6 +53,11 @@ func udpChecksumIPv6(pkt []byte) {
 }
 
 func BuildFakeUDPFromOriginalV6(orig []byte, fakeLen int, hopLimit uint8) ([]byte, bool) {
+	return BuildFakeUDPWithPayloadV6(orig, make([]byte, fakeLen), hopLimit)
+}
+
+// BuildFakeUDPWithPayloadV6 copies the IPv6 and UDP headers of orig around payload
+func BuildFakeUDPWithPayloadV6(orig, payload []byte, hopLimit uint8) ([]byte, bool) {
 	if len(orig) < 48 || orig[0]>>4 != 6 {
 		return nil, false
 	}
@@ -62,6 +67,7 @@ func BuildFakeUDPFromOriginalV6(orig []byte, fakeLen int, hopLimit uint8) ([]byt
 		return nil, false
 	}
 
+	fakeLen := len(payload)
 	out := make([]byte, ipv6HdrLen+8+fakeLen)
 
 	// Copy IPv6 header
@@ -79,10 +85,7 @@ func BuildFakeUDPFromOriginalV6(orig []byte, fakeLen int, hopLimit uint8) ([]byt
 	// Update UDP length
 	binary.BigEndian.PutUint16(out[ipv6HdrLen+4:ipv6HdrLen+6], uint16(8+fakeLen))
 
-	// Zero out fake payload
-	for i := 0; i < fakeLen; i++ {
-		out[ipv6HdrLen+8+i] = 0
-	}
+	copy(out[ipv6HdrLen+8:], payload)
 
 	// Calculate checksum
 	udpChecksumIPv6(out)
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/events"
 	"github.com/daniellavrushin/b4/log"
 	"github.com/daniellavrushin/b4/metrics"
 	"github.com/daniellavrushin/b4/sni"
@@ -460,6 +461,17 @@ func (s *Server) logAndRecordConnection(protocol, clientAddr, dest string) {
 
 	log.Tracef("SOCKS5 %s relay: %s <-> %s (Set: %s)", protocol, clientAddr, dest, setName)
 
+	events.Publish(events.Event{
+		Type:        events.TypeConnection,
+		Protocol:    protocol,
+		Set:         setName,
+		SNISet:      sniTarget,
+		IPSet:       ipTarget,
+		Domain:      domain,
+		Source:      clientAddr,
+		Destination: dest,
+	})
+
 	// Record using base protocol so TCP/UDP counters work correctly
 	baseProtocol := "TCP"
 	if protocol == "P-UDP" {
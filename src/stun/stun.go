@@ -2,6 +2,7 @@
 package stun
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 )
 
@@ -92,3 +93,12 @@ func MessageTypeName(msgType uint16) string {
 		return "Unknown"
 	}
 }
+
+// BuildBindingRequest returns an attribute-less STUN Binding Request with a random transaction ID
+func BuildBindingRequest() []byte {
+	msg := make([]byte, 20)
+	binary.BigEndian.PutUint16(msg[0:2], BindingRequest)
+	binary.BigEndian.PutUint32(msg[4:8], 0x2112A442)
+	_, _ = rand.Read(msg[8:20])
+	return msg
+}
@@ -2,9 +2,11 @@ package tables
 
 import (
 	"bytes"
+	"errors"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/daniellavrushin/b4/config"
 	"github.com/daniellavrushin/b4/http/handler"
@@ -13,36 +15,61 @@ import (
 
 var modulesLoaded sync.Once
 
+// suspended is set while the queue workers' watchdog has the rules down.
+var suspended atomic.Bool
+
 func AddRules(cfg *config.Config) error {
 	if cfg.System.Tables.SkipSetup {
 		return nil
 	}
 
 	handler.SetTablesRefreshFunc(func() error {
+		// Putting the rules back now would send traffic to stalled workers
+		if suspended.Load() {
+			return errors.New("rules are suspended by the queue watchdog")
+		}
 		ClearRules(cfg)
 		return AddRules(cfg)
 	})
 
-	backend := detectFirewallBackend()
+	backend := detectFirewallBackend(cfg)
 	log.Tracef("Detected firewall backend: %s", backend)
 	metrics := handler.GetMetricsCollector()
 	metrics.TablesStatus = backend
 
-	if backend == "nftables" {
-		nft := NewNFTablesManager(cfg)
-		return nft.Apply()
+	var err error
+	prefilterOn := cfg.System.Tables.Prefilter
+	switch backend {
+	case "netlink":
+		err = NewNetlinkManager(cfg).Apply()
+	case "nftables":
+		err = NewNFTablesManager(cfg).Apply()
+	default:
+		ipt := NewIPTablesManager(cfg)
+		err = ipt.Apply()
+		prefilterOn = ipt.prefilter
+	}
+	if err != nil {
+		return err
 	}
 
-	ipt := NewIPTablesManager(cfg)
-
-	return ipt.Apply()
+	if prefilterOn {
+		prefilter.activate(backend, cfg)
+	} else {
+		prefilter.deactivate()
+	}
+	return nil
 }
 
 func ClearRules(cfg *config.Config) error {
+	prefilter.deactivate()
 
-	backend := detectFirewallBackend()
+	backend := detectFirewallBackend(cfg)
 
-	if backend == "nftables" {
+	switch backend {
+	case "netlink":
+		return NewNetlinkManager(cfg).Clear()
+	case "nftables":
 		nft := NewNFTablesManager(cfg)
 		return nft.Clear()
 	}
@@ -51,6 +78,24 @@ func ClearRules(cfg *config.Config) error {
 	return ipt.Clear()
 }
 
+// SuspendRules removes the rules so traffic bypasses the queues, and keeps
+// the monitor from restoring them until ResumeRules.
+func SuspendRules(cfg *config.Config) error {
+	suspended.Store(true)
+	handler.GetMetricsCollector().TablesStatus = "suspended"
+	return ClearRules(cfg)
+}
+
+// ResumeRules reinstalls the rules removed by SuspendRules.
+func ResumeRules(cfg *config.Config) error {
+	ClearRules(cfg)
+	if err := AddRules(cfg); err != nil {
+		return err
+	}
+	suspended.Store(false)
+	return nil
+}
+
 func run(args ...string) (string, error) {
 	var out bytes.Buffer
 	cmd := exec.Command(args[0], args[1:]...)
@@ -60,6 +105,16 @@ func run(args ...string) (string, error) {
 	return out.String(), err
 }
 
+func runStdin(input string, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
 func setSysctlOrProc(name, val string) {
 	_, _ = run("sh", "-c", "sysctl -w "+name+"="+val+" || echo "+val+" > /proc/sys/"+strings.ReplaceAll(name, ".", "/"))
 }
@@ -69,7 +124,14 @@ func getSysctlOrProc(name string) string {
 	return strings.TrimSpace(out)
 }
 
-func detectFirewallBackend() string {
+// detectFirewallBackend returns the configured backend, or for "auto" the nft
+// binary when it works, then iptables, and netlink when neither binary exists.
+func detectFirewallBackend(cfg *config.Config) string {
+	switch cfg.System.Tables.Backend {
+	case "nftables", "iptables", "netlink":
+		return cfg.System.Tables.Backend
+	}
+
 	if hasBinary("nft") {
 		out, err := run("nft", "list", "tables")
 		if err == nil && out != "" {
@@ -85,6 +147,10 @@ func detectFirewallBackend() string {
 		return "iptables"
 	}
 
+	if nlAvailable() {
+		return "netlink"
+	}
+
 	return "iptables"
 }
 
@@ -105,5 +171,7 @@ func loadKernelModules() {
 		_, _ = run("sh", "-c", "modprobe -q nf_nat 2>/dev/null || true")
 		_, _ = run("sh", "-c", "modprobe -q nft_masq 2>/dev/null || true")
 		_, _ = run("sh", "-c", "modprobe -q xt_MASQUERADE 2>/dev/null || true")
+		_, _ = run("sh", "-c", "modprobe -q xt_set 2>/dev/null || true")
+		_, _ = run("sh", "-c", "modprobe -q xt_connmark 2>/dev/null || true")
 	})
 }
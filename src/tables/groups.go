@@ -0,0 +1,219 @@
+package tables
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+)
+
+// Per-set rule groups: sets whose connbytes limits or UDP ports differ from the
+// main set get their own chains. Packets enter them with goto from the set's IP
+// targets or its conntrack mark, so the default group (main set limits, all
+// ports) after them never sees those connections.
+type ruleGroup struct {
+	slot     uint32 // the set's ctmark slot, names its chains and sets
+	name     string
+	mark     uint32
+	ipv4     []string
+	ipv6     []string
+	tcpLimit int
+	udpLimit int
+	udpPorts []string
+}
+
+func collectRuleGroups(cfg *config.Config) []ruleGroup {
+	if cfg.MainSet == nil {
+		return nil
+	}
+
+	var groups []ruleGroup
+	marks := cfg.CtMarks()
+	for _, set := range cfg.Sets {
+		if !cfg.HasRuleGroup(set) {
+			continue
+		}
+		mark := marks[set.Id]
+		if mark == 0 {
+			log.Warnf("Set '%s' is beyond the last rule group, using default limits", set.Name)
+			continue
+		}
+
+		g := ruleGroup{
+			slot:     mark >> 16,
+			name:     set.Name,
+			mark:     mark,
+			tcpLimit: set.TCP.ConnBytesLimit,
+			udpLimit: set.UDP.ConnBytesLimit,
+			udpPorts: set.CollectUDPPorts(),
+		}
+		g.ipv4 = prefilterElements(set.Targets.IpsToMatch, false)
+		g.ipv6 = prefilterElements(set.Targets.IpsToMatch, true)
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func (g ruleGroup) ips(v6 bool) []string {
+	if v6 {
+		return g.ipv6
+	}
+	return g.ipv4
+}
+
+func (g ruleGroup) nftChain() string   { return fmt.Sprintf("b4_set%d", g.slot) }
+func (g ruleGroup) nftChainIn() string { return g.nftChain() + "_in" }
+func (g ruleGroup) iptChain() string   { return fmt.Sprintf("B4_S%d", g.slot) }
+func (g ruleGroup) iptChainIn() string { return g.iptChain() + "_IN" }
+
+// setName returns the name of the group's target set (nftables set or ipset).
+func (g ruleGroup) setName(v6 bool) string {
+	if v6 {
+		return fmt.Sprintf("b4_set%d_6", g.slot)
+	}
+	return fmt.Sprintf("b4_set%d_4", g.slot)
+}
+
+// createRuleGroups creates the chains and target sets of all groups and fills
+// the group chains. The entry rules are added by addRuleGroupEntries.
+func (n *NFTablesManager) createRuleGroups(groups []ruleGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	var script strings.Builder
+	for _, g := range groups {
+		fmt.Fprintf(&script, "add chain inet %s %s\n", nftTableName, g.nftChain())
+		fmt.Fprintf(&script, "add chain inet %s %s\n", nftTableName, g.nftChainIn())
+		for _, f := range prefilterFamilies {
+			ips := g.ips(f.v6)
+			if !f.enabled(n.cfg) || len(ips) == 0 {
+				continue
+			}
+			addrType := "ipv4_addr"
+			if f.v6 {
+				addrType = "ipv6_addr"
+			}
+			fmt.Fprintf(&script, "add set inet %s %s { type %s ; flags interval ; auto-merge ; }\n",
+				nftTableName, g.setName(f.v6), addrType)
+			for _, chunk := range chunkStrings(ips, prefilterChunk) {
+				fmt.Fprintf(&script, "add element inet %s %s { %s }\n", nftTableName, g.setName(f.v6), strings.Join(chunk, ", "))
+			}
+		}
+	}
+	if out, err := runStdin(script.String(), "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("failed to create rule groups: %w: %s", err, strings.TrimSpace(out))
+	}
+
+	for _, g := range groups {
+		tcpLimit := fmt.Sprintf("%d", g.tcpLimit+1)
+		udpLimit := fmt.Sprintf("%d", g.udpLimit+1)
+		udpPortExpr := g.udpPorts[0]
+		if len(g.udpPorts) > 1 {
+			udpPortExpr = "{ " + strings.Join(g.udpPorts, ", ") + " }"
+		}
+
+		if err := n.addQueueRule(g.nftChain(), "tcp", "dport", "443", "ct", "original", "packets", "<", tcpLimit, "counter"); err != nil {
+			return err
+		}
+		if err := n.addQueueRule(g.nftChain(), "udp", "dport", udpPortExpr, "ct", "original", "packets", "<", udpLimit, "counter"); err != nil {
+			return err
+		}
+		if err := n.addQueueRule(g.nftChainIn(), "tcp", "sport", "443", "ct", "original", "packets", "<", tcpLimit, "counter"); err != nil {
+			return err
+		}
+		if err := n.addQueueRule(g.nftChainIn(), "tcp", "sport", "443", "tcp", "flags", "&", "(syn|ack)", "==", "(syn|ack)", "counter"); err != nil {
+			return err
+		}
+		log.Infof("NFTABLES: rule group for set '%s' (tcp %d, udp %d packets, udp ports %s)",
+			g.name, g.tcpLimit, g.udpLimit, strings.Join(g.udpPorts, ","))
+	}
+	return nil
+}
+
+// addRuleGroupEntries sends outgoing packets of each group's targets or ctmark
+// to its chain (in b4_chain) and replies to its reply chain (in prerouting).
+func (n *NFTablesManager) addRuleGroupEntries(groups []ruleGroup) error {
+	markMask := fmt.Sprintf("0x%x", config.SetCtMarkMask)
+	for _, g := range groups {
+		for _, f := range prefilterFamilies {
+			if !f.enabled(n.cfg) || len(g.ips(f.v6)) == 0 {
+				continue
+			}
+			if err := n.addRule(nftChainName, f.nftExpr, "daddr", "@"+g.setName(f.v6), "goto", g.nftChain()); err != nil {
+				return err
+			}
+			if err := n.addRule("prerouting", f.nftExpr, "saddr", "@"+g.setName(f.v6), "goto", g.nftChainIn()); err != nil {
+				return err
+			}
+		}
+
+		mark := fmt.Sprintf("0x%x", g.mark)
+		if err := n.addRule(nftChainName, "ct", "mark", "and", markMask, "==", mark, "goto", g.nftChain()); err != nil {
+			return err
+		}
+		if err := n.addRule("prerouting", "ct", "mark", "and", markMask, "==", mark, "goto", g.nftChainIn()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createRuleGroupSets creates the ipsets holding the groups' IP targets.
+func (im *IPTablesManager) createRuleGroupSets(groups []ruleGroup) error {
+	var script strings.Builder
+	for _, g := range groups {
+		for _, f := range prefilterFamilies {
+			ips := g.ips(f.v6)
+			if !f.enabled(im.cfg) || !hasBinary(f.ipt()) || len(ips) == 0 {
+				continue
+			}
+			fmt.Fprintf(&script, "create %s hash:net family %s maxelem %d\n", g.setName(f.v6), f.ipset, max(65536, len(ips)+1024))
+			fmt.Fprintf(&script, "flush %s\n", g.setName(f.v6))
+			for _, ip := range ips {
+				fmt.Fprintf(&script, "add %s %s\n", g.setName(f.v6), ip)
+			}
+		}
+	}
+	if script.Len() == 0 {
+		return nil
+	}
+	if out, err := runStdin(script.String(), "ipset", "restore", "-exist"); err != nil {
+		return fmt.Errorf("failed to create rule group ipsets: %w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// ruleGroupTargetSpecs returns the matches that select a group's targets in
+// one direction ("dst" or "src"): its connmark and, when ipset is available,
+// its ipset. Without ipset the IP targets are left out rather than matched one
+// rule per CIDR, so the group is reached through sticky sets only.
+func (im *IPTablesManager) ruleGroupTargetSpecs(g ruleGroup, ipt, dir string) [][]string {
+	mark := fmt.Sprintf("0x%x/0x%x", g.mark, config.SetCtMarkMask)
+	specs := [][]string{{"-m", "connmark", "--mark", mark}}
+	if !hasBinary("ipset") {
+		return specs
+	}
+
+	for _, f := range prefilterFamilies {
+		if f.ipt() == ipt && len(g.ips(f.v6)) > 0 {
+			specs = append(specs, []string{"-m", "set", "--match-set", g.setName(f.v6), dir})
+		}
+	}
+	return specs
+}
+
+// destroyRuleGroupSets removes the ipsets of all rule groups, including those
+// of sets that no longer exist.
+func destroyRuleGroupSets() {
+	if !hasBinary("ipset") {
+		return
+	}
+	out, _ := run("ipset", "list", "-n")
+	for _, name := range strings.Fields(out) {
+		if strings.HasPrefix(name, "b4_set") {
+			_, _ = run("ipset", "destroy", name)
+		}
+	}
+}
@@ -16,10 +16,16 @@ import (
 type IPTablesManager struct {
 	cfg              *config.Config
 	multiportSupport map[string]bool // per-binary cache (iptables vs ip6tables may differ)
+	prefilter        bool
 }
 
 func NewIPTablesManager(cfg *config.Config) *IPTablesManager {
-	return &IPTablesManager{cfg: cfg, multiportSupport: make(map[string]bool)}
+	prefilter := cfg.System.Tables.Prefilter
+	if prefilter && !hasBinary("ipset") {
+		log.Warnf("IPTABLES: ipset binary not found, prefilter disabled")
+		prefilter = false
+	}
+	return &IPTablesManager{cfg: cfg, multiportSupport: make(map[string]bool), prefilter: prefilter}
 }
 
 // hasMultiportSupport checks if iptables multiport module is available
@@ -236,13 +242,25 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 	var chains []Chain
 	var rules []Rule
 
+	groups := collectRuleGroups(cfg)
+
 	for _, ipt := range ipts {
+		// Group chains go first so they are removed after the chains jumping to them
+		for _, g := range groups {
+			chains = append(chains,
+				Chain{manager: manager, IPT: ipt, Table: "mangle", Name: g.iptChain()},
+				Chain{manager: manager, IPT: ipt, Table: "mangle", Name: g.iptChainIn()},
+			)
+		}
 		ch := Chain{manager: manager, IPT: ipt, Table: "mangle", Name: chainName}
 		chains = append(chains, ch)
 
 		tcpConnbytesRange := fmt.Sprintf("0:%d", cfg.MainSet.TCP.ConnBytesLimit)
 		udpConnbytesRange := fmt.Sprintf("0:%d", cfg.MainSet.UDP.ConnBytesLimit)
 
+		dstSets := manager.prefilterSpecs(ipt, "dst")
+		srcSets := manager.prefilterSpecs(ipt, "src")
+
 		tcpSpec := append(
 			[]string{"-p", "tcp", "--dport", "443",
 				"-m", "connbytes", "--connbytes-dir", "original",
@@ -274,9 +292,13 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 
 		rules = append(rules,
 			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "PREROUTING", Action: "I", Spec: dnsResponseSpec},
-			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "PREROUTING", Action: "I", Spec: tcpResponseSpec},
-			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "PREROUTING", Action: "I", Spec: synackSpec},
 		)
+		for _, m := range srcSets {
+			rules = append(rules,
+				Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "PREROUTING", Action: "I", Spec: append(append([]string{}, m...), tcpResponseSpec...)},
+				Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "PREROUTING", Action: "I", Spec: append(append([]string{}, m...), synackSpec...)},
+			)
+		}
 
 		// Duplication rules: queue ALL TCP/443 to specific IPs (no connbytes limit).
 		// Must come before the generic connbytes-limited TCP rule.
@@ -298,64 +320,38 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 		}
 
 		rules = append(rules,
-			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: tcpSpec},
 			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: dnsSpec},
 		)
 
-		udpPorts := cfg.CollectUDPPorts()
-		for i, p := range udpPorts {
-			udpPorts[i] = strings.ReplaceAll(p, "-", ":")
+		// Per-set rule groups come before the default group they bypass
+		rules = append(rules, manager.buildRuleGroupRules(ipt, groups)...)
+
+		for _, m := range dstSets {
+			rules = append(rules,
+				Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: append(append([]string{}, m...), tcpSpec...)},
+			)
 		}
 
-		if manager.hasMultiportSupport(ipt) {
-			// Use multiport for efficiency (batches up to 15 ports per rule)
-			udpPortChunks := chunkPorts(udpPorts, 15)
-			for _, chunk := range udpPortChunks {
-				udpPortSpec := []string{"-p", "udp", "-m", "multiport", "--dports", strings.Join(chunk, ",")}
-				udpSpec := append(
-					append(udpPortSpec,
-						"-m", "connbytes", "--connbytes-dir", "original",
-						"--connbytes-mode", "packets", "--connbytes", udpConnbytesRange),
-					manager.buildNFQSpec(queueNum, threads)...,
-				)
-				rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: udpSpec})
-			}
-		} else {
-			// Fallback: create individual rules for each port/range
-			for _, port := range udpPorts {
-				udpPortSpec := []string{"-p", "udp", "--dport", port}
-				udpSpec := append(
-					append(udpPortSpec,
-						"-m", "connbytes", "--connbytes-dir", "original",
-						"--connbytes-mode", "packets", "--connbytes", udpConnbytesRange),
-					manager.buildNFQSpec(queueNum, threads)...,
-				)
-				rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: udpSpec})
+		for _, udpSpec := range manager.buildUDPSpecs(ipt, cfg.CollectUDPPorts(), udpConnbytesRange) {
+			for _, m := range dstSets {
+				rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: chainName, Action: "A", Spec: append(append([]string{}, m...), udpSpec...)})
 			}
 		}
 
-		if cfg.Queue.Devices.Enabled && len(cfg.Queue.Devices.Mac) > 0 {
-			if cfg.Queue.Devices.WhiteIsBlack {
+		if macs, exclude := cfg.DeviceFilter(); len(macs) > 0 {
+			if exclude {
 				rules = append(rules,
 					Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "FORWARD", Action: "I",
 						Spec: []string{"-j", chainName}},
 				)
-				for _, mac := range cfg.Queue.Devices.Mac {
-					mac = strings.ToUpper(strings.TrimSpace(mac))
-					if mac == "" {
-						continue
-					}
+				for _, mac := range macs {
 					rules = append(rules,
 						Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "FORWARD", Action: "I",
 							Spec: []string{"-m", "mac", "--mac-source", mac, "-j", "RETURN"}},
 					)
 				}
 			} else {
-				for _, mac := range cfg.Queue.Devices.Mac {
-					mac = strings.ToUpper(strings.TrimSpace(mac))
-					if mac == "" {
-						continue
-					}
+				for _, mac := range macs {
 					rules = append(rules,
 						Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "FORWARD", Action: "I",
 							Spec: []string{"-m", "mac", "--mac-source", mac, "-j", chainName}},
@@ -451,6 +447,79 @@ func (manager *IPTablesManager) buildManifest() (Manifest, error) {
 	return Manifest{Chains: chains, Rules: rules, Sysctls: sysctls}, nil
 }
 
+// buildUDPSpecs returns the connbytes-limited queue specs for the given UDP
+// ports, batched with multiport when available.
+func (manager *IPTablesManager) buildUDPSpecs(ipt string, ports []string, connbytesRange string) [][]string {
+	udpPorts := make([]string, len(ports))
+	for i, p := range ports {
+		udpPorts[i] = strings.ReplaceAll(p, "-", ":")
+	}
+
+	var portSpecs [][]string
+	if manager.hasMultiportSupport(ipt) {
+		// Use multiport for efficiency (batches up to 15 ports per rule)
+		for _, chunk := range chunkPorts(udpPorts, 15) {
+			portSpecs = append(portSpecs, []string{"-p", "udp", "-m", "multiport", "--dports", strings.Join(chunk, ",")})
+		}
+	} else {
+		// Fallback: create individual rules for each port/range
+		for _, port := range udpPorts {
+			portSpecs = append(portSpecs, []string{"-p", "udp", "--dport", port})
+		}
+	}
+
+	specs := make([][]string, 0, len(portSpecs))
+	for _, portSpec := range portSpecs {
+		specs = append(specs, append(
+			append(portSpec,
+				"-m", "connbytes", "--connbytes-dir", "original",
+				"--connbytes-mode", "packets", "--connbytes", connbytesRange),
+			manager.buildNFQSpec(manager.cfg.Queue.StartNum, manager.cfg.Queue.Threads)...,
+		))
+	}
+	return specs
+}
+
+// buildRuleGroupRules returns the entry rules of the per-set rule groups and
+// the queue rules inside their chains.
+func (manager *IPTablesManager) buildRuleGroupRules(ipt string, groups []ruleGroup) []Rule {
+	nfq := manager.buildNFQSpec(manager.cfg.Queue.StartNum, manager.cfg.Queue.Threads)
+	var rules []Rule
+
+	for _, g := range groups {
+		tcpConnbytesRange := fmt.Sprintf("0:%d", g.tcpLimit)
+
+		for _, m := range manager.ruleGroupTargetSpecs(g, ipt, "dst") {
+			rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "B4", Action: "A",
+				Spec: append(append([]string{}, m...), "-g", g.iptChain())})
+		}
+		// PREROUTING rules are inserted, keep them limited to TCP/443 replies
+		// so the DNS response rule is unaffected
+		for _, m := range manager.ruleGroupTargetSpecs(g, ipt, "src") {
+			rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: "PREROUTING", Action: "I",
+				Spec: append(append([]string{"-p", "tcp", "--sport", "443"}, m...), "-g", g.iptChainIn())})
+		}
+
+		rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: g.iptChain(), Action: "A",
+			Spec: append([]string{"-p", "tcp", "--dport", "443",
+				"-m", "connbytes", "--connbytes-dir", "original",
+				"--connbytes-mode", "packets", "--connbytes", tcpConnbytesRange}, nfq...)})
+		for _, udpSpec := range manager.buildUDPSpecs(ipt, g.udpPorts, fmt.Sprintf("0:%d", g.udpLimit)) {
+			rules = append(rules, Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: g.iptChain(), Action: "A", Spec: udpSpec})
+		}
+
+		rules = append(rules,
+			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: g.iptChainIn(), Action: "A",
+				Spec: append([]string{"-p", "tcp", "--sport", "443",
+					"-m", "connbytes", "--connbytes-dir", "reply",
+					"--connbytes-mode", "packets", "--connbytes", tcpConnbytesRange}, nfq...)},
+			Rule{manager: manager, IPT: ipt, Table: "mangle", Chain: g.iptChainIn(), Action: "A",
+				Spec: append([]string{"-p", "tcp", "--sport", "443", "--tcp-flags", "SYN,ACK", "SYN,ACK"}, nfq...)},
+		)
+	}
+	return rules
+}
+
 func (ipt *IPTablesManager) Apply() error {
 	log.Infof("IPTABLES: adding rules")
 	loadKernelModules()
@@ -458,6 +527,24 @@ func (ipt *IPTablesManager) Apply() error {
 	if err != nil {
 		return err
 	}
+	if ipt.prefilter {
+		if err := ipt.createPrefilterSets(); err != nil {
+			return err
+		}
+	}
+	groups := collectRuleGroups(ipt.cfg)
+	if hasBinary("ipset") {
+		if err := ipt.createRuleGroupSets(groups); err != nil {
+			return err
+		}
+	}
+	for _, g := range groups {
+		log.Infof("IPTABLES: rule group for set '%s' (tcp %d, udp %d packets, udp ports %s)",
+			g.name, g.tcpLimit, g.udpLimit, strings.Join(g.udpPorts, ","))
+		if !hasBinary("ipset") && len(g.ipv4)+len(g.ipv6) > 0 {
+			log.Warnf("IPTABLES: ipset binary not found, IP targets of set '%s' don't lead to its rule group", g.name)
+		}
+	}
 	result := m.Apply()
 
 	if log.Level(log.CurLevel.Load()) >= log.LevelTrace {
@@ -478,9 +565,29 @@ func (ipt *IPTablesManager) Clear() error {
 	m.RemoveRules()
 	time.Sleep(30 * time.Millisecond)
 	m.RemoveChains()
+	ipt.removeRuleGroupChains()
+	destroyPrefilterSets()
+	destroyRuleGroupSets()
 	return nil
 }
 
+// removeRuleGroupChains removes rule group chains of sets that are no longer
+// part of the config.
+func (ipt *IPTablesManager) removeRuleGroupChains() {
+	for _, iptBin := range []string{"iptables", "ip6tables"} {
+		if !hasBinary(iptBin) {
+			continue
+		}
+		out, _ := run(iptBin, "-w", "-t", "mangle", "-S")
+		for _, line := range strings.Split(out, "\n") {
+			parts := strings.Fields(line)
+			if len(parts) == 2 && parts[0] == "-N" && strings.HasPrefix(parts[1], "B4_S") {
+				Chain{manager: ipt, IPT: iptBin, Table: "mangle", Name: parts[1]}.Remove()
+			}
+		}
+	}
+}
+
 func (ipt *IPTablesManager) clearB4JumpRules() {
 	ipts := []string{}
 	if ipt.cfg.Queue.IPv4Enabled && hasBinary("iptables") {
@@ -557,6 +664,30 @@ func (ipt *IPTablesManager) clearB4JumpRules() {
 			}
 		}
 
+		// Clean PREROUTING - remove prefilter response rules and rule group
+		// entries left by an earlier config
+		for {
+			out, _ := run(iptBin, "-w", "-t", "mangle", "-S", "PREROUTING")
+			removed := false
+			for _, line := range strings.Split(out, "\n") {
+				prefilterRule := strings.Contains(line, "--match-set b4_") && strings.Contains(line, "NFQUEUE")
+				if !prefilterRule && !strings.Contains(line, "-g B4_S") {
+					continue
+				}
+				parts := strings.Fields(line)
+				if len(parts) < 3 {
+					continue
+				}
+				if _, err := run(append([]string{iptBin, "-w", "-t", "mangle", "-D", "PREROUTING"}, parts[2:]...)...); err == nil {
+					removed = true
+					break
+				}
+			}
+			if !removed {
+				break
+			}
+		}
+
 		// Clean OUTPUT - parse and remove any B4 mark rules
 		for {
 			out, _ := run(iptBin, "-w", "-t", "mangle", "-S", "OUTPUT")
@@ -1,6 +1,7 @@
 package tables
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -10,12 +11,19 @@ import (
 	"github.com/daniellavrushin/b4/log"
 )
 
+// verifyTTL is how long Verify reuses its last rule check.
+const verifyTTL = 5 * time.Second
+
 type Monitor struct {
 	cfg      *config.Config
 	stop     chan struct{}
 	wg       sync.WaitGroup
 	interval time.Duration
 	backend  string
+
+	verifyMu  sync.Mutex
+	verified  time.Time
+	verifyErr error
 }
 
 func NewMonitor(cfg *config.Config) *Monitor {
@@ -28,7 +36,7 @@ func NewMonitor(cfg *config.Config) *Monitor {
 		cfg:      cfg,
 		stop:     make(chan struct{}),
 		interval: interval,
-		backend:  detectFirewallBackend(),
+		backend:  detectFirewallBackend(cfg),
 	}
 }
 
@@ -66,6 +74,9 @@ func (m *Monitor) monitorLoop() {
 		case <-m.stop:
 			return
 		case <-ticker.C:
+			if suspended.Load() {
+				continue
+			}
 			if !m.checkRules() {
 				log.Warnf("Tables rules missing, restoring...")
 				if err := m.restoreRules(); err != nil {
@@ -78,8 +89,32 @@ func (m *Monitor) monitorLoop() {
 	}
 }
 
+// Verify reports whether the rules are in place, for readiness checks.
+// Results are reused for verifyTTL so frequent probes do not run the
+// firewall tools each time.
+func (m *Monitor) Verify() error {
+	if suspended.Load() {
+		return errors.New("rules removed by the queue watchdog")
+	}
+
+	m.verifyMu.Lock()
+	defer m.verifyMu.Unlock()
+	if time.Since(m.verified) < verifyTTL {
+		return m.verifyErr
+	}
+	m.verifyErr = nil
+	if !m.checkRules() {
+		m.verifyErr = fmt.Errorf("%s rules missing", m.backend)
+	}
+	m.verified = time.Now()
+	return m.verifyErr
+}
+
 func (m *Monitor) checkRules() bool {
-	if m.backend == "nftables" {
+	switch m.backend {
+	case "netlink":
+		return NewNetlinkManager(m.cfg).Verify()
+	case "nftables":
 		return m.checkNFTablesRules()
 	}
 	return m.checkIPTablesRules()
@@ -103,7 +138,7 @@ func (m *Monitor) checkIPTablesRules() bool {
 			return false
 		}
 
-		if m.cfg.Queue.Devices.Enabled && len(m.cfg.Queue.Devices.Mac) > 0 {
+		if macs, _ := m.cfg.DeviceFilter(); len(macs) > 0 {
 			out, _ := run(ipt, "-w", "-t", "mangle", "-S", "FORWARD")
 			if !strings.Contains(out, "B4") {
 				log.Tracef("Monitor: FORWARD->B4 rule missing")
@@ -122,6 +157,16 @@ func (m *Monitor) checkIPTablesRules() bool {
 			return false
 		}
 
+		if m.cfg.System.Tables.Prefilter && hasBinary("ipset") {
+			out, _ := run("ipset", "list", "-n")
+			for _, f := range prefilterFamilies {
+				if f.ipt() == ipt && (!strings.Contains(out, f.targets) || !strings.Contains(out, f.learned)) {
+					log.Tracef("Monitor: prefilter ipsets missing")
+					return false
+				}
+			}
+		}
+
 		markHex := fmt.Sprintf("0x%x", m.cfg.Queue.Mark)
 		if m.cfg.Queue.Mark == 0 {
 			markHex = "0x8000"
@@ -173,7 +218,7 @@ func (m *Monitor) checkNFTablesRules() bool {
 		return false
 	}
 
-	if m.cfg.Queue.Devices.Enabled && len(m.cfg.Queue.Devices.Mac) > 0 {
+	if macs, _ := m.cfg.DeviceFilter(); len(macs) > 0 {
 		if !nft.chainExists("forward") {
 			log.Tracef("Monitor: forward chain missing")
 			return false
@@ -205,6 +250,18 @@ func (m *Monitor) checkNFTablesRules() bool {
 		return false
 	}
 
+	if nft.prefilter {
+		for _, f := range prefilterFamilies {
+			if !f.enabled(m.cfg) {
+				continue
+			}
+			if _, err := nft.runNft("list", "set", "inet", nftTableName, f.learned); err != nil {
+				log.Tracef("Monitor: prefilter set %s missing", f.learned)
+				return false
+			}
+		}
+	}
+
 	if !nft.chainExists("output") {
 		log.Tracef("Monitor: output chain missing")
 		return false
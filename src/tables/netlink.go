@@ -0,0 +1,502 @@
+package tables
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+	"golang.org/x/sys/unix"
+)
+
+// NetlinkManager programs the same ruleset as NFTablesManager directly over
+// nf_tables netlink, without the nft binary. Apply replaces the whole table in
+// a single transaction.
+type NetlinkManager struct {
+	cfg       *config.Config
+	nfproto   byte // restricts queue rules to one family, 0 for both
+	prefilter bool
+	rules     int
+}
+
+// netlinkRuleRef identifies a rule installed by the last Apply.
+type netlinkRuleRef struct {
+	table   string
+	chain   string
+	handle  uint64
+	comment string
+}
+
+var (
+	netlinkInstalledMu sync.Mutex
+	netlinkInstalled   []netlinkRuleRef
+)
+
+func NewNetlinkManager(cfg *config.Config) *NetlinkManager {
+	n := &NetlinkManager{cfg: cfg, prefilter: cfg.System.Tables.Prefilter}
+	switch {
+	case cfg.Queue.IPv4Enabled && !cfg.Queue.IPv6Enabled:
+		n.nfproto = unix.NFPROTO_IPV4
+	case cfg.Queue.IPv6Enabled && !cfg.Queue.IPv4Enabled:
+		n.nfproto = unix.NFPROTO_IPV6
+	}
+	return n
+}
+
+type nlRuleset struct {
+	*nlBatch
+	n       *NetlinkManager
+	family  byte
+	table   string
+	targets map[string]uint32 // prefilter set name -> batch set id
+}
+
+// rule appends a rule tagged with a per-Apply sequence number, so the monitor
+// can later tell its rules apart from anything else in the table.
+func (r *nlRuleset) rule(chain string, exprs ...[]nlExpr) {
+	var all []nlExpr
+	for _, e := range exprs {
+		all = append(all, e...)
+	}
+	r.n.rules++
+	r.addRule(r.family, r.table, chain, fmt.Sprintf("b4:%d", r.n.rules), all)
+}
+
+// queueRule appends a queue rule restricted to the configured IP family.
+func (r *nlRuleset) queueRule(chain string, exprs ...[]nlExpr) {
+	if r.n.nfproto != 0 {
+		exprs = append([][]nlExpr{nlMatchNfproto(r.n.nfproto)}, exprs...)
+	}
+	r.rule(chain, append(exprs, []nlExpr{exCounter()}, r.n.queueAction())...)
+}
+
+// prefilteredQueueRule repeats a queue rule for every prefilter set.
+func (r *nlRuleset) prefilteredQueueRule(chain string, src bool, exprs ...[]nlExpr) {
+	if !r.n.prefilter {
+		r.queueRule(chain, exprs...)
+		return
+	}
+	for _, f := range prefilterFamilies {
+		if !f.enabled(r.n.cfg) {
+			continue
+		}
+		for _, set := range []string{f.targets, f.learned} {
+			r.queueRule(chain, append([][]nlExpr{nlMatchAddrSet(f.v6, src, set, r.targets[set])}, exprs...)...)
+		}
+	}
+}
+
+func (n *NetlinkManager) queueAction() []nlExpr {
+	threads := max(n.cfg.Queue.Threads, 1)
+	return []nlExpr{exQueue(uint16(n.cfg.Queue.StartNum), uint16(threads))}
+}
+
+func (n *NetlinkManager) newRuleset(family byte, table string) *nlRuleset {
+	return &nlRuleset{nlBatch: &nlBatch{}, n: n, family: family, table: table, targets: map[string]uint32{}}
+}
+
+func (n *NetlinkManager) Apply() error {
+	cfg := n.cfg
+	if !nlAvailable() {
+		return errNetlinkUnavailable
+	}
+
+	log.Tracef("NETLINK: building nf_tables ruleset")
+	loadKernelModules()
+
+	n.rules = 0
+
+	r := n.newRuleset(unix.NFPROTO_INET, nftTableName)
+	r.delTable(r.family, r.table)
+	r.addTable(r.family, r.table)
+
+	macs, exclude := cfg.DeviceFilter()
+	devices := len(macs) > 0
+	global, _ := cfg.HasGlobalMSSClamp()
+	needsForward := devices || global || len(cfg.CollectDeviceMSSClamps()) > 0
+
+	r.addChain(r.family, r.table, nftChainName, "", -1, 0)
+	r.addChain(r.family, r.table, "prerouting", "filter", unix.NF_INET_PRE_ROUTING, -150)
+	r.addChain(r.family, r.table, "output", "filter", unix.NF_INET_LOCAL_OUT, -150)
+	if needsForward {
+		r.addChain(r.family, r.table, "forward", "filter", unix.NF_INET_FORWARD, -150)
+	}
+	if !devices {
+		r.addChain(r.family, r.table, "postrouting", "filter", unix.NF_INET_POST_ROUTING, -150)
+	}
+
+	if n.prefilter {
+		n.addPrefilterSets(r)
+	}
+
+	jump := []nlExpr{exVerdict(unix.NFT_JUMP, nftChainName)}
+	if devices {
+		for _, mac := range macs {
+			m, err := nlMatchEther(true, mac)
+			if err != nil {
+				return err
+			}
+			if exclude {
+				r.rule("forward", m, []nlExpr{exVerdict(unix.NFT_RETURN, "")})
+			} else {
+				r.rule("forward", m, jump)
+			}
+		}
+		if exclude {
+			r.rule("forward", jump)
+		}
+	} else {
+		r.rule("postrouting", jump)
+	}
+
+	mark := uint32(cfg.Queue.Mark)
+	r.rule("output", nlMatchOifname("lo"), []nlExpr{exVerdict(unix.NFT_RETURN, "")})
+	r.rule("output", nlMatchMark(mark), []nlExpr{exVerdict(nfAccept, "")})
+	r.rule("output", jump)
+
+	r.rule(nftChainName, nlMatchMark(mark), []nlExpr{exVerdict(unix.NFT_RETURN, "")})
+
+	// Duplication rules: queue ALL TCP/443 packets to specific IPs (no connbytes limit).
+	dupIPv4, dupIPv6 := cfg.CollectDuplicateIPs()
+	tcp443, _ := nlMatchPort(false, "443")
+	for _, dup := range []struct {
+		v6  bool
+		ips []string
+		on  bool
+	}{{false, dupIPv4, cfg.Queue.IPv4Enabled}, {true, dupIPv6, cfg.Queue.IPv6Enabled}} {
+		if !dup.on {
+			continue
+		}
+		for _, ip := range dup.ips {
+			m, err := nlMatchAddr(dup.v6, false, ip)
+			if err != nil {
+				log.Warnf("NETLINK: skipping duplicate target %s: %v", ip, err)
+				continue
+			}
+			r.rule(nftChainName, m, nlMatchL4(unix.IPPROTO_TCP), tcp443, []nlExpr{exCounter()}, n.queueAction())
+		}
+	}
+
+	tcpLimit := nlMatchCtPacketsBelow(uint64(cfg.MainSet.TCP.ConnBytesLimit + 1))
+	udpLimit := nlMatchCtPacketsBelow(uint64(cfg.MainSet.UDP.ConnBytesLimit + 1))
+	tcpSport443, _ := nlMatchPort(true, "443")
+	dns53, _ := nlMatchPort(false, "53")
+	dnsSport53, _ := nlMatchPort(true, "53")
+
+	r.queueRule(nftChainName, nlMatchL4(unix.IPPROTO_UDP), dns53)
+	r.queueRule("prerouting", nlMatchL4(unix.IPPROTO_UDP), dnsSport53)
+
+	// Per-set rule groups come before the default group they bypass
+	if err := n.addRuleGroups(r, collectRuleGroups(cfg)); err != nil {
+		return err
+	}
+
+	r.prefilteredQueueRule(nftChainName, false, nlMatchL4(unix.IPPROTO_TCP), tcp443, tcpLimit)
+	r.prefilteredQueueRule("prerouting", true, nlMatchL4(unix.IPPROTO_TCP), tcpSport443, tcpLimit)
+	r.prefilteredQueueRule("prerouting", true, nlMatchL4(unix.IPPROTO_TCP), tcpSport443, nlMatchTCPFlags(0x12, 0x12))
+
+	for _, port := range cfg.CollectUDPPorts() {
+		m, err := nlMatchPort(false, port)
+		if err != nil {
+			return err
+		}
+		r.prefilteredQueueRule(nftChainName, false, nlMatchL4(unix.IPPROTO_UDP), m, udpLimit)
+	}
+
+	if err := n.addMSSClamp(r); err != nil {
+		return err
+	}
+
+	if err := r.commit(); err != nil {
+		return fmt.Errorf("failed to apply nf_tables ruleset: %w", err)
+	}
+	log.Infof("NETLINK: applied %d rules to table %s in one transaction", n.rules, nftTableName)
+
+	setSysctlOrProc("net.netfilter.nf_conntrack_checksum", "0")
+	setSysctlOrProc("net.netfilter.nf_conntrack_tcp_be_liberal", "1")
+
+	if err := n.ApplyMasquerade(); err != nil {
+		return err
+	}
+
+	return n.recordInstalled()
+}
+
+// addRuleGroups adds the chains, target sets, entry rules and queue rules of
+// the per-set rule groups.
+func (n *NetlinkManager) addRuleGroups(r *nlRuleset, groups []ruleGroup) error {
+	tcp := nlMatchL4(unix.IPPROTO_TCP)
+	udp := nlMatchL4(unix.IPPROTO_UDP)
+	dport443, _ := nlMatchPort(false, "443")
+	sport443, _ := nlMatchPort(true, "443")
+
+	for _, g := range groups {
+		r.addChain(r.family, r.table, g.nftChain(), "", -1, 0)
+		r.addChain(r.family, r.table, g.nftChainIn(), "", -1, 0)
+
+		tcpLimit := nlMatchCtPacketsBelow(uint64(g.tcpLimit + 1))
+		udpLimit := nlMatchCtPacketsBelow(uint64(g.udpLimit + 1))
+		r.queueRule(g.nftChain(), tcp, dport443, tcpLimit)
+		for _, port := range g.udpPorts {
+			m, err := nlMatchPort(false, port)
+			if err != nil {
+				return err
+			}
+			r.queueRule(g.nftChain(), udp, m, udpLimit)
+		}
+		r.queueRule(g.nftChainIn(), tcp, sport443, tcpLimit)
+		r.queueRule(g.nftChainIn(), tcp, sport443, nlMatchTCPFlags(0x12, 0x12))
+
+		out := []nlExpr{exVerdict(unix.NFT_GOTO, g.nftChain())}
+		in := []nlExpr{exVerdict(unix.NFT_GOTO, g.nftChainIn())}
+		for _, f := range prefilterFamilies {
+			ips := g.ips(f.v6)
+			if !f.enabled(n.cfg) || len(ips) == 0 {
+				continue
+			}
+			keyType, keyLen := uint32(nftTypeIPv4Addr), uint32(4)
+			if f.v6 {
+				keyType, keyLen = nftTypeIPv6Addr, 16
+			}
+			id := r.addSet(r.family, r.table, g.setName(f.v6), keyType, keyLen, unix.NFT_SET_INTERVAL, 0, 0)
+			r.addSetElems(r.family, r.table, g.setName(f.v6), nlIntervalElems(ips, f.v6))
+			r.rule(nftChainName, nlMatchAddrSet(f.v6, false, g.setName(f.v6), id), out)
+			r.rule("prerouting", nlMatchAddrSet(f.v6, true, g.setName(f.v6), id), in)
+		}
+		r.rule(nftChainName, nlMatchCtMark(g.mark, config.SetCtMarkMask), out)
+		r.rule("prerouting", nlMatchCtMark(g.mark, config.SetCtMarkMask), in)
+
+		log.Infof("NETLINK: rule group for set '%s' (tcp %d, udp %d packets, udp ports %s)",
+			g.name, g.tcpLimit, g.udpLimit, strings.Join(g.udpPorts, ","))
+	}
+	return nil
+}
+
+func (n *NetlinkManager) addPrefilterSets(r *nlRuleset) {
+	ipv4, ipv6 := n.cfg.CollectPrefilterIPs()
+	timeoutMs := uint64(n.cfg.System.Tables.PrefilterTimeout) * 1000
+
+	for _, f := range prefilterFamilies {
+		if !f.enabled(n.cfg) {
+			continue
+		}
+		keyType, keyLen := uint32(nftTypeIPv4Addr), uint32(4)
+		elems := nlIntervalElems(ipv4, false)
+		if f.v6 {
+			keyType, keyLen = nftTypeIPv6Addr, 16
+			elems = nlIntervalElems(ipv6, true)
+		}
+
+		r.targets[f.targets] = r.addSet(r.family, r.table, f.targets, keyType, keyLen, unix.NFT_SET_INTERVAL, 0, 0)
+		r.addSetElems(r.family, r.table, f.targets, elems)
+		r.targets[f.learned] = r.addSet(r.family, r.table, f.learned, keyType, keyLen, unix.NFT_SET_TIMEOUT, timeoutMs, prefilterLearnedMax)
+		log.Infof("NETLINK: prefilter set %s loaded with %d ranges", f.targets, len(elems)/2)
+	}
+}
+
+func (n *NetlinkManager) addMSSClamp(r *nlRuleset) error {
+	cfg := n.cfg
+	global, globalSize := cfg.HasGlobalMSSClamp()
+	deviceClamps := cfg.CollectDeviceMSSClamps()
+	if !global && len(deviceClamps) == 0 {
+		return nil
+	}
+
+	syn := nlMatchTCPFlags(0x06, 0x02) // tcp flags syn / syn,rst
+	tcp := nlMatchL4(unix.IPPROTO_TCP)
+	dport, _ := nlMatchPort(false, "443")
+	sport, _ := nlMatchPort(true, "443")
+	family := func() []nlExpr {
+		if n.nfproto != 0 {
+			return nlMatchNfproto(n.nfproto)
+		}
+		return nil
+	}
+
+	if global {
+		mss := nlSetMSS(uint16(globalSize))
+		r.rule("output", family(), tcp, dport, syn, mss)
+		r.rule("forward", family(), tcp, dport, syn, mss)
+		r.rule("prerouting", family(), tcp, sport, syn, mss)
+		log.Infof("NETLINK: global MSS clamp enabled (size: %d)", globalSize)
+	}
+
+	for size, macs := range deviceClamps {
+		mss := nlSetMSS(uint16(size))
+		for _, mac := range macs {
+			src, err := nlMatchEther(true, mac)
+			if err != nil {
+				return err
+			}
+			dst, _ := nlMatchEther(false, mac)
+			r.rule("forward", family(), src, tcp, dport, syn, mss)
+			r.rule("forward", family(), dst, tcp, sport, syn, mss)
+		}
+		log.Infof("NETLINK: per-device MSS clamp for %d devices (size: %d)", len(macs), size)
+	}
+	return nil
+}
+
+// ApplyMSSClamp adds the MSS clamp rules to an already applied table.
+func (n *NetlinkManager) ApplyMSSClamp() error {
+	r := n.newRuleset(unix.NFPROTO_INET, nftTableName)
+	r.addTable(r.family, r.table)
+	r.addChain(r.family, r.table, "output", "filter", unix.NF_INET_LOCAL_OUT, -150)
+	r.addChain(r.family, r.table, "forward", "filter", unix.NF_INET_FORWARD, -150)
+	r.addChain(r.family, r.table, "prerouting", "filter", unix.NF_INET_PRE_ROUTING, -150)
+	if err := n.addMSSClamp(r); err != nil {
+		return err
+	}
+	if err := r.commit(); err != nil {
+		return fmt.Errorf("failed to apply MSS clamp rules: %w", err)
+	}
+	return n.recordInstalled()
+}
+
+func (n *NetlinkManager) ApplyMasquerade() error {
+	if !n.cfg.System.Tables.Masquerade {
+		return nil
+	}
+
+	r := n.newRuleset(unix.NFPROTO_IPV4, nftNatTableName)
+	r.delTable(r.family, r.table)
+	r.addTable(r.family, r.table)
+	r.addChain(r.family, r.table, nftNatChainName, "nat", unix.NF_INET_POST_ROUTING, 100)
+
+	var oif []nlExpr
+	iface := n.cfg.System.Tables.MasqueradeInterface
+	if iface != "" {
+		oif = nlMatchOifname(iface)
+	}
+	r.rule(nftNatChainName, oif, []nlExpr{exMasq()})
+
+	if err := r.commit(); err != nil {
+		return fmt.Errorf("failed to apply masquerade rules: %w", err)
+	}
+
+	if iface == "" {
+		iface = "all"
+	}
+	log.Infof("NETLINK: masquerade enabled (interface: %s)", iface)
+	return nil
+}
+
+func (n *NetlinkManager) ClearMasquerade() {
+	b := &nlBatch{}
+	b.delTable(unix.NFPROTO_IPV4, nftNatTableName)
+	if err := b.commit(); err != nil {
+		log.Errorf("Failed to delete nf_tables nat table: %v", err)
+	}
+}
+
+func (n *NetlinkManager) Clear() error {
+	log.Tracef("NETLINK: clearing rules")
+
+	b := &nlBatch{}
+	b.delTable(unix.NFPROTO_INET, nftTableName)
+	b.delTable(unix.NFPROTO_IPV4, nftNatTableName)
+	if err := b.commit(); err != nil {
+		return fmt.Errorf("failed to delete nf_tables tables: %w", err)
+	}
+
+	netlinkInstalledMu.Lock()
+	netlinkInstalled = nil
+	netlinkInstalledMu.Unlock()
+	return nil
+}
+
+// recordInstalled reads back the handles the kernel assigned to our rules.
+func (n *NetlinkManager) recordInstalled() error {
+	var refs []netlinkRuleRef
+	tables := []struct {
+		family byte
+		name   string
+	}{{unix.NFPROTO_INET, nftTableName}}
+	if n.cfg.System.Tables.Masquerade {
+		tables = append(tables, struct {
+			family byte
+			name   string
+		}{unix.NFPROTO_IPV4, nftNatTableName})
+	}
+
+	for _, t := range tables {
+		rules, err := nlListRules(t.family, t.name)
+		if err != nil {
+			return fmt.Errorf("failed to list rules of %s: %w", t.name, err)
+		}
+		for _, rule := range rules {
+			if strings.HasPrefix(rule.comment, "b4:") {
+				refs = append(refs, netlinkRuleRef{table: t.name, chain: rule.chain, handle: rule.handle, comment: rule.comment})
+			}
+		}
+	}
+
+	netlinkInstalledMu.Lock()
+	netlinkInstalled = refs
+	netlinkInstalledMu.Unlock()
+	log.Tracef("NETLINK: recorded %d rule handles", len(refs))
+	return nil
+}
+
+// Verify reports whether every rule installed by the last Apply is still in
+// place, comparing kernel handles rather than rule text.
+func (n *NetlinkManager) Verify() bool {
+	netlinkInstalledMu.Lock()
+	want := append([]netlinkRuleRef(nil), netlinkInstalled...)
+	netlinkInstalledMu.Unlock()
+
+	if len(want) == 0 {
+		log.Tracef("Monitor: no netlink rules recorded")
+		return false
+	}
+
+	have := make(map[string]map[uint64]nlRuleInfo)
+	for _, ref := range want {
+		if _, ok := have[ref.table]; ok {
+			continue
+		}
+		family := byte(unix.NFPROTO_INET)
+		if ref.table == nftNatTableName {
+			family = unix.NFPROTO_IPV4
+		}
+		rules, err := nlListRules(family, ref.table)
+		if err != nil {
+			log.Tracef("Monitor: failed to list %s: %v", ref.table, err)
+			return false
+		}
+		byHandle := make(map[uint64]nlRuleInfo, len(rules))
+		for _, rule := range rules {
+			byHandle[rule.handle] = rule
+		}
+		have[ref.table] = byHandle
+	}
+
+	for _, ref := range want {
+		rule, ok := have[ref.table][ref.handle]
+		if !ok || rule.chain != ref.chain || rule.comment != ref.comment {
+			log.Tracef("Monitor: rule %s (handle %d) missing from %s/%s", ref.comment, ref.handle, ref.table, ref.chain)
+			return false
+		}
+	}
+	return true
+}
+
+// nlAddLearned adds IPs to the learned prefilter sets.
+func nlAddLearned(v4, v6 []string) error {
+	b := &nlBatch{}
+	for _, s := range []struct {
+		name  string
+		elems []nlSetElem
+	}{
+		{prefilterLearned4, nlHostElems(v4, false)},
+		{prefilterLearned6, nlHostElems(v6, true)},
+	} {
+		// Adding an element that is already there keeps its old timeout:
+		// add, delete and add again so every element starts a new one
+		b.addSetElems(unix.NFPROTO_INET, nftTableName, s.name, s.elems)
+		b.delSetElems(unix.NFPROTO_INET, nftTableName, s.name, s.elems)
+		b.addSetElems(unix.NFPROTO_INET, nftTableName, s.name, s.elems)
+	}
+	return b.commit()
+}
@@ -21,10 +21,11 @@ const (
 type NFTablesManager struct {
 	cfg             *config.Config
 	ipVersionFilter string
+	prefilter       bool
 }
 
 func NewNFTablesManager(cfg *config.Config) *NFTablesManager {
-	return &NFTablesManager{cfg: cfg}
+	return &NFTablesManager{cfg: cfg, prefilter: cfg.System.Tables.Prefilter}
 }
 
 func (n *NFTablesManager) runNft(args ...string) (string, error) {
@@ -143,6 +144,12 @@ func (n *NFTablesManager) Apply() error {
 		return err
 	}
 
+	if n.prefilter {
+		if err := n.createPrefilterSets(); err != nil {
+			return err
+		}
+	}
+
 	if err := n.createChain("prerouting", "prerouting", -150, "accept"); err != nil {
 		return err
 	}
@@ -153,28 +160,24 @@ func (n *NFTablesManager) Apply() error {
 
 	markAccept := fmt.Sprintf("0x%x", cfg.Queue.Mark)
 
-	if cfg.Queue.Devices.Enabled && len(cfg.Queue.Devices.Mac) > 0 {
+	if macs, exclude := cfg.DeviceFilter(); len(macs) > 0 {
 		if err := n.createChain("forward", "forward", -150, "accept"); err != nil {
 			return err
 		}
 
-		if cfg.Queue.Devices.WhiteIsBlack {
-			for _, mac := range cfg.Queue.Devices.Mac {
-				if mac = strings.ToUpper(strings.TrimSpace(mac)); mac != "" {
-					if err := n.addRule("forward", "ether", "saddr", mac, "return"); err != nil {
-						return err
-					}
+		if exclude {
+			for _, mac := range macs {
+				if err := n.addRule("forward", "ether", "saddr", mac, "return"); err != nil {
+					return err
 				}
 			}
 			if err := n.addRule("forward", "jump", nftChainName); err != nil {
 				return err
 			}
 		} else {
-			for _, mac := range cfg.Queue.Devices.Mac {
-				if mac = strings.ToUpper(strings.TrimSpace(mac)); mac != "" {
-					if err := n.addRule("forward", "ether", "saddr", mac, "jump", nftChainName); err != nil {
-						return err
-					}
+			for _, mac := range macs {
+				if err := n.addRule("forward", "ether", "saddr", mac, "jump", nftChainName); err != nil {
+					return err
 				}
 			}
 		}
@@ -231,26 +234,35 @@ func (n *NFTablesManager) Apply() error {
 		}
 	}
 
-	tcpLimit := fmt.Sprintf("%d", cfg.MainSet.TCP.ConnBytesLimit+1)
-	udpLimit := fmt.Sprintf("%d", cfg.MainSet.UDP.ConnBytesLimit+1)
+	if err := n.addQueueRule(nftChainName, "udp", "dport", "53", "counter"); err != nil {
+		return err
+	}
 
-	if err := n.addQueueRule(nftChainName, "tcp", "dport", "443", "ct", "original", "packets", "<", tcpLimit, "counter"); err != nil {
+	if err := n.addQueueRule("prerouting", "udp", "sport", "53", "counter"); err != nil {
 		return err
 	}
 
-	if err := n.addQueueRule(nftChainName, "udp", "dport", "53", "counter"); err != nil {
+	// Per-set rule groups come before the default group they bypass
+	groups := collectRuleGroups(cfg)
+	if err := n.createRuleGroups(groups); err != nil {
+		return err
+	}
+	if err := n.addRuleGroupEntries(groups); err != nil {
 		return err
 	}
 
-	if err := n.addQueueRule("prerouting", "udp", "sport", "53", "counter"); err != nil {
+	tcpLimit := fmt.Sprintf("%d", cfg.MainSet.TCP.ConnBytesLimit+1)
+	udpLimit := fmt.Sprintf("%d", cfg.MainSet.UDP.ConnBytesLimit+1)
+
+	if err := n.addPrefilteredQueueRule(nftChainName, "daddr", "tcp", "dport", "443", "ct", "original", "packets", "<", tcpLimit, "counter"); err != nil {
 		return err
 	}
 
-	if err := n.addQueueRule("prerouting", "tcp", "sport", "443", "ct", "original", "packets", "<", tcpLimit, "counter"); err != nil {
+	if err := n.addPrefilteredQueueRule("prerouting", "saddr", "tcp", "sport", "443", "ct", "original", "packets", "<", tcpLimit, "counter"); err != nil {
 		return err
 	}
 
-	if err := n.addQueueRule("prerouting", "tcp", "sport", "443", "tcp", "flags", "&", "(syn|ack)", "==", "(syn|ack)", "counter"); err != nil {
+	if err := n.addPrefilteredQueueRule("prerouting", "saddr", "tcp", "sport", "443", "tcp", "flags", "&", "(syn|ack)", "==", "(syn|ack)", "counter"); err != nil {
 		return err
 	}
 
@@ -261,7 +273,7 @@ func (n *NFTablesManager) Apply() error {
 	} else {
 		udpPortExpr = "{ " + strings.Join(udpPorts, ", ") + " }"
 	}
-	if err := n.addQueueRule(nftChainName, "udp", "dport", udpPortExpr, "ct", "original", "packets", "<", udpLimit, "counter"); err != nil {
+	if err := n.addPrefilteredQueueRule(nftChainName, "daddr", "udp", "dport", udpPortExpr, "ct", "original", "packets", "<", udpLimit, "counter"); err != nil {
 		return err
 	}
 
@@ -0,0 +1,741 @@
+package tables
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Minimal nf_tables netlink encoding for the netlink firewall backend. Every
+// expression loads into or compares against register 1, the same layout nft
+// generates for simple matches.
+
+const (
+	nftTypeIPv4Addr = 7 // nft datatype ids used as set key types
+	nftTypeIPv6Addr = 8
+
+	nftTCPOptMaxseg = 2
+	nftUdataComment = 0 // NFTNL_UDATA_RULE_COMMENT
+	ifNameSize      = 16
+	arphrdEther     = 1
+
+	nfAccept = 1 // NF_ACCEPT verdict, not exported by x/sys/unix
+)
+
+type nlExpr struct {
+	name string
+	data func(ae *netlink.AttributeEncoder)
+}
+
+// nlBatch collects nf_tables messages that are committed as one transaction.
+type nlBatch struct {
+	msgs  []netlink.Message
+	setID uint32
+	err   error
+}
+
+func nftMsgType(cmd int) netlink.HeaderType {
+	return netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | cmd)
+}
+
+func nlEncode(fn func(ae *netlink.AttributeEncoder)) ([]byte, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.ByteOrder = binary.BigEndian
+	fn(ae)
+	return ae.Encode()
+}
+
+func (b *nlBatch) add(cmd int, flags netlink.HeaderFlags, family byte, fn func(ae *netlink.AttributeEncoder)) {
+	if b.err != nil {
+		return
+	}
+	attrs, err := nlEncode(fn)
+	if err != nil {
+		b.err = err
+		return
+	}
+	b.msgs = append(b.msgs, netlink.Message{
+		Header: netlink.Header{
+			Type:  nftMsgType(cmd),
+			Flags: netlink.Request | netlink.Acknowledge | flags,
+		},
+		Data: append([]byte{family, unix.NFNETLINK_V0, 0, 0}, attrs...),
+	})
+}
+
+func (b *nlBatch) addTable(family byte, table string) {
+	b.add(unix.NFT_MSG_NEWTABLE, netlink.Create, family, func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_TABLE_NAME, table)
+	})
+}
+
+// delTable deletes table. Adding it first in the same batch makes the pair
+// succeed whether or not the table exists.
+func (b *nlBatch) delTable(family byte, table string) {
+	b.addTable(family, table)
+	b.add(unix.NFT_MSG_DELTABLE, 0, family, func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_TABLE_NAME, table)
+	})
+}
+
+// addChain adds a chain; hook < 0 creates a regular chain.
+func (b *nlBatch) addChain(family byte, table, chain, chainType string, hook, priority int) {
+	b.add(unix.NFT_MSG_NEWCHAIN, netlink.Create, family, func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_CHAIN_TABLE, table)
+		ae.String(unix.NFTA_CHAIN_NAME, chain)
+		if hook < 0 {
+			return
+		}
+		ae.Nested(unix.NFTA_CHAIN_HOOK, func(h *netlink.AttributeEncoder) error {
+			h.Uint32(unix.NFTA_HOOK_HOOKNUM, uint32(hook))
+			h.Uint32(unix.NFTA_HOOK_PRIORITY, uint32(int32(priority)))
+			return nil
+		})
+		ae.Uint32(unix.NFTA_CHAIN_POLICY, nfAccept)
+		ae.String(unix.NFTA_CHAIN_TYPE, chainType)
+	})
+}
+
+func (b *nlBatch) addRule(family byte, table, chain, comment string, exprs []nlExpr) {
+	b.add(unix.NFT_MSG_NEWRULE, netlink.Create|netlink.Append, family, func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_RULE_TABLE, table)
+		ae.String(unix.NFTA_RULE_CHAIN, chain)
+		ae.Nested(unix.NFTA_RULE_EXPRESSIONS, func(le *netlink.AttributeEncoder) error {
+			for _, e := range exprs {
+				le.Nested(unix.NFTA_LIST_ELEM, func(ee *netlink.AttributeEncoder) error {
+					ee.String(unix.NFTA_EXPR_NAME, e.name)
+					if e.data != nil {
+						ee.Nested(unix.NFTA_EXPR_DATA, func(d *netlink.AttributeEncoder) error {
+							e.data(d)
+							return nil
+						})
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if comment != "" {
+			ae.Bytes(unix.NFTA_RULE_USERDATA, commentUdata(comment))
+		}
+	})
+}
+
+// addSet adds a named set and returns its batch-local id for lookups.
+func (b *nlBatch) addSet(family byte, table, name string, keyType, keyLen, flags uint32, timeoutMs uint64, size uint32) uint32 {
+	b.setID++
+	id := b.setID
+	b.add(unix.NFT_MSG_NEWSET, netlink.Create, family, func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_SET_TABLE, table)
+		ae.String(unix.NFTA_SET_NAME, name)
+		ae.Uint32(unix.NFTA_SET_FLAGS, flags)
+		ae.Uint32(unix.NFTA_SET_KEY_TYPE, keyType)
+		ae.Uint32(unix.NFTA_SET_KEY_LEN, keyLen)
+		ae.Uint32(unix.NFTA_SET_ID, id)
+		if timeoutMs > 0 {
+			ae.Uint64(unix.NFTA_SET_TIMEOUT, timeoutMs)
+		}
+		if size > 0 {
+			ae.Nested(unix.NFTA_SET_DESC, func(d *netlink.AttributeEncoder) error {
+				d.Uint32(unix.NFTA_SET_DESC_SIZE, size)
+				return nil
+			})
+		}
+	})
+	return id
+}
+
+type nlSetElem struct {
+	key         []byte
+	intervalEnd bool
+}
+
+func (b *nlBatch) addSetElems(family byte, table, set string, elems []nlSetElem) {
+	b.setElems(unix.NFT_MSG_NEWSETELEM, netlink.Create, family, table, set, elems)
+}
+
+func (b *nlBatch) delSetElems(family byte, table, set string, elems []nlSetElem) {
+	b.setElems(unix.NFT_MSG_DELSETELEM, 0, family, table, set, elems)
+}
+
+func (b *nlBatch) setElems(cmd int, flags netlink.HeaderFlags, family byte, table, set string, elems []nlSetElem) {
+	for _, chunk := range chunkSetElems(elems, prefilterChunk) {
+		b.add(cmd, flags, family, func(ae *netlink.AttributeEncoder) {
+			ae.String(unix.NFTA_SET_ELEM_LIST_TABLE, table)
+			ae.String(unix.NFTA_SET_ELEM_LIST_SET, set)
+			ae.Nested(unix.NFTA_SET_ELEM_LIST_ELEMENTS, func(le *netlink.AttributeEncoder) error {
+				for _, e := range chunk {
+					le.Nested(unix.NFTA_LIST_ELEM, func(ee *netlink.AttributeEncoder) error {
+						ee.Nested(unix.NFTA_SET_ELEM_KEY, func(k *netlink.AttributeEncoder) error {
+							k.Bytes(unix.NFTA_DATA_VALUE, e.key)
+							return nil
+						})
+						if e.intervalEnd {
+							ee.Uint32(unix.NFTA_SET_ELEM_FLAGS, unix.NFT_SET_ELEM_INTERVAL_END)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func chunkSetElems(elems []nlSetElem, size int) [][]nlSetElem {
+	var chunks [][]nlSetElem
+	for len(elems) > size {
+		// Never separate an interval start from its end
+		n := size
+		if elems[n].intervalEnd {
+			n--
+		}
+		chunks = append(chunks, elems[:n])
+		elems = elems[n:]
+	}
+	if len(elems) > 0 {
+		chunks = append(chunks, elems)
+	}
+	return chunks
+}
+
+// commit sends the batch as one nf_tables transaction and waits for every ack.
+func (b *nlBatch) commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.msgs) == 0 {
+		return nil
+	}
+
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return fmt.Errorf("netlink dial: %w", err)
+	}
+	defer conn.Close()
+
+	batchHdr := []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, unix.NFNL_SUBSYS_NFTABLES}
+	msgs := make([]netlink.Message, 0, len(b.msgs)+2)
+	msgs = append(msgs, netlink.Message{
+		Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_MSG_BATCH_BEGIN), Flags: netlink.Request},
+		Data:   batchHdr,
+	})
+	msgs = append(msgs, b.msgs...)
+	msgs = append(msgs, netlink.Message{
+		Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_MSG_BATCH_END), Flags: netlink.Request},
+		Data:   batchHdr,
+	})
+
+	if _, err := conn.SendMessages(msgs); err != nil {
+		return fmt.Errorf("netlink send: %w", err)
+	}
+
+	for acks := 0; acks < len(b.msgs); {
+		replies, err := conn.Receive()
+		if err != nil {
+			return fmt.Errorf("nf_tables transaction failed: %w", err)
+		}
+		for _, r := range replies {
+			if r.Header.Type == netlink.Error {
+				acks++
+			}
+		}
+	}
+	return nil
+}
+
+// nlRuleInfo is a rule as reported by the kernel.
+type nlRuleInfo struct {
+	chain   string
+	handle  uint64
+	comment string
+}
+
+// nlListRules dumps the rules of a table.
+func nlListRules(family byte, table string) ([]nlRuleInfo, error) {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	attrs, err := nlEncode(func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_RULE_TABLE, table)
+	})
+	if err != nil {
+		return nil, err
+	}
+	replies, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  nftMsgType(unix.NFT_MSG_GETRULE),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append([]byte{family, unix.NFNETLINK_V0, 0, 0}, attrs...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []nlRuleInfo
+	for _, r := range replies {
+		if len(r.Data) < 4 {
+			continue
+		}
+		ad, err := netlink.NewAttributeDecoder(r.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		ad.ByteOrder = binary.BigEndian
+		var info nlRuleInfo
+		var tbl string
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_RULE_TABLE:
+				tbl = ad.String()
+			case unix.NFTA_RULE_CHAIN:
+				info.chain = ad.String()
+			case unix.NFTA_RULE_HANDLE:
+				info.handle = ad.Uint64()
+			case unix.NFTA_RULE_USERDATA:
+				info.comment = parseCommentUdata(ad.Bytes())
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		if tbl == table {
+			rules = append(rules, info)
+		}
+	}
+	return rules, nil
+}
+
+// nlAvailable reports whether nf_tables can be programmed over netlink.
+func nlAvailable() bool {
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	_, err = conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  nftMsgType(unix.NFT_MSG_GETTABLE),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: []byte{unix.NFPROTO_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+	})
+	return err == nil
+}
+
+func commentUdata(comment string) []byte {
+	v := append([]byte(comment), 0)
+	return append([]byte{nftUdataComment, byte(len(v))}, v...)
+}
+
+func parseCommentUdata(b []byte) string {
+	for len(b) >= 2 {
+		typ, l := b[0], int(b[1])
+		if 2+l > len(b) {
+			break
+		}
+		if typ == nftUdataComment {
+			return strings.TrimRight(string(b[2:2+l]), "\x00")
+		}
+		b = b[2+l:]
+	}
+	return ""
+}
+
+// Expressions
+
+func exMeta(key uint32) nlExpr {
+	return nlExpr{"meta", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_META_KEY, key)
+		ae.Uint32(unix.NFTA_META_DREG, unix.NFT_REG_1)
+	}}
+}
+
+func exCmp(op uint32, data []byte) nlExpr {
+	return nlExpr{"cmp", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_CMP_SREG, unix.NFT_REG_1)
+		ae.Uint32(unix.NFTA_CMP_OP, op)
+		ae.Nested(unix.NFTA_CMP_DATA, func(d *netlink.AttributeEncoder) error {
+			d.Bytes(unix.NFTA_DATA_VALUE, data)
+			return nil
+		})
+	}}
+}
+
+func exPayload(base, offset, length uint32) nlExpr {
+	return nlExpr{"payload", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_PAYLOAD_DREG, unix.NFT_REG_1)
+		ae.Uint32(unix.NFTA_PAYLOAD_BASE, base)
+		ae.Uint32(unix.NFTA_PAYLOAD_OFFSET, offset)
+		ae.Uint32(unix.NFTA_PAYLOAD_LEN, length)
+	}}
+}
+
+func exBitwise(mask []byte) nlExpr {
+	return nlExpr{"bitwise", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_BITWISE_SREG, unix.NFT_REG_1)
+		ae.Uint32(unix.NFTA_BITWISE_DREG, unix.NFT_REG_1)
+		ae.Uint32(unix.NFTA_BITWISE_LEN, uint32(len(mask)))
+		ae.Nested(unix.NFTA_BITWISE_MASK, func(d *netlink.AttributeEncoder) error {
+			d.Bytes(unix.NFTA_DATA_VALUE, mask)
+			return nil
+		})
+		ae.Nested(unix.NFTA_BITWISE_XOR, func(d *netlink.AttributeEncoder) error {
+			d.Bytes(unix.NFTA_DATA_VALUE, make([]byte, len(mask)))
+			return nil
+		})
+	}}
+}
+
+// exCT loads a conntrack key; dir is the direction for per-direction keys
+// such as packet counters, or -1 for keys like the mark that have none.
+func exCT(key uint32, dir int) nlExpr {
+	return nlExpr{"ct", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_CT_DREG, unix.NFT_REG_1)
+		ae.Uint32(unix.NFTA_CT_KEY, key)
+		if dir >= 0 {
+			ae.Uint8(unix.NFTA_CT_DIRECTION, uint8(dir))
+		}
+	}}
+}
+
+func exByteorderHton(size uint32) nlExpr {
+	return nlExpr{"byteorder", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_BYTEORDER_SREG, unix.NFT_REG_1)
+		ae.Uint32(unix.NFTA_BYTEORDER_DREG, unix.NFT_REG_1)
+		ae.Uint32(unix.NFTA_BYTEORDER_OP, unix.NFT_BYTEORDER_HTON)
+		ae.Uint32(unix.NFTA_BYTEORDER_LEN, size)
+		ae.Uint32(unix.NFTA_BYTEORDER_SIZE, size)
+	}}
+}
+
+func exCounter() nlExpr {
+	return nlExpr{"counter", func(ae *netlink.AttributeEncoder) {
+		ae.Uint64(unix.NFTA_COUNTER_BYTES, 0)
+		ae.Uint64(unix.NFTA_COUNTER_PACKETS, 0)
+	}}
+}
+
+func exQueue(num, total uint16) nlExpr {
+	return nlExpr{"queue", func(ae *netlink.AttributeEncoder) {
+		ae.Uint16(unix.NFTA_QUEUE_NUM, num)
+		ae.Uint16(unix.NFTA_QUEUE_TOTAL, total)
+		ae.Uint16(unix.NFTA_QUEUE_FLAGS, unix.NFT_QUEUE_FLAG_BYPASS)
+	}}
+}
+
+func exVerdict(code int32, chain string) nlExpr {
+	return nlExpr{"immediate", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_IMMEDIATE_DREG, unix.NFT_REG_VERDICT)
+		ae.Nested(unix.NFTA_IMMEDIATE_DATA, func(d *netlink.AttributeEncoder) error {
+			d.Nested(unix.NFTA_DATA_VERDICT, func(v *netlink.AttributeEncoder) error {
+				v.Uint32(unix.NFTA_VERDICT_CODE, uint32(code))
+				if chain != "" {
+					v.String(unix.NFTA_VERDICT_CHAIN, chain)
+				}
+				return nil
+			})
+			return nil
+		})
+	}}
+}
+
+func exImmediate(data []byte) nlExpr {
+	return nlExpr{"immediate", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_IMMEDIATE_DREG, unix.NFT_REG_1)
+		ae.Nested(unix.NFTA_IMMEDIATE_DATA, func(d *netlink.AttributeEncoder) error {
+			d.Bytes(unix.NFTA_DATA_VALUE, data)
+			return nil
+		})
+	}}
+}
+
+func exLookup(set string, id uint32) nlExpr {
+	return nlExpr{"lookup", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_LOOKUP_SREG, unix.NFT_REG_1)
+		ae.String(unix.NFTA_LOOKUP_SET, set)
+		if id != 0 {
+			ae.Uint32(unix.NFTA_LOOKUP_SET_ID, id)
+		}
+	}}
+}
+
+func exMasq() nlExpr {
+	return nlExpr{name: "masq"}
+}
+
+// exTCPOptWrite writes register 1 into a TCP option field.
+func exTCPOptWrite(opt uint8, offset, length uint32) nlExpr {
+	return nlExpr{"exthdr", func(ae *netlink.AttributeEncoder) {
+		ae.Uint32(unix.NFTA_EXTHDR_SREG, unix.NFT_REG_1)
+		ae.Uint8(unix.NFTA_EXTHDR_TYPE, opt)
+		ae.Uint32(unix.NFTA_EXTHDR_OFFSET, offset)
+		ae.Uint32(unix.NFTA_EXTHDR_LEN, length)
+		ae.Uint32(unix.NFTA_EXTHDR_OP, unix.NFT_EXTHDR_OP_TCPOPT)
+	}}
+}
+
+// Matches built from the expressions above, mirroring what nft generates.
+
+func nlMatchNfproto(proto byte) []nlExpr {
+	return []nlExpr{exMeta(unix.NFT_META_NFPROTO), exCmp(unix.NFT_CMP_EQ, []byte{proto})}
+}
+
+func nlMatchL4(proto byte) []nlExpr {
+	return []nlExpr{exMeta(unix.NFT_META_L4PROTO), exCmp(unix.NFT_CMP_EQ, []byte{proto})}
+}
+
+// nlMatchPort matches a transport port or "lo-hi" range; src selects the source port.
+func nlMatchPort(src bool, spec string) ([]nlExpr, error) {
+	offset := uint32(2)
+	if src {
+		offset = 0
+	}
+	lo, hi, isRange := strings.Cut(spec, "-")
+	from, err := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", spec)
+	}
+	exprs := []nlExpr{exPayload(unix.NFT_PAYLOAD_TRANSPORT_HEADER, offset, 2)}
+	if !isRange {
+		return append(exprs, exCmp(unix.NFT_CMP_EQ, binary.BigEndian.AppendUint16(nil, uint16(from)))), nil
+	}
+	to, err := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+	if err != nil || to < from {
+		return nil, fmt.Errorf("invalid port range %q", spec)
+	}
+	return append(exprs,
+		exCmp(unix.NFT_CMP_GTE, binary.BigEndian.AppendUint16(nil, uint16(from))),
+		exCmp(unix.NFT_CMP_LTE, binary.BigEndian.AppendUint16(nil, uint16(to))),
+	), nil
+}
+
+func nlMatchCtPacketsBelow(n uint64) []nlExpr {
+	return []nlExpr{
+		exCT(unix.NFT_CT_PKTS, 0),
+		exByteorderHton(8),
+		exCmp(unix.NFT_CMP_LT, binary.BigEndian.AppendUint64(nil, n)),
+	}
+}
+
+// nlMatchTCPFlags matches "tcp flags & mask == value".
+func nlMatchTCPFlags(mask, value byte) []nlExpr {
+	return []nlExpr{
+		exPayload(unix.NFT_PAYLOAD_TRANSPORT_HEADER, 13, 1),
+		exBitwise([]byte{mask}),
+		exCmp(unix.NFT_CMP_EQ, []byte{value}),
+	}
+}
+
+func nlMatchMark(mark uint32) []nlExpr {
+	return []nlExpr{exMeta(unix.NFT_META_MARK), exCmp(unix.NFT_CMP_EQ, binary.NativeEndian.AppendUint32(nil, mark))}
+}
+
+func nlMatchCtMark(mark, mask uint32) []nlExpr {
+	return []nlExpr{
+		exCT(unix.NFT_CT_MARK, -1),
+		exBitwise(binary.NativeEndian.AppendUint32(nil, mask)),
+		exCmp(unix.NFT_CMP_EQ, binary.NativeEndian.AppendUint32(nil, mark)),
+	}
+}
+
+func nlMatchOifname(name string) []nlExpr {
+	data := make([]byte, ifNameSize)
+	copy(data, name)
+	return []nlExpr{exMeta(unix.NFT_META_OIFNAME), exCmp(unix.NFT_CMP_EQ, data)}
+}
+
+// nlMatchEther matches the source (or destination) MAC of an Ethernet frame.
+func nlMatchEther(src bool, mac string) ([]nlExpr, error) {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %q", mac)
+	}
+	offset := uint32(0)
+	if src {
+		offset = 6
+	}
+	return []nlExpr{
+		exMeta(unix.NFT_META_IIFTYPE),
+		exCmp(unix.NFT_CMP_EQ, binary.NativeEndian.AppendUint16(nil, arphrdEther)),
+		exPayload(unix.NFT_PAYLOAD_LL_HEADER, offset, 6),
+		exCmp(unix.NFT_CMP_EQ, hw),
+	}, nil
+}
+
+func nlAddrOffset(v6, src bool) (uint32, uint32) {
+	switch {
+	case v6 && src:
+		return 8, 16
+	case v6:
+		return 24, 16
+	case src:
+		return 12, 4
+	default:
+		return 16, 4
+	}
+}
+
+// nlMatchAddr matches an IP or CIDR of the given family.
+func nlMatchAddr(v6, src bool, cidr string) ([]nlExpr, error) {
+	var ip net.IP
+	var mask net.IPMask
+	if strings.Contains(cidr, "/") {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ip, mask = n.IP, n.Mask
+	} else if ip = net.ParseIP(cidr); ip == nil {
+		return nil, fmt.Errorf("invalid address %q", cidr)
+	}
+
+	proto := byte(unix.NFPROTO_IPV4)
+	if v6 {
+		proto = unix.NFPROTO_IPV6
+		ip = ip.To16()
+	} else if ip = ip.To4(); ip == nil {
+		return nil, fmt.Errorf("not an IPv4 address %q", cidr)
+	}
+	if len(mask) > 0 && len(mask) != len(ip) {
+		mask = mask[len(mask)-len(ip):]
+	}
+
+	offset, length := nlAddrOffset(v6, src)
+	exprs := append(nlMatchNfproto(proto), exPayload(unix.NFT_PAYLOAD_NETWORK_HEADER, offset, length))
+	if ones, bits := mask.Size(); len(mask) > 0 && ones < bits {
+		exprs = append(exprs, exBitwise(mask))
+	}
+	return append(exprs, exCmp(unix.NFT_CMP_EQ, ip)), nil
+}
+
+func nlMatchAddrSet(v6, src bool, set string, id uint32) []nlExpr {
+	proto := byte(unix.NFPROTO_IPV4)
+	if v6 {
+		proto = unix.NFPROTO_IPV6
+	}
+	offset, length := nlAddrOffset(v6, src)
+	return append(nlMatchNfproto(proto),
+		exPayload(unix.NFT_PAYLOAD_NETWORK_HEADER, offset, length),
+		exLookup(set, id),
+	)
+}
+
+func nlSetMSS(size uint16) []nlExpr {
+	return []nlExpr{
+		exImmediate(binary.BigEndian.AppendUint16(nil, size)),
+		exTCPOptWrite(nftTCPOptMaxseg, 2, 2),
+	}
+}
+
+// nlIntervalElems turns IPs/CIDRs into the start/end element pairs of an
+// interval set, merging overlapping and adjacent ranges first.
+func nlIntervalElems(list []string, v6 bool) []nlSetElem {
+	size := 4
+	if v6 {
+		size = 16
+	}
+
+	type ipRange struct{ lo, hi []byte } // inclusive
+	var ranges []ipRange
+	for _, s := range prefilterElements(list, v6) {
+		var ip net.IP
+		mask := net.CIDRMask(size*8, size*8)
+		if strings.Contains(s, "/") {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				continue
+			}
+			ip, mask = n.IP, n.Mask
+		} else {
+			ip = net.ParseIP(s)
+		}
+		if v6 {
+			ip = ip.To16()
+		} else {
+			ip = ip.To4()
+		}
+		if len(mask) != size {
+			mask = mask[len(mask)-size:]
+		}
+		lo := make([]byte, size)
+		hi := make([]byte, size)
+		for i := range lo {
+			lo[i] = ip[i] & mask[i]
+			hi[i] = lo[i] | ^mask[i]
+		}
+		ranges = append(ranges, ipRange{lo, hi})
+	}
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].lo, ranges[j].lo) < 0 })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		next, overflow := addrNext(last.hi)
+		if overflow || bytes.Compare(r.lo, next) <= 0 {
+			if bytes.Compare(r.hi, last.hi) > 0 {
+				last.hi = r.hi
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	elems := make([]nlSetElem, 0, 2*len(merged))
+	for _, r := range merged {
+		elems = append(elems, nlSetElem{key: r.lo})
+		if end, overflow := addrNext(r.hi); !overflow {
+			elems = append(elems, nlSetElem{key: end, intervalEnd: true})
+		}
+	}
+	return elems
+}
+
+// addrNext returns addr+1 and whether it wrapped around.
+func addrNext(addr []byte) ([]byte, bool) {
+	next := append([]byte(nil), addr...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next, false
+		}
+	}
+	return next, true
+}
+
+// nlHostElems turns IP strings of one family into plain set elements.
+func nlHostElems(list []string, v6 bool) []nlSetElem {
+	elems := make([]nlSetElem, 0, len(list))
+	for _, s := range list {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		if v6 {
+			if ip.To4() != nil {
+				continue
+			}
+			elems = append(elems, nlSetElem{key: ip.To16()})
+		} else if ip4 := ip.To4(); ip4 != nil {
+			elems = append(elems, nlSetElem{key: ip4})
+		}
+	}
+	return elems
+}
+
+var errNetlinkUnavailable = errors.New("nf_tables netlink interface not available")
@@ -0,0 +1,411 @@
+package tables
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniellavrushin/b4/config"
+	"github.com/daniellavrushin/b4/log"
+)
+
+// Kernel-side target prefilter: the IPs/CIDRs of all enabled sets go into
+// static sets, IPs learned at runtime (SNI matches, DNS answers) into sets
+// with a timeout, and the queue rules only match destinations in either.
+const (
+	prefilterTargets4 = "b4_targets4"
+	prefilterTargets6 = "b4_targets6"
+	prefilterLearned4 = "b4_learned4"
+	prefilterLearned6 = "b4_learned6"
+
+	prefilterFlushDelay = 200 * time.Millisecond
+	prefilterChunk      = 1000
+	prefilterLearnedMax = 65536
+)
+
+type prefilterFamily struct {
+	v6      bool
+	nftExpr string // nftables address expression ("ip" / "ip6")
+	ipset   string // ipset family ("inet" / "inet6")
+	targets string
+	learned string
+}
+
+var prefilterFamilies = []prefilterFamily{
+	{v6: false, nftExpr: "ip", ipset: "inet", targets: prefilterTargets4, learned: prefilterLearned4},
+	{v6: true, nftExpr: "ip6", ipset: "inet6", targets: prefilterTargets6, learned: prefilterLearned6},
+}
+
+func (f prefilterFamily) enabled(cfg *config.Config) bool {
+	if f.v6 {
+		return cfg.Queue.IPv6Enabled
+	}
+	return cfg.Queue.IPv4Enabled
+}
+
+// ipt returns the iptables binary that handles this family.
+func (f prefilterFamily) ipt() string {
+	if f.v6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// prefilterElements keeps the valid IPs/CIDRs of one family.
+func prefilterElements(list []string, v6 bool) []string {
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		var ip net.IP
+		if strings.Contains(s, "/") {
+			var err error
+			if ip, _, err = net.ParseCIDR(s); err != nil {
+				continue
+			}
+		} else if ip = net.ParseIP(s); ip == nil {
+			continue
+		}
+		if (ip.To4() == nil) != v6 {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func chunkStrings(list []string, size int) [][]string {
+	var chunks [][]string
+	for len(list) > size {
+		chunks = append(chunks, list[:size])
+		list = list[size:]
+	}
+	if len(list) > 0 {
+		chunks = append(chunks, list)
+	}
+	return chunks
+}
+
+// createPrefilterSets creates the nftables target sets and loads the static
+// IPs/CIDRs of all enabled sets into them.
+func (n *NFTablesManager) createPrefilterSets() error {
+	cfg := n.cfg
+	ipv4, ipv6 := cfg.CollectPrefilterIPs()
+	timeout := cfg.System.Tables.PrefilterTimeout
+
+	var script strings.Builder
+	for _, f := range prefilterFamilies {
+		if !f.enabled(cfg) {
+			continue
+		}
+		addrType := "ipv4_addr"
+		ips := prefilterElements(ipv4, false)
+		if f.v6 {
+			addrType = "ipv6_addr"
+			ips = prefilterElements(ipv6, true)
+		}
+
+		fmt.Fprintf(&script, "add set inet %s %s { type %s ; flags interval ; auto-merge ; }\n",
+			nftTableName, f.targets, addrType)
+		fmt.Fprintf(&script, "add set inet %s %s { type %s ; flags timeout ; timeout %ds ; size %d ; }\n",
+			nftTableName, f.learned, addrType, timeout, prefilterLearnedMax)
+		for _, chunk := range chunkStrings(ips, prefilterChunk) {
+			fmt.Fprintf(&script, "add element inet %s %s { %s }\n", nftTableName, f.targets, strings.Join(chunk, ", "))
+		}
+		log.Infof("NFTABLES: prefilter set %s loaded with %d entries", f.targets, len(ips))
+	}
+
+	if out, err := runStdin(script.String(), "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("failed to create prefilter sets: %w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// prefilterMatches returns the address matches a queue rule is repeated with
+// when the prefilter is on (dir is "daddr" or "saddr"), or a single empty
+// match when every packet on the port should be queued.
+func (n *NFTablesManager) prefilterMatches(dir string) [][]string {
+	if !n.prefilter {
+		return [][]string{nil}
+	}
+	var matches [][]string
+	for _, f := range prefilterFamilies {
+		if !f.enabled(n.cfg) {
+			continue
+		}
+		matches = append(matches,
+			[]string{f.nftExpr, dir, "@" + f.targets},
+			[]string{f.nftExpr, dir, "@" + f.learned},
+		)
+	}
+	return matches
+}
+
+// addPrefilteredQueueRule adds a queue rule once per prefilter match.
+func (n *NFTablesManager) addPrefilteredQueueRule(chain, dir string, args ...string) error {
+	for _, m := range n.prefilterMatches(dir) {
+		if err := n.addQueueRule(chain, append(append([]string{}, m...), args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPrefilterSets creates the ipsets used by the iptables prefilter rules
+// and loads the static IPs/CIDRs of all enabled sets into them.
+func (im *IPTablesManager) createPrefilterSets() error {
+	cfg := im.cfg
+	ipv4, ipv6 := cfg.CollectPrefilterIPs()
+	timeout := cfg.System.Tables.PrefilterTimeout
+
+	var script strings.Builder
+	for _, f := range prefilterFamilies {
+		if !f.enabled(cfg) || !hasBinary(f.ipt()) {
+			continue
+		}
+		ips := prefilterElements(ipv4, false)
+		if f.v6 {
+			ips = prefilterElements(ipv6, true)
+		}
+		maxElem := max(65536, len(ips)+1024)
+
+		fmt.Fprintf(&script, "create %s hash:net family %s maxelem %d\n", f.targets, f.ipset, maxElem)
+		fmt.Fprintf(&script, "flush %s\n", f.targets)
+		fmt.Fprintf(&script, "create %s hash:ip family %s timeout %d maxelem %d\n", f.learned, f.ipset, timeout, prefilterLearnedMax)
+		for _, ip := range ips {
+			fmt.Fprintf(&script, "add %s %s\n", f.targets, ip)
+		}
+		log.Infof("IPTABLES: prefilter ipset %s loaded with %d entries", f.targets, len(ips))
+	}
+
+	if out, err := runStdin(script.String(), "ipset", "restore", "-exist"); err != nil {
+		return fmt.Errorf("failed to create prefilter ipsets: %w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// prefilterSpecs returns the set matches an iptables queue rule is repeated
+// with when the prefilter is on (dir is "dst" or "src").
+func (im *IPTablesManager) prefilterSpecs(ipt, dir string) [][]string {
+	if !im.prefilter {
+		return [][]string{nil}
+	}
+	for _, f := range prefilterFamilies {
+		if f.ipt() == ipt {
+			return [][]string{
+				{"-m", "set", "--match-set", f.targets, dir},
+				{"-m", "set", "--match-set", f.learned, dir},
+			}
+		}
+	}
+	return [][]string{nil}
+}
+
+func destroyPrefilterSets() {
+	if !hasBinary("ipset") {
+		return
+	}
+	for _, f := range prefilterFamilies {
+		_, _ = run("ipset", "destroy", f.targets)
+		_, _ = run("ipset", "destroy", f.learned)
+	}
+}
+
+// learnedPrefilter pushes IPs learned at runtime into the learned sets of the
+// active backend. A new IP is pushed before the learn call returns, so the
+// connection that follows a DNS answer is already queued; refreshes of IPs
+// still in the set are batched so a burst costs a single nft/ipset
+// invocation or netlink batch.
+type learnedPrefilter struct {
+	mu      sync.Mutex
+	backend string // "" while the prefilter is inactive
+	timeout time.Duration
+	v4, v6  bool
+	pending map[string]net.IP
+	pushed  map[string]time.Time
+	timer   *time.Timer
+	push    func(backend string, timeout time.Duration, ips map[string]net.IP) error
+}
+
+var prefilter = &learnedPrefilter{
+	pending: make(map[string]net.IP),
+	pushed:  make(map[string]time.Time),
+	push:    pushLearned,
+}
+
+// AddTargetIP adds ips to the learned prefilter set so their traffic is
+// queued to userspace. It does nothing while the prefilter is disabled.
+func AddTargetIP(ips ...net.IP) {
+	prefilter.add(ips...)
+}
+
+func (p *learnedPrefilter) activate(backend string, cfg *config.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.backend = backend
+	p.timeout = time.Duration(cfg.System.Tables.PrefilterTimeout) * time.Second
+	p.v4 = cfg.Queue.IPv4Enabled
+	p.v6 = cfg.Queue.IPv6Enabled
+
+	// Sets are recreated on every rules refresh, so put back what is still live
+	now := time.Now()
+	for key, at := range p.pushed {
+		if now.Sub(at) < p.timeout {
+			p.pending[key] = net.ParseIP(key)
+		}
+	}
+	p.pushed = make(map[string]time.Time)
+	p.schedule()
+}
+
+func (p *learnedPrefilter) deactivate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.backend = ""
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+func (p *learnedPrefilter) add(ips ...net.IP) {
+	p.mu.Lock()
+	if p.backend == "" {
+		p.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	fresh := make(map[string]net.IP)
+	for _, ip := range ips {
+		if ip == nil {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			if !p.v4 {
+				continue
+			}
+			ip = ip4
+		} else if !p.v6 {
+			continue
+		}
+
+		key := ip.String()
+		at, ok := p.pushed[key]
+		switch {
+		case ok && now.Sub(at) < p.timeout/2:
+			// Refresh entries only once they are halfway to expiring
+		case ok && now.Sub(at) < p.timeout:
+			p.pending[key] = ip
+		default:
+			fresh[key] = ip
+			p.pushed[key] = now
+			delete(p.pending, key)
+		}
+	}
+	p.schedule()
+	backend, timeout := p.backend, p.timeout
+	p.mu.Unlock()
+
+	if len(fresh) == 0 {
+		return
+	}
+	if err := p.push(backend, timeout, fresh); err != nil {
+		log.Errorf("Prefilter: %v", err)
+		// Let the next sighting retry
+		p.mu.Lock()
+		for key := range fresh {
+			if p.pushed[key].Equal(now) {
+				delete(p.pushed, key)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *learnedPrefilter) schedule() {
+	if p.timer == nil && len(p.pending) > 0 {
+		p.timer = time.AfterFunc(prefilterFlushDelay, p.flush)
+	}
+}
+
+func (p *learnedPrefilter) flush() {
+	p.mu.Lock()
+	backend := p.backend
+	timeout := p.timeout
+	pending := p.pending
+	p.pending = make(map[string]net.IP)
+	p.timer = nil
+	now := time.Now()
+	if len(p.pushed) > prefilterLearnedMax {
+		for key, at := range p.pushed {
+			if now.Sub(at) >= p.timeout {
+				delete(p.pushed, key)
+			}
+		}
+	}
+	for key := range pending {
+		p.pushed[key] = now
+	}
+	p.mu.Unlock()
+
+	if backend == "" || len(pending) == 0 {
+		return
+	}
+	if err := p.push(backend, timeout, pending); err != nil {
+		log.Errorf("Prefilter: %v", err)
+	}
+}
+
+// pushLearned adds ips to the learned sets of backend.
+func pushLearned(backend string, timeout time.Duration, ips map[string]net.IP) error {
+	var v4, v6 []string
+	for key, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, key)
+		} else {
+			v6 = append(v6, key)
+		}
+	}
+
+	var script strings.Builder
+	var out string
+	var err error
+	switch backend {
+	case "netlink":
+		err = nlAddLearned(v4, v6)
+	case "nftables":
+		// Adding an element that is already there keeps its old timeout: add,
+		// delete and add again in one transaction so every element starts a
+		// new one
+		for _, set := range []struct {
+			name string
+			ips  []string
+		}{{prefilterLearned4, v4}, {prefilterLearned6, v6}} {
+			for _, chunk := range chunkStrings(set.ips, prefilterChunk) {
+				elems := strings.Join(chunk, ", ")
+				for _, op := range []string{"add", "delete", "add"} {
+					fmt.Fprintf(&script, "%s element inet %s %s { %s }\n", op, nftTableName, set.name, elems)
+				}
+			}
+		}
+		out, err = runStdin(script.String(), "nft", "-f", "-")
+	default:
+		secs := int(timeout / time.Second)
+		for _, ip := range v4 {
+			fmt.Fprintf(&script, "add %s %s timeout %d\n", prefilterLearned4, ip, secs)
+		}
+		for _, ip := range v6 {
+			fmt.Fprintf(&script, "add %s %s timeout %d\n", prefilterLearned6, ip, secs)
+		}
+		out, err = runStdin(script.String(), "ipset", "restore", "-exist")
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to add %d learned IPs: %w: %s", len(ips), err, strings.TrimSpace(out))
+	}
+	log.Tracef("Prefilter: added %d learned IPs (%d IPv4, %d IPv6)", len(ips), len(v4), len(v6))
+	return nil
+}
@@ -1,7 +1,9 @@
 package tables
 
 import (
+	"net"
 	"testing"
+	"time"
 
 	"github.com/daniellavrushin/b4/config"
 )
@@ -293,3 +295,174 @@ func TestLoadSysctlSnapshot_NoFile(t *testing.T) {
 		t.Error("should return empty map for non-existent file")
 	}
 }
+
+func TestPrefilterElements(t *testing.T) {
+	list := []string{"1.2.3.4", " 10.0.0.0/8 ", "2001:db8::/32", "::1", "bogus", "300.1.1.1"}
+
+	v4 := prefilterElements(list, false)
+	if len(v4) != 2 || v4[0] != "1.2.3.4" || v4[1] != "10.0.0.0/8" {
+		t.Errorf("IPv4 elements = %v", v4)
+	}
+
+	v6 := prefilterElements(list, true)
+	if len(v6) != 2 || v6[0] != "2001:db8::/32" || v6[1] != "::1" {
+		t.Errorf("IPv6 elements = %v", v6)
+	}
+}
+
+func TestNFTablesManager_PrefilterMatches(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Queue.IPv4Enabled = true
+	cfg.Queue.IPv6Enabled = false
+
+	manager := NewNFTablesManager(&cfg)
+	if m := manager.prefilterMatches("daddr"); len(m) != 1 || m[0] != nil {
+		t.Errorf("disabled prefilter should yield a single empty match, got %v", m)
+	}
+
+	cfg.System.Tables.Prefilter = true
+	manager = NewNFTablesManager(&cfg)
+	m := manager.prefilterMatches("daddr")
+	if len(m) != 2 {
+		t.Fatalf("expected 2 matches for IPv4 only, got %v", m)
+	}
+	if m[0][2] != "@"+prefilterTargets4 || m[1][2] != "@"+prefilterLearned4 {
+		t.Errorf("unexpected matches: %v", m)
+	}
+}
+
+func TestNlIntervalElems(t *testing.T) {
+	elems := nlIntervalElems([]string{"10.0.0.0/8", "10.1.2.3", "11.0.0.0/8", "192.168.1.1", "::1"}, false)
+	want := []struct {
+		key string
+		end bool
+	}{
+		{"10.0.0.0", false}, {"12.0.0.0", true},
+		{"192.168.1.1", false}, {"192.168.1.2", true},
+	}
+	if len(elems) != len(want) {
+		t.Fatalf("got %d elements, want %d", len(elems), len(want))
+	}
+	for i, w := range want {
+		if got := net.IP(elems[i].key).String(); got != w.key || elems[i].intervalEnd != w.end {
+			t.Errorf("elems[%d] = %s (end %v), want %s (end %v)", i, got, elems[i].intervalEnd, w.key, w.end)
+		}
+	}
+
+	if v6 := nlIntervalElems([]string{"2001:db8::/32"}, true); len(v6) != 2 || len(v6[0].key) != 16 {
+		t.Errorf("unexpected IPv6 elements: %v", v6)
+	}
+}
+
+func TestChunkSetElems(t *testing.T) {
+	elems := []nlSetElem{{}, {intervalEnd: true}, {}, {intervalEnd: true}}
+	chunks := chunkSetElems(elems, 3)
+	if len(chunks) != 2 || len(chunks[0]) != 2 || len(chunks[1]) != 2 {
+		t.Errorf("interval was split across chunks: %v", chunks)
+	}
+}
+
+func TestNlMatchPort(t *testing.T) {
+	if m, err := nlMatchPort(false, "443"); err != nil || len(m) != 2 {
+		t.Errorf("single port: %d exprs, err %v", len(m), err)
+	}
+	if m, err := nlMatchPort(true, "50000-50100"); err != nil || len(m) != 3 {
+		t.Errorf("port range: %d exprs, err %v", len(m), err)
+	}
+	if _, err := nlMatchPort(false, "https"); err == nil {
+		t.Error("expected error for non-numeric port")
+	}
+}
+
+func TestCommentUdata(t *testing.T) {
+	if got := parseCommentUdata(commentUdata("b4:12")); got != "b4:12" {
+		t.Errorf("comment round trip = %q", got)
+	}
+	if got := parseCommentUdata([]byte{1, 2, 0, 0}); got != "" {
+		t.Errorf("non-comment udata parsed as %q", got)
+	}
+}
+
+func TestDetectFirewallBackend_Explicit(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.System.Tables.Backend = "netlink"
+	if got := detectFirewallBackend(&cfg); got != "netlink" {
+		t.Errorf("detectFirewallBackend = %q, want netlink", got)
+	}
+}
+
+func TestCollectRuleGroups(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Validate()
+
+	same := config.NewSetConfig()
+	same.Id, same.Name, same.Enabled = "same", "same", true
+	same.TCP.ConnBytesLimit = cfg.MainSet.TCP.ConnBytesLimit
+	same.UDP.ConnBytesLimit = cfg.MainSet.UDP.ConnBytesLimit
+
+	deep := config.NewSetConfig()
+	deep.Id, deep.Name, deep.Enabled = "deep", "deep", true
+	deep.TCP.ConnBytesLimit = cfg.MainSet.TCP.ConnBytesLimit + 20
+	deep.UDP.ConnBytesLimit = cfg.MainSet.UDP.ConnBytesLimit
+	deep.UDP.DPortFilter = "50000-50100"
+	deep.Targets.IpsToMatch = []string{"203.0.113.0/24", "2001:db8::1"}
+
+	cfg.Sets = append(cfg.Sets, &same, &deep)
+
+	groups := collectRuleGroups(&cfg)
+	if len(groups) != 1 {
+		t.Fatalf("expected one group, got %d", len(groups))
+	}
+	g := groups[0]
+	if g.name != "deep" || g.tcpLimit != deep.TCP.ConnBytesLimit {
+		t.Errorf("unexpected group %+v", g)
+	}
+	if g.mark != cfg.CtMarkOf(&deep) || g.mark&^config.SetCtMarkMask != 0 {
+		t.Errorf("group mark = 0x%x", g.mark)
+	}
+	if len(g.ipv4) != 1 || len(g.ipv6) != 1 {
+		t.Errorf("targets = %v / %v", g.ipv4, g.ipv6)
+	}
+	if len(g.udpPorts) != 2 || g.udpPorts[0] != "443" || g.udpPorts[1] != "50000-50100" {
+		t.Errorf("udp ports = %v", g.udpPorts)
+	}
+	if g.nftChain() == g.nftChainIn() || g.setName(false) == g.setName(true) {
+		t.Error("group chain and set names must be distinct")
+	}
+}
+
+func TestLearnedPrefilter_NewIPsPushedBeforeReturn(t *testing.T) {
+	var pushed []string
+	p := &learnedPrefilter{
+		pending: make(map[string]net.IP),
+		pushed:  make(map[string]time.Time),
+		push: func(backend string, timeout time.Duration, ips map[string]net.IP) error {
+			for key := range ips {
+				pushed = append(pushed, key)
+			}
+			return nil
+		},
+	}
+	cfg := config.NewConfig()
+	cfg.Queue.IPv4Enabled = true
+	cfg.System.Tables.PrefilterTimeout = 3600
+	p.activate("nftables", &cfg)
+	defer p.deactivate()
+
+	// The DNS verdict follows this call, so the element must already be in the set
+	p.add(net.ParseIP("203.0.113.7"), net.ParseIP("203.0.113.8"))
+	if len(pushed) != 2 {
+		t.Fatalf("pushed %v before returning, want both IPs", pushed)
+	}
+
+	// An IP already in the set is not pushed again
+	p.add(net.ParseIP("203.0.113.7"))
+	if len(pushed) != 2 {
+		t.Errorf("pushed %v, want no second push of a live entry", pushed)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) != 0 {
+		t.Errorf("pending = %v, want empty", p.pending)
+	}
+}